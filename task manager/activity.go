@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The activity log is an append-only record of who changed what. Like
+// version bumps and events it is derived in updateAppDataAs by comparing
+// tasks and projects before and after each update, so every mutation from
+// the CLI or the HTTP API is covered without the code making it having to
+// log anything. Entries are never edited or dropped, since task history,
+// cycle and lead times and the project of deleted tasks all come from
+// them, unless TASKMANAGER_ACTIVITY_LIMIT caps the log.
+
+type Activity struct {
+	ID        int           `json:"id"`
+	Time      time.Time     `json:"time"`
+	Actor     string        `json:"actor"`
+	Action    string        `json:"action"` // created, updated, deleted, restored, commented, timer_started, timer_stopped, time_added
+	Entity    string        `json:"entity"` // task or project
+	EntityID  int           `json:"entity_id"`
+	ProjectID int           `json:"project_id,omitempty"`
+	Title     string        `json:"title"` // task description or project name at the time
+	Changes   []FieldChange `json:"changes,omitempty"`
+}
+
+// FieldChange holds a field's JSON value before and after a change.
+type FieldChange struct {
+	Field string          `json:"field"`
+	From  json.RawMessage `json:"from,omitempty"`
+	To    json.RawMessage `json:"to,omitempty"`
+}
+
+type ActivityPage struct {
+	Entries []Activity `json:"entries"`
+	Total   int        `json:"total"`
+	Offset  int        `json:"offset"`
+	Limit   int        `json:"limit"`
+}
+
+// Fields left out of diffs: the version changes with everything, and
+// comments get their own "commented" entries.
+var unloggedFields = map[string]bool{"version": true, "comments": true, "updated_at": true}
+
+// requestActor names the caller of r in the activity log.
+func requestActor(r *http.Request) string {
+	if user, ok := requestUser(r); ok {
+		return user.Username
+	}
+	return "anonymous"
+}
+
+// cliActor names the local user for changes made from the CLI.
+func cliActor() string {
+	if user := currentUser(); user != "" {
+		return user
+	}
+	return "local"
+}
+
+// diffFields compares two JSON objects field by field.
+func diffFields(before, after string) []FieldChange {
+	var old, cur map[string]json.RawMessage
+	json.Unmarshal([]byte(before), &old)
+	json.Unmarshal([]byte(after), &cur)
+
+	fields := map[string]bool{}
+	for f := range old {
+		fields[f] = true
+	}
+	for f := range cur {
+		fields[f] = true
+	}
+	var changes []FieldChange
+	for f := range fields {
+		if unloggedFields[f] || string(old[f]) == string(cur[f]) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: f, From: old[f], To: cur[f]})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// activityLimit is how many entries the log keeps, from
+// TASKMANAGER_ACTIVITY_LIMIT; 0 or unset keeps everything.
+func activityLimit() int {
+	if v := os.Getenv("TASKMANAGER_ACTIVITY_LIMIT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// recordActivity appends what an update changed to appData.Activity. snap
+// was taken before the update.
+func recordActivity(appData *AppData, snap versionSnapshot, actor string) {
+	now := time.Now()
+	id := 0
+	if n := len(appData.Activity); n > 0 {
+		id = appData.Activity[n-1].ID
+	}
+	add := func(a Activity) {
+		id++
+		a.ID, a.Time, a.Actor = id, now, actor
+		appData.Activity = append(appData.Activity, a)
+	}
+	defer func() {
+		if limit := activityLimit(); limit > 0 && len(appData.Activity) > limit {
+			dropped := len(appData.Activity) - limit
+			log.Printf("activity log: dropping %d entries up to #%d (TASKMANAGER_ACTIVITY_LIMIT=%d)",
+				dropped, appData.Activity[dropped-1].ID, limit)
+			appData.Activity = append([]Activity(nil), appData.Activity[dropped:]...)
+		}
+	}()
+	// IDs are never reused, so a new ID that was in the trash is a restore.
+	createdOrRestored := func(trashed bool) string {
+		if trashed {
+			return "restored"
+		}
+		return "created"
+	}
+
+	seen := map[int]bool{}
+	for _, p := range appData.Projects {
+		seen[p.ID] = true
+		entry := Activity{Entity: "project", EntityID: p.ID, ProjectID: p.ID, Title: p.Name}
+		before, ok := snap.projects[p.ID]
+		if !ok {
+			entry.Action = createdOrRestored(snap.trashedProjects[p.ID])
+			add(entry)
+			continue
+		}
+		if changes := diffFields(before, jsonString(p)); len(changes) > 0 {
+			entry.Action, entry.Changes = "updated", changes
+			add(entry)
+		}
+	}
+	for id, before := range snap.projects {
+		if !seen[id] {
+			var old Project
+			json.Unmarshal([]byte(before), &old)
+			add(Activity{Action: "deleted", Entity: "project", EntityID: id, ProjectID: id, Title: old.Name})
+		}
+	}
+
+	seen = map[int]bool{}
+	for _, t := range appData.Tasks {
+		seen[t.ID] = true
+		entry := Activity{Entity: "task", EntityID: t.ID, ProjectID: t.ProjectID, Title: t.Description}
+		before, ok := snap.tasks[t.ID]
+		if !ok {
+			entry.Action = createdOrRestored(snap.trashedTasks[t.ID])
+			add(entry)
+			continue
+		}
+		after := jsonString(t)
+		if after == before {
+			continue
+		}
+		if changes := diffFields(before, after); len(changes) > 0 {
+			entry.Action, entry.Changes = "updated", changes
+			add(entry)
+		}
+		var old Task
+		json.Unmarshal([]byte(before), &old)
+		known := map[int]bool{}
+		for _, c := range old.Comments {
+			known[c.ID] = true
+		}
+		for _, c := range t.Comments {
+			if !known[c.ID] {
+				text, _ := json.Marshal(c.Text)
+				entry.Action, entry.Changes = "commented", []FieldChange{{Field: "comment", To: text}}
+				add(entry)
+			}
+		}
+	}
+	for id, before := range snap.tasks {
+		if !seen[id] {
+			var old Task
+			json.Unmarshal([]byte(before), &old)
+			add(Activity{Action: "deleted", Entity: "task", EntityID: id, ProjectID: old.ProjectID, Title: old.Description})
+		}
+	}
+
+	timers := timerChanges(snap, appData)
+	for _, e := range appData.TimeEntries {
+		entry := Activity{Entity: "task", EntityID: e.TaskID}
+		if t := findTask(appData, e.TaskID); t != nil {
+			entry.ProjectID, entry.Title = t.ProjectID, t.Description
+		}
+		duration, _ := json.Marshal(e.Duration)
+		switch timers[e.ID] {
+		case "started":
+			entry.Action = "timer_started"
+			add(entry)
+		case "stopped":
+			entry.Action, entry.Changes = "timer_stopped", []FieldChange{{Field: "duration", To: duration}}
+			add(entry)
+		case "added":
+			entry.Action, entry.Changes = "time_added", []FieldChange{{Field: "duration", To: duration}}
+			add(entry)
+		}
+	}
+}
+
+// taskHistory returns the entries for one task, oldest first.
+func taskHistory(appData *AppData, taskID int) []Activity {
+	history := []Activity{}
+	for _, a := range appData.Activity {
+		if a.Entity == "task" && a.EntityID == taskID {
+			history = append(history, a)
+		}
+	}
+	return history
+}
+
+// projectActivity returns a page of a project's feed, newest first.
+func projectActivity(appData *AppData, projectID, offset, limit int) ActivityPage {
+	var entries []Activity
+	for i := len(appData.Activity) - 1; i >= 0; i-- {
+		if appData.Activity[i].ProjectID == projectID {
+			entries = append(entries, appData.Activity[i])
+		}
+	}
+	page := ActivityPage{Entries: []Activity{}, Total: len(entries), Offset: offset, Limit: limit}
+	if offset < len(entries) {
+		entries = entries[offset:]
+		if limit > 0 && len(entries) > limit {
+			entries = entries[:limit]
+		}
+		page.Entries = entries
+	}
+	return page
+}
+
+// describeActivity renders an entry as one line for the CLI.
+func describeActivity(a Activity) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s  %s ", a.Time.Local().Format("2006-01-02 15:04"), a.Actor)
+	switch a.Action {
+	case "created", "deleted", "restored":
+		fmt.Fprintf(&b, "%s %s #%d %q", a.Action, a.Entity, a.EntityID, a.Title)
+	case "commented":
+		fmt.Fprintf(&b, "commented on #%d: %s", a.EntityID, changeValue("comment", a.Changes[0].To))
+	case "timer_started":
+		fmt.Fprintf(&b, "started a timer on #%d", a.EntityID)
+	case "timer_stopped":
+		fmt.Fprintf(&b, "stopped a timer on #%d (%s)", a.EntityID, changeValue("duration", a.Changes[0].To))
+	case "time_added":
+		fmt.Fprintf(&b, "added %s of time to #%d", changeValue("duration", a.Changes[0].To), a.EntityID)
+	default:
+		var parts []string
+		for _, c := range a.Changes {
+			parts = append(parts, fmt.Sprintf("%s: %s → %s", c.Field, changeValue(c.Field, c.From), changeValue(c.Field, c.To)))
+		}
+		fmt.Fprintf(&b, "changed %s #%d: %s", a.Entity, a.EntityID, strings.Join(parts, ", "))
+	}
+	return b.String()
+}
+
+// changeValue makes a logged JSON value readable.
+func changeValue(field string, raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "(none)"
+	}
+	switch field {
+	case "priority":
+		var p Priority
+		if json.Unmarshal(raw, &p) == nil {
+			return p.String()
+		}
+	case "due_date", "completed_at":
+		var t time.Time
+		if json.Unmarshal(raw, &t) == nil {
+			return formatDue(t)
+		}
+	case "duration":
+		var secs int
+		if json.Unmarshal(raw, &secs) == nil {
+			return (time.Duration(secs) * time.Second).String()
+		}
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	return string(raw)
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestActivityLimit(t *testing.T) {
+	tests := []struct {
+		env          string
+		kept, oldest int
+	}{
+		{"", 30, 1},
+		{"0", 30, 1},
+		{"25", 25, 6},
+	}
+	for _, tt := range tests {
+		t.Setenv("TASKMANAGER_ACTIVITY_LIMIT", tt.env)
+		appData := &AppData{}
+		for i := 1; i <= 30; i++ {
+			snap := snapshotVersions(appData)
+			appData.Tasks = append(appData.Tasks, Task{ID: i, ProjectID: 1, Description: "task"})
+			recordActivity(appData, snap, "ann")
+		}
+		if len(appData.Activity) != tt.kept || appData.Activity[0].ID != tt.oldest {
+			t.Errorf("limit %q: kept %d entries from #%d, want %d from #%d",
+				tt.env, len(appData.Activity), appData.Activity[0].ID, tt.kept, tt.oldest)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Analytics look back at the tasks completed in a date range. Cycle time
+// runs from the first move to in_progress to completion, lead time from
+// creation to completion, and throughput counts completions per ISO week.
+// Status moves come from the activity log; a task's status before its
+// first logged move is taken to be the one it was created with.
+//
+// Estimate accuracy compares each completed task's own estimate with the
+// time tracked on it. Tasks without an estimate or without tracked time
+// are left out, since they say nothing about how good the estimate was.
+
+// StatusChange is one logged move of a task between statuses.
+type StatusChange struct {
+	Time time.Time  `json:"time"`
+	From TaskStatus `json:"from"`
+	To   TaskStatus `json:"to"`
+}
+
+// AnalyticsOptions narrow the analytics to a range of completion days, a
+// project or an assignee.
+type AnalyticsOptions struct {
+	From      time.Time
+	To        time.Time // exclusive
+	ProjectID int
+	Assignee  string
+}
+
+type TaskAnalytics struct {
+	TaskID         int        `json:"task_id"`
+	Description    string     `json:"description"`
+	ProjectID      int        `json:"project_id,omitempty"`
+	Project        string     `json:"project,omitempty"`
+	Assignee       string     `json:"assignee,omitempty"`
+	CompletedAt    time.Time  `json:"completed_at"`
+	EstimatedHours float64    `json:"estimated_hours,omitempty"`
+	ActualHours    float64    `json:"actual_hours"`
+	Ratio          float64    `json:"ratio,omitempty"`       // actual / estimated
+	StartedAt      *time.Time `json:"started_at,omitempty"`  // first moved to in_progress
+	CycleHours     *float64   `json:"cycle_hours,omitempty"` // nil when never in progress
+	LeadHours      float64    `json:"lead_hours"`
+}
+
+// EstimateAccuracy sums estimated and tracked hours over a group of tasks.
+// Ratio is actual over estimated hours, so above 1 means the group took
+// longer than estimated; MeanError is the average of each task's absolute
+// error as a fraction of its estimate.
+type EstimateAccuracy struct {
+	Assignee       string  `json:"assignee,omitempty"`
+	ProjectID      int     `json:"project_id,omitempty"`
+	Project        string  `json:"project,omitempty"`
+	Tasks          int     `json:"tasks"`
+	EstimatedHours float64 `json:"estimated_hours"`
+	ActualHours    float64 `json:"actual_hours"`
+	Ratio          float64 `json:"ratio"`
+	MeanError      float64 `json:"mean_error"`
+	Over           int     `json:"over"`  // took longer than estimated
+	Under          int     `json:"under"` // took less
+}
+
+type WeeklyThroughput struct {
+	Week           string    `json:"week"` // ISO week, 2006-W01
+	Start          time.Time `json:"start"`
+	Completed      int       `json:"completed"`
+	EstimatedHours float64   `json:"estimated_hours"`
+	ActualHours    float64   `json:"actual_hours"`
+}
+
+// FlowStats describe a set of durations in hours.
+type FlowStats struct {
+	Tasks   int     `json:"tasks"`
+	Average float64 `json:"average_hours"`
+	Median  float64 `json:"median_hours"`
+	P85     float64 `json:"p85_hours"` // 85% of tasks took at most this long
+	Max     float64 `json:"max_hours"`
+}
+
+type Analytics struct {
+	From       time.Time          `json:"from"`
+	To         time.Time          `json:"to"` // exclusive
+	Tasks      []TaskAnalytics    `json:"tasks"`
+	Accuracy   EstimateAccuracy   `json:"accuracy"`
+	ByAssignee []EstimateAccuracy `json:"accuracy_by_assignee"`
+	ByProject  []EstimateAccuracy `json:"accuracy_by_project"`
+	Throughput []WeeklyThroughput `json:"throughput"`
+	CycleTime  FlowStats          `json:"cycle_time"`
+	LeadTime   FlowStats          `json:"lead_time"`
+}
+
+// parseAnalyticsOptions reads from and to (completion days, to inclusive;
+// the last 12 weeks by default), project (name or id) and assignee.
+func parseAnalyticsOptions(appData *AppData, values map[string]string, now time.Time) (AnalyticsOptions, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	opts := AnalyticsOptions{Assignee: strings.TrimSpace(values["assignee"])}
+	var err error
+	opts.From, opts.To, err = parseDayRange(values["from"], values["to"], startOfWeek(today).AddDate(0, 0, -7*11), today.AddDate(0, 0, 1), now)
+	if err != nil {
+		return opts, err
+	}
+	if v := strings.TrimSpace(values["project"]); v != "" {
+		project := findProject(appData, 0, v)
+		if project == nil {
+			return opts, &apiError{Status: http.StatusNotFound, Message: fmt.Sprintf("project %q not found", v)}
+		}
+		opts.ProjectID = project.ID
+	}
+	return opts, nil
+}
+
+// statusChanges collects the logged status moves of every task, oldest
+// first. Older entries that only flip done count as moves to and from done.
+func statusChanges(appData *AppData) map[int][]StatusChange {
+	changes := map[int][]StatusChange{}
+	for _, a := range appData.Activity {
+		if a.Entity != "task" || a.Action != "updated" {
+			continue
+		}
+		var from, to string
+		var done *bool
+		for _, c := range a.Changes {
+			switch c.Field {
+			case "status":
+				json.Unmarshal(c.From, &from)
+				json.Unmarshal(c.To, &to)
+			case "done":
+				var d bool
+				if json.Unmarshal(c.To, &d) == nil {
+					done = &d
+				}
+			}
+		}
+		if from == "" && to == "" && done != nil {
+			from, to = string(StatusDone), string(StatusTodo)
+			if *done {
+				from, to = to, from
+			}
+		}
+		if from == "" && to == "" {
+			continue
+		}
+		if from == "" {
+			from = string(StatusTodo)
+		}
+		if to == "" {
+			to = string(StatusTodo)
+		}
+		if from == to {
+			continue
+		}
+		changes[a.EntityID] = append(changes[a.EntityID], StatusChange{Time: a.Time, From: TaskStatus(from), To: TaskStatus(to)})
+	}
+	return changes
+}
+
+// taskFlow returns when a done task was first started and when it was
+// last completed, from its status moves.
+func taskFlow(t Task, moves []StatusChange) (started *time.Time, completed time.Time) {
+	initial := taskStatus(t)
+	if len(moves) > 0 {
+		initial = moves[0].From
+	}
+	if initial == StatusInProgress {
+		created := t.CreatedAt
+		started = &created
+	}
+	for _, m := range moves {
+		if m.To == StatusInProgress && started == nil {
+			at := m.Time
+			started = &at
+		}
+		if m.To == StatusDone {
+			completed = m.Time
+		}
+	}
+	if t.CompletedAt != nil {
+		completed = *t.CompletedAt
+	}
+	if completed.IsZero() && initial == StatusDone {
+		completed = t.CreatedAt
+	}
+	if started != nil && started.After(completed) {
+		started = nil
+	}
+	return started, completed
+}
+
+// buildAnalytics computes the analytics of the done tasks completed in the
+// range of opts.
+func buildAnalytics(appData *AppData, opts AnalyticsOptions) Analytics {
+	result := Analytics{From: opts.From, To: opts.To, Tasks: []TaskAnalytics{}, ByAssignee: []EstimateAccuracy{}, ByProject: []EstimateAccuracy{}}
+	tracked := trackedHours(appData.TimeEntries)
+	moves := statusChanges(appData)
+	names := projectNames(appData.Projects)
+
+	var cycle, lead []float64
+	for _, t := range appData.Tasks {
+		if !t.Done && taskStatus(t) != StatusDone {
+			continue
+		}
+		if opts.ProjectID != 0 && t.ProjectID != opts.ProjectID {
+			continue
+		}
+		if opts.Assignee != "" && !strings.EqualFold(t.Assignee, opts.Assignee) {
+			continue
+		}
+		started, completed := taskFlow(t, moves[t.ID])
+		if completed.Before(opts.From) || !completed.Before(opts.To) {
+			continue
+		}
+		ta := TaskAnalytics{
+			TaskID: t.ID, Description: t.Description, ProjectID: t.ProjectID, Project: names[t.ProjectID],
+			Assignee: t.Assignee, CompletedAt: completed, EstimatedHours: t.EstimatedHours,
+			ActualHours: roundHours(tracked[t.ID]), StartedAt: started,
+			LeadHours: roundHours(completed.Sub(t.CreatedAt).Hours()),
+		}
+		if t.EstimatedHours > 0 && ta.ActualHours > 0 {
+			ta.Ratio = roundHours(tracked[t.ID] / t.EstimatedHours)
+		}
+		lead = append(lead, completed.Sub(t.CreatedAt).Hours())
+		if started != nil {
+			hours := completed.Sub(*started).Hours()
+			cycle = append(cycle, hours)
+			rounded := roundHours(hours)
+			ta.CycleHours = &rounded
+		}
+		result.Tasks = append(result.Tasks, ta)
+	}
+	sort.SliceStable(result.Tasks, func(i, j int) bool { return result.Tasks[i].CompletedAt.Before(result.Tasks[j].CompletedAt) })
+
+	byAssignee := map[string]*EstimateAccuracy{}
+	byProject := map[int]*EstimateAccuracy{}
+	overall := &EstimateAccuracy{}
+	errorSums := map[*EstimateAccuracy]float64{}
+	for _, ta := range result.Tasks {
+		if ta.Ratio == 0 {
+			continue
+		}
+		key := strings.ToLower(ta.Assignee)
+		if byAssignee[key] == nil {
+			byAssignee[key] = &EstimateAccuracy{Assignee: ta.Assignee}
+		}
+		if byProject[ta.ProjectID] == nil {
+			byProject[ta.ProjectID] = &EstimateAccuracy{ProjectID: ta.ProjectID, Project: ta.Project}
+		}
+		for _, acc := range []*EstimateAccuracy{overall, byAssignee[key], byProject[ta.ProjectID]} {
+			acc.Tasks++
+			acc.EstimatedHours += ta.EstimatedHours
+			acc.ActualHours += ta.ActualHours
+			errorSums[acc] += math.Abs(ta.ActualHours-ta.EstimatedHours) / ta.EstimatedHours
+			switch {
+			case ta.ActualHours > ta.EstimatedHours:
+				acc.Over++
+			case ta.ActualHours < ta.EstimatedHours:
+				acc.Under++
+			}
+		}
+	}
+	finish := func(acc *EstimateAccuracy) EstimateAccuracy {
+		out := *acc
+		if out.Tasks > 0 {
+			out.Ratio = roundHours(out.ActualHours / out.EstimatedHours)
+			out.MeanError = roundHours(errorSums[acc] / float64(out.Tasks))
+		}
+		out.EstimatedHours, out.ActualHours = roundHours(out.EstimatedHours), roundHours(out.ActualHours)
+		return out
+	}
+	result.Accuracy = finish(overall)
+	for _, acc := range byAssignee {
+		result.ByAssignee = append(result.ByAssignee, finish(acc))
+	}
+	sort.Slice(result.ByAssignee, func(i, j int) bool {
+		return strings.ToLower(result.ByAssignee[i].Assignee) < strings.ToLower(result.ByAssignee[j].Assignee)
+	})
+	for _, acc := range byProject {
+		result.ByProject = append(result.ByProject, finish(acc))
+	}
+	sort.Slice(result.ByProject, func(i, j int) bool {
+		return strings.ToLower(result.ByProject[i].Project) < strings.ToLower(result.ByProject[j].Project)
+	})
+
+	result.Throughput = weeklyThroughput(result.Tasks, opts.From, opts.To)
+	result.CycleTime = flowStats(cycle)
+	result.LeadTime = flowStats(lead)
+	return result
+}
+
+// weeklyThroughput counts the completed tasks of every week in the range,
+// including weeks without any.
+func weeklyThroughput(tasks []TaskAnalytics, from, to time.Time) []WeeklyThroughput {
+	weeks := []WeeklyThroughput{}
+	index := map[string]int{}
+	for start := startOfWeek(from); start.Before(to); start = start.AddDate(0, 0, 7) {
+		year, week := start.ISOWeek()
+		name := fmt.Sprintf("%d-W%02d", year, week)
+		index[name] = len(weeks)
+		weeks = append(weeks, WeeklyThroughput{Week: name, Start: start})
+	}
+	for _, ta := range tasks {
+		year, week := ta.CompletedAt.In(from.Location()).ISOWeek()
+		i, ok := index[fmt.Sprintf("%d-W%02d", year, week)]
+		if !ok {
+			continue
+		}
+		weeks[i].Completed++
+		weeks[i].EstimatedHours = roundHours(weeks[i].EstimatedHours + ta.EstimatedHours)
+		weeks[i].ActualHours = roundHours(weeks[i].ActualHours + ta.ActualHours)
+	}
+	return weeks
+}
+
+// flowStats summarises durations in hours. Percentiles use the nearest
+// rank.
+func flowStats(hours []float64) FlowStats {
+	stats := FlowStats{Tasks: len(hours)}
+	if len(hours) == 0 {
+		return stats
+	}
+	sorted := append([]float64(nil), hours...)
+	sort.Float64s(sorted)
+	sum := 0.0
+	for _, h := range sorted {
+		sum += h
+	}
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(sorted)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return sorted[i]
+	}
+	stats.Average = roundHours(sum / float64(len(sorted)))
+	stats.Median = roundHours(rank(0.5))
+	stats.P85 = roundHours(rank(0.85))
+	stats.Max = roundHours(sorted[len(sorted)-1])
+	return stats
+}
+
+func roundHours(h float64) float64 {
+	return math.Round(h*100) / 100
+}
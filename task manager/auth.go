@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The web server requires a signed-in user for every API call except
+// logging in and registering. Scripts can use an API key instead (see
+// rbac.go). A session is a random token handed out at
+// login, sent back either as the tm_session cookie (the web UI) or as an
+// "Authorization: Bearer" header (scripts). Only a SHA-256 of the token is
+// stored, so a copy of the data file does not let anyone sign in.
+
+const (
+	sessionCookie     = "tm_session"
+	sessionLifetime   = 30 * 24 * time.Hour
+	minPasswordLength = 8
+)
+
+type User struct {
+	ID           int       `json:"id"`
+	Username     string    `json:"username"`
+	Name         string    `json:"name,omitempty"`
+	PasswordHash string    `json:"password_hash"` // bcrypt
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// UserInfo is what the API shows of a user.
+type UserInfo struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (u User) Info() UserInfo {
+	return UserInfo{ID: u.ID, Username: u.Username, Name: u.Name, CreatedAt: u.CreatedAt}
+}
+
+type Session struct {
+	TokenHash string    `json:"token_hash"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+// withUser attaches the authenticated user to the request.
+func withUser(r *http.Request, user User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey, user))
+}
+
+// requestUser returns the user routeHandler authenticated for r.
+func requestUser(r *http.Request) (User, bool) {
+	user, ok := r.Context().Value(userContextKey).(User)
+	return user, ok
+}
+
+func findUser(appData *AppData, username string) *User {
+	for i := range appData.Users {
+		if strings.EqualFold(appData.Users[i].Username, username) {
+			return &appData.Users[i]
+		}
+	}
+	return nil
+}
+
+func findUserByID(appData *AppData, id int) *User {
+	for i := range appData.Users {
+		if appData.Users[i].ID == id {
+			return &appData.Users[i]
+		}
+	}
+	return nil
+}
+
+func validUsername(name string) bool {
+	if name == "" || len(name) > 32 || strings.EqualFold(name, "me") || strings.EqualFold(name, "none") {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("._-", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("password must be at least %d characters", minPasswordLength)}
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// createUser adds an account. Usernames are unique ignoring case.
+func createUser(appData *AppData, username, name, password string) (*User, error) {
+	username = strings.TrimSpace(username)
+	if !validUsername(username) {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: "username must be 1-32 letters, digits, '.', '_' or '-' (and not \"me\" or \"none\")"}
+	}
+	if findUser(appData, username) != nil {
+		return nil, &apiError{Status: http.StatusConflict, Message: fmt.Sprintf("user %q already exists", username)}
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	id := 1
+	for _, u := range appData.Users {
+		if u.ID >= id {
+			id = u.ID + 1
+		}
+	}
+	appData.Users = append(appData.Users, User{
+		ID:           id,
+		Username:     username,
+		Name:         strings.TrimSpace(name),
+		PasswordHash: hash,
+		CreatedAt:    time.Now(),
+	})
+	return &appData.Users[len(appData.Users)-1], nil
+}
+
+// dummyPasswordHash is compared against for unknown usernames, so they take
+// as long to reject as a wrong password and do not show which users exist.
+const dummyPasswordHash = "$2a$10$kgBdkP8cGLGhoSjbRxNmC.u8LXGknEObHlSyagnJxsPhPadNSuK8."
+
+// checkPassword returns the user if the credentials are right. The error
+// does not say which part was wrong. bcrypt is slow on purpose, so callers
+// should check against a loaded copy rather than inside updateAppData.
+func checkPassword(appData *AppData, username, password string) (*User, error) {
+	user := findUser(appData, username)
+	hash := dummyPasswordHash
+	if user != nil {
+		hash = user.PasswordHash
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil || user == nil {
+		return nil, &apiError{Status: http.StatusUnauthorized, Message: "wrong username or password"}
+	}
+	return user, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newSession starts a session for the user and returns its token. Expired
+// sessions are dropped on the way.
+func newSession(appData *AppData, userID int) (string, Session, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", Session{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	now := time.Now()
+
+	kept := appData.Sessions[:0]
+	for _, s := range appData.Sessions {
+		if s.ExpiresAt.After(now) {
+			kept = append(kept, s)
+		}
+	}
+	session := Session{TokenHash: hashToken(token), UserID: userID, CreatedAt: now, ExpiresAt: now.Add(sessionLifetime)}
+	appData.Sessions = append(kept, session)
+	return token, session, nil
+}
+
+func endSession(appData *AppData, token string) {
+	hash := hashToken(token)
+	kept := appData.Sessions[:0]
+	for _, s := range appData.Sessions {
+		if s.TokenHash != hash {
+			kept = append(kept, s)
+		}
+	}
+	appData.Sessions = kept
+}
+
+// requestToken returns the bearer token or session cookie sent with r.
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// authenticate resolves the caller of r from its session token or API key,
+// or for the calendar feed its calendar token.
+func authenticate(r *http.Request) (User, error) {
+	token := requestToken(r)
+	if token == "" && r.URL.Path == calendarPath {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		return User{}, &apiError{Status: http.StatusUnauthorized, Message: "Sign in required"}
+	}
+	appData, err := loadAppData()
+	if err != nil {
+		return User{}, err
+	}
+	hash := hashToken(token)
+	for _, s := range appData.Sessions {
+		if s.TokenHash != hash || time.Now().After(s.ExpiresAt) {
+			continue
+		}
+		if user := findUserByID(appData, s.UserID); user != nil {
+			return *user, nil
+		}
+	}
+	for _, k := range appData.APIKeys {
+		if k.KeyHash != hash {
+			continue
+		}
+		if user := findUserByID(appData, k.UserID); user != nil {
+			return *user, nil
+		}
+	}
+	if r.URL.Path == calendarPath && r.Method == "GET" {
+		if user := calendarUser(appData, token); user != nil {
+			return *user, nil
+		}
+	}
+	if strings.HasPrefix(token, calendarTokenPrefix) {
+		return User{}, &apiError{Status: http.StatusUnauthorized, Message: "Unknown or replaced calendar token"}
+	}
+	if strings.HasPrefix(token, apiKeyPrefix) {
+		return User{}, &apiError{Status: http.StatusUnauthorized, Message: "Unknown or revoked API key"}
+	}
+	return User{}, &apiError{Status: http.StatusUnauthorized, Message: "Session expired; sign in again"}
+}
+
+// publicEndpoint lists the API calls that work without signing in.
+func publicEndpoint(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/api/auth/login", "/api/auth/register", "/api/auth/logout":
+		return r.Method == "POST"
+	}
+	return false
+}
+
+// signupOpen reports whether anyone may register. The first account can
+// always be created; after that only with TASKMANAGER_SIGNUP=open, and
+// otherwise accounts are added with "taskmanager user add".
+func signupOpen(appData *AppData) bool {
+	return len(appData.Users) == 0 || strings.EqualFold(os.Getenv("TASKMANAGER_SIGNUP"), "open")
+}
+
+// resolveAssignee turns the assignee given in a request into a username:
+// "me" is the caller, "none" clears it, anything else must be an account.
+func resolveAssignee(appData *AppData, value string, caller User) (string, error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case strings.EqualFold(value, "me"):
+		return caller.Username, nil
+	case strings.EqualFold(value, "none"):
+		return "", nil
+	}
+	user := findUser(appData, value)
+	if user == nil {
+		return "", &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("assignee %q is not a user", value)}
+	}
+	return user.Username, nil
+}
+
+// newTaskAssignee is resolveAssignee for a new task, which is assigned to
+// its creator unless the request says otherwise.
+func newTaskAssignee(appData *AppData, value string, caller User) (string, error) {
+	if strings.TrimSpace(value) == "" {
+		value = "me"
+	}
+	return resolveAssignee(appData, value, caller)
+}
+
+// corsOrigins holds the origins allowed to call the API from a browser,
+// from TASKMANAGER_CORS_ORIGINS (comma-separated, or "*" for any origin
+// without credentials). Empty means same-origin only.
+var corsOrigins []string
+
+func loadCORSConfig() {
+	corsOrigins = nil
+	for _, origin := range strings.Split(os.Getenv("TASKMANAGER_CORS_ORIGINS"), ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			corsOrigins = append(corsOrigins, origin)
+		}
+	}
+}
+
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
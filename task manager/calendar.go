@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// GET /api/calendar.ics publishes tasks with a due date as an iCalendar
+// feed (RFC 5545) that calendar apps can subscribe to. Those apps cannot
+// sign in, so each user can create a calendar token that is passed as
+// ?token= instead. It only opens the feed, and only shows what its user
+// may see. Like other tokens only its hash is stored; creating a new one
+// replaces the old.
+//
+// Tasks are VEVENTs by default, so they show up in ordinary calendars, or
+// VTODOs with ?kind=todo for apps that handle tasks.
+
+const (
+	calendarPath        = "/api/calendar.ics"
+	calendarTokenPrefix = "tmc_"
+)
+
+type CalendarToken struct {
+	UserID    int       `json:"user_id"`
+	TokenHash string    `json:"token_hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CalendarOptions narrow a feed to one project or assignee.
+type CalendarOptions struct {
+	Name      string // shown as the calendar's name
+	ProjectID int
+	Assignee  string
+	Todo      bool // VTODO instead of VEVENT
+}
+
+// newCalendarToken creates the user's calendar token, replacing any
+// earlier one.
+func newCalendarToken(appData *AppData, userID int) (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := calendarTokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	revokeCalendarToken(appData, userID)
+	appData.CalendarTokens = append(appData.CalendarTokens, CalendarToken{
+		UserID:    userID,
+		TokenHash: hashToken(token),
+		CreatedAt: time.Now(),
+	})
+	return token, nil
+}
+
+func revokeCalendarToken(appData *AppData, userID int) bool {
+	kept := appData.CalendarTokens[:0]
+	for _, c := range appData.CalendarTokens {
+		if c.UserID != userID {
+			kept = append(kept, c)
+		}
+	}
+	revoked := len(kept) < len(appData.CalendarTokens)
+	appData.CalendarTokens = kept
+	return revoked
+}
+
+// calendarUser returns the owner of a calendar token.
+func calendarUser(appData *AppData, token string) *User {
+	hash := hashToken(token)
+	for _, c := range appData.CalendarTokens {
+		if c.TokenHash == hash {
+			return findUserByID(appData, c.UserID)
+		}
+	}
+	return nil
+}
+
+// calendarURL is the address to subscribe to, as seen by the client of r.
+func calendarURL(r *http.Request, token string) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s?token=%s", scheme, r.Host, calendarPath, token)
+}
+
+// icalPriorities maps priorities to iCalendar's 1 (highest) to 9 (lowest).
+var icalPriorities = map[Priority]int{Urgent: 1, High: 3, Medium: 5, Low: 9}
+
+// renderCalendar writes the tasks with a due date as an iCalendar file.
+func renderCalendar(appData *AppData, opts CalendarOptions) string {
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		b.WriteString(foldICalLine(fmt.Sprintf(format, args...)))
+	}
+	now := time.Now().UTC().Format("20060102T150405Z")
+	names := projectNames(appData.Projects)
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//taskmanager//Task Manager//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:%s", icalText(opts.Name))
+
+	for _, t := range appData.Tasks {
+		if t.DueDate == nil ||
+			opts.ProjectID != 0 && t.ProjectID != opts.ProjectID ||
+			opts.Assignee != "" && !strings.EqualFold(t.Assignee, opts.Assignee) {
+			continue
+		}
+		component := "VEVENT"
+		if opts.Todo {
+			component = "VTODO"
+		}
+		due := *t.DueDate
+		allDay := !hasClockTime(due)
+
+		line("BEGIN:%s", component)
+		line("UID:task-%d@taskmanager", t.ID)
+		line("DTSTAMP:%s", now)
+		line("CREATED:%s", t.CreatedAt.UTC().Format("20060102T150405Z"))
+		summary := t.Description
+		if t.Done && !opts.Todo {
+			summary = "✓ " + summary
+		}
+		line("SUMMARY:%s", icalText(summary))
+		line("DESCRIPTION:%s", icalText(calendarDescription(t, names[t.ProjectID])))
+		line("PRIORITY:%d", icalPriorities[t.Priority])
+
+		switch {
+		case opts.Todo && allDay:
+			line("DTSTART;VALUE=DATE:%s", due.Format("20060102"))
+			line("DUE;VALUE=DATE:%s", due.AddDate(0, 0, 1).Format("20060102"))
+		case opts.Todo:
+			line("DTSTART:%s", due.UTC().Format("20060102T150405Z"))
+			line("DUE:%s", due.UTC().Format("20060102T150405Z"))
+		case allDay:
+			line("DTSTART;VALUE=DATE:%s", due.Format("20060102"))
+			line("DTEND;VALUE=DATE:%s", due.AddDate(0, 0, 1).Format("20060102"))
+		default:
+			line("DTSTART:%s", due.UTC().Format("20060102T150405Z"))
+			line("DTEND:%s", due.Add(30*time.Minute).UTC().Format("20060102T150405Z"))
+		}
+		if t.Recurrence != nil {
+			line("RRULE:%s", calendarRRule(*t.Recurrence, allDay))
+		}
+
+		status := taskStatus(t)
+		if opts.Todo {
+			switch status {
+			case StatusDone:
+				line("STATUS:COMPLETED")
+				line("PERCENT-COMPLETE:100")
+				if t.CompletedAt != nil {
+					line("COMPLETED:%s", t.CompletedAt.UTC().Format("20060102T150405Z"))
+				}
+			case StatusInProgress, StatusInReview:
+				line("STATUS:IN-PROCESS")
+			default:
+				line("STATUS:NEEDS-ACTION")
+			}
+		} else {
+			// VEVENT has no COMPLETED status: calendar apps show the "✓"
+			// in the summary, and the X- property keeps the real one.
+			line("STATUS:CONFIRMED")
+			if status == StatusDone {
+				line("X-TASKMANAGER-STATUS:COMPLETED")
+			} else {
+				line("X-TASKMANAGER-STATUS:%s", strings.ToUpper(string(status)))
+			}
+		}
+
+		var categories []string
+		if t.Category != "" {
+			categories = append(categories, icalText(t.Category))
+		}
+		for _, tag := range t.Tags {
+			categories = append(categories, icalText(tag))
+		}
+		if len(categories) > 0 {
+			line("CATEGORIES:%s", strings.Join(categories, ","))
+		}
+		line("END:%s", component)
+	}
+	line("END:VCALENDAR")
+	return b.String()
+}
+
+func calendarDescription(t Task, project string) string {
+	parts := []string{
+		"Status: " + strings.ReplaceAll(string(taskStatus(t)), "_", " "),
+		"Priority: " + strings.ToLower(t.Priority.String()),
+	}
+	if project != "" {
+		parts = append(parts, "Project: "+project)
+	}
+	if t.Assignee != "" {
+		parts = append(parts, "Assignee: "+t.Assignee)
+	}
+	if t.Recurrence != nil {
+		parts = append(parts, "Repeats "+t.Recurrence.String())
+	}
+	return strings.Join(parts, "\n")
+}
+
+// calendarRRule is Recurrence.RRule with UNTIL as a date for all-day
+// events, since it has to match DTSTART.
+func calendarRRule(rec Recurrence, allDay bool) string {
+	if !allDay || rec.Until == nil {
+		return rec.RRule()
+	}
+	until := *rec.Until
+	rec.Until = nil
+	return rec.RRule() + ";UNTIL=" + until.Format("20060102")
+}
+
+// icalText escapes a TEXT value.
+func icalText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// foldICalLine ends a content line with CRLF, folding it so no line is
+// longer than 75 octets, without splitting a UTF-8 character.
+func foldICalLine(s string) string {
+	var b strings.Builder
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		b.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+		limit = 74 // the leading space counts
+	}
+	b.WriteString(s + "\r\n")
+	return b.String()
+}
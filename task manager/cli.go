@@ -0,0 +1,2140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cliCommands are the one-shot subcommands accepted on the command line,
+// e.g. "taskmanager list --status todo --json". Anything else starts the
+// interactive REPL (no arguments) or is rejected.
+var cliCommands = map[string]bool{
+	"add": true, "create": true, "list": true, "view": true, "done": true,
+	"undone": true, "delete": true, "priority": true, "due": true,
+	"search": true, "stats": true, "projects": true, "timer": true,
+	"checklist": true, "deps": true, "critical-path": true, "user": true,
+	"members": true, "activity": true, "trash": true, "export": true,
+	"import": true, "calendar": true, "remind": true, "webhook": true,
+	"timesheet": true, "analytics": true,
+}
+
+// runCLI executes a one-shot command and returns the process exit code.
+// With --json, results and errors are written to stdout as an APIResponse
+// so scripts get the same envelope as the HTTP API.
+func runCLI(args []string) int {
+	args, asJSON := extractFlag(args, "--json")
+	data, message, err := runCommand(args)
+	if err != nil {
+		if asJSON {
+			writeJSON(APIResponse{Success: false, Message: err.Error()})
+		} else {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		return 1
+	}
+	if asJSON {
+		writeJSON(APIResponse{Success: true, Message: message, Data: data})
+		return 0
+	}
+	printCommandResult(data, message)
+	return 0
+}
+
+func writeJSON(response APIResponse) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(response)
+}
+
+// extractFlag removes every occurrence of a boolean flag from args.
+func extractFlag(args []string, name string) ([]string, bool) {
+	out := make([]string, 0, len(args))
+	found := false
+	for _, arg := range args {
+		if arg == name {
+			found = true
+			continue
+		}
+		out = append(out, arg)
+	}
+	return out, found
+}
+
+func parseIDArg(args []string, command string) (int, error) {
+	if len(args) < 1 {
+		return 0, fmt.Errorf("%s requires an id", command)
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, errors.New("id must be a number")
+	}
+	return id, nil
+}
+
+// runCommand performs a command and returns its result for printing.
+func runCommand(args []string) (interface{}, string, error) {
+	cmd, args := args[0], args[1:]
+
+	switch cmd {
+	case "add", "create":
+		input, err := parseCreateCommand(args)
+		if err != nil {
+			return nil, "", err
+		}
+		task, err := saveNewTask(input)
+		if err != nil {
+			return nil, "", err
+		}
+		return task, fmt.Sprintf("✓ Added #%d: %s", task.ID, task.Description), nil
+
+	case "list":
+		page, err := queryTasksCommand(args)
+		return page, "", err
+
+	case "view":
+		id, err := parseIDArg(args, "view")
+		if err != nil {
+			return nil, "", err
+		}
+		detail, err := taskDetail(id)
+		if err != nil {
+			return nil, "", err
+		}
+		return detail, "", nil
+
+	case "done":
+		args, force := extractFlag(args, "--force")
+		id, err := parseIDArg(args, "done")
+		if err != nil {
+			return nil, "", err
+		}
+		task, next, err := completeTask(id, force)
+		if err != nil {
+			return nil, "", err
+		}
+		if next != nil {
+			return task, fmt.Sprintf("✓ Completed #%d\n↻ Next occurrence #%d due %s", id, next.ID, formatDue(*next.DueDate)), nil
+		}
+		return task, fmt.Sprintf("✓ Completed #%d", id), nil
+
+	case "undone":
+		id, err := parseIDArg(args, "undone")
+		if err != nil {
+			return nil, "", err
+		}
+		task, err := modifyTask(id, func(t *Task) error {
+			setTaskStatus(t, StatusTodo)
+			return nil
+		})
+		return task, fmt.Sprintf("✓ Reopened #%d", id), err
+
+	case "delete":
+		id, err := parseIDArg(args, "delete")
+		if err != nil {
+			return nil, "", err
+		}
+		item, err := removeTask(id)
+		if err != nil {
+			return nil, "", err
+		}
+		return item.Tasks[0], deletedMessage(item), nil
+
+	case "priority":
+		id, err := parseIDArg(args, "priority")
+		if err != nil {
+			return nil, "", err
+		}
+		if len(args) < 2 {
+			return nil, "", errors.New("priority requires an id and priority level")
+		}
+		priority := parsePriority(args[1])
+		task, err := modifyTask(id, func(t *Task) error {
+			t.Priority = priority
+			return nil
+		})
+		return task, fmt.Sprintf("✓ Updated #%d priority to %s", id, priority), err
+
+	case "due":
+		id, err := parseIDArg(args, "due")
+		if err != nil {
+			return nil, "", err
+		}
+		if len(args) < 2 {
+			return nil, "", errors.New("due requires an id and date")
+		}
+		dueDate, err := parseDate(strings.Join(args[1:], " "))
+		if err != nil {
+			return nil, "", err
+		}
+		task, err := modifyTask(id, func(t *Task) error {
+			t.DueDate = dueDate
+			return nil
+		})
+		return task, fmt.Sprintf("✓ Set due date for #%d to %s", id, formatDue(*dueDate)), err
+
+	case "search":
+		if len(args) < 1 {
+			return nil, "", errors.New("search requires a query")
+		}
+		return runSearchCommand(args)
+
+	case "stats":
+		tasks, err := loadTasks()
+		if err != nil {
+			return nil, "", err
+		}
+		return computeTaskStats(tasks), "", nil
+
+	case "projects":
+		return runProjectsCommand(args)
+
+	case "timesheet":
+		return runTimesheetCommand(args)
+
+	case "analytics":
+		flags, words, err := transferFlags(args, "--from", "--to", "--project", "--assignee")
+		if err != nil {
+			return nil, "", err
+		}
+		if len(words) > 0 {
+			return nil, "", errors.New("usage: analytics [--from d] [--to d] [--project p] [--assignee a]")
+		}
+		appData, err := loadAppData()
+		if err != nil {
+			return nil, "", err
+		}
+		values := map[string]string{}
+		for name, v := range flags {
+			values[strings.TrimPrefix(name, "--")] = v
+		}
+		if strings.EqualFold(values["assignee"], "me") {
+			values["assignee"] = currentUser()
+		}
+		opts, err := parseAnalyticsOptions(appData, values, time.Now())
+		if err != nil {
+			return nil, "", err
+		}
+		return buildAnalytics(appData, opts), "", nil
+
+	case "timer":
+		return runTimerCommand(args)
+
+	case "checklist":
+		return runChecklistCommand(args)
+
+	case "deps":
+		return runDepsCommand(args)
+
+	case "user":
+		return runUserCommand(args)
+
+	case "members":
+		return runMembersCommand(args)
+
+	case "activity":
+		return runActivityCommand(args)
+
+	case "trash":
+		return runTrashCommand(args)
+
+	case "export":
+		return runExportCommand(args)
+
+	case "import":
+		return runImportCommand(args)
+
+	case "calendar":
+		return runCalendarCommand(args)
+
+	case "remind":
+		return runRemindCommand(args)
+
+	case "webhook":
+		return runWebhookCommand(args)
+
+	case "critical-path":
+		if len(args) < 1 {
+			return nil, "", errors.New("critical-path requires a project name or id")
+		}
+		appData, err := loadAppData()
+		if err != nil {
+			return nil, "", err
+		}
+		project := findProject(appData, 0, strings.Join(args, " "))
+		if project == nil {
+			return nil, "", fmt.Errorf("project %q not found", strings.Join(args, " "))
+		}
+		return criticalPath(appData, project.ID), "", nil
+	}
+
+	return nil, "", fmt.Errorf("unknown command: %s", cmd)
+}
+
+// queryTasksCommand implements "list" and "search": query terms (see
+// query.go) plus [--status s] [--project p] [--category c] [--tag t]
+// [--sort keys] [--limit n] [--offset n | --page n].
+func queryTasksCommand(args []string) (TaskPage, error) {
+	opts := TaskQueryOptions{User: currentUser()}
+	page := 0
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "--") && args[i] != "-s" && args[i] != "-c" && args[i] != "-t" {
+			opts.Query = append(opts.Query, args[i])
+			continue
+		}
+		if i+1 >= len(args) {
+			return TaskPage{}, fmt.Errorf("%s requires a value", args[i])
+		}
+		value := args[i+1]
+		switch args[i] {
+		case "--status", "-s":
+			opts.Query = append(opts.Query, "status:"+value)
+		case "--project":
+			opts.Query = append(opts.Query, "project:"+value)
+		case "--category", "-c":
+			opts.Query = append(opts.Query, "category:"+value)
+		case "--tag", "-t":
+			opts.Query = append(opts.Query, "tag:"+value)
+		case "--sort":
+			opts.Sort = value
+		case "--limit", "--offset", "--page":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return TaskPage{}, fmt.Errorf("%s must be a non-negative number", args[i])
+			}
+			switch args[i] {
+			case "--limit":
+				opts.Limit = n
+			case "--offset":
+				opts.Offset = n
+			default:
+				page = n
+			}
+		default:
+			return TaskPage{}, fmt.Errorf("unknown option %s", args[i])
+		}
+		i++
+	}
+	if page > 0 {
+		if opts.Limit == 0 {
+			opts.Limit = 20
+		}
+		opts.Offset = (page - 1) * opts.Limit
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		return TaskPage{}, err
+	}
+	return findTasks(appData, opts)
+}
+
+// runSearchCommand implements "search <words> [filters] [--limit n]
+// [--offset n]": ranked full-text search, narrowed by query-language terms.
+func runSearchCommand(args []string) (interface{}, string, error) {
+	var terms []string
+	offset, limit := 0, 20
+	for i := 0; i < len(args); i++ {
+		if args[i] != "--limit" && args[i] != "--offset" {
+			terms = append(terms, args[i])
+			continue
+		}
+		if i+1 >= len(args) {
+			return nil, "", fmt.Errorf("%s requires a value", args[i])
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil || n < 0 {
+			return nil, "", fmt.Errorf("%s must be a non-negative number", args[i])
+		}
+		if args[i] == "--limit" {
+			limit = n
+		} else {
+			offset = n
+		}
+		i++
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		return nil, "", err
+	}
+	results, err := searchTasks(appData, terms, offset, limit, currentUser(), nil)
+	return results, "", err
+}
+
+// runActivityCommand implements "activity <project> [--limit n] [--offset n]",
+// the project's activity feed, newest first.
+func runActivityCommand(args []string) (interface{}, string, error) {
+	var words []string
+	offset, limit := 0, 20
+	for i := 0; i < len(args); i++ {
+		if args[i] != "--limit" && args[i] != "--offset" {
+			words = append(words, args[i])
+			continue
+		}
+		if i+1 >= len(args) {
+			return nil, "", fmt.Errorf("%s requires a value", args[i])
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil || n < 0 {
+			return nil, "", fmt.Errorf("%s must be a non-negative number", args[i])
+		}
+		if args[i] == "--limit" {
+			limit = n
+		} else {
+			offset = n
+		}
+		i++
+	}
+	if len(words) == 0 {
+		return nil, "", errors.New("activity requires a project name or id")
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		return nil, "", err
+	}
+	project := findProject(appData, 0, strings.Join(words, " "))
+	if project == nil {
+		return nil, "", fmt.Errorf("project %q not found", strings.Join(words, " "))
+	}
+	return projectActivity(appData, project.ID, offset, limit), "", nil
+}
+
+// transferFlags reads the options shared by export and import: flags that
+// take a value, boolean flags, and the remaining words.
+func transferFlags(args []string, valued ...string) (map[string]string, []string, error) {
+	values := map[string]string{}
+	var words []string
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		known := false
+		for _, v := range valued {
+			known = known || name == v
+		}
+		switch {
+		case known && hasValue:
+			values[name] = value
+		case known:
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("%s requires a value", name)
+			}
+			values[name] = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--"):
+			values[args[i]] = "true"
+		default:
+			words = append(words, args[i])
+		}
+	}
+	return values, words, nil
+}
+
+// runExportCommand implements "export [--format f] [--project p]
+// [--output file]", writing to standard output unless --output is given.
+func runExportCommand(args []string) (interface{}, string, error) {
+	flags, words, err := transferFlags(args, "--format", "--project", "--output")
+	if err != nil {
+		return nil, "", err
+	}
+	if len(words) > 0 {
+		return nil, "", errors.New("usage: export [--format json|csv|md|todotxt] [--project p] [--output file]")
+	}
+	output := flags["--output"]
+	format := flags["--format"]
+	if format == "" && output != "" && strings.Contains(output, ".") {
+		format = output[strings.LastIndex(output, ".")+1:]
+	}
+	if format == "" {
+		format = "json"
+	}
+	if format, err = parseExportFormat(format); err != nil {
+		return nil, "", err
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		return nil, "", err
+	}
+	projectID := 0
+	if name := flags["--project"]; name != "" {
+		project := findProject(appData, 0, name)
+		if project == nil {
+			return nil, "", fmt.Errorf("project %q not found", name)
+		}
+		projectID = project.ID
+	}
+	data, err := collectExport(appData, projectID)
+	if err != nil {
+		return nil, "", err
+	}
+	content, err := renderExport(data, format)
+	if err != nil {
+		return nil, "", err
+	}
+	if output == "" || output == "-" {
+		return nil, strings.TrimRight(string(content), "\n"), nil
+	}
+	if err := os.WriteFile(output, content, 0644); err != nil {
+		return nil, "", err
+	}
+	return nil, fmt.Sprintf("✓ Exported %d task(s) to %s", len(data.Tasks), output), nil
+}
+
+// runImportCommand implements "import <file|-> [--format f] [--project p]
+// [--dry-run]". The format defaults to the file's extension.
+func runImportCommand(args []string) (interface{}, string, error) {
+	flags, words, err := transferFlags(args, "--format", "--project")
+	if err != nil {
+		return nil, "", err
+	}
+	if len(words) != 1 {
+		return nil, "", errors.New("usage: import <file|-> [--format json|csv|md|todotxt] [--project p] [--dry-run]")
+	}
+	path := words[0]
+	format := flags["--format"]
+	if format == "" && strings.Contains(path, ".") {
+		format = path[strings.LastIndex(path, ".")+1:]
+	}
+	if format == "" {
+		return nil, "", errors.New("import needs --format when the file name has no extension")
+	}
+	if format, err = parseExportFormat(format); err != nil {
+		return nil, "", err
+	}
+
+	var content []byte
+	if path == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := parseImport(content, format)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var report ImportReport
+	run := func(appData *AppData) error {
+		opts := ImportOptions{DryRun: flags["--dry-run"] != ""}
+		if name := flags["--project"]; name != "" {
+			project := findProject(appData, 0, name)
+			if project == nil {
+				return fmt.Errorf("project %q not found", name)
+			}
+			opts.ProjectID = project.ID
+		}
+		var err error
+		report, err = importData(appData, data, opts)
+		return err
+	}
+	if flags["--dry-run"] != "" {
+		appData, err := loadAppData()
+		if err != nil {
+			return nil, "", err
+		}
+		err = run(appData)
+		return report, "", err
+	}
+	return report, "", updateAppData(run)
+}
+
+// runCalendarCommand implements "calendar [--project p] [--assignee a]
+// [--todo] [--output file]", the iCalendar feed of the web server as a file.
+func runCalendarCommand(args []string) (interface{}, string, error) {
+	flags, words, err := transferFlags(args, "--project", "--assignee", "--output")
+	if err != nil {
+		return nil, "", err
+	}
+	if len(words) > 0 {
+		return nil, "", errors.New("usage: calendar [--project p] [--assignee a] [--todo] [--output file]")
+	}
+	appData, err := loadAppData()
+	if err != nil {
+		return nil, "", err
+	}
+	opts := CalendarOptions{Name: "Tasks", Assignee: flags["--assignee"], Todo: flags["--todo"] != ""}
+	if name := flags["--project"]; name != "" {
+		project := findProject(appData, 0, name)
+		if project == nil {
+			return nil, "", fmt.Errorf("project %q not found", name)
+		}
+		opts.ProjectID, opts.Name = project.ID, project.Name
+	}
+	content := renderCalendar(appData, opts)
+	output := flags["--output"]
+	if output == "" || output == "-" {
+		return nil, strings.TrimRight(content, "\r\n"), nil
+	}
+	if err := os.WriteFile(output, []byte(content), 0644); err != nil {
+		return nil, "", err
+	}
+	return nil, fmt.Sprintf("✓ Wrote %s", output), nil
+}
+
+// runRemindCommand shows upcoming reminders, sends the due ones, snoozes
+// them and edits preferences. Without a user it works on the current
+// user's account, or on the reminders of tasks not assigned to an account.
+func runRemindCommand(args []string) (interface{}, string, error) {
+	flags, words, err := transferFlags(args, "--days", "--offsets", "--channels", "--email", "--webhook")
+	if err != nil {
+		return nil, "", err
+	}
+	action := "list"
+	if len(words) > 0 {
+		action, words = words[0], words[1:]
+	}
+	appData, err := loadAppData()
+	if err != nil {
+		return nil, "", err
+	}
+	username := ""
+	if user := findUser(appData, currentUser()); user != nil {
+		username = user.Username
+	}
+	if (action == "list" || action == "prefs") && len(words) > 0 {
+		user := findUser(appData, words[0])
+		if user == nil {
+			return nil, "", fmt.Errorf("user %q not found", words[0])
+		}
+		username = user.Username
+	}
+
+	switch action {
+	case "list":
+		days := 7
+		if v := flags["--days"]; v != "" {
+			if days, err = strconv.Atoi(v); err != nil || days < 1 {
+				return nil, "", errors.New("--days must be a positive number")
+			}
+		}
+		info := remindersInfo(appData, username, time.Now())
+		info.Upcoming = upcomingReminders(appData, username, time.Now(), time.Now().AddDate(0, 0, days))
+		return info, "", nil
+
+	case "check":
+		sent, err := checkReminders(loadNotifiers(), time.Now())
+		if err != nil {
+			return nil, "", err
+		}
+		return nil, fmt.Sprintf("✓ Sent %d reminder(s)", sent), nil
+
+	case "snooze", "unsnooze":
+		id, err := parseIDArg(words, "remind "+action)
+		if err != nil {
+			return nil, "", err
+		}
+		if action == "snooze" && len(words) < 2 {
+			return nil, "", errors.New(`usage: remind snooze <task-id> <2h | "tomorrow 9am">`)
+		}
+		var message string
+		err = updateAppData(func(appData *AppData) error {
+			task := findTask(appData, id)
+			if task == nil {
+				return fmt.Errorf("task #%d not found", id)
+			}
+			recipient := reminderRecipient(appData, *task)
+			if action == "unsnooze" {
+				if !unsnoozeTask(appData, id, recipient) {
+					return fmt.Errorf("task #%d is not snoozed", id)
+				}
+				message = fmt.Sprintf("✓ Reminders for #%d are back on", id)
+				return nil
+			}
+			until, err := parseSnooze(strings.Join(words[1:], " "), time.Now())
+			if err != nil {
+				return err
+			}
+			message = fmt.Sprintf("✓ Snoozed #%d until %s", id, until.Format("2006-01-02 15:04"))
+			return snoozeTask(appData, id, recipient, until)
+		})
+		return nil, message, err
+
+	case "prefs":
+		prefs := reminderPrefs(appData, username)
+		if len(flags) == 0 {
+			return prefs, "", nil
+		}
+		if v, ok := flags["--offsets"]; ok {
+			prefs.Offsets = strings.Split(v, ",")
+		}
+		if v, ok := flags["--channels"]; ok {
+			prefs.Channels = strings.Split(v, ",")
+		}
+		if v, ok := flags["--email"]; ok {
+			prefs.Email = v
+		}
+		if v, ok := flags["--webhook"]; ok {
+			prefs.WebhookURL = v
+		}
+		if flags["--mute"] != "" {
+			prefs.Muted = true
+		}
+		if flags["--unmute"] != "" {
+			prefs.Muted = false
+		}
+		err := updateAppData(func(appData *AppData) error {
+			return setReminderPrefs(appData, prefs)
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		appData, err := loadAppData()
+		if err != nil {
+			return nil, "", err
+		}
+		return reminderPrefs(appData, username), "", nil
+	}
+	return nil, "", errors.New("usage: remind [list [user] [--days n] | check | snooze <task-id> <when> | unsnooze <task-id> | prefs [user] [options]]")
+}
+
+// runWebhookCommand lists, adds, changes and removes webhooks and shows
+// their deliveries. The web server sends the deliveries.
+func runWebhookCommand(args []string) (interface{}, string, error) {
+	flags, words, err := transferFlags(args, "--events", "--secret")
+	if err != nil {
+		return nil, "", err
+	}
+	usage := errors.New("usage: webhook [list [project]] | webhook add <project> <url> [--events e1,e2] [--secret s] | " +
+		"webhook enable|disable|remove <id> | webhook deliveries <id> | webhook redeliver <id> <delivery>")
+	action := "list"
+	if len(words) > 0 {
+		action, words = words[0], words[1:]
+	}
+	appData, err := loadAppData()
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch action {
+	case "list":
+		hooks := []Webhook{}
+		var project *Project
+		if len(words) > 0 {
+			if project = findProject(appData, 0, strings.Join(words, " ")); project == nil {
+				return nil, "", fmt.Errorf("project %q not found", strings.Join(words, " "))
+			}
+		}
+		for _, h := range appData.Webhooks {
+			if project == nil || h.ProjectID == project.ID {
+				hooks = append(hooks, h.Public())
+			}
+		}
+		return hooks, "", nil
+
+	case "add":
+		if len(words) < 2 {
+			return nil, "", usage
+		}
+		project := findProject(appData, 0, words[0])
+		if project == nil {
+			return nil, "", fmt.Errorf("project %q not found", words[0])
+		}
+		hook := Webhook{ProjectID: project.ID, URL: words[1], Secret: flags["--secret"], CreatedBy: cliActor()}
+		if v := flags["--events"]; v != "" {
+			hook.Events = strings.Split(v, ",")
+		}
+		err := updateAppData(func(appData *AppData) error {
+			var err error
+			hook, err = addWebhook(appData, hook)
+			return err
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		return hook, fmt.Sprintf("✓ Added webhook #%d for %s (%s)\n  secret: %s", hook.ID, project.Name, strings.Join(hook.Events, ", "), hook.Secret), nil
+
+	case "enable", "disable", "remove", "deliveries":
+		id, err := parseIDArg(words, "webhook "+action)
+		if err != nil {
+			return nil, "", err
+		}
+		if findWebhook(appData, id) == nil {
+			return nil, "", fmt.Errorf("webhook #%d not found", id)
+		}
+		if action == "deliveries" {
+			return webhookDeliveries(appData, id), "", nil
+		}
+		err = updateAppData(func(appData *AppData) error {
+			if action == "remove" {
+				removeWebhook(appData, id)
+				return nil
+			}
+			h := findWebhook(appData, id)
+			if h == nil {
+				return fmt.Errorf("webhook #%d not found", id)
+			}
+			h.Active = action == "enable"
+			return nil
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		verbs := map[string]string{"enable": "Enabled", "disable": "Disabled", "remove": "Removed"}
+		return nil, fmt.Sprintf("✓ %s webhook #%d", verbs[action], id), nil
+
+	case "redeliver":
+		if len(words) < 2 {
+			return nil, "", usage
+		}
+		id, err1 := strconv.Atoi(words[0])
+		deliveryID, err2 := strconv.Atoi(words[1])
+		if err1 != nil || err2 != nil {
+			return nil, "", errors.New("ids must be numbers")
+		}
+		err := updateAppData(func(appData *AppData) error {
+			_, err := redeliver(appData, id, deliveryID)
+			return err
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		return nil, fmt.Sprintf("✓ Delivery %d queued; the server sends it", deliveryID), nil
+	}
+	return nil, "", usage
+}
+
+func hasTag(t Task, tag string) bool {
+	for _, tg := range t.Tags {
+		if strings.EqualFold(tg, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// taskMatches reports whether the lower-cased query occurs in the task's
+// description, category or tags.
+func taskMatches(t Task, query string) bool {
+	if strings.Contains(strings.ToLower(t.Description), query) ||
+		strings.Contains(strings.ToLower(t.Category), query) {
+		return true
+	}
+	for _, tag := range t.Tags {
+		if strings.Contains(strings.ToLower(tag), query) {
+			return true
+		}
+	}
+	return false
+}
+
+type TaskStats struct {
+	Total      int            `json:"total_tasks"`
+	Completed  int            `json:"completed_tasks"`
+	Pending    int            `json:"pending_tasks"`
+	Overdue    int            `json:"overdue_tasks"`
+	ByPriority map[string]int `json:"by_priority"`
+	Categories map[string]int `json:"categories"`
+}
+
+func computeTaskStats(tasks []Task) TaskStats {
+	stats := TaskStats{
+		Total:      len(tasks),
+		ByPriority: map[string]int{"low": 0, "medium": 0, "high": 0, "urgent": 0},
+		Categories: make(map[string]int),
+	}
+	for _, t := range tasks {
+		if t.Done {
+			stats.Completed++
+		} else {
+			stats.ByPriority[strings.ToLower(t.Priority.String())]++
+			if t.DueDate != nil && t.DueDate.Before(time.Now()) {
+				stats.Overdue++
+			}
+		}
+		if t.Category != "" {
+			stats.Categories[t.Category]++
+		}
+	}
+	stats.Pending = stats.Total - stats.Completed
+	return stats
+}
+
+// runTimerCommand implements "timer start <task-id> [--note text]",
+// "timer stop [entry-id]" and "timer status".
+func runTimerCommand(args []string) (interface{}, string, error) {
+	if len(args) < 1 {
+		return nil, "", errors.New("timer requires start, stop or status")
+	}
+	flags, words, err := transferFlags(args[1:], "--note", "--user", "--task", "--start", "--end")
+	if err != nil {
+		return nil, "", err
+	}
+	user := currentUser()
+	if v, ok := flags["--user"]; ok {
+		user = v
+	}
+
+	switch args[0] {
+	case "start":
+		taskID, err := parseIDArg(words, "timer start")
+		if err != nil {
+			return nil, "", err
+		}
+		var entry, stopped TimeEntry
+		err = updateAppData(func(appData *AppData) error {
+			started, previous, err := startTimerEntry(appData, taskID, user, flags["--note"], flags["--no-auto-stop"] == "")
+			if err != nil {
+				return err
+			}
+			entry = *started
+			if previous != nil {
+				stopped = *previous
+			}
+			return nil
+		})
+		message := fmt.Sprintf("⏱ Started timer #%d on task #%d", entry.ID, taskID)
+		if stopped.ID != 0 {
+			message += fmt.Sprintf(" (stopped #%d on task #%d after %s)", stopped.ID, stopped.TaskID, time.Duration(stopped.Duration)*time.Second)
+		}
+		return entry, message, err
+
+	case "stop":
+		var entry TimeEntry
+		err := updateAppData(func(appData *AppData) error {
+			id := 0
+			if len(words) > 0 {
+				var err error
+				if id, err = parseIDArg(words, "timer stop"); err != nil {
+					return err
+				}
+			} else if running := runningTimer(appData, user); running != nil {
+				id = running.ID
+			} else {
+				return errors.New("no timer is running")
+			}
+			stopped, err := stopTimerEntry(appData, id)
+			if err != nil {
+				return err
+			}
+			entry = *stopped
+			return nil
+		})
+		return entry, fmt.Sprintf("⏹ Stopped timer #%d after %s", entry.ID, time.Duration(entry.Duration)*time.Second), err
+
+	case "pause", "resume":
+		var entry TimeEntry
+		err := updateAppData(func(appData *AppData) error {
+			running := runningTimer(appData, user)
+			if running == nil {
+				return errors.New("no timer is running")
+			}
+			var changed *TimeEntry
+			var err error
+			if args[0] == "pause" {
+				changed, err = pauseTimerEntry(appData, running.ID)
+			} else {
+				changed, err = resumeTimerEntry(appData, running.ID)
+			}
+			if err != nil {
+				return err
+			}
+			entry = *changed
+			return nil
+		})
+		if args[0] == "pause" {
+			return entry, fmt.Sprintf("⏸ Paused timer #%d at %s", entry.ID, time.Duration(entry.Duration)*time.Second), err
+		}
+		return entry, fmt.Sprintf("▶ Resumed timer #%d", entry.ID), err
+
+	case "add":
+		// timer add <task-id> <start> <end | duration>
+		if len(words) < 3 {
+			return nil, "", errors.New(`usage: timer add <task-id> <start> <end|duration> [--note text], e.g. timer add 4 "today 9am" 1h30m`)
+		}
+		taskID, err := parseIDArg(words, "timer add")
+		if err != nil {
+			return nil, "", err
+		}
+		start, err := parseEntryTime(words[1], time.Time{})
+		if err != nil {
+			return nil, "", err
+		}
+		end, err := parseEntryTime(strings.Join(words[2:], " "), start)
+		if err != nil {
+			return nil, "", err
+		}
+		var entry TimeEntry
+		err = updateAppData(func(appData *AppData) error {
+			added, err := addTimeEntry(appData, TimeEntry{TaskID: taskID, User: user, StartTime: start, EndTime: &end, Note: flags["--note"], Billable: billableFlag(flags)})
+			if err != nil {
+				return err
+			}
+			entry = *added
+			return nil
+		})
+		return entry, fmt.Sprintf("✓ Added time entry #%d: %s on task #%d", entry.ID, time.Duration(entry.Duration)*time.Second, taskID), err
+
+	case "edit":
+		id, err := parseIDArg(words, "timer edit")
+		if err != nil {
+			return nil, "", err
+		}
+		var patch TimeEntryPatch
+		if v, ok := flags["--task"]; ok {
+			taskID, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, "", errors.New("--task must be a task id")
+			}
+			patch.TaskID = &taskID
+		}
+		if v, ok := flags["--start"]; ok {
+			start, err := parseEntryTime(v, time.Time{})
+			if err != nil {
+				return nil, "", err
+			}
+			patch.StartTime = &start
+		}
+		if v, ok := flags["--note"]; ok {
+			patch.Note = &v
+		}
+		patch.Billable = billableFlag(flags)
+		var entry TimeEntry
+		err = updateAppData(func(appData *AppData) error {
+			if v, ok := flags["--end"]; ok {
+				e := findTimeEntry(appData, id)
+				if e == nil {
+					return fmt.Errorf("time entry #%d not found", id)
+				}
+				from := e.StartTime
+				if patch.StartTime != nil {
+					from = *patch.StartTime
+				}
+				end, err := parseEntryTime(v, from)
+				if err != nil {
+					return err
+				}
+				patch.EndTime = &end
+			}
+			edited, err := editTimeEntry(appData, id, patch)
+			if err != nil {
+				return err
+			}
+			entry = *edited
+			return nil
+		})
+		return entry, fmt.Sprintf("✓ Updated time entry #%d (%s)", id, time.Duration(entry.Duration)*time.Second), err
+
+	case "split":
+		id, err := parseIDArg(words, "timer split")
+		if err != nil {
+			return nil, "", err
+		}
+		if len(words) < 2 {
+			return nil, "", errors.New("usage: timer split <entry-id> <time>")
+		}
+		var first, second TimeEntry
+		err = updateAppData(func(appData *AppData) error {
+			e := findTimeEntry(appData, id)
+			if e == nil {
+				return fmt.Errorf("time entry #%d not found", id)
+			}
+			at, err := parseEntryTime(strings.Join(words[1:], " "), e.StartTime)
+			if err != nil {
+				return err
+			}
+			first, second, err = splitTimeEntry(appData, id, at)
+			return err
+		})
+		return []TimeEntry{first, second}, fmt.Sprintf("✓ Split #%d into #%d (%s) and #%d (%s)", id,
+			first.ID, time.Duration(first.Duration)*time.Second, second.ID, time.Duration(second.Duration)*time.Second), err
+
+	case "delete":
+		id, err := parseIDArg(words, "timer delete")
+		if err != nil {
+			return nil, "", err
+		}
+		err = updateAppData(func(appData *AppData) error {
+			if !deleteTimeEntry(appData, id) {
+				return fmt.Errorf("time entry #%d not found", id)
+			}
+			return nil
+		})
+		return nil, fmt.Sprintf("✓ Deleted time entry #%d", id), err
+
+	case "list":
+		appData, err := loadAppData()
+		if err != nil {
+			return nil, "", err
+		}
+		entries := []TimeEntry{}
+		taskID := 0
+		if len(words) > 0 {
+			if taskID, err = parseIDArg(words, "timer list"); err != nil {
+				return nil, "", err
+			}
+		}
+		for _, e := range appData.TimeEntries {
+			if taskID == 0 || e.TaskID == taskID {
+				entries = append(entries, e)
+			}
+		}
+		return entries, "", nil
+
+	case "status":
+		appData, err := loadAppData()
+		if err != nil {
+			return nil, "", err
+		}
+		if flags["--all"] != "" {
+			var lines []string
+			for _, e := range appData.TimeEntries {
+				if e.EndTime == nil {
+					lines = append(lines, fmt.Sprintf("⏱ %s: timer #%d on task #%d running for %s",
+						timerOwner(e.User), e.ID, e.TaskID, time.Since(e.StartTime).Truncate(time.Second)))
+				}
+			}
+			if len(lines) == 0 {
+				return nil, "No timers are running", nil
+			}
+			return nil, strings.Join(lines, "\n"), nil
+		}
+		running := runningTimer(appData, user)
+		if running == nil {
+			return nil, "No timer is running", nil
+		}
+		elapsed := trackedDuration(*running, time.Now()).Truncate(time.Second)
+		if running.Paused {
+			return *running, fmt.Sprintf("⏸ Timer #%d on task #%d paused at %s", running.ID, running.TaskID, elapsed), nil
+		}
+		return *running, fmt.Sprintf("⏱ Timer #%d on task #%d running for %s", running.ID, running.TaskID, elapsed), nil
+	}
+
+	return nil, "", fmt.Errorf("unknown timer command: %s", args[0])
+}
+
+// billableFlag reads --billable or --non-billable; nil means neither.
+func billableFlag(flags map[string]string) *bool {
+	var billable bool
+	switch {
+	case flags["--billable"] != "":
+		billable = true
+	case flags["--non-billable"] != "":
+		billable = false
+	default:
+		return nil
+	}
+	return &billable
+}
+
+// runProjectsCommand implements "projects" and "projects billing <project>
+// [--rate n] [--billable | --non-billable]".
+func runProjectsCommand(args []string) (interface{}, string, error) {
+	if len(args) == 0 || args[0] == "list" {
+		appData, err := loadAppData()
+		if err != nil {
+			return nil, "", err
+		}
+		return appData.Projects, "", nil
+	}
+	if args[0] != "billing" {
+		return nil, "", fmt.Errorf("unknown projects command: %s", args[0])
+	}
+	flags, words, err := transferFlags(args[1:], "--rate")
+	if err != nil {
+		return nil, "", err
+	}
+	if len(words) == 0 {
+		return nil, "", errors.New("usage: projects billing <project> [--rate n] [--billable | --non-billable]")
+	}
+	var rate *float64
+	if v, ok := flags["--rate"]; ok {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil || r < 0 {
+			return nil, "", errors.New("--rate must be a non-negative number")
+		}
+		rate = &r
+	}
+	var project Project
+	err = updateAppData(func(appData *AppData) error {
+		p := findProject(appData, 0, strings.Join(words, " "))
+		if p == nil {
+			return fmt.Errorf("project %q not found", strings.Join(words, " "))
+		}
+		if rate != nil || billableFlag(flags) != nil {
+			setProjectBilling(p, rate, billableFlag(flags))
+			p.UpdatedAt = time.Now()
+		}
+		project = *p
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return []Project{project}, "", nil
+}
+
+// runTimesheetCommand implements "timesheet [--from d] [--to d] [--group g]
+// [--round 15m] [--rounding up|down|nearest] [--project p] [--user u]
+// [--billable | --non-billable] [--format csv|html] [--output file]
+// [--client name] [--number n]", writing CSV and HTML to standard output
+// unless --output is given.
+func runTimesheetCommand(args []string) (interface{}, string, error) {
+	flags, words, err := transferFlags(args, "--from", "--to", "--group", "--round", "--rounding",
+		"--project", "--user", "--format", "--output", "--client", "--number")
+	if err != nil {
+		return nil, "", err
+	}
+	if len(words) > 0 {
+		return nil, "", fmt.Errorf("unexpected argument %q", words[0])
+	}
+	output := flags["--output"]
+	format := flags["--format"]
+	if format == "" && output != "" && strings.Contains(output, ".") {
+		format = output[strings.LastIndex(output, ".")+1:]
+	}
+	if format != "" {
+		if format, err = parseTimesheetFormat(format); err != nil {
+			return nil, "", err
+		}
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		return nil, "", err
+	}
+	values := map[string]string{}
+	for name, v := range flags {
+		values[strings.TrimPrefix(name, "--")] = v
+	}
+	if strings.EqualFold(values["user"], "me") {
+		values["user"] = currentUser()
+	}
+	delete(values, "billable")
+	if b := billableFlag(flags); b != nil {
+		values["billable"] = strconv.FormatBool(*b)
+	}
+	opts, err := parseTimesheetOptions(appData, values, time.Now())
+	if err != nil {
+		return nil, "", err
+	}
+	sheet := buildTimesheet(appData, opts)
+	if format == "" || format == "json" {
+		if output != "" {
+			return nil, "", errors.New("--output needs --format csv or html")
+		}
+		return sheet, "", nil
+	}
+
+	content, err := renderTimesheet(sheet, format, flags["--client"], flags["--number"])
+	if err != nil {
+		return nil, "", err
+	}
+	if output == "" || output == "-" {
+		return nil, strings.TrimRight(string(content), "\n"), nil
+	}
+	if err := os.WriteFile(output, content, 0644); err != nil {
+		return nil, "", err
+	}
+	return nil, fmt.Sprintf("✓ Wrote timesheet of %d row(s) to %s", len(sheet.Rows), output), nil
+}
+
+// parseEntryTime reads a time for a time entry. After a start time, a
+// duration such as 1h30m counts from it.
+func parseEntryTime(s string, start time.Time) (time.Time, error) {
+	if !start.IsZero() {
+		if d, err := time.ParseDuration(strings.ReplaceAll(s, " ", "")); err == nil {
+			return start.Add(d), nil
+		}
+	}
+	t, err := parseDate(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %v", err)
+	}
+	return *t, nil
+}
+
+func timerOwner(user string) string {
+	if user == "" {
+		return "(nobody)"
+	}
+	return user
+}
+
+// runChecklistCommand implements "checklist <task-id>" to show the list and
+// "checklist <task-id> add <text>|check <item>|uncheck <item>|remove <item>".
+// runMembersCommand implements "members <project> [set <user> <role> |
+// remove <user> | default <role|none>]". The CLI works on the data file directly, so it can also
+// repair a project nobody owns any more.
+func runMembersCommand(args []string) (interface{}, string, error) {
+	if len(args) < 1 {
+		return nil, "", errors.New("usage: members <project> [set <user> <role> | remove <user> | default <role|none>]")
+	}
+	projectArg := args[0]
+	if len(args) == 1 {
+		appData, err := loadAppData()
+		if err != nil {
+			return nil, "", err
+		}
+		project := findProject(appData, 0, projectArg)
+		if project == nil {
+			return nil, "", fmt.Errorf("project %q not found", projectArg)
+		}
+		members := project.Members
+		if members == nil {
+			members = []ProjectMember{}
+		}
+		return members, "", nil
+	}
+
+	var message string
+	err := updateAppData(func(appData *AppData) error {
+		project := findProject(appData, 0, projectArg)
+		if project == nil {
+			return fmt.Errorf("project %q not found", projectArg)
+		}
+		switch {
+		case args[1] == "set" && len(args) == 4:
+			role, err := parseRole(args[3])
+			if err != nil {
+				return err
+			}
+			message = fmt.Sprintf("✓ %s is now %s of %s", args[2], role, project.Name)
+			return setProjectMember(appData, project, args[2], role)
+		case args[1] == "remove" && len(args) == 3:
+			message = fmt.Sprintf("✓ Removed %s from %s", args[2], project.Name)
+			return removeProjectMember(project, args[2])
+		case args[1] == "default" && len(args) == 3:
+			role, err := parseDefaultRole(args[2])
+			if err != nil {
+				return err
+			}
+			project.DefaultRole = role
+			message = fmt.Sprintf("✓ Non-members of %s now have no access", project.Name)
+			if role != "" {
+				message = fmt.Sprintf("✓ Non-members of %s are now %s", project.Name, role)
+			}
+			return nil
+		}
+		return errors.New("usage: members <project> [set <user> <role> | remove <user> | default <role|none>]")
+	})
+	return nil, message, err
+}
+
+// runTrashCommand implements "trash" to list deleted tasks and projects,
+// "trash restore <id>", "trash purge <id>" and "trash empty".
+func runTrashCommand(args []string) (interface{}, string, error) {
+	if len(args) == 0 || args[0] == "list" {
+		appData, err := loadAppData()
+		if err != nil {
+			return nil, "", err
+		}
+		return visibleTrash(appData, ""), "", nil
+	}
+
+	var message string
+	err := updateAppData(func(appData *AppData) error {
+		switch args[0] {
+		case "restore", "purge":
+			id, err := parseIDArg(args[1:], "trash "+args[0])
+			if err != nil {
+				return err
+			}
+			if args[0] == "purge" {
+				if !removeTrashItem(appData, id) {
+					return fmt.Errorf("trash item %d not found", id)
+				}
+				message = fmt.Sprintf("✓ Deleted trash item %d permanently", id)
+				return nil
+			}
+			item, err := restoreTrashItem(appData, id)
+			if err != nil {
+				return err
+			}
+			message = fmt.Sprintf("✓ Restored %s %q", item.Kind, item.Title)
+			return nil
+		case "empty":
+			message = fmt.Sprintf("✓ Deleted %d trash item(s) permanently", len(appData.Trash))
+			appData.Trash = nil
+			return nil
+		}
+		return errors.New("usage: trash [list | restore <id> | purge <id> | empty]")
+	})
+	return nil, message, err
+}
+
+// runUserCommand implements "user list|add|passwd|remove" for managing the
+// web server's accounts, and "user key|keys|revoke-key" for their API keys. Without --password the password is read from
+// standard input.
+func runUserCommand(args []string) (interface{}, string, error) {
+	if len(args) == 0 || args[0] == "list" {
+		appData, err := loadAppData()
+		if err != nil {
+			return nil, "", err
+		}
+		users := []UserInfo{}
+		for _, u := range appData.Users {
+			users = append(users, u.Info())
+		}
+		return users, "", nil
+	}
+	if len(args) < 2 {
+		return nil, "", fmt.Errorf("user %s requires a username", args[0])
+	}
+	action, username := args[0], args[1]
+
+	switch action {
+	case "keys":
+		appData, err := loadAppData()
+		if err != nil {
+			return nil, "", err
+		}
+		user := findUser(appData, username)
+		if user == nil {
+			return nil, "", fmt.Errorf("user %q not found", username)
+		}
+		return userAPIKeys(appData, user.ID), "", nil
+	case "revoke-key":
+		if len(args) < 3 {
+			return nil, "", errors.New("usage: user revoke-key <username> <key-id>")
+		}
+		keyID, err := strconv.Atoi(args[2])
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid key ID %q", args[2])
+		}
+		err = updateAppData(func(appData *AppData) error {
+			user := findUser(appData, username)
+			if user == nil {
+				return fmt.Errorf("user %q not found", username)
+			}
+			return revokeAPIKey(appData, user.ID, keyID)
+		})
+		return nil, fmt.Sprintf("✓ Revoked API key %d", keyID), err
+	case "calendar":
+		revoke := len(args) > 2 && args[2] == "revoke"
+		var token string
+		err := updateAppData(func(appData *AppData) error {
+			user := findUser(appData, username)
+			if user == nil {
+				return fmt.Errorf("user %q not found", username)
+			}
+			if revoke {
+				if !revokeCalendarToken(appData, user.ID) {
+					return fmt.Errorf("%s has no calendar token", username)
+				}
+				return nil
+			}
+			var err error
+			token, err = newCalendarToken(appData, user.ID)
+			return err
+		})
+		if revoke {
+			return nil, fmt.Sprintf("✓ Revoked the calendar token of %s", username), err
+		}
+		return map[string]string{"token": token}, fmt.Sprintf("✓ Calendar token for %s (shown only once; replaces any earlier one):\n%s\nSubscribe to http://<server>%s?token=%s", username, token, calendarPath, token), err
+	}
+
+	var name, password string
+	for i := 2; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return nil, "", fmt.Errorf("%s requires a value", args[i])
+		}
+		switch args[i] {
+		case "--name":
+			name = args[i+1]
+		case "--password":
+			password = args[i+1]
+		default:
+			return nil, "", fmt.Errorf("unknown option %s", args[i])
+		}
+		i++
+	}
+	if password == "" && (action == "add" || action == "passwd") {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return nil, "", errors.New("no password given")
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	switch action {
+	case "key":
+		var key string
+		err := updateAppData(func(appData *AppData) error {
+			user := findUser(appData, username)
+			if user == nil {
+				return fmt.Errorf("user %q not found", username)
+			}
+			var err error
+			key, _, err = newAPIKey(appData, user.ID, name)
+			return err
+		})
+		return map[string]string{"key": key}, fmt.Sprintf("✓ API key for %s (shown only once):\n%s", username, key), err
+
+	case "add":
+		var info UserInfo
+		err := updateAppData(func(appData *AppData) error {
+			user, err := createUser(appData, username, name, password)
+			if err != nil {
+				return err
+			}
+			info = user.Info()
+			return nil
+		})
+		return info, fmt.Sprintf("✓ Added user %s", username), err
+
+	case "passwd":
+		err := updateAppData(func(appData *AppData) error {
+			user := findUser(appData, username)
+			if user == nil {
+				return fmt.Errorf("user %q not found", username)
+			}
+			hash, err := hashPassword(password)
+			if err != nil {
+				return err
+			}
+			user.PasswordHash = hash
+			// Changing the password signs the user out everywhere.
+			kept := appData.Sessions[:0]
+			for _, s := range appData.Sessions {
+				if s.UserID != user.ID {
+					kept = append(kept, s)
+				}
+			}
+			appData.Sessions = kept
+			return nil
+		})
+		return nil, fmt.Sprintf("✓ Changed the password of %s", username), err
+
+	case "remove":
+		err := updateAppData(func(appData *AppData) error {
+			user := findUser(appData, username)
+			if user == nil {
+				return fmt.Errorf("user %q not found", username)
+			}
+			id := user.ID
+			users := appData.Users[:0]
+			for _, u := range appData.Users {
+				if u.ID != id {
+					users = append(users, u)
+				}
+			}
+			appData.Users = users
+			sessions := appData.Sessions[:0]
+			for _, s := range appData.Sessions {
+				if s.UserID != id {
+					sessions = append(sessions, s)
+				}
+			}
+			appData.Sessions = sessions
+			keys := appData.APIKeys[:0]
+			for _, k := range appData.APIKeys {
+				if k.UserID != id {
+					keys = append(keys, k)
+				}
+			}
+			appData.APIKeys = keys
+			revokeCalendarToken(appData, id)
+			return nil
+		})
+		return nil, fmt.Sprintf("✓ Removed user %s", username), err
+	}
+	return nil, "", fmt.Errorf("unknown user command %q (use list, add, passwd, remove, key, keys, revoke-key or calendar)", action)
+}
+
+func runChecklistCommand(args []string) (interface{}, string, error) {
+	taskID, err := parseIDArg(args, "checklist")
+	if err != nil {
+		return nil, "", err
+	}
+	if len(args) < 2 {
+		detail, err := taskDetail(taskID)
+		if err != nil {
+			return nil, "", err
+		}
+		items := detail.Checklist
+		if items == nil {
+			items = []ChecklistItem{}
+		}
+		return items, "", nil
+	}
+
+	action, rest := args[1], args[2:]
+	if action == "add" {
+		if len(rest) == 0 {
+			return nil, "", errors.New("checklist add requires text")
+		}
+		var item ChecklistItem
+		_, err := modifyTask(taskID, func(t *Task) error {
+			var err error
+			item, err = addChecklistItem(t, strings.Join(rest, " "))
+			return err
+		})
+		return item, fmt.Sprintf("✓ Added checklist item %d to #%d", item.ID, taskID), err
+	}
+
+	itemID, err := parseIDArg(rest, "checklist "+action)
+	if err != nil {
+		return nil, "", err
+	}
+	var item ChecklistItem
+	_, err = modifyTask(taskID, func(t *Task) error {
+		if action == "remove" {
+			return removeChecklistItem(t, itemID)
+		}
+		found, err := findChecklistItem(t, itemID)
+		if err != nil {
+			return err
+		}
+		switch action {
+		case "check":
+			found.Done = true
+		case "uncheck":
+			found.Done = false
+		default:
+			return fmt.Errorf("unknown checklist command: %s", action)
+		}
+		item = *found
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	switch action {
+	case "remove":
+		return nil, fmt.Sprintf("✓ Removed checklist item %d from #%d", itemID, taskID), nil
+	case "check":
+		return item, fmt.Sprintf("✓ Checked item %d on #%d", itemID, taskID), nil
+	}
+	return item, fmt.Sprintf("✓ Unchecked item %d on #%d", itemID, taskID), nil
+}
+
+// runDepsCommand implements "deps <task-id>" to show what blocks a task and
+// what it blocks, and "deps <task-id> add|remove <blocker-id>".
+func runDepsCommand(args []string) (interface{}, string, error) {
+	taskID, err := parseIDArg(args, "deps")
+	if err != nil {
+		return nil, "", err
+	}
+	if len(args) > 1 {
+		action := args[1]
+		if action != "add" && action != "remove" {
+			return nil, "", fmt.Errorf("unknown deps command: %s", action)
+		}
+		blockerID, err := parseIDArg(args[2:], "deps "+action)
+		if err != nil {
+			return nil, "", err
+		}
+		err = updateAppData(func(appData *AppData) error {
+			if action == "add" {
+				return addDependency(appData, taskID, blockerID)
+			}
+			return removeDependency(appData, taskID, blockerID)
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		if action == "add" {
+			return nil, fmt.Sprintf("✓ #%d is now blocked by #%d", taskID, blockerID), nil
+		}
+		return nil, fmt.Sprintf("✓ #%d is no longer blocked by #%d", taskID, blockerID), nil
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		return nil, "", err
+	}
+	graph, err := dependencyGraph(appData, taskID, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("task #%d not found", taskID)
+	}
+	return graph, "", nil
+}
+
+// printCommandResult renders a command result for humans.
+func printCommandResult(data interface{}, message string) {
+	if message != "" {
+		fmt.Println(message)
+		return
+	}
+	switch v := data.(type) {
+	case []Task:
+		if len(v) == 0 {
+			fmt.Println("No tasks found.")
+			return
+		}
+		printTaskTable(v)
+	case TaskPage:
+		if len(v.Tasks) == 0 {
+			fmt.Println("No tasks found.")
+			return
+		}
+		printTaskTable(v.Tasks)
+		if len(v.Tasks) < v.Total {
+			fmt.Printf("Showing %d-%d of %d\n", v.Offset+1, v.Offset+len(v.Tasks), v.Total)
+		}
+	case SearchResults:
+		if len(v.Results) == 0 {
+			fmt.Printf("No tasks found matching '%s'\n", v.Query)
+			return
+		}
+		fmt.Printf("\nFound %d task(s) matching '%s':\n", v.Total, v.Query)
+		for _, r := range v.Results {
+			status := " "
+			if r.Task.Done {
+				status = "✓"
+			}
+			fmt.Printf("[%s] #%d %s%s\033[0m: %s\n", status, r.Task.ID, r.Task.Priority.Color(), r.Task.Priority, r.Task.Description)
+			for _, s := range r.Snippets {
+				if s.Field != "description" {
+					fmt.Printf("      %s: %s\n", s.Field, highlightSnippet(s))
+				}
+			}
+		}
+		if v.Offset+len(v.Results) < v.Total {
+			fmt.Printf("Showing %d-%d of %d\n", v.Offset+1, v.Offset+len(v.Results), v.Total)
+		}
+	case TaskDetail:
+		printTaskDetails(v)
+	case TaskStats:
+		printTaskStats(v)
+	case []ChecklistItem:
+		if len(v) == 0 {
+			fmt.Println("Checklist is empty.")
+			return
+		}
+		for _, item := range v {
+			mark := " "
+			if item.Done {
+				mark = "x"
+			}
+			fmt.Printf("[%s] %d. %s\n", mark, item.ID, item.Text)
+		}
+	case DependencyGraph:
+		if len(v.BlockedBy) == 0 && len(v.Blocks) == 0 {
+			fmt.Printf("#%d has no dependencies.\n", v.TaskID)
+			return
+		}
+		printTaskRefs("Blocked by:", v.BlockedBy)
+		printTaskRefs("Blocks:", v.Blocks)
+		if v.Blocked {
+			fmt.Println("⛔ Blocked: finish the tasks above first")
+		}
+	case CriticalPath:
+		if len(v.Tasks) == 0 {
+			fmt.Println("No open tasks in this project.")
+			return
+		}
+		fmt.Printf("Critical path: %.1fh\n", v.TotalHours)
+		for i, t := range v.Tasks {
+			fmt.Printf("  %d. #%d %s (%.1fh)\n", i+1, t.ID, t.Description, t.EstimatedHours)
+		}
+	case []UserInfo:
+		if len(v) == 0 {
+			fmt.Println("No users yet.")
+			return
+		}
+		for _, u := range v {
+			fmt.Printf("#%d %s %s\n", u.ID, u.Username, u.Name)
+		}
+	case []Project:
+		for _, p := range v {
+			billing := ""
+			if p.HourlyRate != 0 {
+				billing = fmt.Sprintf("  %s/h", formatHours(p.HourlyRate))
+			}
+			if p.Billable {
+				billing += "  billable"
+			}
+			fmt.Printf("#%d %s%s\n", p.ID, p.Name, billing)
+		}
+	case Timesheet:
+		printTimesheet(v)
+	case Analytics:
+		printAnalytics(v)
+	case ActivityPage:
+		if len(v.Entries) == 0 {
+			fmt.Println("No activity yet.")
+			return
+		}
+		for _, a := range v.Entries {
+			fmt.Println(describeActivity(a))
+		}
+		if v.Offset+len(v.Entries) < v.Total {
+			fmt.Printf("Showing %d-%d of %d\n", v.Offset+1, v.Offset+len(v.Entries), v.Total)
+		}
+	case []ProjectMember:
+		if len(v) == 0 {
+			fmt.Println("No members yet: roles apply once the first account exists.")
+			return
+		}
+		for _, m := range v {
+			fmt.Printf("%-20s %s\n", m.Username, m.Role)
+		}
+	case ImportReport:
+		verb := "Imported"
+		if v.DryRun {
+			verb = "Dry run: would import"
+		}
+		fmt.Printf("%s %d task(s), %d time entries\n", verb, v.Tasks, v.TimeEntries)
+		for _, p := range v.Projects {
+			if p.Created {
+				fmt.Printf("  new project #%d %s\n", p.ID, p.Name)
+			}
+		}
+		for _, r := range v.Remapped {
+			fmt.Printf("  %s #%d → #%d\n", r.Kind, r.From, r.To)
+		}
+		if len(v.Conflicts) > 0 {
+			fmt.Printf("%d conflict(s):\n", len(v.Conflicts))
+			for _, c := range v.Conflicts {
+				if c.ID != 0 {
+					fmt.Printf("  %s #%d: %s\n", c.Kind, c.ID, c.Message)
+				} else {
+					fmt.Printf("  %s: %s\n", c.Kind, c.Message)
+				}
+			}
+		}
+	case []TrashItem:
+		if len(v) == 0 {
+			fmt.Println("Trash is empty.")
+			return
+		}
+		for _, item := range v {
+			extra := ""
+			if item.Kind == "project" {
+				extra = fmt.Sprintf(" (%d tasks)", len(item.Tasks))
+			}
+			fmt.Printf("%3d  %-7s %s%s  deleted %s by %s\n", item.ID, item.Kind, item.Title, extra,
+				item.DeletedAt.Local().Format("2006-01-02 15:04"), item.DeletedBy)
+		}
+	case RemindersInfo:
+		printReminderPrefs(v.Prefs)
+		if len(v.Upcoming) == 0 {
+			fmt.Println("No reminders coming up.")
+		}
+		for _, n := range v.Upcoming {
+			fmt.Printf("%s  %s: %s\n", n.At.Local().Format("Mon 2006-01-02 15:04"), n.Title, n.Task.Description)
+		}
+		for _, sn := range v.Snoozes {
+			fmt.Printf("💤 #%d snoozed until %s\n", sn.TaskID, sn.Until.Local().Format("2006-01-02 15:04"))
+		}
+	case ReminderPrefs:
+		printReminderPrefs(v)
+	case []TimeEntry:
+		if len(v) == 0 {
+			fmt.Println("No time entries.")
+			return
+		}
+		for _, e := range v {
+			end, flags := "running", ""
+			if e.EndTime != nil {
+				end = e.EndTime.Local().Format("15:04")
+			}
+			if e.Paused {
+				end = "paused"
+			}
+			if len(e.Segments) > 1 {
+				flags += fmt.Sprintf(" [%d segments]", len(e.Segments))
+			}
+			if e.AutoClosed {
+				flags += " [auto-closed]"
+			}
+			if e.Billable != nil && *e.Billable {
+				flags += " [billable]"
+			} else if e.Billable != nil {
+				flags += " [non-billable]"
+			}
+			if e.Note != "" {
+				flags += " " + e.Note
+			}
+			duration := time.Duration(e.Duration) * time.Second
+			if e.EndTime == nil {
+				duration = trackedDuration(e, time.Now()).Truncate(time.Second)
+			}
+			fmt.Printf("%4d  task #%-4d %-10s %s – %-7s %9s%s\n", e.ID, e.TaskID, timerOwner(e.User),
+				e.StartTime.Local().Format("2006-01-02 15:04"), end, duration, flags)
+		}
+	case []Webhook:
+		if len(v) == 0 {
+			fmt.Println("No webhooks.")
+			return
+		}
+		for _, h := range v {
+			state := ""
+			if !h.Active {
+				state = " (disabled)"
+			}
+			fmt.Printf("#%d project #%d %s%s: %s\n", h.ID, h.ProjectID, h.URL, state, strings.Join(h.Events, ", "))
+		}
+	case []WebhookDelivery:
+		if len(v) == 0 {
+			fmt.Println("No deliveries yet.")
+			return
+		}
+		for _, d := range v {
+			detail := ""
+			switch {
+			case d.Error != "":
+				detail = " " + d.Error
+			case d.ResponseCode != 0:
+				detail = fmt.Sprintf(" HTTP %d", d.ResponseCode)
+			}
+			if d.NextAttempt != nil && d.Status == "pending" {
+				detail += " (next try " + d.NextAttempt.Local().Format("15:04:05") + ")"
+			}
+			fmt.Printf("%4d  %s  %-14s %-9s %d attempt(s)%s\n", d.ID, d.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+				d.Event, d.Status, d.Attempts, detail)
+		}
+	case []APIKey:
+		if len(v) == 0 {
+			fmt.Println("No API keys.")
+			return
+		}
+		for _, k := range v {
+			fmt.Printf("#%d %s… %s (created %s)\n", k.ID, k.Prefix, k.Name, k.CreatedAt.Format("2006-01-02"))
+		}
+	default:
+		if data != nil {
+			fmt.Printf("%+v\n", data)
+		}
+	}
+}
+
+// highlightSnippet renders a snippet's highlights in bold yellow.
+func highlightSnippet(s SearchSnippet) string {
+	var b strings.Builder
+	pos := 0
+	for _, h := range s.Highlights {
+		b.WriteString(s.Text[pos:h[0]])
+		b.WriteString("\033[1;33m" + s.Text[h[0]:h[1]] + "\033[0m")
+		pos = h[1]
+	}
+	b.WriteString(s.Text[pos:])
+	return b.String()
+}
+
+func printTaskRefs(title string, refs []TaskRef) {
+	if len(refs) == 0 {
+		return
+	}
+	fmt.Println(title)
+	for _, t := range refs {
+		mark := " "
+		if t.Done {
+			mark = "✓"
+		}
+		fmt.Printf("  [%s] #%d %s (%s)\n", mark, t.ID, t.Description, t.Status)
+	}
+}
+
+func printReminderPrefs(p ReminderPrefs) {
+	who := p.Username
+	if who == "" {
+		who = "tasks without an account"
+	}
+	muted := ""
+	if p.Muted {
+		muted = " (muted)"
+	}
+	fmt.Printf("Reminders for %s%s: %s (before due), via %s\n", who, muted, strings.Join(p.Offsets, ", "), strings.Join(p.Channels, ", "))
+	if p.Email != "" {
+		fmt.Printf("  email: %s\n", p.Email)
+	}
+	if p.WebhookURL != "" {
+		fmt.Printf("  webhook: %s\n", p.WebhookURL)
+	}
+}
+
+func printTimesheet(sheet Timesheet) {
+	rounding := ""
+	if sheet.Rounding != "" {
+		rounding = ", rounded " + sheet.Rounding
+	}
+	fmt.Printf("Timesheet %s – %s by %s%s\n", sheet.From.Format("2006-01-02"), sheet.Until().Format("2006-01-02"),
+		strings.Join(sheet.GroupBy, ", "), rounding)
+	if len(sheet.Rows) == 0 {
+		fmt.Println("No time tracked.")
+		return
+	}
+	for _, r := range sheet.Rows {
+		label := r.Label()
+		if label == "" {
+			label = "(no user)"
+		}
+		fmt.Printf("  %-44s %7.2fh %7.2fh %10.2f\n", label, r.Hours, r.BillableHours, r.Amount)
+	}
+	fmt.Printf("  %-44s %7.2fh %7.2fh %10.2f %s\n", "Total (hours, billable, amount)", sheet.Total.Hours,
+		sheet.Total.BillableHours, sheet.Total.Amount, sheet.Currency)
+}
+
+func printAnalytics(a Analytics) {
+	fmt.Printf("Completed %s – %s: %d task(s)\n", a.From.Format("2006-01-02"), a.To.AddDate(0, 0, -1).Format("2006-01-02"), len(a.Tasks))
+	if len(a.Tasks) == 0 {
+		return
+	}
+	flow := func(name string, s FlowStats) {
+		if s.Tasks > 0 {
+			fmt.Printf("  %-11s %d task(s): average %.1fh, median %.1fh, 85%% within %.1fh, max %.1fh\n",
+				name, s.Tasks, s.Average, s.Median, s.P85, s.Max)
+		}
+	}
+	flow("Cycle time", a.CycleTime)
+	flow("Lead time", a.LeadTime)
+
+	fmt.Println("\nThroughput per week:")
+	for _, w := range a.Throughput {
+		fmt.Println(strings.TrimRight(fmt.Sprintf("  %s  %-3d %s", w.Week, w.Completed, strings.Repeat("█", w.Completed)), " "))
+	}
+
+	if a.Accuracy.Tasks == 0 {
+		fmt.Println("\nNo completed task has both an estimate and tracked time.")
+		return
+	}
+	accuracy := func(name string, acc EstimateAccuracy) {
+		fmt.Printf("  %-20s %3d task(s)  est %6.1fh  actual %6.1fh  ×%.2f  ±%.0f%%  over %d, under %d\n",
+			name, acc.Tasks, acc.EstimatedHours, acc.ActualHours, acc.Ratio, acc.MeanError*100, acc.Over, acc.Under)
+	}
+	fmt.Println("\nEstimate accuracy (actual ÷ estimate, mean error):")
+	accuracy("All", a.Accuracy)
+	for _, acc := range a.ByProject {
+		name := acc.Project
+		if name == "" {
+			name = "No project"
+		}
+		accuracy(name, acc)
+	}
+	for _, acc := range a.ByAssignee {
+		name := "@" + acc.Assignee
+		if acc.Assignee == "" {
+			name = "Unassigned"
+		}
+		accuracy(name, acc)
+	}
+	fmt.Println("\nTasks:")
+	for _, t := range a.Tasks {
+		detail := fmt.Sprintf("lead %.1fh", t.LeadHours)
+		if t.CycleHours != nil {
+			detail += fmt.Sprintf(", cycle %.1fh", *t.CycleHours)
+		}
+		if t.Ratio != 0 {
+			detail += fmt.Sprintf(", %.1fh of %.1fh estimated", t.ActualHours, t.EstimatedHours)
+		}
+		fmt.Printf("  #%-4d %s  %s (%s)\n", t.TaskID, t.CompletedAt.Local().Format("2006-01-02"), t.Description, detail)
+	}
+}
+
+func cliUsage() {
+	fmt.Fprintln(os.Stderr, `Usage: taskmanager [-store json|sqlite] [-db path] <command> [args] [--json]
+
+Commands:
+  add <description> [create options]   Add a task (options as for create)
+  create --desc "..." [options]         --priority, --category, --due, --tags,
+                                        --project, --status, --assignee, --estimate,
+                                        --parent
+  list [query] [--sort keys] [--limit n] [--page n | --offset n]
+                                        Query terms: status:in_progress priority>=high
+                                        due<7d tag:x -tag:y assignee:me project:"Web"
+                                        is:overdue|blocked|open|done, free text;
+                                        --status/--project/--category/--tag also work
+  view <id>                             Show one task
+  done <id> [--force] | undone <id> | delete <id>
+  export [--format json|csv|md|todotxt] [--project p] [--output file]
+                                        Tasks, projects and time entries; the format
+                                        defaults to the file extension, else JSON
+  import <file|-> [--format f] [--project p] [--dry-run]
+                                        Add tasks from an export or another tool;
+                                        --project is for tasks naming none, --dry-run
+                                        shows conflicts and new IDs without saving
+  trash [list] | trash restore <id> | trash purge <id> | trash empty
+                                        Deleted tasks and projects; they are purged
+                                        after TASKMANAGER_TRASH_DAYS days (default 30,
+                                        0 keeps them)
+  priority <id> <low|medium|high|urgent>
+  due <id> <date>
+  search <words> [filters] [--limit n] [--offset n]
+                                        Ranked full-text search over descriptions,
+                                        tags, categories and comments; prefixes
+                                        match, filters as for list
+  stats
+  projects | projects billing <project> [--rate n] [--billable | --non-billable]
+                                        Hourly rate and whether the project's time is
+                                        billed (setting a rate makes it billable)
+  timer start <task-id> [--note text] [--no-auto-stop] | timer stop [entry-id]
+  timer pause | timer resume | timer list [task-id]
+  timer add <task-id> <start> <end|duration> [--note text] [--billable | --non-billable]
+  timer edit <entry-id> [--task id] [--start t] [--end t|duration] [--note text]
+             [--billable | --non-billable]
+  timer split <entry-id> <time> | timer delete <entry-id>
+                                        A user's entries may not overlap; timers left
+                                        running TASKMANAGER_TIMER_MAX_HOURS (default 12,
+                                        0 never) are closed there and flagged
+  timer status [--all]                  Timers are per user ($TASKMANAGER_USER or $USER,
+                                        or --user name); starting one stops your
+                                        running timer unless --no-auto-stop
+  timesheet [--from d] [--to d] [--group day,week,user,project,task] [--project p]
+            [--user u|me] [--billable | --non-billable] [--round 15m]
+            [--rounding up|down|nearest] [--format csv|html] [--output file]
+            [--client name] [--number n]
+                                        Tracked time of a date range (this month by
+                                        default), grouped (by project,task by default)
+                                        and billed at the project rates in
+                                        TASKMANAGER_CURRENCY; html is a printable invoice
+  analytics [--from d] [--to d] [--project p] [--assignee a|me]
+                                        Estimate accuracy, weekly throughput, cycle time
+                                        (in progress to done) and lead time of the tasks
+                                        completed in a range (the last 12 weeks by default)
+  checklist <id> [add <text> | check <item> | uncheck <item> | remove <item>]
+  deps <id> [add <blocker-id> | remove <blocker-id>]
+  critical-path <project>               Longest chain of dependent open tasks
+  activity <project> [--limit n] [--offset n]
+                                        Who changed what in a project, newest first
+                                        ("view" shows a task's own history)
+  user [list] | user add <name> [--name "Full Name"] [--password p]
+  user passwd <name> [--password p] | user remove <name>
+                                        Accounts for the web server (the password is
+                                        read from stdin when not given)
+  user key <name> [--name label] | user keys <name> | user revoke-key <name> <id>
+                                        API keys, sent as "Authorization: Bearer <key>"
+  user calendar <name> [revoke]         Token for subscribing to the calendar feed at
+                                        /api/calendar.ics?token=... (project_id,
+                                        assignee and kind=event|todo narrow it)
+  calendar [--project p] [--assignee a] [--todo] [--output file]
+                                        Tasks with a due date as an iCalendar file
+  remind [list [user]] [--days n]      Reminders coming up for due tasks; the server
+                                        sends them, "remind check" sends the due ones
+  remind snooze <task-id> <2h|date> | remind unsnooze <task-id>
+  remind prefs [user] [--offsets 1d,1h,0,-1d] [--channels stdout,smtp,webhook]
+               [--email addr] [--webhook url] [--mute | --unmute]
+                                        When (before the due date; negative is after)
+                                        and how to remind; date-only due dates count
+                                        from 9:00; the webhook must be a public https URL
+  webhook [list [project]] | webhook add <project> <url> [--events e1,e2] [--secret s]
+  webhook enable|disable|remove <id> | webhook deliveries <id>
+  webhook redeliver <id> <delivery>     POST task.created, task.moved, task.completed,
+                                        comment.added, timer.stopped and
+                                        time_entry.created (time added by hand or
+                                        split off) events of a project, signed in
+                                        X-Taskmanager-Signature
+                                        (sha256= HMAC of the body) to a public https
+                                        URL; the server retries failures with backoff
+  members <project> [set <user> <owner|editor|commenter|viewer> | remove <user>]
+  members <project> default <role|none> Who may see and change a project in the web
+                                        server; non-members get the default role
+  server                                Start the web interface; environment:
+                                        TASKMANAGER_CORS_ORIGINS  origins allowed to call
+                                          the API from a browser (comma-separated or *)
+                                        TASKMANAGER_SIGNUP=open   let anyone register (the
+                                          first account can always register)
+                                        TASKMANAGER_NOTIFY_COMMAND  run for stdout reminders
+                                          with title and message (e.g. notify-send)
+                                        TASKMANAGER_SMTP_ADDR, _FROM, _USER, _PASSWORD
+                                          mail server for the smtp channel
+                                        TASKMANAGER_REMINDER_WEBHOOK  default webhook URL
+                                        TASKMANAGER_ACTIVITY_LIMIT  keep only the newest
+                                          activity entries (default: all of them)
+  migrate [-from file] [-to file]       Copy the JSON data file into SQLite
+
+With no command the interactive mode starts. --json prints machine-readable
+output; errors exit with status 1.`)
+}
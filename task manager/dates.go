@@ -0,0 +1,346 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Due dates given as a plain day ("friday", "2026-03-01") mean the end of
+// that day. Anything with a clock time or timezone keeps it.
+
+var errBadDate = errors.New("invalid date. Try: YYYY-MM-DD, 2026-03-01T15:00+02:00, today, tomorrow 9am, " +
+	"friday, next friday 3pm, in 2 weeks, end of month")
+
+// Layouts carrying their own timezone.
+var zonedLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04Z07:00",
+}
+
+// Layouts with a time of day, read in the local timezone.
+var localTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// Layouts for a day only. The ones without a year are handled by
+// parseDayOfYear.
+var dayLayouts = []string{
+	"2006-01-02",
+	"2006/01/02",
+	"01-02-2006",
+	"01/02/2006",
+	"Jan 02 2006",
+	"Jan 2 2006",
+	"January 2 2006",
+	"02 Jan 2006",
+	"2 Jan 2006",
+	"2 January 2006",
+}
+
+// parseDate reads a due date relative to the current time.
+func parseDate(dateStr string) (*time.Time, error) {
+	t, err := parseDateAt(dateStr, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", dateStr, err)
+	}
+	return t, nil
+}
+
+// parseDateAt reads a due date relative to now, which also supplies the
+// local timezone. Besides absolute dates and ISO datetimes it understands:
+//
+//	today, tomorrow, yesterday, and "tonight" (today at 8pm)
+//	monday .. sunday, "this fri" (on or after today), "next fri" (after today)
+//	in 3 days | in 2 weeks | in a month | in 90 minutes | in 2 hours
+//	next week | next month | next year (their first day)
+//	end of week | end of month | end of year (also eow, eom, eoy)
+//
+// optionally followed by a time: "mon 3pm", "tomorrow at 9:30am", "fri noon",
+// "2026-03-01 17:00". A time on its own means today.
+func parseDateAt(dateStr string, now time.Time) (*time.Time, error) {
+	s := strings.TrimSpace(dateStr)
+	if s == "" {
+		return nil, errBadDate
+	}
+	loc := now.Location()
+
+	for _, layout := range zonedLayouts {
+		if t, err := time.Parse(layout, strings.ToUpper(s)); err == nil {
+			return &t, nil
+		}
+	}
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return &t, nil
+		}
+	}
+
+	lower := strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(s, ",", " ")), " "))
+
+	// "in 2 hours" is an exact moment rather than a day.
+	if t, ok := parseDuration(lower, now); ok {
+		return &t, nil
+	}
+
+	words := strings.Fields(lower)
+	hour, min, sec, timeWords := -1, 0, 0, 0
+	for n := 1; n <= 2 && n <= len(words); n++ {
+		if h, m, ok := parseClock(strings.Join(words[len(words)-n:], "")); ok {
+			hour, min, timeWords = h, m, n
+		}
+	}
+	words = words[:len(words)-timeWords]
+	if len(words) > 0 && words[len(words)-1] == "at" {
+		words = words[:len(words)-1]
+	}
+
+	var day time.Time
+	if len(words) == 0 {
+		if hour < 0 {
+			return nil, errBadDate
+		}
+		day = now
+	} else {
+		var err error
+		if day, err = parseDay(strings.Join(words, " "), now); err != nil {
+			return nil, err
+		}
+		if hour < 0 && strings.Join(words, " ") == "tonight" {
+			hour = 20
+		}
+	}
+
+	if hour < 0 {
+		hour, min, sec = 23, 59, 59
+	}
+	t := time.Date(day.Year(), day.Month(), day.Day(), hour, min, sec, 0, loc)
+	return &t, nil
+}
+
+// parseDuration handles "in N minutes" and "in N hours".
+func parseDuration(s string, now time.Time) (time.Time, bool) {
+	words := strings.Fields(s)
+	if len(words) != 3 || words[0] != "in" {
+		return time.Time{}, false
+	}
+	n, ok := parseCount(words[1])
+	if !ok {
+		return time.Time{}, false
+	}
+	switch strings.TrimSuffix(words[2], "s") {
+	case "minute", "min":
+		return now.Add(time.Duration(n) * time.Minute), true
+	case "hour", "hr":
+		return now.Add(time.Duration(n) * time.Hour), true
+	}
+	return time.Time{}, false
+}
+
+// parseCount reads "3", "a" or "an".
+func parseCount(s string) (int, bool) {
+	if s == "a" || s == "an" {
+		return 1, true
+	}
+	n, err := strconv.Atoi(s)
+	return n, err == nil && n >= 0
+}
+
+// parseClock reads a time of day such as 3pm, 3:30pm, 15:00 or noon. The
+// caller joins "3 pm" into "3pm" before calling.
+func parseClock(s string) (int, int, bool) {
+	switch s {
+	case "noon", "midday":
+		return 12, 0, true
+	case "midnight":
+		return 0, 0, true
+	}
+
+	suffix := ""
+	if strings.HasSuffix(s, "am") || strings.HasSuffix(s, "pm") {
+		suffix = s[len(s)-2:]
+		s = s[:len(s)-2]
+	}
+	hourStr, minStr, hasMin := strings.Cut(s, ":")
+	if !hasMin && suffix == "" {
+		return 0, 0, false // a bare number is a day or count, not a time
+	}
+	hour, err := strconv.Atoi(hourStr)
+	if err != nil {
+		return 0, 0, false
+	}
+	min := 0
+	if hasMin {
+		if len(minStr) != 2 {
+			return 0, 0, false
+		}
+		if min, err = strconv.Atoi(minStr); err != nil || min > 59 {
+			return 0, 0, false
+		}
+	}
+
+	switch suffix {
+	case "":
+		if hour > 23 {
+			return 0, 0, false
+		}
+	default:
+		if hour < 1 || hour > 12 {
+			return 0, 0, false
+		}
+		if hour == 12 {
+			hour = 0
+		}
+		if suffix == "pm" {
+			hour += 12
+		}
+	}
+	return hour, min, true
+}
+
+var weekdaysByName = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tues": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// parseDay resolves a day expression to some time on that day.
+func parseDay(s string, now time.Time) (time.Time, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch s {
+	case "today", "tonight":
+		return today, nil
+	case "tomorrow", "tmrw", "tmr":
+		return today.AddDate(0, 0, 1), nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	case "next week":
+		return startOfWeek(today).AddDate(0, 0, 7), nil
+	case "next month":
+		return time.Date(today.Year(), today.Month()+1, 1, 0, 0, 0, 0, today.Location()), nil
+	case "next year":
+		return time.Date(today.Year()+1, time.January, 1, 0, 0, 0, 0, today.Location()), nil
+	case "end of week", "end of the week", "eow":
+		return startOfWeek(today).AddDate(0, 0, 6), nil
+	case "end of month", "end of the month", "eom":
+		return time.Date(today.Year(), today.Month()+1, 0, 0, 0, 0, 0, today.Location()), nil
+	case "end of year", "end of the year", "eoy":
+		return time.Date(today.Year(), time.December, 31, 0, 0, 0, 0, today.Location()), nil
+	}
+
+	words := strings.Fields(s)
+
+	// "in 3 days", "in a week", "in 2 months"
+	if len(words) == 3 && words[0] == "in" {
+		if n, ok := parseCount(words[1]); ok {
+			switch strings.TrimSuffix(words[2], "s") {
+			case "day":
+				return today.AddDate(0, 0, n), nil
+			case "week":
+				return today.AddDate(0, 0, 7*n), nil
+			case "fortnight":
+				return today.AddDate(0, 0, 14*n), nil
+			case "month":
+				return monthDate(today.Year(), today.Month()+time.Month(n), today.Day(), today), nil
+			case "year":
+				return monthDate(today.Year()+n, today.Month(), today.Day(), today), nil
+			}
+		}
+		return time.Time{}, errBadDate
+	}
+
+	// "fri", "this fri", "next fri"
+	if len(words) <= 2 {
+		name, qualifier := words[len(words)-1], ""
+		if len(words) == 2 {
+			qualifier = words[0]
+		}
+		if wd, ok := weekdaysByName[name]; ok && (qualifier == "" || qualifier == "this" || qualifier == "next" || qualifier == "on") {
+			days := (int(wd) - int(today.Weekday()) + 7) % 7
+			if days == 0 && qualifier == "next" {
+				days = 7
+			}
+			return today.AddDate(0, 0, days), nil
+		}
+	}
+
+	for _, layout := range dayLayouts {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	if t, ok := parseDayOfYear(s, today); ok {
+		return t, nil
+	}
+	return time.Time{}, errBadDate
+}
+
+// parseDayOfYear reads "mar 3" or "3 march" as the next such day, this year
+// or next.
+func parseDayOfYear(s string, today time.Time) (time.Time, bool) {
+	for _, layout := range []string{"Jan 2", "January 2", "2 Jan", "2 January"} {
+		t, err := time.ParseInLocation(layout, s, today.Location())
+		if err != nil {
+			continue
+		}
+		t = time.Date(today.Year(), t.Month(), t.Day(), 0, 0, 0, 0, today.Location())
+		if t.Before(today) {
+			t = t.AddDate(1, 0, 0)
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// hasClockTime reports whether a due date carries a time of day rather than
+// the end-of-day default.
+func hasClockTime(t time.Time) bool {
+	return !(t.Hour() == 23 && t.Minute() == 59 && t.Second() == 59)
+}
+
+// formatDue prints a due date with its time only when it has one.
+func formatDue(t time.Time) string {
+	if hasClockTime(t) {
+		return t.Format("2006-01-02 15:04")
+	}
+	return t.Format("2006-01-02")
+}
+
+// parseDayRange reads the from and to days of a report, to inclusive, and
+// returns the range as [from, to). Empty values keep the defaults.
+func parseDayRange(from, to string, defaultFrom, defaultTo, now time.Time) (time.Time, time.Time, error) {
+	start, end := defaultFrom, defaultTo
+	for _, v := range []struct {
+		name, value string
+	}{{"from", from}, {"to", to}} {
+		if strings.TrimSpace(v.value) == "" {
+			continue
+		}
+		t, err := parseDateAt(v.value, now)
+		if err != nil {
+			return start, end, queryError("%s: %q: %v", v.name, v.value, err)
+		}
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		if v.name == "from" {
+			start = day
+		} else {
+			end = day.AddDate(0, 0, 1)
+		}
+	}
+	if !end.After(start) {
+		return start, end, queryError("to must not be before from")
+	}
+	return start, end, nil
+}
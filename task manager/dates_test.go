@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseDateAt(t *testing.T) {
+	// Wednesday, 14 October 2026, 10:00 UTC.
+	now := time.Date(2026, time.October, 14, 10, 0, 0, 0, time.UTC)
+	eod := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 23, 59, 59, 0, time.UTC)
+	}
+	at := func(y int, m time.Month, d, h, min int) time.Time {
+		return time.Date(y, m, d, h, min, 0, 0, time.UTC)
+	}
+	plus2 := time.FixedZone("", 2*60*60)
+
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2026-11-03", eod(2026, time.November, 3)},
+		{"2026/11/03", eod(2026, time.November, 3)},
+		{"11/03/2026", eod(2026, time.November, 3)},
+		{"Nov 3 2026", eod(2026, time.November, 3)},
+		{"3 November 2026", eod(2026, time.November, 3)},
+		{"nov 3", eod(2026, time.November, 3)},
+		{"mar 3", eod(2027, time.March, 3)},
+		{"2026-11-03T15:30:00+02:00", time.Date(2026, time.November, 3, 15, 30, 0, 0, plus2)},
+		{"2026-11-03T15:30Z", at(2026, time.November, 3, 15, 30)},
+		{"2026-11-03T15:30", at(2026, time.November, 3, 15, 30)},
+		{"2026-11-03 15:30", at(2026, time.November, 3, 15, 30)},
+		{"2026-11-03 3pm", at(2026, time.November, 3, 15, 0)},
+		{"today", eod(2026, time.October, 14)},
+		{"Today", eod(2026, time.October, 14)},
+		{"tonight", at(2026, time.October, 14, 20, 0)},
+		{"tomorrow", eod(2026, time.October, 15)},
+		{"tomorrow 9am", at(2026, time.October, 15, 9, 0)},
+		{"tomorrow at 9:30am", at(2026, time.October, 15, 9, 30)},
+		{"yesterday", eod(2026, time.October, 13)},
+		{"5pm", at(2026, time.October, 14, 17, 0)},
+		{"5 pm", at(2026, time.October, 14, 17, 0)},
+		{"noon", at(2026, time.October, 14, 12, 0)},
+		{"12am", at(2026, time.October, 14, 0, 0)},
+		{"12pm", at(2026, time.October, 14, 12, 0)},
+		{"in 3 days", eod(2026, time.October, 17)},
+		{"in 1 day", eod(2026, time.October, 15)},
+		{"in 2 weeks", eod(2026, time.October, 28)},
+		{"in a week", eod(2026, time.October, 21)},
+		{"in 1 month", eod(2026, time.November, 14)},
+		{"in 2 hours", at(2026, time.October, 14, 12, 0)},
+		{"in 90 minutes", at(2026, time.October, 14, 11, 30)},
+		{"friday", eod(2026, time.October, 16)},
+		{"fri", eod(2026, time.October, 16)},
+		{"wed", eod(2026, time.October, 14)},
+		{"this wed", eod(2026, time.October, 14)},
+		{"next wed", eod(2026, time.October, 21)},
+		{"next friday", eod(2026, time.October, 16)},
+		{"mon", eod(2026, time.October, 19)},
+		{"mon 3pm", at(2026, time.October, 19, 15, 0)},
+		{"next friday at 17:00", at(2026, time.October, 16, 17, 0)},
+		{"next week", eod(2026, time.October, 19)},
+		{"next month", eod(2026, time.November, 1)},
+		{"next year", eod(2027, time.January, 1)},
+		{"end of week", eod(2026, time.October, 18)},
+		{"end of month", eod(2026, time.October, 31)},
+		{"eom", eod(2026, time.October, 31)},
+		{"end of year", eod(2026, time.December, 31)},
+		{"end of month 5pm", at(2026, time.October, 31, 17, 0)},
+	}
+	for _, tt := range tests {
+		got, err := parseDateAt(tt.in, now)
+		if err != nil {
+			t.Errorf("parseDateAt(%q): %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseDateAt(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseDateAtMonthEnd(t *testing.T) {
+	now := time.Date(2027, time.January, 31, 8, 0, 0, 0, time.UTC)
+	got, err := parseDateAt("in 1 month", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2027, time.February, 28, 23, 59, 59, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("in 1 month from Jan 31 = %v, want %v", got, want)
+	}
+}
+
+func TestParseDateAtUsesClockLocation(t *testing.T) {
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	// Late evening on the 14th locally, already the 15th in UTC.
+	now := time.Date(2026, time.October, 14, 22, 0, 0, 0, loc)
+
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"today", time.Date(2026, time.October, 14, 23, 59, 59, 0, loc)},
+		{"2026-11-03", time.Date(2026, time.November, 3, 23, 59, 59, 0, loc)},
+		{"2026-11-03 09:00", time.Date(2026, time.November, 3, 9, 0, 0, 0, loc)},
+		{"2026-11-03T09:00:00Z", time.Date(2026, time.November, 3, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseDateAt(tt.in, now)
+		if err != nil {
+			t.Errorf("parseDateAt(%q): %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) || got.Location().String() != tt.want.Location().String() {
+			t.Errorf("parseDateAt(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseDateAtInvalid(t *testing.T) {
+	now := time.Date(2026, time.October, 14, 10, 0, 0, 0, time.UTC)
+	for _, in := range []string{
+		"",
+		"soon",
+		"next",
+		"in days",
+		"in 3 parsecs",
+		"13pm",
+		"25:00",
+		"fri 3",
+		"2026-13-01",
+		"next blursday",
+	} {
+		if got, err := parseDateAt(in, now); !errors.Is(err, errBadDate) {
+			t.Errorf("parseDateAt(%q) = %v, %v; want errBadDate", in, got, err)
+		}
+	}
+}
+
+func TestFormatDue(t *testing.T) {
+	tests := []struct {
+		in   time.Time
+		want string
+	}{
+		{time.Date(2026, time.November, 3, 23, 59, 59, 0, time.UTC), "2026-11-03"},
+		{time.Date(2026, time.November, 3, 15, 30, 0, 0, time.UTC), "2026-11-03 15:30"},
+	}
+	for _, tt := range tests {
+		if got := formatDue(tt.in); got != tt.want {
+			t.Errorf("formatDue(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
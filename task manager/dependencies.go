@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// A task's BlockedBy lists the tasks that must be finished before work on it
+// can start. "Blocks" is the same link seen from the other end and is not
+// stored.
+
+// TaskRef is the short form of a task used in dependency graphs.
+type TaskRef struct {
+	ID             int        `json:"id"`
+	ProjectID      int        `json:"project_id"`
+	Description    string     `json:"description"`
+	Status         TaskStatus `json:"status"`
+	Done           bool       `json:"done"`
+	EstimatedHours float64    `json:"estimated_hours,omitempty"`
+}
+
+func taskRef(t Task) TaskRef {
+	return TaskRef{
+		ID:             t.ID,
+		ProjectID:      t.ProjectID,
+		Description:    t.Description,
+		Status:         taskStatus(t),
+		Done:           t.Done,
+		EstimatedHours: t.EstimatedHours,
+	}
+}
+
+// DependencyEdge means From must be finished before To can start.
+type DependencyEdge struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// DependencyGraph is everything upstream and downstream of one task.
+type DependencyGraph struct {
+	TaskID    int              `json:"task_id"`
+	BlockedBy []TaskRef        `json:"blocked_by"` // direct blockers
+	Blocks    []TaskRef        `json:"blocks"`     // tasks directly waiting on this one
+	Blocked   bool             `json:"blocked"`    // some blocker is unfinished
+	Nodes     []TaskRef        `json:"nodes"`
+	Edges     []DependencyEdge `json:"edges"`
+}
+
+// CriticalPath is the longest chain of unfinished, dependent tasks in a
+// project, weighted by EstimatedHours.
+type CriticalPath struct {
+	ProjectID  int       `json:"project_id"`
+	Tasks      []TaskRef `json:"tasks"` // in the order they have to be done
+	TotalHours float64   `json:"total_hours"`
+}
+
+// startedStatuses are the columns that mean work on a task has begun, which
+// its blockers have to allow.
+var startedStatuses = map[TaskStatus]bool{
+	StatusInProgress: true,
+	StatusInReview:   true,
+	StatusDone:       true,
+}
+
+// unfinishedBlockers returns the tasks blocking t that are not done yet.
+func unfinishedBlockers(appData *AppData, t Task) []TaskRef {
+	blockers := []TaskRef{}
+	for _, id := range t.BlockedBy {
+		if b := findTask(appData, id); b != nil && !b.Done {
+			blockers = append(blockers, taskRef(*b))
+		}
+	}
+	return blockers
+}
+
+// checkBlockers refuses to move t to a started status while one of its
+// blockers is unfinished. force skips the check.
+func checkBlockers(appData *AppData, t Task, status TaskStatus, force bool) error {
+	current := taskStatus(t)
+	if force || !startedStatuses[status] || status == current {
+		return nil
+	}
+	// Moving on from a column that was already allowed (or forced) is fine,
+	// but finishing is checked again.
+	if startedStatuses[current] && status != StatusDone {
+		return nil
+	}
+	blockers := unfinishedBlockers(appData, t)
+	if len(blockers) == 0 {
+		return nil
+	}
+	return &apiError{
+		Status:  http.StatusConflict,
+		Message: fmt.Sprintf("Task #%d is blocked by %d unfinished task(s); finish them first or force the move", t.ID, len(blockers)),
+		Data:    blockers,
+	}
+}
+
+// addDependency records that blockerID blocks taskID, rejecting links to
+// missing tasks, to the task itself, and links that would close a cycle.
+func addDependency(appData *AppData, taskID, blockerID int) error {
+	task := findTask(appData, taskID)
+	if task == nil {
+		return &apiError{Status: http.StatusNotFound, Message: "Task not found"}
+	}
+	if findTask(appData, blockerID) == nil {
+		return &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("blocking task #%d not found", blockerID)}
+	}
+	if blockerID == taskID {
+		return &apiError{Status: http.StatusBadRequest, Message: "a task cannot block itself"}
+	}
+	for _, id := range task.BlockedBy {
+		if id == blockerID {
+			return nil
+		}
+	}
+	if path := dependencyPath(appData, blockerID, taskID); path != nil {
+		return &apiError{
+			Status:  http.StatusConflict,
+			Message: fmt.Sprintf("#%d already depends on #%d; linking them would create a cycle", blockerID, taskID),
+			Data:    path,
+		}
+	}
+	task.BlockedBy = append(task.BlockedBy, blockerID)
+	sort.Ints(task.BlockedBy)
+	return nil
+}
+
+// removeDependency drops the link; removing a missing link is not an error.
+func removeDependency(appData *AppData, taskID, blockerID int) error {
+	task := findTask(appData, taskID)
+	if task == nil {
+		return &apiError{Status: http.StatusNotFound, Message: "Task not found"}
+	}
+	out := task.BlockedBy[:0]
+	for _, id := range task.BlockedBy {
+		if id != blockerID {
+			out = append(out, id)
+		}
+	}
+	task.BlockedBy = out
+	if len(task.BlockedBy) == 0 {
+		task.BlockedBy = nil
+	}
+	return nil
+}
+
+// dependencyPath returns the chain of task IDs through which from waits on
+// to (from is blocked by ... by to), or nil if it does not.
+func dependencyPath(appData *AppData, from, to int) []int {
+	blockedBy := make(map[int][]int, len(appData.Tasks))
+	for _, t := range appData.Tasks {
+		blockedBy[t.ID] = t.BlockedBy
+	}
+	seen := map[int]bool{}
+	var walk func(id int) []int
+	walk = func(id int) []int {
+		if id == to {
+			return []int{id}
+		}
+		if seen[id] {
+			return nil
+		}
+		seen[id] = true
+		for _, next := range blockedBy[id] {
+			if rest := walk(next); rest != nil {
+				return append([]int{id}, rest...)
+			}
+		}
+		return nil
+	}
+	return walk(from)
+}
+
+// pruneDependencies removes links to tasks that no longer exist. Call it
+// after deleting tasks.
+func pruneDependencies(appData *AppData) {
+	exists := make(map[int]bool, len(appData.Tasks))
+	for _, t := range appData.Tasks {
+		exists[t.ID] = true
+	}
+	for i := range appData.Tasks {
+		t := &appData.Tasks[i]
+		if len(t.BlockedBy) == 0 {
+			continue
+		}
+		out := t.BlockedBy[:0]
+		for _, id := range t.BlockedBy {
+			if exists[id] {
+				out = append(out, id)
+			}
+		}
+		t.BlockedBy = out
+		if len(t.BlockedBy) == 0 {
+			t.BlockedBy = nil
+		}
+	}
+}
+
+// blocksIndex maps each task ID to the IDs of the tasks it blocks.
+func blocksIndex(appData *AppData) map[int][]int {
+	blocks := map[int][]int{}
+	for _, t := range appData.Tasks {
+		for _, id := range t.BlockedBy {
+			blocks[id] = append(blocks[id], t.ID)
+		}
+	}
+	return blocks
+}
+
+// dependencyGraph collects every task upstream and downstream of id.
+// visible, when not nil, leaves out the tasks the caller may not see and
+// does not walk past them; Blocked still counts hidden blockers.
+func dependencyGraph(appData *AppData, id int, visible func(Task) bool) (DependencyGraph, error) {
+	task := findTask(appData, id)
+	if task == nil {
+		return DependencyGraph{}, &apiError{Status: http.StatusNotFound, Message: "Task not found"}
+	}
+	blocks := blocksIndex(appData)
+	shown := func(id int) *Task {
+		t := findTask(appData, id)
+		if t == nil || (visible != nil && !visible(*t)) {
+			return nil
+		}
+		return t
+	}
+
+	graph := DependencyGraph{
+		TaskID:    id,
+		BlockedBy: []TaskRef{},
+		Blocks:    []TaskRef{},
+		Nodes:     []TaskRef{},
+		Edges:     []DependencyEdge{},
+	}
+	for _, b := range task.BlockedBy {
+		if t := findTask(appData, b); t != nil && !t.Done {
+			graph.Blocked = true
+		}
+		if t := shown(b); t != nil {
+			graph.BlockedBy = append(graph.BlockedBy, taskRef(*t))
+		}
+	}
+	for _, b := range blocks[id] {
+		if t := shown(b); t != nil {
+			graph.Blocks = append(graph.Blocks, taskRef(*t))
+		}
+	}
+
+	nodes := map[int]bool{id: true}
+	edges := map[DependencyEdge]bool{}
+	// Walk upstream along BlockedBy and downstream along blocks.
+	var up, down func(int)
+	up = func(n int) {
+		for _, b := range findTask(appData, n).BlockedBy {
+			if shown(b) == nil {
+				continue
+			}
+			edges[DependencyEdge{From: b, To: n}] = true
+			if !nodes[b] {
+				nodes[b] = true
+				up(b)
+			}
+		}
+	}
+	down = func(n int) {
+		for _, b := range blocks[n] {
+			if shown(b) == nil {
+				continue
+			}
+			edges[DependencyEdge{From: n, To: b}] = true
+			if !nodes[b] {
+				nodes[b] = true
+				down(b)
+			}
+		}
+	}
+	up(id)
+	down(id)
+
+	ids := make([]int, 0, len(nodes))
+	for n := range nodes {
+		ids = append(ids, n)
+	}
+	sort.Ints(ids)
+	for _, n := range ids {
+		graph.Nodes = append(graph.Nodes, taskRef(*findTask(appData, n)))
+	}
+	for e := range edges {
+		graph.Edges = append(graph.Edges, e)
+	}
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].From != graph.Edges[j].From {
+			return graph.Edges[i].From < graph.Edges[j].From
+		}
+		return graph.Edges[i].To < graph.Edges[j].To
+	})
+	return graph, nil
+}
+
+// criticalPath finds the chain of unfinished tasks in a project whose
+// estimates add up to the most hours. Only links inside the project count,
+// and finished tasks are left out since they no longer hold anything up.
+func criticalPath(appData *AppData, projectID int) CriticalPath {
+	open := map[int]Task{}
+	for _, t := range appData.Tasks {
+		if t.ProjectID == projectID && !t.Done {
+			open[t.ID] = t
+		}
+	}
+
+	// finish[id] is the most hours of work that has to happen up to and
+	// including id; prev[id] is the blocker on that chain.
+	finish := map[int]float64{}
+	prev := map[int]int{}
+	visiting := map[int]bool{}
+	var longest func(id int) float64
+	longest = func(id int) float64 {
+		if f, ok := finish[id]; ok {
+			return f
+		}
+		if visiting[id] {
+			return 0 // a cycle in stored data; addDependency never creates one
+		}
+		visiting[id] = true
+		best, bestPrev := 0.0, 0
+		for _, b := range open[id].BlockedBy {
+			if _, ok := open[b]; !ok {
+				continue
+			}
+			if f := longest(b); f > best || (f == best && bestPrev == 0) {
+				best, bestPrev = f, b
+			}
+		}
+		finish[id] = best + open[id].EstimatedHours
+		prev[id] = bestPrev
+		return finish[id]
+	}
+
+	ids := make([]int, 0, len(open))
+	for id := range open {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	path := CriticalPath{ProjectID: projectID, Tasks: []TaskRef{}}
+	end := 0
+	for _, id := range ids {
+		if f := longest(id); end == 0 || f > path.TotalHours {
+			end, path.TotalHours = id, f
+		}
+	}
+	// A cycle in stored data can make prev loop; stop at the first repeat.
+	seen := map[int]bool{}
+	for id := end; id != 0 && !seen[id]; id = prev[id] {
+		seen[id] = true
+		path.Tasks = append([]TaskRef{taskRef(open[id])}, path.Tasks...)
+	}
+	return path
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDependencyGraphHidesOtherProjects(t *testing.T) {
+	// #1 (shared) is blocked by #2 (private), which is blocked by #3
+	// (shared); #4 (private) waits on #1.
+	appData := &AppData{
+		Users: []User{{ID: 1, Username: "ann"}, {ID: 2, Username: "bob"}},
+		Projects: []Project{
+			{ID: 1, Name: "Shared", DefaultRole: RoleViewer, Members: []ProjectMember{{Username: "ann", Role: RoleOwner}}},
+			{ID: 2, Name: "Private", Members: []ProjectMember{{Username: "ann", Role: RoleOwner}}},
+		},
+		Tasks: []Task{
+			{ID: 1, ProjectID: 1, BlockedBy: []int{2}},
+			{ID: 2, ProjectID: 2, BlockedBy: []int{3}},
+			{ID: 3, ProjectID: 1},
+			{ID: 4, ProjectID: 2, BlockedBy: []int{1}},
+		},
+	}
+	tests := []struct {
+		user                     string
+		nodes, edges, by, blocks string
+	}{
+		{"ann", "[1 2 3 4]", "[{1 4} {2 1} {3 2}]", "[2]", "[4]"},
+		{"bob", "[1]", "[]", "[]", "[]"},
+	}
+	for _, tt := range tests {
+		r := withUser(httptest.NewRequest("GET", "/api/tasks/1/dependencies", nil), User{Username: tt.user})
+		graph, err := dependencyGraph(appData, 1, canSeeTask(r, appData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var nodes, by, blocks []int
+		for _, n := range graph.Nodes {
+			nodes = append(nodes, n.ID)
+		}
+		for _, n := range graph.BlockedBy {
+			by = append(by, n.ID)
+		}
+		for _, n := range graph.Blocks {
+			blocks = append(blocks, n.ID)
+		}
+		got := fmt.Sprint(nodes, graph.Edges, by, blocks)
+		want := fmt.Sprint(tt.nodes, " ", tt.edges, " ", tt.by, " ", tt.blocks)
+		if got != want {
+			t.Errorf("%s: graph %s, want %s", tt.user, got, want)
+		}
+		if !graph.Blocked {
+			t.Errorf("%s: a hidden unfinished blocker should still block", tt.user)
+		}
+	}
+}
+
+func TestBlockersMustBeVisible(t *testing.T) {
+	newData := func() *AppData {
+		return &AppData{
+			Users: []User{{ID: 1, Username: "ann"}, {ID: 2, Username: "bob"}},
+			Projects: []Project{
+				{ID: 1, Name: "Shared", DefaultRole: RoleEditor, Members: []ProjectMember{{Username: "ann", Role: RoleOwner}}},
+				{ID: 2, Name: "Private", Members: []ProjectMember{{Username: "ann", Role: RoleOwner}}},
+			},
+			Tasks: []Task{{ID: 1, ProjectID: 1}, {ID: 2, ProjectID: 2}, {ID: 3, ProjectID: 1}},
+		}
+	}
+	tests := []struct {
+		name, user, method, path, body string
+		want                           int
+	}{
+		{"link own project", "bob", "POST", "/api/tasks/1/dependencies", `{"blocked_by": 3}`, http.StatusOK},
+		{"link hidden task", "bob", "POST", "/api/tasks/1/dependencies", `{"blocked_by": 2}`, http.StatusBadRequest},
+		{"member links across", "ann", "POST", "/api/tasks/1/dependencies", `{"blocked_by": 2}`, http.StatusOK},
+		{"create blocked by own", "bob", "POST", "/api/tasks", `{"description": "x", "project_id": 1, "blocked_by": [3]}`, http.StatusCreated},
+		{"create blocked by hidden", "bob", "POST", "/api/tasks", `{"description": "x", "project_id": 1, "blocked_by": [2]}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		useTestStore(t, signInAll(newData()))
+		w := serveAs(tt.user, tt.method, tt.path, tt.body)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+}
+
+func TestCriticalPathSurvivesStoredCycles(t *testing.T) {
+	// addDependency refuses cycles, but a hand-edited or imported data
+	// file can still hold one.
+	appData := &AppData{Tasks: []Task{
+		{ID: 1, ProjectID: 1, EstimatedHours: 2, BlockedBy: []int{2}},
+		{ID: 2, ProjectID: 1, EstimatedHours: 3, BlockedBy: []int{1}},
+		{ID: 3, ProjectID: 1, EstimatedHours: 1, BlockedBy: []int{3}},
+	}}
+	done := make(chan CriticalPath)
+	go func() { done <- criticalPath(appData, 1) }()
+	select {
+	case path := <-done:
+		if len(path.Tasks) == 0 || len(path.Tasks) > 2 {
+			t.Errorf("path = %+v", path.Tasks)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("criticalPath did not return on a cycle")
+	}
+}
+
+func TestAddDependency(t *testing.T) {
+	// #2 is blocked by #1 and #3 by #2.
+	newData := func() *AppData {
+		return &AppData{Tasks: []Task{
+			{ID: 1, ProjectID: 1},
+			{ID: 2, ProjectID: 1, BlockedBy: []int{1}},
+			{ID: 3, ProjectID: 1, BlockedBy: []int{2}},
+			{ID: 4, ProjectID: 1},
+		}}
+	}
+	tests := []struct {
+		name            string
+		task, blocker   int
+		status          int    // of the error; 0 for none
+		blockedBy, path string // #task's blockers afterwards, and the cycle reported
+	}{
+		{"new link", 4, 3, 0, "[3]", "[]"},
+		{"existing link", 2, 1, 0, "[1]", "[]"},
+		{"self", 4, 4, http.StatusBadRequest, "[]", "[]"},
+		{"missing blocker", 4, 9, http.StatusBadRequest, "[]", "[]"},
+		{"missing task", 9, 1, http.StatusNotFound, "", "[]"},
+		{"direct cycle", 1, 2, http.StatusConflict, "[]", "[2 1]"},
+		{"longer cycle", 1, 3, http.StatusConflict, "[]", "[3 2 1]"},
+	}
+	for _, tt := range tests {
+		appData := newData()
+		err := addDependency(appData, tt.task, tt.blocker)
+		status, path := 0, []int{}
+		if apiErr, ok := err.(*apiError); ok {
+			status = apiErr.Status
+			if p, ok := apiErr.Data.([]int); ok {
+				path = p
+			}
+		} else if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if status != tt.status || fmt.Sprint(path) != tt.path {
+			t.Errorf("%s: error %v with path %v, want status %d and path %s", tt.name, err, path, tt.status, tt.path)
+		}
+		if task := findTask(appData, tt.task); task != nil && fmt.Sprint(task.BlockedBy) != tt.blockedBy {
+			t.Errorf("%s: #%d blocked by %v, want %s", tt.name, tt.task, task.BlockedBy, tt.blockedBy)
+		}
+	}
+}
+
+func TestCriticalPath(t *testing.T) {
+	hours := func(id int, h float64, done bool, projectID int, blockedBy ...int) Task {
+		return Task{ID: id, ProjectID: projectID, EstimatedHours: h, Done: done, BlockedBy: blockedBy}
+	}
+	tests := []struct {
+		name  string
+		tasks []Task
+		want  string // task IDs in order, then the total
+	}{
+		{"empty", nil, "[] 0"},
+		{"single task", []Task{hours(1, 2, false, 1)}, "[1] 2"},
+		{"longest chain by hours", []Task{
+			hours(1, 1, false, 1), hours(2, 5, false, 1, 1),
+			hours(3, 4, false, 1), hours(4, 4, false, 1, 3),
+		}, "[3 4] 8"},
+		{"join picks the heavier branch", []Task{
+			hours(1, 2, false, 1), hours(2, 3, false, 1),
+			hours(3, 1, false, 1, 1, 2),
+		}, "[2 3] 4"},
+		{"finished tasks drop out", []Task{
+			hours(1, 10, true, 1), hours(2, 1, false, 1, 1), hours(3, 2, false, 1),
+		}, "[3] 2"},
+		{"other projects do not count", []Task{
+			hours(1, 10, false, 2), hours(2, 1, false, 1, 1), hours(3, 2, false, 1),
+		}, "[3] 2"},
+	}
+	for _, tt := range tests {
+		path := criticalPath(&AppData{Tasks: tt.tasks}, 1)
+		var ids []int
+		for _, ref := range path.Tasks {
+			ids = append(ids, ref.ID)
+		}
+		if got := fmt.Sprint(ids, " ", path.TotalHours); got != tt.want {
+			t.Errorf("%s: path %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every successful updateAppData is turned into typed events by comparing
+// the data before and after, so no handler has to remember to publish.
+// The web server streams them at /api/events; a reconnecting client sends
+// the last ID it saw and gets what it missed from a ring buffer. Events
+// only cover changes made by this process: a CLI edit while the server
+// runs shows up on the next reload.
+
+const (
+	eventBufferSize   = 1000
+	eventPingInterval = 25 * time.Second
+)
+
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"` // task.created, task.moved, task.completed, comment.added, ...
+	ProjectID int         `json:"project_id,omitempty"`
+	Data      interface{} `json:"data"`
+	Time      time.Time   `json:"time"`
+}
+
+// TaskMove is the data of a task.moved event.
+type TaskMove struct {
+	Task Task       `json:"task"`
+	From TaskStatus `json:"from"`
+}
+
+type eventHub struct {
+	mu          sync.Mutex
+	boot        string // tells event IDs from an earlier server run apart
+	seq         int64
+	buffer      []Event
+	subscribers map[chan struct{}]bool
+}
+
+var events = &eventHub{
+	boot:        strconv.FormatInt(time.Now().UnixNano(), 36),
+	subscribers: map[chan struct{}]bool{},
+}
+
+func (h *eventHub) publish(batch []Event) {
+	if len(batch) == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, e := range batch {
+		h.seq++
+		e.ID = fmt.Sprintf("%s-%d", h.boot, h.seq)
+		h.buffer = append(h.buffer, e)
+	}
+	if len(h.buffer) > eventBufferSize {
+		h.buffer = append([]Event(nil), h.buffer[len(h.buffer)-eventBufferSize:]...)
+	}
+	for ch := range h.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default: // already signalled
+		}
+	}
+}
+
+func (h *eventHub) subscribe() chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	h.subscribers[ch] = true
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, ch)
+}
+
+func (h *eventHub) latest() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.seq
+}
+
+// since returns the events after sequence number seq, and false if some of
+// them have already left the buffer.
+func (h *eventHub) since(seq int64) ([]Event, int64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	first := h.seq - int64(len(h.buffer)) + 1
+	if seq+1 < first {
+		return nil, h.seq, false
+	}
+	start := int(seq + 1 - first)
+	if start > len(h.buffer) {
+		start = len(h.buffer)
+	}
+	return append([]Event(nil), h.buffer[start:]...), h.seq, true
+}
+
+// resumePoint reads a Last-Event-ID. It returns false when the ID is from
+// another server run or unreadable, so the client must reload.
+func (h *eventHub) resumePoint(id string) (int64, bool) {
+	boot, seqStr, ok := strings.Cut(id, "-")
+	if !ok || boot != h.boot {
+		return 0, false
+	}
+	seq, err := strconv.ParseInt(seqStr, 10, 64)
+	return seq, err == nil
+}
+
+// changeEvents describes what an update changed. snap was taken before it.
+func changeEvents(snap versionSnapshot, appData *AppData) []Event {
+	now := time.Now()
+	var out []Event
+	add := func(typ string, projectID int, data interface{}) {
+		out = append(out, Event{Type: typ, ProjectID: projectID, Data: data, Time: now})
+	}
+
+	seen := map[int]bool{}
+	for _, p := range appData.Projects {
+		seen[p.ID] = true
+		before, ok := snap.projects[p.ID]
+		switch {
+		case !ok:
+			add("project.created", p.ID, p)
+		case jsonString(p) != before:
+			add("project.updated", p.ID, p)
+		}
+	}
+	for id := range snap.projects {
+		if !seen[id] {
+			add("project.deleted", id, map[string]int{"id": id})
+		}
+	}
+
+	seen = map[int]bool{}
+	for _, t := range appData.Tasks {
+		seen[t.ID] = true
+		before, ok := snap.tasks[t.ID]
+		if !ok {
+			add("task.created", t.ProjectID, t)
+			continue
+		}
+		if jsonString(t) == before {
+			continue
+		}
+		var old Task
+		json.Unmarshal([]byte(before), &old)
+		if old.Status != t.Status || old.Position != t.Position {
+			add("task.moved", t.ProjectID, TaskMove{Task: t, From: old.Status})
+		} else {
+			add("task.updated", t.ProjectID, t)
+		}
+		if t.Done && !old.Done {
+			add("task.completed", t.ProjectID, t)
+		}
+		known := map[int]bool{}
+		for _, c := range old.Comments {
+			known[c.ID] = true
+		}
+		for _, c := range t.Comments {
+			if !known[c.ID] {
+				add("comment.added", t.ProjectID, c)
+			}
+		}
+	}
+	for id, before := range snap.tasks {
+		if !seen[id] {
+			var old Task
+			json.Unmarshal([]byte(before), &old)
+			add("task.deleted", old.ProjectID, map[string]int{"id": id})
+		}
+	}
+
+	timers := timerChanges(snap, appData)
+	for _, e := range appData.TimeEntries {
+		projectID := taskProject(appData, e.TaskID)
+		switch timers[e.ID] {
+		case "started":
+			add("timer.started", projectID, e)
+		case "stopped":
+			add("timer.stopped", projectID, e)
+		case "added", "split":
+			add("time_entry.created", projectID, e)
+		}
+	}
+	return out
+}
+
+func jsonString(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// handleEvents streams events as Server-Sent Events. Clients resume with
+// the Last-Event-ID header (or ?last_event_id=); if the events they missed
+// are gone they get a "reset" event and should reload everything.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Streaming is not supported",
+		})
+		return
+	}
+
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	seq := events.latest()
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	reset := false
+	if lastID != "" {
+		if from, ok := events.resumePoint(lastID); ok {
+			seq = from
+		} else {
+			reset = true
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	user, _ := requestUser(r)
+	ping := time.NewTicker(eventPingInterval)
+	defer ping.Stop()
+	for {
+		if reset {
+			seq = events.latest()
+			fmt.Fprintf(w, "id: %s-%d\nevent: reset\ndata: {}\n\n", events.boot, seq)
+			reset = false
+		}
+		batch, latest, ok := events.since(seq)
+		if !ok {
+			reset = true
+			continue
+		}
+		if len(batch) > 0 {
+			appData, err := loadAppData()
+			if err != nil {
+				return
+			}
+			for _, e := range batch {
+				if checkProjectRole(appData, e.ProjectID, user.Username, RoleViewer) != nil {
+					continue
+				}
+				data, _ := json.Marshal(e)
+				fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+			}
+		}
+		seq = latest
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ch:
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTestStore points dataStore at a fresh file holding appData.
+func useTestStore(t *testing.T, appData *AppData) {
+	saved := dataStore
+	t.Cleanup(func() { dataStore = saved })
+	dataStore = &jsonStore{path: filepath.Join(t.TempDir(), "data.json")}
+	if err := dataStore.Save(appData); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// signInAll gives every user of appData a session whose token is their
+// username, for serveAs.
+func signInAll(appData *AppData) *AppData {
+	for _, u := range appData.Users {
+		appData.Sessions = append(appData.Sessions, Session{
+			TokenHash: hashToken(u.Username), UserID: u.ID, ExpiresAt: time.Now().Add(time.Hour),
+		})
+	}
+	return appData
+}
+
+// serveAs sends a request through routeHandler signed in as username.
+func serveAs(username, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+username)
+	w := httptest.NewRecorder()
+	routeHandler(w, r)
+	return w
+}
+
+// streamEvents subscribes to /api/events as username, runs publish once the
+// stream is open and returns the event types received up to and including
+// the first of type until.
+func streamEvents(t *testing.T, username string, publish func(), until string) []string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleEvents(w, withUser(r, User{Username: username}))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var types []string
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		line := lines.Text()
+		if strings.HasPrefix(line, "retry:") {
+			publish()
+		}
+		if typ, ok := strings.CutPrefix(line, "event: "); ok {
+			types = append(types, typ)
+			if typ == until {
+				return types
+			}
+		}
+	}
+	t.Fatalf("stream ended before %s: %v (%v)", until, types, lines.Err())
+	return nil
+}
+
+func TestEventsOfTrashedProjectsReachOnlyMembers(t *testing.T) {
+	private := Project{ID: 2, Name: "Private", Members: []ProjectMember{{Username: "ann", Role: RoleOwner}}}
+	useTestStore(t, &AppData{
+		Users: []User{{ID: 1, Username: "ann"}, {ID: 2, Username: "bob"}},
+		Projects: []Project{{
+			ID: 1, Name: "Default", DefaultRole: RoleViewer,
+			Members: []ProjectMember{{Username: "ann", Role: RoleOwner}},
+		}},
+		Trash: []TrashItem{{ID: 1, Kind: "project", ProjectID: 2, Project: &private, Tasks: []Task{{ID: 5, ProjectID: 2}}}},
+	})
+	publish := func() {
+		events.publish([]Event{
+			{Type: "task.deleted", ProjectID: 2, Data: map[string]int{"id": 5}},
+			{Type: "project.updated", ProjectID: 1},
+		})
+	}
+
+	if got := streamEvents(t, "bob", publish, "project.updated"); strings.Join(got, ",") != "project.updated" {
+		t.Errorf("non-member received %v", got)
+	}
+	if got := streamEvents(t, "ann", publish, "project.updated"); strings.Join(got, ",") != "task.deleted,project.updated" {
+		t.Errorf("member received %v", got)
+	}
+}
+
+func TestTimerEvents(t *testing.T) {
+	start := time.Date(2026, time.October, 14, 9, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	running := TimeEntry{ID: 1, TaskID: 1, User: "ann", StartTime: start}
+	closed := running
+	closed.EndTime, closed.Duration = &end, 3600
+	manual := TimeEntry{ID: 2, TaskID: 1, User: "ann", StartTime: start.Add(-3 * time.Hour), EndTime: &start, Duration: 10800}
+	edited := manual
+	edited.StartTime, edited.Duration = start.Add(-2*time.Hour), 7200
+
+	tests := []struct {
+		name          string
+		before, after []TimeEntry
+		want          string
+	}{
+		{"start", nil, []TimeEntry{running}, "timer.started"},
+		{"stop", []TimeEntry{running}, []TimeEntry{closed}, "timer.stopped"},
+		{"add by hand", []TimeEntry{running}, []TimeEntry{running, manual}, "time_entry.created"},
+		{"edit a closed entry", []TimeEntry{manual}, []TimeEntry{edited}, ""},
+	}
+	for _, tt := range tests {
+		appData := &AppData{Tasks: []Task{{ID: 1, ProjectID: 1}}, TimeEntries: tt.before}
+		snap := snapshotVersions(appData)
+		appData.TimeEntries = tt.after
+		var types []string
+		for _, e := range changeEvents(snap, appData) {
+			types = append(types, e.Type)
+		}
+		if got := strings.Join(types, ","); got != tt.want {
+			t.Errorf("%s: events %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
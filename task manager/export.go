@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tasks, projects and time entries can be exported as JSON (everything,
+// for backups and moving between installs), CSV (one row per task, for
+// spreadsheets), Markdown (a checklist per project, for reports) or
+// todo.txt (one line per task). import.go reads all four back. IDs in an
+// export are the exporting side's; the importer maps them to free IDs.
+
+var exportFormats = []string{"json", "csv", "md", "todotxt"}
+
+// ExportData is the JSON export format, and what the other formats are
+// parsed into before importing.
+type ExportData struct {
+	ExportedAt  time.Time   `json:"exported_at"`
+	Projects    []Project   `json:"projects"`
+	Tasks       []Task      `json:"tasks"`
+	TimeEntries []TimeEntry `json:"time_entries"`
+}
+
+var csvHeader = []string{
+	"id", "project", "parent_id", "description", "status", "priority", "category", "tags",
+	"assignee", "due_date", "estimated_hours", "tracked_hours", "blocked_by", "created_at", "completed_at",
+}
+
+// parseExportFormat accepts a format name or a file extension.
+func parseExportFormat(s string) (string, error) {
+	switch strings.ToLower(strings.TrimPrefix(strings.TrimSpace(s), ".")) {
+	case "json":
+		return "json", nil
+	case "csv":
+		return "csv", nil
+	case "md", "markdown":
+		return "md", nil
+	case "todotxt", "todo.txt", "txt", "todo":
+		return "todotxt", nil
+	}
+	return "", &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("unknown format %q (use %s)", s, strings.Join(exportFormats, ", "))}
+}
+
+// exportContentType returns the MIME type and file extension of a format.
+func exportContentType(format string) (string, string) {
+	switch format {
+	case "csv":
+		return "text/csv; charset=utf-8", "csv"
+	case "md":
+		return "text/markdown; charset=utf-8", "md"
+	case "todotxt":
+		return "text/plain; charset=utf-8", "txt"
+	}
+	return "application/json", "json"
+}
+
+// collectExport gathers the data to export, limited to one project when
+// projectID is not 0.
+func collectExport(appData *AppData, projectID int) (ExportData, error) {
+	data := ExportData{ExportedAt: time.Now(), Projects: []Project{}, Tasks: []Task{}, TimeEntries: []TimeEntry{}}
+	if projectID != 0 && findProject(appData, projectID, "") == nil {
+		return data, &apiError{Status: http.StatusNotFound, Message: fmt.Sprintf("project #%d not found", projectID)}
+	}
+	for _, p := range appData.Projects {
+		if projectID == 0 || p.ID == projectID {
+			p.Members = nil
+			data.Projects = append(data.Projects, p)
+		}
+	}
+	included := map[int]bool{}
+	for _, t := range appData.Tasks {
+		if projectID == 0 || t.ProjectID == projectID {
+			included[t.ID] = true
+			data.Tasks = append(data.Tasks, t)
+		}
+	}
+	for _, e := range appData.TimeEntries {
+		if included[e.TaskID] {
+			data.TimeEntries = append(data.TimeEntries, e)
+		}
+	}
+	return data, nil
+}
+
+// renderExport writes data in the given format.
+func renderExport(data ExportData, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		return json.MarshalIndent(data, "", "  ")
+	case "csv":
+		return exportCSV(data)
+	case "md":
+		return exportMarkdown(data), nil
+	case "todotxt":
+		return exportTodoTxt(data), nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// trackedHours sums the finished time entries of each task.
+func trackedHours(entries []TimeEntry) map[int]float64 {
+	hours := map[int]float64{}
+	for _, e := range entries {
+		if e.EndTime != nil {
+			hours[e.TaskID] += float64(e.Duration) / 3600
+		}
+	}
+	return hours
+}
+
+func projectNames(projects []Project) map[int]string {
+	names := map[int]string{}
+	for _, p := range projects {
+		names[p.ID] = p.Name
+	}
+	return names
+}
+
+func formatHours(h float64) string {
+	return strconv.FormatFloat(h, 'f', -1, 64)
+}
+
+func joinIDs(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+func exportCSV(data ExportData) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(csvHeader)
+	names := projectNames(data.Projects)
+	tracked := trackedHours(data.TimeEntries)
+	for _, t := range data.Tasks {
+		row := []string{
+			strconv.Itoa(t.ID), names[t.ProjectID], "", t.Description, string(taskStatus(t)),
+			strings.ToLower(t.Priority.String()), t.Category, strings.Join(t.Tags, ","), t.Assignee,
+			"", "", "", joinIDs(t.BlockedBy), t.CreatedAt.Format(time.RFC3339), "",
+		}
+		if t.ParentID != 0 {
+			row[2] = strconv.Itoa(t.ParentID)
+		}
+		if t.DueDate != nil {
+			row[9] = t.DueDate.Format(time.RFC3339)
+		}
+		if t.EstimatedHours != 0 {
+			row[10] = formatHours(t.EstimatedHours)
+		}
+		if h := tracked[t.ID]; h >= 0.005 {
+			row[11] = strconv.FormatFloat(h, 'f', 2, 64)
+		}
+		if t.CompletedAt != nil {
+			row[14] = t.CompletedAt.Format(time.RFC3339)
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// formatExportDate writes a due date as a plain date unless it has a time.
+func formatExportDate(t time.Time) string {
+	if hasClockTime(t) {
+		return t.Format("2006-01-02T15:04")
+	}
+	return t.Format("2006-01-02")
+}
+
+// exportMarkdown writes a checklist per project, subtasks indented under
+// their parents. Details follow the description in italics, separated by
+// " · ", so parseMarkdown can read them back.
+func exportMarkdown(data ExportData) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Tasks\n\nExported %s\n", data.ExportedAt.Format("2006-01-02 15:04"))
+
+	tracked := trackedHours(data.TimeEntries)
+	children := map[int][]Task{}
+	byProject := map[int][]Task{}
+	present := map[int]bool{}
+	for _, t := range data.Tasks {
+		present[t.ID] = true
+	}
+	for _, t := range data.Tasks {
+		if t.ParentID != 0 && present[t.ParentID] {
+			children[t.ParentID] = append(children[t.ParentID], t)
+		} else {
+			byProject[t.ProjectID] = append(byProject[t.ProjectID], t)
+		}
+	}
+
+	var write func(t Task, depth int)
+	write = func(t Task, depth int) {
+		mark := " "
+		if t.Done {
+			mark = "x"
+		}
+		fmt.Fprintf(&b, "%s- [%s] #%d %s", strings.Repeat("  ", depth), mark, t.ID, t.Description)
+		if details := markdownDetails(t, tracked[t.ID]); details != "" {
+			fmt.Fprintf(&b, " _(%s)_", details)
+		}
+		b.WriteString("\n")
+		for _, c := range children[t.ID] {
+			write(c, depth+1)
+		}
+	}
+
+	projects := append([]Project(nil), data.Projects...)
+	if len(byProject[0]) > 0 {
+		projects = append(projects, Project{Name: "No project"})
+	}
+	for _, p := range projects {
+		done, total, hours := 0, 0, 0.0
+		for _, t := range data.Tasks {
+			if t.ProjectID == p.ID {
+				total++
+				hours += tracked[t.ID]
+				if t.Done {
+					done++
+				}
+			}
+		}
+		fmt.Fprintf(&b, "\n## %s\n\n", p.Name)
+		if p.Description != "" {
+			fmt.Fprintf(&b, "%s\n\n", p.Description)
+		}
+		fmt.Fprintf(&b, "%d of %d done", done, total)
+		if hours >= 0.05 {
+			fmt.Fprintf(&b, " · %.1fh tracked", hours)
+		}
+		b.WriteString("\n\n")
+		for _, t := range byProject[p.ID] {
+			write(t, 0)
+		}
+	}
+	return b.Bytes()
+}
+
+func markdownDetails(t Task, tracked float64) string {
+	var parts []string
+	if t.Priority != Medium {
+		parts = append(parts, strings.ToLower(t.Priority.String()))
+	}
+	if status := taskStatus(t); status != StatusTodo && status != StatusDone {
+		parts = append(parts, strings.ReplaceAll(string(status), "_", " "))
+	}
+	if t.DueDate != nil {
+		parts = append(parts, "due "+formatExportDate(*t.DueDate))
+	}
+	if t.Category != "" {
+		parts = append(parts, "category: "+t.Category)
+	}
+	if len(t.Tags) > 0 {
+		parts = append(parts, "#"+strings.Join(t.Tags, " #"))
+	}
+	if t.Assignee != "" {
+		parts = append(parts, "@"+t.Assignee)
+	}
+	if t.EstimatedHours != 0 {
+		parts = append(parts, "est "+formatHours(t.EstimatedHours)+"h")
+	}
+	if tracked >= 0.005 {
+		parts = append(parts, strconv.FormatFloat(tracked, 'f', 2, 64)+"h tracked")
+	}
+	return strings.Join(parts, " · ")
+}
+
+// todoTxtPriorities maps priorities to todo.txt's (A)-(D).
+var todoTxtPriorities = map[Priority]string{Urgent: "A", High: "B", Medium: "C", Low: "D"}
+
+// exportTodoTxt writes one todo.txt line per task. Projects become
+// +Project and tags @context (spaces turned into underscores); everything
+// else goes into key:value extensions.
+func exportTodoTxt(data ExportData) []byte {
+	var b bytes.Buffer
+	names := projectNames(data.Projects)
+	tracked := trackedHours(data.TimeEntries)
+	for _, t := range data.Tasks {
+		var parts []string
+		if t.Done {
+			parts = append(parts, "x")
+			if t.CompletedAt != nil {
+				parts = append(parts, t.CompletedAt.Format("2006-01-02"))
+			}
+		} else {
+			parts = append(parts, "("+todoTxtPriorities[t.Priority]+")")
+		}
+		if !t.CreatedAt.IsZero() && (!t.Done || t.CompletedAt != nil) {
+			parts = append(parts, t.CreatedAt.Format("2006-01-02"))
+		}
+		parts = append(parts, strings.ReplaceAll(t.Description, "\n", " "))
+		if name := names[t.ProjectID]; name != "" {
+			parts = append(parts, "+"+todoTxtWord(name))
+		}
+		for _, tag := range t.Tags {
+			parts = append(parts, "@"+todoTxtWord(tag))
+		}
+		if t.DueDate != nil {
+			parts = append(parts, "due:"+formatExportDate(*t.DueDate))
+		}
+		parts = append(parts, "id:"+strconv.Itoa(t.ID))
+		if t.Done {
+			parts = append(parts, "pri:"+todoTxtPriorities[t.Priority])
+		}
+		if status := taskStatus(t); status != StatusTodo && status != StatusDone {
+			parts = append(parts, "status:"+string(status))
+		}
+		if t.ParentID != 0 {
+			parts = append(parts, "parent:"+strconv.Itoa(t.ParentID))
+		}
+		if len(t.BlockedBy) > 0 {
+			parts = append(parts, "blocked:"+joinIDs(t.BlockedBy))
+		}
+		if t.Category != "" {
+			parts = append(parts, "category:"+todoTxtWord(t.Category))
+		}
+		if t.Assignee != "" {
+			parts = append(parts, "assignee:"+t.Assignee)
+		}
+		if t.EstimatedHours != 0 {
+			parts = append(parts, "est:"+formatHours(t.EstimatedHours))
+		}
+		if h := tracked[t.ID]; h >= 0.005 {
+			parts = append(parts, "tracked:"+strconv.FormatFloat(h, 'f', 2, 64))
+		}
+		b.WriteString(strings.Join(parts, " ") + "\n")
+	}
+	return b.Bytes()
+}
+
+func todoTxtWord(s string) string {
+	return strings.Join(strings.Fields(s), "_")
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// Advisory locks are only implemented on unix; elsewhere the in-process
+// mutex is the only protection.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -1,3 +1,14 @@
 module taskmanager
 
 go 1.25.5
+
+require (
+	github.com/mattn/go-sqlite3 v1.14.33
+	github.com/peterh/liner v1.2.2
+	golang.org/x/crypto v0.46.0
+)
+
+require (
+	github.com/mattn/go-runewidth v0.0.3 // indirect
+	golang.org/x/sys v0.39.0 // indirect
+)
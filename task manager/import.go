@@ -0,0 +1,681 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Imports are parsed into ExportData whatever the format, then added by
+// importData. Every imported task and project gets a free ID in this data
+// file; the report lists the ones that changed so references elsewhere can
+// be fixed up. Projects are matched to existing ones by name. A task that
+// was here before the import with the same project, description, due date,
+// recurrence and status is taken to be a duplicate and skipped; tasks in
+// the import are never duplicates of each other. Both show up as conflicts, along with anything
+// that could not be imported, so a dry run tells what an import would do.
+
+type ImportOptions struct {
+	DryRun    bool
+	ProjectID int    // project for tasks that name none; 0 means Default
+	Owner     string // made owner of projects the import creates
+}
+
+type ImportReport struct {
+	DryRun      bool             `json:"dry_run"`
+	Projects    []ImportedItem   `json:"projects"`
+	Tasks       int              `json:"tasks_imported"`
+	TimeEntries int              `json:"time_entries_imported"`
+	Remapped    []IDRemap        `json:"remapped"`
+	Conflicts   []ImportConflict `json:"conflicts"`
+}
+
+// ImportedItem says where an imported project went.
+type ImportedItem struct {
+	Name    string `json:"name"`
+	ID      int    `json:"id"`
+	Created bool   `json:"created"`
+}
+
+// IDRemap records that an imported task or project got a different ID.
+type IDRemap struct {
+	Kind string `json:"kind"` // task or project
+	From int    `json:"from"`
+	To   int    `json:"to"`
+}
+
+type ImportConflict struct {
+	Kind    string `json:"kind"`         // project, task or time_entry
+	ID      int    `json:"id,omitempty"` // ID in the import
+	Message string `json:"message"`
+}
+
+// parseImport reads an export in any of the supported formats.
+func parseImport(content []byte, format string) (ExportData, error) {
+	var data ExportData
+	var err error
+	switch format {
+	case "json":
+		err = json.Unmarshal(content, &data)
+	case "csv":
+		data, err = parseCSV(content)
+	case "md":
+		data, err = parseMarkdown(content)
+	case "todotxt":
+		data, err = parseTodoTxt(content)
+	default:
+		_, err = parseExportFormat(format)
+	}
+	if err != nil {
+		if _, ok := err.(*apiError); !ok {
+			err = &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("cannot read %s: %v", format, err)}
+		}
+		return data, err
+	}
+	assignSourceIDs(&data)
+	return data, nil
+}
+
+// assignSourceIDs numbers tasks that came without an ID, after the highest
+// one given.
+func assignSourceIDs(data *ExportData) {
+	id := nextID(data.Tasks)
+	for i := range data.Tasks {
+		if data.Tasks[i].ID == 0 {
+			data.Tasks[i].ID = id
+			id++
+		}
+	}
+}
+
+// sourceProject returns the ID of the project called name in data, adding
+// it if needed. Projects known only by name get negative IDs, which never
+// show up as remapped.
+func sourceProject(data *ExportData, name string) int {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0
+	}
+	for _, p := range data.Projects {
+		if strings.EqualFold(p.Name, name) {
+			return p.ID
+		}
+	}
+	id := -len(data.Projects) - 1
+	data.Projects = append(data.Projects, Project{ID: id, Name: name})
+	return id
+}
+
+// trackedEntry turns the tracked hours of a CSV, Markdown or todo.txt task
+// into one finished time entry ending when the task was completed.
+func trackedEntry(data *ExportData, t Task, hours float64) {
+	if hours <= 0 {
+		return
+	}
+	end := time.Now()
+	if t.CompletedAt != nil {
+		end = *t.CompletedAt
+	}
+	duration := time.Duration(hours * float64(time.Hour))
+	data.TimeEntries = append(data.TimeEntries, TimeEntry{
+		ID:        len(data.TimeEntries) + 1,
+		TaskID:    t.ID,
+		StartTime: end.Add(-duration),
+		EndTime:   &end,
+		Duration:  int(duration.Seconds()),
+		Note:      "Imported",
+	})
+}
+
+// parseImportTime reads a timestamp or anything parseDate understands.
+func parseImportTime(s string) (*time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return &t, nil
+	}
+	return parseDate(s)
+}
+
+func parseIDList(s string) []int {
+	var ids []int
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ';' || r == ' ' }) {
+		if id, err := strconv.Atoi(strings.TrimPrefix(part, "#")); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// csvColumns maps header names, including common ones from other tools,
+// to our fields.
+var csvColumns = map[string]string{
+	"id": "id", "task_id": "id",
+	"project": "project", "list": "project",
+	"parent_id": "parent_id", "parent": "parent_id",
+	"description": "description", "title": "description", "task": "description", "name": "description", "summary": "description",
+	"status": "status", "state": "status",
+	"done": "done", "completed": "done",
+	"priority": "priority",
+	"category": "category",
+	"tags":     "tags", "labels": "tags",
+	"assignee": "assignee", "owner": "assignee",
+	"due_date": "due_date", "due": "due_date",
+	"estimated_hours": "estimated_hours", "estimate": "estimated_hours",
+	"tracked_hours": "tracked_hours", "hours": "tracked_hours",
+	"blocked_by": "blocked_by",
+	"created_at": "created_at", "created": "created_at",
+	"completed_at": "completed_at",
+}
+
+// parseCSV reads a CSV with a header row. Columns are found by name, so
+// only a description column is required.
+func parseCSV(content []byte) (ExportData, error) {
+	data := ExportData{}
+	r := csv.NewReader(bytes.NewReader(content))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return data, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		key := strings.ToLower(strings.Join(strings.Fields(strings.TrimPrefix(name, "\ufeff")), "_"))
+		if field, ok := csvColumns[key]; ok {
+			if _, seen := columns[field]; !seen {
+				columns[field] = i
+			}
+		}
+	}
+	if _, ok := columns["description"]; !ok {
+		return data, &apiError{Status: http.StatusBadRequest, Message: "CSV needs a description (or title) column"}
+	}
+
+	for line := 2; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return data, err
+		}
+		get := func(field string) string {
+			if i, ok := columns[field]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		t, err := importedTask(get)
+		if err != nil {
+			return data, &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("line %d: %v", line, err)}
+		}
+		t.ProjectID = sourceProject(&data, get("project"))
+		if t.ID == 0 {
+			t.ID = -len(data.Tasks) - 1
+		}
+		data.Tasks = append(data.Tasks, t)
+		hours, _ := strconv.ParseFloat(get("tracked_hours"), 64)
+		trackedEntry(&data, t, hours)
+	}
+	renumberSynthetic(&data)
+	return data, nil
+}
+
+// importedTask builds a task from named text fields, as found in CSV.
+func importedTask(get func(string) string) (Task, error) {
+	t := Task{Description: get("description"), Category: get("category"), Assignee: get("assignee"), Priority: Medium}
+	t.ID, _ = strconv.Atoi(get("id"))
+	t.ParentID, _ = strconv.Atoi(get("parent_id"))
+	t.BlockedBy = parseIDList(get("blocked_by"))
+	if p := get("priority"); p != "" {
+		t.Priority = parseImportPriority(p)
+	}
+	if tags := get("tags"); tags != "" {
+		t.Tags = strings.FieldsFunc(tags, func(r rune) bool { return r == ',' || r == ';' })
+	}
+	t.Status = StatusTodo
+	if s := get("status"); s != "" {
+		status, err := parseStatus(s)
+		if err != nil {
+			return t, err
+		}
+		t.Status = status
+	} else if done, _ := strconv.ParseBool(get("done")); done || strings.EqualFold(get("done"), "x") {
+		t.Status = StatusDone
+	}
+	t.Done = t.Status == StatusDone
+	if s := get("due_date"); s != "" {
+		due, err := parseImportTime(s)
+		if err != nil {
+			return t, err
+		}
+		t.DueDate = due
+	}
+	if s := get("estimated_hours"); s != "" {
+		hours, err := strconv.ParseFloat(strings.TrimSuffix(s, "h"), 64)
+		if err != nil {
+			return t, fmt.Errorf("invalid estimate %q", s)
+		}
+		t.EstimatedHours = hours
+	}
+	if s := get("created_at"); s != "" {
+		if created, err := parseImportTime(s); err == nil {
+			t.CreatedAt = *created
+		}
+	}
+	if s := get("completed_at"); s != "" && t.Done {
+		if completed, err := parseImportTime(s); err == nil {
+			t.CompletedAt = completed
+		}
+	}
+	return t, nil
+}
+
+// parseImportPriority also understands todo.txt letters.
+func parseImportPriority(s string) Priority {
+	for p, letter := range todoTxtPriorities {
+		if strings.EqualFold(s, letter) {
+			return p
+		}
+	}
+	return parsePriority(s)
+}
+
+var markdownTask = regexp.MustCompile(`^(\s*)[-*+] \[([ xX])\] (?:#(\d+) )?(.*?)(?: _\((.*)\)_)?\s*$`)
+
+// parseMarkdown reads "## Project" headings and "- [ ] task" items, with
+// nested items becoming subtasks. It understands the details written by
+// exportMarkdown and ignores everything else.
+func parseMarkdown(content []byte) (ExportData, error) {
+	data := ExportData{}
+	projectID := 0
+	type level struct{ indent, id int }
+	var parents []level
+	nextSynthetic := -1
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if heading, ok := strings.CutPrefix(line, "## "); ok {
+			projectID = 0
+			if name := strings.TrimSpace(heading); name != "No project" {
+				projectID = sourceProject(&data, name)
+			}
+			parents = nil
+			continue
+		}
+		m := markdownTask.FindStringSubmatch(line)
+		if m == nil || strings.TrimSpace(m[4]) == "" {
+			continue
+		}
+		t := Task{Description: strings.TrimSpace(m[4]), ProjectID: projectID, Priority: Medium, Status: StatusTodo}
+		if m[2] != " " {
+			t.Status, t.Done = StatusDone, true
+		}
+		if m[3] != "" {
+			t.ID, _ = strconv.Atoi(m[3])
+		} else {
+			// Numbered properly by assignSourceIDs once all IDs are known.
+			t.ID = nextSynthetic
+			nextSynthetic--
+		}
+		indent := len(strings.ReplaceAll(m[1], "\t", "  "))
+		for len(parents) > 0 && parents[len(parents)-1].indent >= indent {
+			parents = parents[:len(parents)-1]
+		}
+		if len(parents) > 0 {
+			t.ParentID = parents[len(parents)-1].id
+		}
+		parents = append(parents, level{indent, t.ID})
+
+		hours, err := parseMarkdownDetails(&t, m[5])
+		if err != nil {
+			return data, fmt.Errorf("line %d: %v", n, err)
+		}
+		data.Tasks = append(data.Tasks, t)
+		trackedEntry(&data, t, hours)
+	}
+	if err := scanner.Err(); err != nil {
+		return data, err
+	}
+	renumberSynthetic(&data)
+	return data, nil
+}
+
+func parseMarkdownDetails(t *Task, details string) (float64, error) {
+	tracked := 0.0
+	for _, part := range strings.Split(details, " · ") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+		case strings.HasPrefix(part, "due "):
+			due, err := parseImportTime(strings.TrimPrefix(part, "due "))
+			if err != nil {
+				return 0, err
+			}
+			t.DueDate = due
+		case strings.HasPrefix(part, "category: "):
+			t.Category = strings.TrimPrefix(part, "category: ")
+		case strings.HasPrefix(part, "#"):
+			for _, tag := range strings.Fields(part) {
+				t.Tags = append(t.Tags, strings.TrimPrefix(tag, "#"))
+			}
+		case strings.HasPrefix(part, "@"):
+			t.Assignee = strings.TrimPrefix(part, "@")
+		case strings.HasPrefix(part, "est "):
+			t.EstimatedHours, _ = strconv.ParseFloat(strings.TrimSuffix(strings.TrimPrefix(part, "est "), "h"), 64)
+		case strings.HasSuffix(part, "h tracked"):
+			tracked, _ = strconv.ParseFloat(strings.TrimSuffix(part, "h tracked"), 64)
+		default:
+			if status, err := parseStatus(part); err == nil {
+				t.Status = status
+			} else if p := strings.ToUpper(part); p == "LOW" || p == "HIGH" || p == "URGENT" || p == "MEDIUM" {
+				t.Priority = parsePriority(p)
+			}
+		}
+	}
+	return tracked, nil
+}
+
+// renumberSynthetic gives the negative placeholder IDs of tasks that came
+// without one real ones, updating parent links and time entries.
+func renumberSynthetic(data *ExportData) {
+	next := 1
+	for _, t := range data.Tasks {
+		if t.ID >= next {
+			next = t.ID + 1
+		}
+	}
+	ids := map[int]int{}
+	for i := range data.Tasks {
+		if data.Tasks[i].ID < 0 {
+			ids[data.Tasks[i].ID] = next
+			data.Tasks[i].ID = next
+			next++
+		}
+	}
+	for i := range data.Tasks {
+		if id, ok := ids[data.Tasks[i].ParentID]; ok {
+			data.Tasks[i].ParentID = id
+		}
+	}
+	for i := range data.TimeEntries {
+		if id, ok := ids[data.TimeEntries[i].TaskID]; ok {
+			data.TimeEntries[i].TaskID = id
+		}
+	}
+}
+
+var todoTxtDate = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// parseTodoTxt reads todo.txt lines: "x" and dates at the start, (A)-(D)
+// priorities, +project, @context as tags and the key:value extensions
+// written by exportTodoTxt.
+func parseTodoTxt(content []byte) (ExportData, error) {
+	data := ExportData{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		words := strings.Fields(scanner.Text())
+		if len(words) == 0 {
+			continue
+		}
+		t := Task{Priority: Medium, Status: StatusTodo}
+		if words[0] == "x" {
+			t.Status, t.Done = StatusDone, true
+			words = words[1:]
+			if len(words) > 1 && todoTxtDate.MatchString(words[0]) && todoTxtDate.MatchString(words[1]) {
+				completed, _ := time.ParseInLocation("2006-01-02", words[0], time.Local)
+				t.CompletedAt = &completed
+				words = words[1:]
+			}
+		}
+		if len(words) > 0 && len(words[0]) == 3 && words[0][0] == '(' && words[0][2] == ')' {
+			t.Priority = parseImportPriority(words[0][1:2])
+			words = words[1:]
+		}
+		if len(words) > 0 && todoTxtDate.MatchString(words[0]) {
+			t.CreatedAt, _ = time.ParseInLocation("2006-01-02", words[0], time.Local)
+			words = words[1:]
+		}
+
+		var desc []string
+		tracked := 0.0
+		for _, w := range words {
+			key, value, isExt := strings.Cut(w, ":")
+			switch {
+			case len(w) > 1 && w[0] == '+':
+				t.ProjectID = sourceProject(&data, strings.ReplaceAll(w[1:], "_", " "))
+			case len(w) > 1 && w[0] == '@':
+				t.Tags = append(t.Tags, strings.ReplaceAll(w[1:], "_", " "))
+			case isExt && value != "" && !strings.Contains(value, "/"):
+				switch key {
+				case "due":
+					due, err := parseImportTime(value)
+					if err != nil {
+						return data, fmt.Errorf("line %d: %v", line, err)
+					}
+					t.DueDate = due
+				case "id":
+					t.ID, _ = strconv.Atoi(value)
+				case "pri":
+					t.Priority = parseImportPriority(value)
+				case "status":
+					status, err := parseStatus(value)
+					if err != nil {
+						return data, fmt.Errorf("line %d: %v", line, err)
+					}
+					t.Status = status
+				case "parent":
+					t.ParentID, _ = strconv.Atoi(value)
+				case "blocked":
+					t.BlockedBy = parseIDList(value)
+				case "category":
+					t.Category = strings.ReplaceAll(value, "_", " ")
+				case "assignee":
+					t.Assignee = value
+				case "est":
+					t.EstimatedHours, _ = strconv.ParseFloat(value, 64)
+				case "tracked":
+					tracked, _ = strconv.ParseFloat(value, 64)
+				default:
+					desc = append(desc, w)
+				}
+			default:
+				desc = append(desc, w)
+			}
+		}
+		t.Description = strings.Join(desc, " ")
+		if t.Description == "" {
+			continue
+		}
+		if t.ID == 0 {
+			t.ID = -len(data.Tasks) - 1
+		}
+		data.Tasks = append(data.Tasks, t)
+		trackedEntry(&data, t, tracked)
+	}
+	if err := scanner.Err(); err != nil {
+		return data, err
+	}
+	renumberSynthetic(&data)
+	return data, nil
+}
+
+// importKey identifies a task for spotting duplicates of it.
+func importKey(projectID int, t Task) string {
+	due := ""
+	if t.DueDate != nil {
+		due = t.DueDate.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%d\x00%s\x00%s\x00%s\x00%s", projectID, strings.ToLower(strings.TrimSpace(t.Description)),
+		due, jsonString(t.Recurrence), taskStatus(t))
+}
+
+// importData adds data to appData and reports what it did. A dry run is
+// the same call on data that is then not saved.
+func importData(appData *AppData, data ExportData, opts ImportOptions) (ImportReport, error) {
+	report := ImportReport{DryRun: opts.DryRun, Projects: []ImportedItem{}, Remapped: []IDRemap{}, Conflicts: []ImportConflict{}}
+	conflict := func(kind string, id int, format string, args ...interface{}) {
+		if id < 0 {
+			id = 0
+		}
+		report.Conflicts = append(report.Conflicts, ImportConflict{Kind: kind, ID: id, Message: fmt.Sprintf(format, args...)})
+	}
+	if opts.ProjectID != 0 && findProject(appData, opts.ProjectID, "") == nil {
+		return report, &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("project #%d not found", opts.ProjectID)}
+	}
+
+	projects := map[int]int{}
+	for _, p := range data.Projects {
+		name := strings.TrimSpace(p.Name)
+		if name == "" {
+			conflict("project", p.ID, "project #%d has no name; its tasks go to the default project", p.ID)
+			continue
+		}
+		if existing := findProject(appData, 0, name); existing != nil && strings.EqualFold(existing.Name, name) {
+			projects[p.ID] = existing.ID
+			report.Projects = append(report.Projects, ImportedItem{Name: existing.Name, ID: existing.ID})
+			conflict("project", p.ID, "project %q already exists as #%d; its tasks are added to it", name, existing.ID)
+			continue
+		}
+		created := Project{
+			ID:          newProjectID(appData),
+			Name:        name,
+			Description: p.Description,
+			Color:       p.Color,
+			CreatedAt:   p.CreatedAt,
+			UpdatedAt:   time.Now(),
+		}
+		if created.Color == "" {
+			created.Color = "#6366f1"
+		}
+		if created.CreatedAt.IsZero() {
+			created.CreatedAt = time.Now()
+		}
+		if opts.Owner != "" {
+			created.Members = []ProjectMember{{Username: opts.Owner, Role: RoleOwner}}
+		}
+		appData.Projects = append(appData.Projects, created)
+		projects[p.ID] = created.ID
+		report.Projects = append(report.Projects, ImportedItem{Name: name, ID: created.ID, Created: true})
+		if p.ID > 0 && p.ID != created.ID {
+			report.Remapped = append(report.Remapped, IDRemap{Kind: "project", From: p.ID, To: created.ID})
+		}
+	}
+
+	// Tasks that were already here before the import, for spotting
+	// duplicates.
+	existing := map[string]int{}
+	for _, t := range appData.Tasks {
+		existing[importKey(t.ProjectID, t)] = t.ID
+	}
+
+	tasks := map[int]int{}
+	imported := map[int]bool{}
+	for _, src := range data.Tasks {
+		projectID := projects[src.ProjectID]
+		if projectID == 0 {
+			projectID = opts.ProjectID
+		}
+		if projectID == 0 {
+			projectID = defaultProject(appData).ID
+		}
+		if id, ok := existing[importKey(projectID, src)]; ok {
+			tasks[src.ID] = id
+			conflict("task", src.ID, "%q already exists as #%d; skipped", src.Description, id)
+			continue
+		}
+		priority := src.Priority
+		task, err := createTask(appData, TaskInput{
+			Description:    src.Description,
+			ProjectID:      projectID,
+			Category:       src.Category,
+			Priority:       &priority,
+			Status:         string(taskStatus(src)),
+			DueDate:        src.DueDate,
+			Tags:           src.Tags,
+			Assignee:       src.Assignee,
+			EstimatedHours: src.EstimatedHours,
+			Recurrence:     src.Recurrence,
+		})
+		if err != nil {
+			conflict("task", src.ID, "%q: %v; skipped", src.Description, err)
+			continue
+		}
+		if !src.CreatedAt.IsZero() {
+			task.CreatedAt = src.CreatedAt
+		}
+		if task.Done && src.CompletedAt != nil {
+			task.CompletedAt = src.CompletedAt
+		}
+		for _, c := range src.Comments {
+			c.TaskID = task.ID
+			task.Comments = append(task.Comments, c)
+		}
+		task.Checklist = src.Checklist
+		tasks[src.ID] = task.ID
+		imported[src.ID] = true
+		report.Tasks++
+		if src.ID != task.ID {
+			report.Remapped = append(report.Remapped, IDRemap{Kind: "task", From: src.ID, To: task.ID})
+		}
+	}
+
+	// Links are set once every task has its new ID.
+	for _, src := range data.Tasks {
+		if !imported[src.ID] {
+			continue
+		}
+		id := tasks[src.ID]
+		if src.SeriesID != 0 {
+			if seriesID, ok := tasks[src.SeriesID]; ok {
+				findTask(appData, id).SeriesID = seriesID
+			}
+		}
+		if src.ParentID != 0 {
+			task := findTask(appData, id)
+			if parentID, ok := tasks[src.ParentID]; !ok {
+				conflict("task", src.ID, "parent #%d is not in the import; imported as a top-level task", src.ParentID)
+			} else if err := validateParent(appData, id, parentID, task.ProjectID); err != nil {
+				conflict("task", src.ID, "cannot become a subtask of #%d: %v", parentID, err)
+			} else {
+				task.ParentID = parentID
+			}
+		}
+		for _, blocker := range src.BlockedBy {
+			blockerID, ok := tasks[blocker]
+			if !ok {
+				conflict("task", src.ID, "blocker #%d is not in the import; dropped", blocker)
+				continue
+			}
+			if err := addDependency(appData, id, blockerID); err != nil {
+				conflict("task", src.ID, "blocker #%d: %v", blocker, err)
+			}
+		}
+	}
+
+	for _, e := range data.TimeEntries {
+		taskID, ok := tasks[e.TaskID]
+		switch {
+		case !ok:
+			conflict("time_entry", e.ID, "task #%d is not in the import; skipped", e.TaskID)
+		case !imported[e.TaskID]:
+			// Its task was a duplicate, so this time is most likely here already.
+			conflict("time_entry", e.ID, "task #%d was skipped; its time entry too", e.TaskID)
+		case e.EndTime == nil:
+			conflict("time_entry", e.ID, "timer still running; skipped")
+		default:
+			e.ID = nextTimeEntryID(appData.TimeEntries)
+			e.TaskID = taskID
+			appData.TimeEntries = append(appData.TimeEntries, e)
+			report.TimeEntries++
+		}
+	}
+	return report, nil
+}
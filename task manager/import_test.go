@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// exportFixture has a recurring series whose occurrences share their
+// description, plus two unrelated tasks with the same description.
+func exportFixture() *AppData {
+	day := func(d, h int) *time.Time {
+		t := time.Date(2026, time.October, d, h, 0, 0, 0, time.FixedZone("", 5*60*60+30*60))
+		return &t
+	}
+	created := time.Date(2026, time.September, 1, 8, 0, 0, 0, time.UTC)
+	weekly := &Recurrence{Freq: "weekly", Interval: 1, Weekdays: []string{"MO"}}
+	done := func(t Task, d int) Task {
+		t.Status, t.Done, t.CompletedAt = StatusDone, true, day(d, 17)
+		return t
+	}
+	task := func(id, project int, desc string, due *time.Time) Task {
+		return Task{ID: id, ProjectID: project, Description: desc, Priority: Medium, Status: StatusTodo, DueDate: due, CreatedAt: created}
+	}
+
+	tasks := []Task{
+		done(task(1, 2, "Weekly report", day(5, 9)), 5),
+		done(task(2, 2, "Weekly report", day(12, 9)), 12),
+		task(3, 2, "Weekly report", day(19, 9)),
+		task(4, 1, "Call the bank", day(20, 15)),
+		task(5, 1, "Call the bank", nil),
+		task(6, 2, "Draft the outline", nil),
+		task(7, 2, "Send the draft", day(22, 23)),
+	}
+	for i := 0; i < 3; i++ {
+		tasks[i].SeriesID = 1
+	}
+	tasks[2].Recurrence = weekly
+	tasks[3].Tags = []string{"errand"}
+	tasks[3].Priority = High
+	tasks[5].ParentID = 7
+	tasks[5].Checklist = []ChecklistItem{{ID: 1, Text: "Intro", Done: true}}
+	tasks[6].BlockedBy = []int{4}
+	tasks[6].Comments = []Comment{{ID: 1, TaskID: 7, Author: "ann", Text: "Soon", CreatedAt: created}}
+	return &AppData{
+		Projects: []Project{{ID: 1, Name: "Default", Color: "#6366f1"}, {ID: 2, Name: "Work", Color: "#10b981"}},
+		Tasks:    tasks,
+	}
+}
+
+// comparableTasks drops what an import assigns afresh.
+func comparableTasks(tasks []Task) string {
+	out := make([]Task, len(tasks))
+	for i, t := range tasks {
+		t.Position, t.Version = 0, 0
+		out[i] = t
+	}
+	return jsonString(out)
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	source := exportFixture()
+	data, err := collectExport(source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := renderExport(data, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseImport(content, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := &AppData{}
+	report, err := importData(target, parsed, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Tasks != len(source.Tasks) {
+		t.Errorf("imported %d tasks, want %d; conflicts: %+v", report.Tasks, len(source.Tasks), report.Conflicts)
+	}
+	if got, want := comparableTasks(target.Tasks), comparableTasks(source.Tasks); got != want {
+		t.Errorf("tasks after round trip:\n got %s\nwant %s", got, want)
+	}
+
+	// Importing the same file again finds nothing new.
+	again, err := importData(target, parsed, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Tasks != 0 || len(target.Tasks) != len(source.Tasks) {
+		t.Errorf("second import added %d tasks, now %d", again.Tasks, len(target.Tasks))
+	}
+}
+
+func TestCSVKeepsDueDateOffset(t *testing.T) {
+	source := exportFixture()
+	data, err := collectExport(source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := renderExport(data, "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseImport(content, "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range source.Tasks {
+		got := parsed.Tasks[i]
+		switch {
+		case want.DueDate == nil && got.DueDate != nil:
+			t.Errorf("task #%d: due %v, want none", want.ID, got.DueDate)
+		case want.DueDate != nil && (got.DueDate == nil || !got.DueDate.Equal(*want.DueDate)):
+			t.Errorf("task #%d: due %v, want %v", want.ID, got.DueDate, want.DueDate)
+		}
+	}
+}
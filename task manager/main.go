@@ -1,12 +1,11 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -80,37 +79,59 @@ const (
 type TimeEntry struct {
 	ID        int        `json:"id"`
 	TaskID    int        `json:"task_id"`
+	User      string     `json:"user,omitempty"` // who tracked it
 	StartTime time.Time  `json:"start_time"`
 	EndTime   *time.Time `json:"end_time,omitempty"`
 	Duration  int        `json:"duration"` // seconds
 	Note      string     `json:"note,omitempty"`
+	Billable  *bool      `json:"billable,omitempty"` // nil: as the project
+
+	Segments   []TimeSegment `json:"segments,omitempty"` // set once paused; only these count
+	Paused     bool          `json:"paused,omitempty"`
+	AutoClosed bool          `json:"auto_closed,omitempty"` // closed at the timer limit
+}
+
+type TimeSegment struct {
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end,omitempty"`
 }
 
 type Project struct {
-	ID          int       `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description,omitempty"`
-	Color       string    `json:"color"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          int             `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Color       string          `json:"color"`
+	Members     []ProjectMember `json:"members,omitempty"`
+	DefaultRole Role            `json:"default_role,omitempty"` // of signed-in users who are not members
+	HourlyRate  float64         `json:"hourly_rate,omitempty"`
+	Billable    bool            `json:"billable,omitempty"` // entries are billable unless they say otherwise
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Version     int             `json:"version"`
 }
 
 type Task struct {
-	ID             int        `json:"id"`
-	ProjectID      int        `json:"project_id"`
-	Description    string     `json:"description"`
-	Category       string     `json:"category,omitempty"`
-	Priority       Priority   `json:"priority"`
-	Status         TaskStatus `json:"status"`
-	Done           bool       `json:"done"`
-	DueDate        *time.Time `json:"due_date,omitempty"`
-	CreatedAt      time.Time  `json:"created_at"`
-	CompletedAt    *time.Time `json:"completed_at,omitempty"`
-	Tags           []string   `json:"tags,omitempty"`
-	Assignee       string     `json:"assignee,omitempty"`
-	EstimatedHours float64    `json:"estimated_hours,omitempty"`
-	Position       int        `json:"position"` // For kanban ordering
-	Comments       []Comment  `json:"comments,omitempty"`
+	ID             int             `json:"id"`
+	ProjectID      int             `json:"project_id"`
+	ParentID       int             `json:"parent_id,omitempty"` // 0 for top-level tasks
+	Description    string          `json:"description"`
+	Category       string          `json:"category,omitempty"`
+	Priority       Priority        `json:"priority"`
+	Status         TaskStatus      `json:"status"`
+	Done           bool            `json:"done"`
+	DueDate        *time.Time      `json:"due_date,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	CompletedAt    *time.Time      `json:"completed_at,omitempty"`
+	Tags           []string        `json:"tags,omitempty"`
+	Assignee       string          `json:"assignee,omitempty"`
+	EstimatedHours float64         `json:"estimated_hours,omitempty"`
+	Position       int             `json:"position"` // For kanban ordering
+	Comments       []Comment       `json:"comments,omitempty"`
+	Checklist      []ChecklistItem `json:"checklist,omitempty"`
+	BlockedBy      []int           `json:"blocked_by,omitempty"` // IDs of tasks that must be done first
+	Recurrence     *Recurrence     `json:"recurrence,omitempty"` // only on the open occurrence of a series
+	SeriesID       int             `json:"series_id,omitempty"`  // ID of the first task of a recurring series
+	Version        int             `json:"version"`              // Bumped on every change, exposed as the ETag
 }
 
 type Comment struct {
@@ -121,53 +142,78 @@ type Comment struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+type ChecklistItem struct {
+	ID   int    `json:"id"`
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
 type AppData struct {
 	Projects    []Project   `json:"projects"`
 	Tasks       []Task      `json:"tasks"`
 	TimeEntries []TimeEntry `json:"time_entries"`
-}
+	Users       []User      `json:"users,omitempty"`
+	Sessions    []Session   `json:"sessions,omitempty"`
+	APIKeys     []APIKey    `json:"api_keys,omitempty"`
+	Activity    []Activity  `json:"activity,omitempty"`
+	Trash       []TrashItem `json:"trash,omitempty"`
 
-func dataFile() (string, error) {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return "", err
-	}
-	return filepath.Join(home, ".project_manager.json"), nil
+	// The highest task and project IDs ever handed out. IDs are never
+	// reused, so a purged task's history cannot attach to a new one.
+	LastTaskID    int `json:"last_task_id,omitempty"`
+	LastProjectID int `json:"last_project_id,omitempty"`
+
+	CalendarTokens []CalendarToken `json:"calendar_tokens,omitempty"`
+	ReminderPrefs  []ReminderPrefs `json:"reminder_prefs,omitempty"`
+	SentReminders  []SentReminder  `json:"sent_reminders,omitempty"`
+	Snoozes        []Snooze        `json:"snoozes,omitempty"`
+
+	Webhooks          []Webhook         `json:"webhooks,omitempty"`
+	WebhookDeliveries []WebhookDelivery `json:"webhook_deliveries,omitempty"`
 }
 
 func loadAppData() (*AppData, error) {
-	path, err := dataFile()
+	appData, err := dataStore.Load()
 	if err != nil {
 		return nil, err
 	}
-	data, err := os.ReadFile(path)
-	if errors.Is(err, os.ErrNotExist) {
-		return &AppData{
-			Projects:    []Project{},
-			Tasks:       []Task{},
-			TimeEntries: []TimeEntry{},
-		}, nil
-	}
-	if err != nil {
-		return nil, err
-	}
-	var appData AppData
-	if err := json.Unmarshal(data, &appData); err != nil {
-		return nil, err
-	}
-	return &appData, nil
+	assignProjectOwners(appData, nil, "")
+	return appData, nil
 }
 
-func saveAppData(appData *AppData) error {
-	path, err := dataFile()
-	if err != nil {
-		return err
-	}
-	data, err := json.MarshalIndent(appData, "", "  ")
-	if err != nil {
-		return err
+// updateAppData loads, modifies and saves the data as one serialized step.
+// Every mutation must go through here rather than loadAppData + Save. Tasks
+// and projects that fn changes get their Version bumped, the changes are
+// logged as activity of the local user and published as events.
+func updateAppData(fn func(appData *AppData) error) error {
+	return updateAppDataAs(cliActor(), fn)
+}
+
+// updateAppDataAs is updateAppData on behalf of actor, for the activity log.
+func updateAppDataAs(actor string, fn func(appData *AppData) error) error {
+	var updated *AppData
+	var changes []Event
+	err := dataStore.Update(func(appData *AppData) error {
+		snap := snapshotVersions(appData)
+		// Role checks inside fn see the same owners as loadAppData gives.
+		assignProjectOwners(appData, nil, "")
+		if err := fn(appData); err != nil {
+			return err
+		}
+		assignProjectOwners(appData, snap.projects, actor)
+		snap.bump(appData)
+		reserveIDs(appData, snap)
+		recordActivity(appData, snap, actor)
+		changes = changeEvents(snap, appData)
+		queueWebhookDeliveries(appData, changes)
+		updated = appData
+		return nil
+	})
+	if err == nil {
+		taskIndex.Update(updated)
+		events.publish(changes)
 	}
-	return os.WriteFile(path, data, 0o644)
+	return err
 }
 
 func loadTasks() ([]Task, error) {
@@ -178,15 +224,6 @@ func loadTasks() ([]Task, error) {
 	return appData.Tasks, nil
 }
 
-func saveTasks(tasks []Task) error {
-	appData, err := loadAppData()
-	if err != nil {
-		return err
-	}
-	appData.Tasks = tasks
-	return saveAppData(appData)
-}
-
 func nextID(tasks []Task) int {
 	maxID := 0
 	for _, t := range tasks {
@@ -217,90 +254,9 @@ func nextTimeEntryID(entries []TimeEntry) int {
 	return maxID + 1
 }
 
-func addTask(desc string) error {
-	if strings.TrimSpace(desc) == "" {
-		return errors.New("description cannot be empty")
-	}
-	appData, err := loadAppData()
-	if err != nil {
-		return err
-	}
-
-	// Get or create default project
-	var defaultProject *Project
-	for i := range appData.Projects {
-		if appData.Projects[i].Name == "Default" {
-			defaultProject = &appData.Projects[i]
-			break
-		}
-	}
-	if defaultProject == nil {
-		newProject := Project{
-			ID:        nextProjectID(appData.Projects),
-			Name:      "Default",
-			Color:     "#6366f1",
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
-		appData.Projects = append(appData.Projects, newProject)
-		defaultProject = &newProject
-	}
-
-	task := Task{
-		ID:          nextID(appData.Tasks),
-		ProjectID:   defaultProject.ID,
-		Description: desc,
-		Priority:    Medium,
-		Status:      StatusTodo,
-		Done:        false,
-		CreatedAt:   time.Now(),
-		Position:    len(appData.Tasks),
-	}
-	appData.Tasks = append(appData.Tasks, task)
-	if err := saveAppData(appData); err != nil {
-		return err
-	}
-	fmt.Printf("✓ Added #%d: %s\n", task.ID, task.Description)
-	return nil
-}
-
-func addTaskAdvanced(desc, category string, priority Priority, dueDate *time.Time, tags []string) error {
-	if strings.TrimSpace(desc) == "" {
-		return errors.New("description cannot be empty")
-	}
-	tasks, err := loadTasks()
-	if err != nil {
-		return err
-	}
-	task := Task{
-		ID:          nextID(tasks),
-		Description: desc,
-		Category:    category,
-		Priority:    priority,
-		DueDate:     dueDate,
-		Tags:        tags,
-		Done:        false,
-		CreatedAt:   time.Now(),
-	}
-	tasks = append(tasks, task)
-	if err := saveTasks(tasks); err != nil {
-		return err
-	}
-	fmt.Printf("✓ Added #%d: %s [%s%s\033[0m]\n", task.ID, task.Description, task.Priority.Color(), task.Priority)
-	return nil
-}
-
-func listTasks() error {
-	tasks, err := loadTasks()
-	if err != nil {
-		return err
-	}
-	if len(tasks) == 0 {
-		fmt.Println("No tasks yet.")
-		return nil
-	}
-
-	// Sort by priority (highest first), then by due date
+// sortTasks orders pending tasks before completed ones, then by priority
+// (highest first) and due date.
+func sortTasks(tasks []Task) {
 	sort.Slice(tasks, func(i, j int) bool {
 		if tasks[i].Done != tasks[j].Done {
 			return !tasks[i].Done // Incomplete tasks first
@@ -313,7 +269,9 @@ func listTasks() error {
 		}
 		return tasks[i].ID < tasks[j].ID
 	})
+}
 
+func printTaskTable(tasks []Task) {
 	fmt.Println("\n" + strings.Repeat("=", 80))
 	fmt.Printf("%-4s %-6s %-8s %-35s %-12s %s\n", "ID", "Status", "Priority", "Task", "Category", "Due Date")
 	fmt.Println(strings.Repeat("=", 80))
@@ -326,7 +284,7 @@ func listTasks() error {
 
 		dueStr := ""
 		if t.DueDate != nil {
-			dueStr = t.DueDate.Format("2006-01-02")
+			dueStr = formatDue(*t.DueDate)
 			if !t.Done && t.DueDate.Before(time.Now()) {
 				dueStr = "\033[31m" + dueStr + " ⚠\033[0m"
 			}
@@ -351,199 +309,144 @@ func listTasks() error {
 		fmt.Println()
 	}
 	fmt.Println(strings.Repeat("=", 80))
-	return nil
 }
 
-func listByCategory(category string) error {
-	tasks, err := loadTasks()
-	if err != nil {
-		return err
-	}
-
-	filtered := []Task{}
-	for _, t := range tasks {
-		if strings.EqualFold(t.Category, category) {
-			filtered = append(filtered, t)
+func printTaskDetails(d TaskDetail) {
+	t := d.Task
+	fmt.Println("\n" + strings.Repeat("=", 60))
+	fmt.Printf("Task #%d\n", t.ID)
+	fmt.Println(strings.Repeat("=", 60))
+	fmt.Printf("Description:  %s\n", t.Description)
+	fmt.Printf("Status:       %s\n", map[bool]string{true: "✓ Completed", false: "○ Pending"}[t.Done])
+	fmt.Printf("Priority:     %s%s\033[0m\n", t.Priority.Color(), t.Priority)
+	if t.Category != "" {
+		fmt.Printf("Category:     %s\n", t.Category)
+	}
+	if t.DueDate != nil {
+		fmt.Printf("Due Date:     %s\n", t.DueDate.Format("2006-01-02 15:04"))
+		if !t.Done && t.DueDate.Before(time.Now()) {
+			fmt.Printf("              \033[31m⚠ OVERDUE\033[0m\n")
 		}
 	}
-
-	if len(filtered) == 0 {
-		fmt.Printf("No tasks in category '%s'\n", category)
-		return nil
-	}
-
-	fmt.Printf("\nTasks in category '%s':\n", category)
-	for _, t := range filtered {
-		status := " "
-		if t.Done {
-			status = "✓"
-		}
-		fmt.Printf("[%s] #%d %s%s\033[0m: %s\n", status, t.ID, t.Priority.Color(), t.Priority, t.Description)
+	fmt.Printf("Created:      %s\n", t.CreatedAt.Format("2006-01-02 15:04"))
+	if t.CompletedAt != nil {
+		fmt.Printf("Completed:    %s\n", t.CompletedAt.Format("2006-01-02 15:04"))
 	}
-	return nil
-}
-
-func searchTasks(query string) error {
-	tasks, err := loadTasks()
-	if err != nil {
-		return err
+	if len(t.Tags) > 0 {
+		fmt.Printf("Tags:         %s\n", strings.Join(t.Tags, ", "))
 	}
-
-	query = strings.ToLower(query)
-	found := []Task{}
-
-	for _, t := range tasks {
-		if strings.Contains(strings.ToLower(t.Description), query) ||
-			strings.Contains(strings.ToLower(t.Category), query) {
-			found = append(found, t)
-		} else {
-			for _, tag := range t.Tags {
-				if strings.Contains(strings.ToLower(tag), query) {
-					found = append(found, t)
-					break
-				}
-			}
-		}
+	if t.ParentID != 0 {
+		fmt.Printf("Subtask of:   #%d\n", t.ParentID)
 	}
-
-	if len(found) == 0 {
-		fmt.Printf("No tasks found matching '%s'\n", query)
-		return nil
+	if t.Recurrence != nil {
+		fmt.Printf("Repeats:      %s\n", t.Recurrence)
 	}
 
-	fmt.Printf("\nFound %d task(s) matching '%s':\n", len(found), query)
-	for _, t := range found {
-		status := " "
-		if t.Done {
-			status = "✓"
+	p := d.Progress
+	if p.SubtasksTotal > 0 || p.ChecklistTotal > 0 {
+		fmt.Printf("Progress:     %d%%", p.Percent)
+		if p.SubtasksTotal > 0 {
+			fmt.Printf("  (%d/%d subtasks", p.SubtasksDone, p.SubtasksTotal)
+			if p.ChecklistTotal > 0 {
+				fmt.Printf(", %d/%d checklist)", p.ChecklistDone, p.ChecklistTotal)
+			} else {
+				fmt.Print(")")
+			}
+		} else {
+			fmt.Printf("  (%d/%d checklist)", p.ChecklistDone, p.ChecklistTotal)
 		}
-		fmt.Printf("[%s] #%d %s%s\033[0m: %s\n", status, t.ID, t.Priority.Color(), t.Priority, t.Description)
+		fmt.Println()
 	}
-	return nil
-}
-
-func viewTask(id int) error {
-	tasks, err := loadTasks()
-	if err != nil {
-		return err
+	if p.SubtasksTotal > 0 && (p.EstimatedHours > 0 || p.TrackedHours > 0) {
+		fmt.Printf("Hours:        %.1fh estimated, %.1fh tracked (incl. subtasks)\n", p.EstimatedHours, p.TrackedHours)
 	}
 
-	for _, t := range tasks {
-		if t.ID == id {
-			fmt.Println("\n" + strings.Repeat("=", 60))
-			fmt.Printf("Task #%d\n", t.ID)
-			fmt.Println(strings.Repeat("=", 60))
-			fmt.Printf("Description:  %s\n", t.Description)
-			fmt.Printf("Status:       %s\n", map[bool]string{true: "✓ Completed", false: "○ Pending"}[t.Done])
-			fmt.Printf("Priority:     %s%s\033[0m\n", t.Priority.Color(), t.Priority)
-			if t.Category != "" {
-				fmt.Printf("Category:     %s\n", t.Category)
-			}
-			if t.DueDate != nil {
-				fmt.Printf("Due Date:     %s\n", t.DueDate.Format("2006-01-02 15:04"))
-				if !t.Done && t.DueDate.Before(time.Now()) {
-					fmt.Printf("              \033[31m⚠ OVERDUE\033[0m\n")
-				}
+	if len(d.Subtasks) > 0 {
+		fmt.Println("\nSubtasks:")
+		for _, sub := range d.Subtasks {
+			mark := " "
+			if sub.Done {
+				mark = "✓"
 			}
-			fmt.Printf("Created:      %s\n", t.CreatedAt.Format("2006-01-02 15:04"))
-			if t.CompletedAt != nil {
-				fmt.Printf("Completed:    %s\n", t.CompletedAt.Format("2006-01-02 15:04"))
+			fmt.Printf("  [%s] #%d %s", mark, sub.ID, sub.Description)
+			if sub.Progress.SubtasksTotal > 0 || sub.Progress.ChecklistTotal > 0 {
+				fmt.Printf(" (%d%%)", sub.Progress.Percent)
 			}
-			if len(t.Tags) > 0 {
-				fmt.Printf("Tags:         %s\n", strings.Join(t.Tags, ", "))
-			}
-			fmt.Println(strings.Repeat("=", 60))
-			return nil
+			fmt.Println()
 		}
 	}
-	return fmt.Errorf("task #%d not found", id)
-}
-
-func updatePriority(id int, priority Priority) error {
-	tasks, err := loadTasks()
-	if err != nil {
-		return err
+	if len(d.Blockers) > 0 || len(d.Blocks) > 0 {
+		fmt.Println()
+		if d.Blocked {
+			fmt.Println("\033[31m⛔ Blocked by unfinished tasks\033[0m")
+		}
+		printTaskRefs("Blocked by:", d.Blockers)
+		printTaskRefs("Blocks:", d.Blocks)
 	}
-
-	for i := range tasks {
-		if tasks[i].ID == id {
-			tasks[i].Priority = priority
-			if err := saveTasks(tasks); err != nil {
-				return err
+	if len(t.Checklist) > 0 {
+		fmt.Println("\nChecklist:")
+		for _, item := range t.Checklist {
+			mark := " "
+			if item.Done {
+				mark = "x"
 			}
-			fmt.Printf("✓ Updated #%d priority to %s%s\033[0m\n", id, priority.Color(), priority)
-			return nil
+			fmt.Printf("  [%s] %d. %s\n", mark, item.ID, item.Text)
 		}
 	}
-	return fmt.Errorf("task #%d not found", id)
-}
-
-func setDueDate(id int, dueDate time.Time) error {
-	tasks, err := loadTasks()
-	if err != nil {
-		return err
-	}
-
-	for i := range tasks {
-		if tasks[i].ID == id {
-			tasks[i].DueDate = &dueDate
-			if err := saveTasks(tasks); err != nil {
-				return err
-			}
-			fmt.Printf("✓ Set due date for #%d to %s\n", id, dueDate.Format("2006-01-02"))
-			return nil
+	if len(d.History) > 0 {
+		fmt.Println("\nHistory:")
+		history := d.History
+		if len(history) > 10 {
+			fmt.Printf("  (%d earlier changes)\n", len(history)-10)
+			history = history[len(history)-10:]
+		}
+		for _, a := range history {
+			fmt.Println("  " + describeActivity(a))
 		}
 	}
-	return fmt.Errorf("task #%d not found", id)
+	fmt.Println(strings.Repeat("=", 60))
 }
 
-func showStats() error {
-	tasks, err := loadTasks()
-	if err != nil {
-		return err
-	}
-
-	total := len(tasks)
-	completed := 0
-	overdue := 0
-	byPriority := map[Priority]int{Low: 0, Medium: 0, High: 0, Urgent: 0}
-	categories := make(map[string]int)
-
-	for _, t := range tasks {
-		if t.Done {
-			completed++
-		} else {
-			byPriority[t.Priority]++
-			if t.DueDate != nil && t.DueDate.Before(time.Now()) {
-				overdue++
+// modifyTask applies fn to task id inside a single update and returns the
+// task as saved.
+func modifyTask(id int, fn func(t *Task) error) (Task, error) {
+	var task *Task
+	err := updateAppData(func(appData *AppData) error {
+		for i := range appData.Tasks {
+			if appData.Tasks[i].ID == id {
+				task = &appData.Tasks[i]
+				return fn(task)
 			}
 		}
-		if t.Category != "" {
-			categories[t.Category]++
-		}
+		return fmt.Errorf("task #%d not found", id)
+	})
+	if err != nil {
+		return Task{}, err
 	}
+	return *task, nil
+}
 
+func printTaskStats(stats TaskStats) {
 	fmt.Println("\n" + strings.Repeat("=", 50))
 	fmt.Println("📊 Task Statistics")
 	fmt.Println(strings.Repeat("=", 50))
-	fmt.Printf("Total Tasks:      %d\n", total)
-	fmt.Printf("Completed:        %d (%.1f%%)\n", completed, float64(completed)/float64(max(total, 1))*100)
-	fmt.Printf("Pending:          %d\n", total-completed)
-	fmt.Printf("Overdue:          %d\n", overdue)
+	fmt.Printf("Total Tasks:      %d\n", stats.Total)
+	fmt.Printf("Completed:        %d (%.1f%%)\n", stats.Completed, float64(stats.Completed)/float64(max(stats.Total, 1))*100)
+	fmt.Printf("Pending:          %d\n", stats.Pending)
+	fmt.Printf("Overdue:          %d\n", stats.Overdue)
 	fmt.Println("\nBy Priority (pending only):")
-	fmt.Printf("  Urgent:         %d\n", byPriority[Urgent])
-	fmt.Printf("  High:           %d\n", byPriority[High])
-	fmt.Printf("  Medium:         %d\n", byPriority[Medium])
-	fmt.Printf("  Low:            %d\n", byPriority[Low])
+	fmt.Printf("  Urgent:         %d\n", stats.ByPriority["urgent"])
+	fmt.Printf("  High:           %d\n", stats.ByPriority["high"])
+	fmt.Printf("  Medium:         %d\n", stats.ByPriority["medium"])
+	fmt.Printf("  Low:            %d\n", stats.ByPriority["low"])
 
-	if len(categories) > 0 {
+	if len(stats.Categories) > 0 {
 		fmt.Println("\nBy Category:")
-		for cat, count := range categories {
+		for cat, count := range stats.Categories {
 			fmt.Printf("  %-15s %d\n", cat+":", count)
 		}
 	}
 	fmt.Println(strings.Repeat("=", 50))
-	return nil
 }
 
 func max(a, b int) int {
@@ -553,100 +456,50 @@ func max(a, b int) int {
 	return b
 }
 
-func markDone(id int) error {
-	tasks, err := loadTasks()
-	if err != nil {
-		return err
-	}
-	found := false
-	now := time.Now()
-	for i := range tasks {
-		if tasks[i].ID == id {
-			tasks[i].Done = true
-			tasks[i].CompletedAt = &now
-			found = true
-			break
+// completeTask marks task id done and, for a recurring task, returns the
+// next occurrence it spawned. Unless force is set it refuses while a task
+// blocking it is unfinished.
+func completeTask(id int, force bool) (Task, *Task, error) {
+	var task, next *Task
+	err := updateAppData(func(appData *AppData) error {
+		task = findTask(appData, id)
+		if task == nil {
+			return fmt.Errorf("task #%d not found", id)
 		}
-	}
-	if !found {
-		return fmt.Errorf("task #%d not found", id)
-	}
-	if err := saveTasks(tasks); err != nil {
-		return err
-	}
-	fmt.Printf("✓ Completed #%d\n", id)
-	return nil
-}
-
-func deleteTask(id int) error {
-	tasks, err := loadTasks()
-	if err != nil {
-		return err
-	}
-	out := tasks[:0]
-	found := false
-	for _, t := range tasks {
-		if t.ID == id {
-			found = true
-			continue
+		if err := checkBlockers(appData, *task, StatusDone, force); err != nil {
+			return err
 		}
-		out = append(out, t)
-	}
-	if !found {
-		return fmt.Errorf("task #%d not found", id)
-	}
-	if err := saveTasks(out); err != nil {
-		return err
-	}
-	fmt.Printf("✓ Deleted #%d\n", id)
-	return nil
-}
-
-func parseDate(dateStr string) (*time.Time, error) {
-	// Try various date formats
-	formats := []string{
-		"2006-01-02",
-		"2006/01/02",
-		"01-02-2006",
-		"01/02/2006",
-		"Jan 02 2006",
-		"02 Jan 2006",
-	}
-
-	// Handle relative dates
-	lower := strings.ToLower(dateStr)
-	now := time.Now()
-
-	switch lower {
-	case "today":
-		t := time.Date(now.Year(), now.Month(), now.Day(), 23, 59, 59, 0, now.Location())
-		return &t, nil
-	case "tomorrow":
-		t := now.AddDate(0, 0, 1)
-		t = time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 59, 0, t.Location())
-		return &t, nil
-	}
-
-	// Check if it's "in X days"
-	if strings.HasPrefix(lower, "in ") && strings.HasSuffix(lower, " days") {
-		daysStr := strings.TrimPrefix(lower, "in ")
-		daysStr = strings.TrimSuffix(daysStr, " days")
-		days, err := strconv.Atoi(daysStr)
-		if err == nil {
-			t := now.AddDate(0, 0, days)
-			t = time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 59, 0, t.Location())
-			return &t, nil
+		setTaskStatus(task, StatusDone)
+		var err error
+		if next, err = spawnNextOccurrence(appData, id); err != nil {
+			return err
 		}
+		// Spawning may have moved the task slice.
+		task = findTask(appData, id)
+		return nil
+	})
+	if err != nil {
+		return Task{}, nil, err
 	}
+	return *task, next, nil
+}
 
-	for _, format := range formats {
-		if t, err := time.Parse(format, dateStr); err == nil {
-			t = time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 59, 0, t.Location())
-			return &t, nil
+// removeTask moves task id to the trash and returns the trash item.
+func removeTask(id int) (TrashItem, error) {
+	var item TrashItem
+	err := updateAppData(func(appData *AppData) error {
+		trashed, err := trashTask(appData, id, cliActor())
+		if err != nil {
+			return err
 		}
-	}
+		item = *trashed
+		return nil
+	})
+	return item, err
+}
 
-	return nil, fmt.Errorf("invalid date format. Try: YYYY-MM-DD, today, tomorrow, or 'in X days'")
+func deletedMessage(item TrashItem) string {
+	return fmt.Sprintf("✓ Moved %s #%d to the trash (undo with: trash restore %d)", item.Kind, item.Tasks[0].ID, item.ID)
 }
 
 func usage() {
@@ -655,264 +508,310 @@ func usage() {
 	fmt.Println(strings.Repeat("=", 70))
 	fmt.Println("\nBasic Commands:")
 	fmt.Println("  add <description>                    - Add a simple task")
-	fmt.Println("  list                                 - List all tasks")
-	fmt.Println("  done <id>                            - Mark task as complete")
-	fmt.Println("  delete <id>                          - Delete a task")
+	fmt.Println("  list [query] [--sort keys] [--limit n] [--page n] - List tasks, optionally filtered")
+	fmt.Println("  done <id> [--force]                  - Mark task as complete (--force ignores blockers)")
+	fmt.Println("  delete <id>                          - Move a task to the trash")
 	fmt.Println("  view <id>                            - View task details")
 	fmt.Println("\nAdvanced Commands:")
 	fmt.Println("  create --desc \"...\" [options]        - Create task with options")
 	fmt.Println("    Options:")
 	fmt.Println("      --priority <low|medium|high|urgent>")
 	fmt.Println("      --category <name>")
-	fmt.Println("      --due <date>                     - Date format: YYYY-MM-DD, today, tomorrow")
+	fmt.Println("      --due <date>                     - e.g. YYYY-MM-DD, 2026-03-01T15:00, tomorrow 9am, next fri, in 2 weeks, end of month")
 	fmt.Println("      --tags <tag1,tag2>")
+	fmt.Println("      --project <name|id>              - Defaults to the Default project")
+	fmt.Println("      --status <backlog|todo|in_progress|in_review|done>")
+	fmt.Println("      --assignee <name>")
+	fmt.Println("      --estimate <hours>")
+	fmt.Println("      --parent <id>                    - Create as a subtask of task <id>")
+	fmt.Println("      --repeat <rule>                  - daily, weekdays, weekly on mon,fri, every 2 weeks,")
+	fmt.Println("                                         monthly on 15|last, or an RRULE (FREQ=...)")
 	fmt.Println("\nFilter & Search:")
-	fmt.Println("  search <query>                       - Search tasks by keyword")
+	fmt.Println("  search <words> [filters]             - Ranked full-text search, including comments")
+	fmt.Println("    Query terms for list and search (all must match; prefix with - to negate):")
+	fmt.Println("      status:in_progress  priority>=high  due<7d  due:none  created>-2w")
+	fmt.Println("      tag:backend  assignee:me  project:\"Website\"  category:x  estimate>4")
+	fmt.Println("      is:overdue|blocked|open|done|subtask|recurring  sort:due,-priority  words")
 	fmt.Println("  category <name>                      - List tasks by category")
 	fmt.Println("  stats                                - Show task statistics")
 	fmt.Println("\nUpdate Commands:")
 	fmt.Println("  priority <id> <low|medium|high|urgent> - Update task priority")
 	fmt.Println("  due <id> <date>                      - Set/update due date")
+	fmt.Println("  checklist <id> [add <text>|check <n>|uncheck <n>|remove <n>]")
+	fmt.Println("  deps <id> [add <blocker-id>|remove <blocker-id>] - Show or change blockers")
+	fmt.Println("  critical-path <project>              - Longest chain of dependent open tasks")
+	fmt.Println("  trash [restore <id>|purge <id>|empty] - Show or restore deleted tasks and projects")
+	fmt.Println("  export [--format json|csv|md|todotxt] [--project p] [--output file]")
+	fmt.Println("  import <file> [--format f] [--project p] [--dry-run] - Add tasks from a file")
+	fmt.Println("  remind [list | snooze <id> <2h|date> | unsnooze <id> | prefs] - Due-date reminders")
+	fmt.Println("  timesheet [--from d] [--to d] [--group day,user,...] [--format csv|html] - Tracked time and billing")
+	fmt.Println("  analytics [--from d] [--to d] [--project p] [--assignee a] - Estimates vs actuals, throughput, cycle time")
+	fmt.Println("  undo / redo                          - Take back or repeat the last change (up to 20)")
+	fmt.Println("  undone, projects, timer, activity, members, calendar, webhook, user")
+	fmt.Println("                                       - As on the command line; see 'taskmanager help'")
 	fmt.Println("\nOther:")
+	fmt.Println("  history                              - Show recent commands")
 	fmt.Println("  help                                 - Show this help")
 	fmt.Println("  clear                                - Clear screen")
 	fmt.Println("  quit/exit                            - Exit program")
+	fmt.Println("\nQuote arguments that contain spaces (\"...\" or '...'); --flag=value also")
+	fmt.Println("works. An open quote or a trailing \\ continues on the next line. Use Tab to")
+	fmt.Println("complete commands, task IDs, categories and tags, and ↑/↓ for history.")
 	fmt.Println(strings.Repeat("=", 70))
 }
 
-func parseCreateCommand(args []string) (desc, category string, priority Priority, dueDate *time.Time, tags []string, err error) {
-	priority = Medium
+// parseCreateCommand parses the options shared by the REPL "create" command
+// and the one-shot "add"/"create" subcommands. Words that are not options
+// form the description when --desc is not given.
+func parseCreateCommand(args []string) (TaskInput, error) {
+	var input TaskInput
+	var words []string
 
 	for i := 0; i < len(args); i++ {
-		switch args[i] {
-		case "--desc", "-d":
+		option := args[i]
+		switch option {
+		case "--desc", "-d", "--priority", "-p", "--category", "-c", "--due",
+			"--tags", "-t", "--project", "--status", "-s", "--assignee", "-a", "--estimate", "-e", "--parent",
+			"--repeat", "-r":
 			if i+1 >= len(args) {
-				err = errors.New("--desc requires a value")
-				return
+				return input, fmt.Errorf("%s requires a value", option)
 			}
-			desc = args[i+1]
 			i++
-		case "--priority", "-p":
-			if i+1 >= len(args) {
-				err = errors.New("--priority requires a value")
-				return
+		default:
+			if !strings.HasPrefix(option, "-") {
+				words = append(words, option)
 			}
-			priority = parsePriority(args[i+1])
-			i++
+			continue
+		}
+
+		value := args[i]
+		switch option {
+		case "--desc", "-d":
+			input.Description = value
+		case "--priority", "-p":
+			priority := parsePriority(value)
+			input.Priority = &priority
 		case "--category", "-c":
-			if i+1 >= len(args) {
-				err = errors.New("--category requires a value")
-				return
-			}
-			category = args[i+1]
-			i++
+			input.Category = value
 		case "--due":
-			if i+1 >= len(args) {
-				err = errors.New("--due requires a value")
-				return
-			}
-			dueDate, err = parseDate(args[i+1])
+			dueDate, err := parseDate(value)
 			if err != nil {
-				return
+				return input, err
 			}
-			i++
+			input.DueDate = dueDate
 		case "--tags", "-t":
-			if i+1 >= len(args) {
-				err = errors.New("--tags requires a value")
-				return
+			input.Tags = strings.Split(value, ",")
+		case "--project":
+			input.ProjectName = value
+		case "--status", "-s":
+			input.Status = value
+		case "--assignee", "-a":
+			input.Assignee = value
+		case "--estimate", "-e":
+			hours, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return input, errors.New("--estimate must be a number of hours")
 			}
-			tags = strings.Split(args[i+1], ",")
-			for j := range tags {
-				tags[j] = strings.TrimSpace(tags[j])
+			input.EstimatedHours = hours
+		case "--parent":
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				return input, errors.New("--parent must be a task id")
 			}
-			i++
+			input.ParentID = id
+		case "--repeat", "-r":
+			rec, err := parseRecurrence(value)
+			if err != nil {
+				return input, err
+			}
+			input.Recurrence = rec
 		}
 	}
 
-	if desc == "" {
-		err = errors.New("description is required (use --desc)")
+	if input.Description == "" {
+		input.Description = strings.Join(words, " ")
+	}
+	if input.Description == "" {
+		return input, errors.New("description is required (use --desc)")
 	}
-	return
+	return input, nil
 }
 
 func clearScreen() {
 	fmt.Print("\033[H\033[2J")
 }
 
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	from := fs.String("from", "", "JSON data file to import (default ~/.project_manager.json)")
+	to := fs.String("to", "", "SQLite database to create (default ~/.project_manager.db)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	dst, err := openStore("sqlite", *to)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	appData, err := migrateStore(&jsonStore{path: *from}, dst)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Migrated %d projects, %d tasks and %d time entries to SQLite\n",
+		len(appData.Projects), len(appData.Tasks), len(appData.TimeEntries))
+	return nil
+}
+
 func main() {
-	// Check if server mode is requested
-	if len(os.Args) > 1 && os.Args[1] == "server" {
-		startServer()
+	storeKind := flag.String("store", os.Getenv("TASKMANAGER_STORE"), "storage backend: json or sqlite (env TASKMANAGER_STORE)")
+	storePath := flag.String("db", os.Getenv("TASKMANAGER_DB"), "path to the data file (env TASKMANAGER_DB)")
+	flag.Usage = cliUsage
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrate(args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	store, err := openStore(*storeKind, *storePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	dataStore = store
+	defer dataStore.Close()
+
+	if len(args) > 0 {
+		switch {
+		case args[0] == "server":
+			startServer()
+		case cliCommands[args[0]]:
+			code := runCLI(args)
+			dataStore.Close()
+			os.Exit(code)
+		case args[0] == "help":
+			cliUsage()
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", args[0])
+			cliUsage()
+			dataStore.Close()
+			os.Exit(2)
+		}
 		return
 	}
 
+	lr := newLineReader()
+	defer lr.Close()
+	runREPL(lr)
+}
+
+// runREPL runs the interactive mode until quit or end of input.
+func runREPL(lr lineReader) {
 	clearScreen()
 	fmt.Println("╔══════════════════════════════════════════════════════════════╗")
 	fmt.Println("║           📋 CLI Task Manager - Interactive Mode            ║")
 	fmt.Println("╚══════════════════════════════════════════════════════════════╝")
 	fmt.Println("\nType 'help' for available commands or 'quit' to exit")
-	fmt.Println("💡 Tip: Run 'go run . server' to start the web interface\n")
+	fmt.Println("💡 Tip: Run 'go run . server' to start the web interface")
 
-	scanner := bufio.NewScanner(os.Stdin)
+	var history []string
+	var undo undoStack
 
 	for {
-		fmt.Print("\n\033[36m>\033[0m ")
-		if !scanner.Scan() {
+		input, parts, err := readCommand(lr, "\n\033[36m>\033[0m ")
+		if err == io.EOF {
 			break
 		}
-
-		input := strings.TrimSpace(scanner.Text())
-		if input == "" {
+		if err != nil {
+			fmt.Println("Error reading input:", err)
+			break
+		}
+		if len(parts) == 0 {
 			continue
 		}
+		lr.AddHistory(input)
+		history = append(history, input)
 
-		parts := strings.Fields(input)
 		cmd := parts[0]
-
 		switch cmd {
-		case "add":
-			if len(parts) < 2 {
-				fmt.Println("Error: add requires a description")
-				continue
-			}
-			desc := strings.Join(parts[1:], " ")
-			if err := addTask(desc); err != nil {
-				fmt.Println("Error:", err)
+		case "history":
+			start := 0
+			if len(history) > 20 {
+				start = len(history) - 20
 			}
-
-		case "create":
-			desc, category, priority, dueDate, tags, err := parseCreateCommand(parts[1:])
-			if err != nil {
-				fmt.Println("Error:", err)
-				fmt.Println("Usage: create --desc \"task description\" [--priority low|medium|high|urgent] [--category name] [--due YYYY-MM-DD] [--tags tag1,tag2]")
-				continue
-			}
-			if err := addTaskAdvanced(desc, category, priority, dueDate, tags); err != nil {
-				fmt.Println("Error:", err)
-			}
-
-		case "list":
-			if err := listTasks(); err != nil {
-				fmt.Println("Error:", err)
-			}
-
-		case "view":
-			if len(parts) < 2 {
-				fmt.Println("Error: view requires an id")
-				continue
-			}
-			id, err := strconv.Atoi(parts[1])
-			if err != nil {
-				fmt.Println("Error: id must be a number")
-				continue
-			}
-			if err := viewTask(id); err != nil {
-				fmt.Println("Error:", err)
-			}
-
-		case "done":
-			if len(parts) < 2 {
-				fmt.Println("Error: done requires an id")
-				continue
-			}
-			id, err := strconv.Atoi(parts[1])
-			if err != nil {
-				fmt.Println("Error: id must be a number")
-				continue
-			}
-			if err := markDone(id); err != nil {
-				fmt.Println("Error:", err)
+			for i := start; i < len(history); i++ {
+				fmt.Printf("%4d  %s\n", i+1, history[i])
 			}
+			continue
 
-		case "delete", "del":
-			if len(parts) < 2 {
-				fmt.Println("Error: delete requires an id")
-				continue
-			}
-			id, err := strconv.Atoi(parts[1])
+		case "undo":
+			label, err := undo.undo()
 			if err != nil {
-				fmt.Println("Error: id must be a number")
-				continue
-			}
-			if err := deleteTask(id); err != nil {
 				fmt.Println("Error:", err)
-			}
-
-		case "priority":
-			if len(parts) < 3 {
-				fmt.Println("Error: priority requires an id and priority level")
 				continue
 			}
-			id, err := strconv.Atoi(parts[1])
-			if err != nil {
-				fmt.Println("Error: id must be a number")
-				continue
-			}
-			priority := parsePriority(parts[2])
-			if err := updatePriority(id, priority); err != nil {
-				fmt.Println("Error:", err)
-			}
+			fmt.Printf("↶ Undid: %s\n", label)
+			continue
 
-		case "due":
-			if len(parts) < 3 {
-				fmt.Println("Error: due requires an id and date")
-				continue
-			}
-			id, err := strconv.Atoi(parts[1])
-			if err != nil {
-				fmt.Println("Error: id must be a number")
-				continue
-			}
-			dueDate, err := parseDate(parts[2])
+		case "redo":
+			label, err := undo.redo()
 			if err != nil {
 				fmt.Println("Error:", err)
 				continue
 			}
-			if err := setDueDate(id, *dueDate); err != nil {
-				fmt.Println("Error:", err)
-			}
-
-		case "search":
-			if len(parts) < 2 {
-				fmt.Println("Error: search requires a query")
-				continue
-			}
-			query := strings.Join(parts[1:], " ")
-			if err := searchTasks(query); err != nil {
-				fmt.Println("Error:", err)
-			}
-
-		case "category", "cat":
-			if len(parts) < 2 {
-				fmt.Println("Error: category requires a category name")
-				continue
-			}
-			if err := listByCategory(parts[1]); err != nil {
-				fmt.Println("Error:", err)
-			}
-
-		case "stats":
-			if err := showStats(); err != nil {
-				fmt.Println("Error:", err)
-			}
+			fmt.Printf("↷ Redid: %s\n", label)
+			continue
 
 		case "clear", "cls":
 			clearScreen()
+			continue
 
 		case "help", "h", "?":
 			usage()
+			continue
 
 		case "quit", "exit", "q":
 			fmt.Println("\n👋 Goodbye! Stay productive!")
 			return
+		}
 
-		default:
+		args := replArgs(parts)
+		if args == nil {
 			fmt.Printf("Unknown command: %s\n", cmd)
 			fmt.Println("Type 'help' for available commands")
+			continue
 		}
-	}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Println("Error reading input:", err)
+		var before *undoState
+		if undoableCommands[args[0]] {
+			if appData, err := loadAppData(); err == nil {
+				state := captureUndoState(appData)
+				before = &state
+			}
+		}
+
+		data, message, err := runCommand(args)
+		if err != nil {
+			fmt.Println("Error:", err)
+		} else {
+			printCommandResult(data, message)
+		}
+
+		if before != nil {
+			undo.record(input, *before)
+		}
 	}
 }
+
+// undoableCommands are the REPL commands that "undo" can take back.
+var undoableCommands = map[string]bool{
+	"add": true, "create": true, "done": true, "undone": true, "delete": true,
+	"priority": true, "due": true, "checklist": true, "deps": true, "trash": true,
+	"import": true, "timer": true,
+}
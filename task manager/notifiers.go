@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// A Notifier delivers reminders over one channel. Users pick channels by
+// name in their reminder preferences; the server sets the channels up from
+// the environment:
+//
+//	stdout   always on. Prints a line, and runs TASKMANAGER_NOTIFY_COMMAND
+//	         (e.g. notify-send) with the title and message as arguments.
+//	smtp     when TASKMANAGER_SMTP_ADDR (host:port) is set; also reads
+//	         TASKMANAGER_SMTP_FROM, _USER and _PASSWORD. Mails the address
+//	         in the user's preferences.
+//	webhook  POSTs JSON to the user's webhook URL, or to
+//	         TASKMANAGER_REMINDER_WEBHOOK. Users may only name https URLs
+//	         of public hosts; the connection is refused if the name
+//	         resolves to a loopback, private or link-local address.
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// Notification is one reminder for one user.
+type Notification struct {
+	Username string    `json:"username,omitempty"`
+	Title    string    `json:"title"`
+	Message  string    `json:"message"`
+	Task     Task      `json:"task"`
+	DueAt    time.Time `json:"due_at"`
+	At       time.Time `json:"at"`     // when the reminder is sent
+	Offset   string    `json:"offset"` // as in the preferences, e.g. "1d"
+	Snoozed  bool      `json:"snoozed,omitempty"`
+
+	prefs ReminderPrefs
+}
+
+// loadNotifiers returns the channels configured in the environment.
+func loadNotifiers() map[string]Notifier {
+	notifiers := map[string]Notifier{
+		"stdout": stdoutNotifier{command: os.Getenv("TASKMANAGER_NOTIFY_COMMAND")},
+		"webhook": webhookNotifier{
+			url:        os.Getenv("TASKMANAGER_REMINDER_WEBHOOK"),
+			client:     &http.Client{Timeout: 10 * time.Second},
+			userClient: publicClient(10 * time.Second),
+		},
+	}
+	if addr := os.Getenv("TASKMANAGER_SMTP_ADDR"); addr != "" {
+		from := os.Getenv("TASKMANAGER_SMTP_FROM")
+		if from == "" {
+			from = "taskmanager@localhost"
+		}
+		n := smtpNotifier{addr: addr, from: from}
+		if user := os.Getenv("TASKMANAGER_SMTP_USER"); user != "" {
+			host := strings.Split(addr, ":")[0]
+			n.auth = smtp.PlainAuth("", user, os.Getenv("TASKMANAGER_SMTP_PASSWORD"), host)
+		}
+		notifiers["smtp"] = n
+	}
+	return notifiers
+}
+
+type stdoutNotifier struct {
+	command string
+}
+
+func (s stdoutNotifier) Notify(n Notification) error {
+	to := ""
+	if n.Username != "" {
+		to = " @" + n.Username
+	}
+	fmt.Printf("🔔 %s%s: %s\n", n.Title, to, n.Message)
+	if s.command == "" {
+		return nil
+	}
+	fields := strings.Fields(s.command)
+	cmd := exec.Command(fields[0], append(fields[1:], n.Title, n.Message)...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+type smtpNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func (s smtpNotifier) Notify(n Notification) error {
+	if n.prefs.Email == "" {
+		return errors.New("no email address in the reminder preferences")
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		s.from, n.prefs.Email, n.Title, time.Now().Format(time.RFC1123Z), n.Message)
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{n.prefs.Email}, []byte(msg))
+}
+
+// webhookNotifier posts to the server's own URL with client, and to URLs
+// from user preferences with userClient, which only reaches public hosts.
+type webhookNotifier struct {
+	url        string
+	client     *http.Client
+	userClient *http.Client
+}
+
+func (wh webhookNotifier) Notify(n Notification) error {
+	target, client := wh.url, wh.client
+	if n.prefs.WebhookURL != "" {
+		// Checked again in case it was stored before the rules existed.
+		if err := checkUserWebhookURL(n.prefs.WebhookURL); err != nil {
+			return err
+		}
+		target, client = n.prefs.WebhookURL, wh.userClient
+	}
+	if target == "" {
+		return errors.New("no webhook URL in the reminder preferences")
+	}
+	body, err := json.Marshal(map[string]interface{}{"type": "task.reminder", "data": n})
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// publicIP reports whether ip is an address on the public internet, as
+// opposed to this machine, a private network or the cloud metadata
+// service at 169.254.169.254.
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+// sharedAddressSpace is carrier-grade NAT (RFC 6598), private in practice.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// checkUserWebhookURL vets a webhook URL a user entered: https only, and
+// not an address publicIP rejects. Host names are checked again when
+// publicClient connects, as they may resolve differently by then.
+func checkUserWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q", raw)
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("webhook URL %q must use https", raw)
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); (ip != nil && !publicIP(ip)) || strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return fmt.Errorf("webhook URL %q points to a private address", raw)
+	}
+	return nil
+}
+
+// publicClient is an HTTP client that refuses to connect to non-public
+// addresses, also after a redirect, and ignores proxy settings.
+func publicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("refusing to connect to private address %s", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout},
+	}
+}
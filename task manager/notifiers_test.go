@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckUserWebhookURL(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://hooks.example.com/remind", true},
+		{"https://93.184.216.34/remind", true},
+		{"http://hooks.example.com/remind", false},
+		{"ftp://hooks.example.com/", false},
+		{"https://", false},
+		{"https://localhost/remind", false},
+		{"https://api.localhost/remind", false},
+		{"https://127.0.0.1/remind", false},
+		{"https://[::1]:8443/remind", false},
+		{"https://169.254.169.254/latest/meta-data", false},
+		{"https://10.0.0.5/remind", false},
+		{"https://172.16.3.4/remind", false},
+		{"https://192.168.1.1/remind", false},
+		{"https://100.64.0.1/remind", false},
+		{"https://0.0.0.0/remind", false},
+		{"https://[::ffff:127.0.0.1]/remind", false},
+	}
+	for _, tt := range tests {
+		if err := checkUserWebhookURL(tt.url); (err == nil) != tt.ok {
+			t.Errorf("checkUserWebhookURL(%q) = %v, want ok %v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestPublicClientRefusesLoopback(t *testing.T) {
+	called := false
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer local.Close()
+
+	_, err := publicClient(time.Second).Get(local.URL)
+	if err == nil || !strings.Contains(err.Error(), "private address") || called {
+		t.Errorf("request to %s: err %v, reached %v", local.URL, err, called)
+	}
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A task query is a list of space-separated terms that must all match:
+//
+//	status:in_progress priority>=high due<7d tag:backend -tag:blocked
+//	assignee:me project:"Website" is:overdue login
+//
+// A term is field, operator and value. ":" and "=" test equality (a comma
+// separates alternatives, as in status:todo,in_progress), "!=" the opposite,
+// and < <= > >= compare statuses, priorities, numbers and dates. A leading
+// "-" negates a term, and a word without a field matches the description,
+// category or tags. "sort:due,-priority" sets the order.
+
+// TaskQuery is a parsed query, ready to run against the tasks it was
+// compiled for.
+type TaskQuery struct {
+	filters []func(Task) bool
+	sort    []sortKey
+}
+
+// TaskQueryOptions is a query together with its ordering and page.
+type TaskQueryOptions struct {
+	Query  []string // terms, already split the way tokenize does
+	Sort   string   // comma-separated keys, "-" for descending; overrides sort: terms
+	Offset int
+	Limit  int       // 0 means no limit
+	Now    time.Time // what relative dates are measured from
+	User   string    // who "me" is
+}
+
+// TaskPage is one page of query results.
+type TaskPage struct {
+	Tasks  []Task `json:"tasks"`
+	Total  int    `json:"total"` // matches before paging
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+var queryFields = []string{
+	"status", "priority", "due", "created", "completed", "tag", "assignee", "project",
+	"category", "text", "id", "parent", "estimate", "is", "sort",
+}
+
+var sortFields = []string{
+	"id", "priority", "due", "created", "completed", "status", "estimate", "description", "project", "position",
+}
+
+var termPattern = regexp.MustCompile(`^([a-z_]+)(:|>=|<=|!=|=|>|<)(.*)$`)
+
+var relativePattern = regexp.MustCompile(`^([+-]?\d+)([hdwmy])$`)
+
+var priorityNames = map[string]Priority{
+	"low": Low, "l": Low, "1": Low,
+	"medium": Medium, "med": Medium, "m": Medium, "2": Medium,
+	"high": High, "h": High, "3": High,
+	"urgent": Urgent, "u": Urgent, "4": Urgent,
+}
+
+// currentUser is who "assignee:me" refers to on the command line.
+func currentUser() string {
+	if user := os.Getenv("TASKMANAGER_USER"); user != "" {
+		return user
+	}
+	return os.Getenv("USER")
+}
+
+func queryError(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+// parseTaskQuery compiles the terms against appData, which is used to look
+// up projects and blockers.
+func parseTaskQuery(appData *AppData, terms []string, now time.Time, user string) (*TaskQuery, error) {
+	q := &TaskQuery{}
+	for _, term := range terms {
+		if term == "" {
+			continue
+		}
+		negate := false
+		if len(term) > 1 && term[0] == '-' {
+			negate, term = true, term[1:]
+		}
+
+		m := termPattern.FindStringSubmatch(strings.ToLower(term))
+		if m == nil {
+			text := strings.ToLower(term)
+			q.add(negate, func(t Task) bool { return taskMatches(t, text) })
+			continue
+		}
+		field, op := m[1], m[2]
+		value := term[len(m[1])+len(m[2]):] // keep the value's case for dates and names
+
+		if field == "sort" {
+			keys, err := parseSortKeys(value)
+			if err != nil {
+				return nil, err
+			}
+			q.sort = keys
+			continue
+		}
+		if op == "!=" {
+			negate, op = !negate, ":"
+		}
+		if op == "=" {
+			op = ":"
+		}
+		if value == "" {
+			return nil, queryError("%s: missing value", term)
+		}
+
+		match, err := compileTerm(appData, field, op, value, now, user)
+		if err != nil {
+			return nil, err
+		}
+		q.add(negate, match)
+	}
+	return q, nil
+}
+
+// isFilterTerm reports whether term is a field term or a negated word, as
+// opposed to a plain word.
+func isFilterTerm(term string) bool {
+	if len(term) > 1 && term[0] == '-' {
+		return true
+	}
+	m := termPattern.FindStringSubmatch(strings.ToLower(term))
+	if m == nil {
+		return false
+	}
+	for _, f := range queryFields {
+		if f == m[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *TaskQuery) add(negate bool, match func(Task) bool) {
+	if negate {
+		q.filters = append(q.filters, func(t Task) bool { return !match(t) })
+		return
+	}
+	q.filters = append(q.filters, match)
+}
+
+// Match reports whether t satisfies every term.
+func (q *TaskQuery) Match(t Task) bool {
+	for _, f := range q.filters {
+		if !f(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// anyOf matches when one of the comma-separated values matches.
+func anyOf(value string, parse func(string) (func(Task) bool, error)) (func(Task) bool, error) {
+	var matchers []func(Task) bool
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		match, err := parse(v)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, match)
+	}
+	return func(t Task) bool {
+		for _, match := range matchers {
+			if match(t) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func compileTerm(appData *AppData, field, op, value string, now time.Time, user string) (func(Task) bool, error) {
+	equalityOnly := func() error {
+		if op != ":" {
+			return queryError("%s only supports : and !=", field)
+		}
+		return nil
+	}
+
+	switch field {
+	case "status":
+		return anyOf(value, func(v string) (func(Task) bool, error) {
+			status, err := parseStatus(v)
+			if err != nil {
+				return nil, err
+			}
+			want := statusRank(status)
+			return func(t Task) bool { return compareInts(statusRank(taskStatus(t)), op, want) }, nil
+		})
+
+	case "priority":
+		return anyOf(value, func(v string) (func(Task) bool, error) {
+			p, ok := priorityNames[strings.ToLower(v)]
+			if !ok {
+				return nil, queryError("invalid priority %q (use low, medium, high or urgent)", v)
+			}
+			return func(t Task) bool { return compareInts(int(t.Priority), op, int(p)) }, nil
+		})
+
+	case "due", "created", "completed":
+		get := map[string]func(Task) *time.Time{
+			"due":       func(t Task) *time.Time { return t.DueDate },
+			"created":   func(t Task) *time.Time { return &t.CreatedAt },
+			"completed": func(t Task) *time.Time { return t.CompletedAt },
+		}[field]
+		switch strings.ToLower(value) {
+		case "none":
+			return func(t Task) bool { return get(t) == nil }, equalityOnly()
+		case "any":
+			return func(t Task) bool { return get(t) != nil }, equalityOnly()
+		}
+		start, end, err := parseQueryDate(value, now)
+		if err != nil {
+			return nil, err
+		}
+		return func(t Task) bool {
+			d := get(t)
+			if d == nil {
+				return false
+			}
+			switch op {
+			case "<":
+				return d.Before(start)
+			case "<=":
+				return !d.After(end)
+			case ">":
+				return d.After(end)
+			case ">=":
+				return !d.Before(start)
+			}
+			return !d.Before(start) && !d.After(end)
+		}, nil
+
+	case "tag":
+		if err := equalityOnly(); err != nil {
+			return nil, err
+		}
+		return anyOf(value, func(v string) (func(Task) bool, error) {
+			if strings.EqualFold(v, "none") {
+				return func(t Task) bool { return len(t.Tags) == 0 }, nil
+			}
+			return func(t Task) bool { return hasTag(t, v) }, nil
+		})
+
+	case "assignee", "category":
+		if err := equalityOnly(); err != nil {
+			return nil, err
+		}
+		return anyOf(value, func(v string) (func(Task) bool, error) {
+			if field == "assignee" && strings.EqualFold(v, "me") {
+				if user == "" {
+					return nil, queryError("assignee:me needs a current user")
+				}
+				v = user
+			}
+			if strings.EqualFold(v, "none") {
+				v = ""
+			}
+			if field == "assignee" {
+				return func(t Task) bool { return strings.EqualFold(t.Assignee, v) }, nil
+			}
+			return func(t Task) bool { return strings.EqualFold(t.Category, v) }, nil
+		})
+
+	case "project":
+		if err := equalityOnly(); err != nil {
+			return nil, err
+		}
+		return anyOf(value, func(v string) (func(Task) bool, error) {
+			project := findProject(appData, 0, v)
+			if project == nil {
+				return nil, queryError("project %q not found", v)
+			}
+			id := project.ID
+			return func(t Task) bool { return t.ProjectID == id }, nil
+		})
+
+	case "text":
+		if err := equalityOnly(); err != nil {
+			return nil, err
+		}
+		text := strings.ToLower(value)
+		return func(t Task) bool { return taskMatches(t, text) }, nil
+
+	case "id", "parent":
+		return anyOf(value, func(v string) (func(Task) bool, error) {
+			want := 0
+			if !(field == "parent" && strings.EqualFold(v, "none")) {
+				n, err := strconv.Atoi(strings.TrimPrefix(v, "#"))
+				if err != nil {
+					return nil, queryError("%s: %q is not a task ID", field, v)
+				}
+				want = n
+			}
+			if field == "id" {
+				return func(t Task) bool { return compareInts(t.ID, op, want) }, nil
+			}
+			return func(t Task) bool { return compareInts(t.ParentID, op, want) }, nil
+		})
+
+	case "estimate":
+		hours, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(value), "h"), 64)
+		if err != nil {
+			return nil, queryError("estimate: %q is not a number of hours", value)
+		}
+		return func(t Task) bool { return compareFloats(t.EstimatedHours, op, hours) }, nil
+
+	case "is":
+		if err := equalityOnly(); err != nil {
+			return nil, err
+		}
+		blocked := map[int]bool{}
+		for _, t := range appData.Tasks {
+			blocked[t.ID] = len(unfinishedBlockers(appData, t)) > 0
+		}
+		return anyOf(value, func(v string) (func(Task) bool, error) {
+			switch strings.ToLower(v) {
+			case "done":
+				return func(t Task) bool { return t.Done }, nil
+			case "open":
+				return func(t Task) bool { return !t.Done }, nil
+			case "overdue":
+				return func(t Task) bool { return !t.Done && t.DueDate != nil && t.DueDate.Before(now) }, nil
+			case "blocked":
+				return func(t Task) bool { return blocked[t.ID] }, nil
+			case "subtask":
+				return func(t Task) bool { return t.ParentID != 0 }, nil
+			case "recurring":
+				return func(t Task) bool { return t.Recurrence != nil || t.SeriesID != 0 }, nil
+			case "unassigned":
+				return func(t Task) bool { return t.Assignee == "" }, nil
+			}
+			return nil, queryError("is:%s is not supported (use done, open, overdue, blocked, subtask, recurring or unassigned)", v)
+		})
+	}
+	return nil, queryError("unknown field %q (use %s)", field, strings.Join(queryFields, ", "))
+}
+
+// parseQueryDate turns a date value into the span it covers. Relative
+// values count from now: 7d, -2w, 3m (months), 1y cover a whole day and 12h
+// is an exact moment. Anything else goes through parseDateAt and covers its
+// day unless it has a time.
+func parseQueryDate(value string, now time.Time) (time.Time, time.Time, error) {
+	dayOf := func(t time.Time) (time.Time, time.Time, error) {
+		start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+
+	if m := relativePattern.FindStringSubmatch(strings.ToLower(value)); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		switch m[2] {
+		case "h":
+			t := now.Add(time.Duration(n) * time.Hour)
+			return t, t, nil
+		case "d":
+			return dayOf(now.AddDate(0, 0, n))
+		case "w":
+			return dayOf(now.AddDate(0, 0, 7*n))
+		case "m":
+			return dayOf(now.AddDate(0, n, 0))
+		default:
+			return dayOf(now.AddDate(n, 0, 0))
+		}
+	}
+
+	t, err := parseDateAt(value, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, queryError("invalid date %q: %v", value, err)
+	}
+	if hasClockTime(*t) {
+		return *t, *t, nil
+	}
+	return dayOf(*t)
+}
+
+// statusRank orders statuses the way the board does, left to right.
+func statusRank(s TaskStatus) int {
+	for i, status := range validStatuses {
+		if status == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func compareInts(a int, op string, b int) bool {
+	return compareFloats(float64(a), op, float64(b))
+}
+
+func compareFloats(a float64, op string, b float64) bool {
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return a == b
+}
+
+func parseSortKeys(value string) ([]sortKey, error) {
+	var keys []sortKey
+	for _, part := range strings.Split(strings.ToLower(value), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key := sortKey{field: strings.TrimLeft(part, "+-"), desc: strings.HasPrefix(part, "-")}
+		if key.field == "title" || key.field == "desc" {
+			key.field = "description"
+		}
+		valid := false
+		for _, f := range sortFields {
+			valid = valid || f == key.field
+		}
+		if !valid {
+			return nil, queryError("cannot sort by %q (use %s)", key.field, strings.Join(sortFields, ", "))
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// compareTasks orders a and b by one key: negative, zero or positive. Tasks
+// without the date always come last.
+func compareTasks(a, b Task, field string, projectNames map[int]string) int {
+	cmpTime := func(x, y *time.Time) int {
+		switch {
+		case x == nil && y == nil:
+			return 0
+		case x == nil:
+			return 2 // kept last regardless of direction
+		case y == nil:
+			return -2
+		case x.Before(*y):
+			return -1
+		case y.Before(*x):
+			return 1
+		}
+		return 0
+	}
+	cmpInt := func(x, y int) int {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+
+	switch field {
+	case "priority":
+		return cmpInt(int(a.Priority), int(b.Priority))
+	case "due":
+		return cmpTime(a.DueDate, b.DueDate)
+	case "created":
+		return cmpTime(&a.CreatedAt, &b.CreatedAt)
+	case "completed":
+		return cmpTime(a.CompletedAt, b.CompletedAt)
+	case "status":
+		return cmpInt(statusRank(taskStatus(a)), statusRank(taskStatus(b)))
+	case "estimate":
+		switch {
+		case a.EstimatedHours < b.EstimatedHours:
+			return -1
+		case a.EstimatedHours > b.EstimatedHours:
+			return 1
+		}
+		return 0
+	case "description":
+		return strings.Compare(strings.ToLower(a.Description), strings.ToLower(b.Description))
+	case "project":
+		return strings.Compare(strings.ToLower(projectNames[a.ProjectID]), strings.ToLower(projectNames[b.ProjectID]))
+	case "position":
+		return cmpInt(a.Position, b.Position)
+	}
+	return cmpInt(a.ID, b.ID)
+}
+
+func sortTasksBy(tasks []Task, keys []sortKey, projectNames map[int]string) {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		for _, key := range keys {
+			c := compareTasks(tasks[i], tasks[j], key.field, projectNames)
+			if c == 2 || c == -2 {
+				return c < 0
+			}
+			if key.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return tasks[i].ID < tasks[j].ID
+	})
+}
+
+// findTasks runs a query and returns the requested page. Without sort keys
+// the usual list order applies.
+func findTasks(appData *AppData, opts TaskQueryOptions) (TaskPage, error) {
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	if opts.Offset < 0 || opts.Limit < 0 {
+		return TaskPage{}, queryError("offset and limit cannot be negative")
+	}
+	q, err := parseTaskQuery(appData, opts.Query, opts.Now, opts.User)
+	if err != nil {
+		return TaskPage{}, err
+	}
+	if opts.Sort != "" {
+		if q.sort, err = parseSortKeys(opts.Sort); err != nil {
+			return TaskPage{}, err
+		}
+	}
+
+	tasks := []Task{}
+	for _, t := range appData.Tasks {
+		if q.Match(t) {
+			tasks = append(tasks, t)
+		}
+	}
+	if len(q.sort) == 0 {
+		sortTasks(tasks)
+	} else {
+		projectNames := make(map[int]string, len(appData.Projects))
+		for _, p := range appData.Projects {
+			projectNames[p.ID] = p.Name
+		}
+		sortTasksBy(tasks, q.sort, projectNames)
+	}
+
+	page := TaskPage{Total: len(tasks), Offset: opts.Offset, Limit: opts.Limit}
+	if opts.Offset > len(tasks) {
+		opts.Offset = len(tasks)
+	}
+	tasks = tasks[opts.Offset:]
+	if opts.Limit > 0 && len(tasks) > opts.Limit {
+		tasks = tasks[:opts.Limit]
+	}
+	page.Tasks = tasks
+	return page, nil
+}
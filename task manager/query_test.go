@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// queryNow is a Wednesday.
+var queryNow = time.Date(2026, time.October, 14, 10, 0, 0, 0, time.UTC)
+
+func queryTestData() *AppData {
+	day := func(d int) *time.Time {
+		t := time.Date(2026, time.October, d, 23, 59, 59, 0, time.UTC)
+		return &t
+	}
+	task := func(t Task, status TaskStatus) Task {
+		setTaskStatus(&t, status)
+		if t.Done {
+			t.CompletedAt = day(12)
+		}
+		return t
+	}
+	return &AppData{
+		Projects: []Project{{ID: 1, Name: "Default"}, {ID: 2, Name: "Website"}, {ID: 3, Name: "Big Launch"}},
+		Tasks: []Task{
+			task(Task{ID: 1, ProjectID: 2, Description: "Write launch post", Priority: High, DueDate: day(15),
+				Tags: []string{"backend"}, Assignee: "ann", EstimatedHours: 3, CreatedAt: *day(1), Position: 2}, StatusTodo),
+			task(Task{ID: 2, ProjectID: 1, Description: "Fix login bug", Priority: Urgent, DueDate: day(10),
+				Tags: []string{"backend", "blocked"}, Assignee: "bob", EstimatedHours: 1, CreatedAt: *day(5), BlockedBy: []int{1}}, StatusInProgress),
+			task(Task{ID: 3, ProjectID: 3, Description: "plan roadmap", Category: "planning", Priority: Low,
+				CreatedAt: *day(2), Position: 1}, StatusDone),
+			task(Task{ID: 4, ProjectID: 2, ParentID: 1, Description: "Draft outline", Priority: Medium, DueDate: day(21),
+				EstimatedHours: 0.5, CreatedAt: *day(13)}, StatusBacklog),
+		},
+	}
+}
+
+// queryIDs runs line through tokenize and findTasks, ordered by ID unless
+// the query sorts.
+func queryIDs(appData *AppData, line, sort string) (string, error) {
+	terms, err := tokenize(line)
+	if err != nil {
+		return "", err
+	}
+	page, err := findTasks(appData, TaskQueryOptions{Query: terms, Sort: sort, Now: queryNow, User: "ann"})
+	if err != nil {
+		return "", err
+	}
+	ids := []int{}
+	for _, t := range page.Tasks {
+		ids = append(ids, t.ID)
+	}
+	return fmt.Sprint(ids), nil
+}
+
+func TestFindTasks(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{"", "[1 2 3 4]"},
+		{"status:todo", "[1]"},
+		{"status=todo,in_progress", "[1 2]"},
+		{"status!=done", "[1 2 4]"},
+		{"status>=in_progress", "[2 3]"},
+		{"status<todo", "[4]"},
+		{"priority>=high", "[1 2]"},
+		{"priority<=m", "[3 4]"},
+		{"priority>2", "[1 2]"},
+		{"-priority:low", "[1 2 4]"},
+		{"due:tomorrow", "[1]"},
+		{"due:1d", "[1]"},
+		{"due<0d", "[2]"},
+		{"due<7d", "[1 2]"},
+		{"due<=7d", "[1 2 4]"},
+		{"due>1d", "[4]"},
+		{"due>=2026-10-15", "[1 4]"},
+		{"due<12h", "[2]"},
+		{"due:none", "[3]"},
+		{"due:any", "[1 2 4]"},
+		{"created>=-2d", "[4]"},
+		{"created:2026-10-05", "[2]"},
+		{"completed:any", "[3]"},
+		{"completed>-1w", "[3]"},
+		{"tag:backend", "[1 2]"},
+		{"tag:BLOCKED,none", "[2 3 4]"},
+		{"-tag:blocked", "[1 3 4]"},
+		{"tag:none", "[3 4]"},
+		{"assignee:me", "[1]"},
+		{"assignee:ANN,bob", "[1 2]"},
+		{"assignee:none", "[3 4]"},
+		{"assignee!=none", "[1 2]"},
+		{"category:Planning", "[3]"},
+		{`project:"Big Launch"`, "[3]"},
+		{"project:'big launch'", "[3]"},
+		{"project:website", "[1 4]"},
+		{"text:login", "[2]"},
+		{`text:"launch post"`, "[1]"},
+		{"login", "[2]"},
+		{"-login", "[1 3 4]"},
+		{"id:1,#3", "[1 3]"},
+		{"id>2", "[3 4]"},
+		{"parent:1", "[4]"},
+		{"parent:none", "[1 2 3]"},
+		{"estimate>=1", "[1 2]"},
+		{"estimate<1h", "[3 4]"},
+		{"is:overdue", "[2]"},
+		{"is:blocked", "[2]"},
+		{"is:subtask", "[4]"},
+		{"is:done", "[3]"},
+		{"is:open", "[1 2 4]"},
+		{"is:unassigned", "[3 4]"},
+		{"tag:backend priority:urgent", "[2]"},
+		{"TAG:backend Status:TODO", "[1]"},
+	}
+	appData := queryTestData()
+	for _, tt := range tests {
+		got, err := queryIDs(appData, tt.query, "id")
+		if err != nil || got != tt.want {
+			t.Errorf("%s: %s (%v), want %s", tt.query, got, err, tt.want)
+		}
+	}
+}
+
+func TestFindTasksSorts(t *testing.T) {
+	tests := []struct {
+		query, sort, want string
+	}{
+		{"", "", "[2 1 4 3]"}, // the list order: open first, then by priority
+		{"", "id", "[1 2 3 4]"},
+		{"", "-id", "[4 3 2 1]"},
+		{"sort:priority", "", "[3 4 1 2]"},
+		{"sort:-priority", "", "[2 1 4 3]"},
+		{"sort:-priority", "id", "[1 2 3 4]"}, // Sort overrides sort: terms
+		{"sort:due", "", "[2 1 4 3]"},
+		{"sort:-due", "", "[4 1 2 3]"}, // no due date stays last
+		{"sort:completed", "", "[3 1 2 4]"},
+		{"sort:created", "", "[1 3 2 4]"},
+		{"sort:status", "", "[4 1 2 3]"},
+		{"sort:estimate", "", "[3 4 2 1]"},
+		{"sort:title", "", "[4 2 3 1]"},
+		{"sort:project", "", "[3 2 1 4]"},
+		{"sort:position", "", "[2 4 3 1]"},
+		{"sort:-project,-id", "", "[4 1 2 3]"},
+		{"sort:+project,+priority", "", "[3 2 4 1]"},
+		{"tag:backend sort:-estimate", "", "[1 2]"},
+	}
+	appData := queryTestData()
+	for _, tt := range tests {
+		got, err := queryIDs(appData, tt.query, tt.sort)
+		if err != nil || got != tt.want {
+			t.Errorf("%q sorted %q: %s (%v), want %s", tt.query, tt.sort, got, err, tt.want)
+		}
+	}
+}
+
+func TestFindTasksRejectsMalformedQueries(t *testing.T) {
+	queries := []string{
+		"status:", "status:nope", "status:todo,nope", "priority:max", "priority>=",
+		"due:someday", "due<soon", "due>any", "created<none", "completed:2026-13-40",
+		"tag>x", "tag<=none", "assignee>ann", "category<x", "project:Nowhere", "project>=Website",
+		"text>x", "id:abc", "id:#", "parent:x", "estimate:lots", "estimate:h",
+		"is:weird", "is>done", "bogus:1", "sort:bogus", "sort:due,whatever",
+	}
+	appData := queryTestData()
+	for _, q := range queries {
+		_, err := queryIDs(appData, q, "")
+		if apiErr, ok := err.(*apiError); !ok || apiErr.Status != http.StatusBadRequest {
+			t.Errorf("%s: error %v, want a query error", q, err)
+		}
+	}
+
+	// "me" needs to know who is asking.
+	_, err := findTasks(queryTestData(), TaskQueryOptions{Query: []string{"assignee:me"}, Now: queryNow})
+	if apiErr, ok := err.(*apiError); !ok || apiErr.Status != http.StatusBadRequest {
+		t.Errorf("assignee:me without a user: error %v", err)
+	}
+	_, err = findTasks(queryTestData(), TaskQueryOptions{Offset: -1})
+	if apiErr, ok := err.(*apiError); !ok || apiErr.Status != http.StatusBadRequest {
+		t.Errorf("negative offset: error %v", err)
+	}
+}
+
+func TestFindTasksPages(t *testing.T) {
+	tests := []struct {
+		offset, limit int
+		want          string
+	}{
+		{0, 0, "[1 2 3 4] 4"},
+		{0, 2, "[1 2] 4"},
+		{3, 2, "[4] 4"},
+		{9, 2, "[] 4"},
+	}
+	for _, tt := range tests {
+		page, err := findTasks(queryTestData(), TaskQueryOptions{Sort: "id", Offset: tt.offset, Limit: tt.limit, Now: queryNow})
+		ids := []int{}
+		for _, task := range page.Tasks {
+			ids = append(ids, task.ID)
+		}
+		if got := fmt.Sprint(ids, " ", page.Total); err != nil || got != tt.want {
+			t.Errorf("offset %d limit %d: %s (%v), want %s", tt.offset, tt.limit, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseQueryDate(t *testing.T) {
+	const layout = "2006-01-02 15:04:05"
+	tests := []struct {
+		value, start, end string // end "" means the whole day of start
+	}{
+		{"0d", "2026-10-14 00:00:00", ""},
+		{"7d", "2026-10-21 00:00:00", ""},
+		{"+1d", "2026-10-15 00:00:00", ""},
+		{"-2w", "2026-09-30 00:00:00", ""},
+		{"3m", "2027-01-14 00:00:00", ""},
+		{"1Y", "2027-10-14 00:00:00", ""},
+		{"12h", "2026-10-14 22:00:00", "2026-10-14 22:00:00"},
+		{"-3h", "2026-10-14 07:00:00", "2026-10-14 07:00:00"},
+		{"2026-10-20", "2026-10-20 00:00:00", ""},
+		{"tomorrow", "2026-10-15 00:00:00", ""},
+		{"friday", "2026-10-16 00:00:00", ""},
+		{"2026-10-20 15:30", "2026-10-20 15:30:00", "2026-10-20 15:30:00"},
+		{"tomorrow 9am", "2026-10-15 09:00:00", "2026-10-15 09:00:00"},
+	}
+	for _, tt := range tests {
+		start, end, err := parseQueryDate(tt.value, queryNow)
+		if err != nil {
+			t.Errorf("%s: %v", tt.value, err)
+			continue
+		}
+		wantEnd := tt.end
+		if wantEnd == "" {
+			wantEnd = tt.start[:10] + " 23:59:59"
+		}
+		if start.Format(layout) != tt.start || end.Format(layout) != wantEnd {
+			t.Errorf("%s: %s to %s, want %s to %s", tt.value, start.Format(layout), end.Format(layout), tt.start, wantEnd)
+		}
+		if tt.end == "" && !end.Add(time.Nanosecond).Equal(start.AddDate(0, 0, 1)) {
+			t.Errorf("%s: day ends at %v", tt.value, end)
+		}
+	}
+
+	for _, value := range []string{"", "soon", "7x", "d7", "2026-02-30"} {
+		if _, _, err := parseQueryDate(value, queryNow); err == nil {
+			t.Errorf("%q: no error", value)
+		} else if apiErr, ok := err.(*apiError); !ok || apiErr.Status != http.StatusBadRequest {
+			t.Errorf("%q: error %v, want a query error", value, err)
+		}
+	}
+}
+
+func TestSortTasksBy(t *testing.T) {
+	due := func(d int) *time.Time {
+		t := time.Date(2026, time.October, d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	tasks := []Task{
+		{ID: 1, Priority: High, DueDate: due(20), ProjectID: 2},
+		{ID: 2, Priority: Low, ProjectID: 1},
+		{ID: 3, Priority: High, DueDate: due(10), ProjectID: 1},
+		{ID: 4, Priority: Urgent, ProjectID: 2},
+	}
+	names := map[int]string{1: "beta", 2: "Alpha"}
+	tests := []struct {
+		keys string
+		want string
+	}{
+		{"", "[1 2 3 4]"},
+		{"priority", "[2 1 3 4]"},
+		{"-priority", "[4 1 3 2]"},
+		{"-priority,-id", "[4 3 1 2]"},
+		{"due", "[3 1 2 4]"},
+		{"-due", "[1 3 2 4]"},
+		{"project,-priority", "[4 1 3 2]"},
+		{"-project,due", "[3 2 1 4]"},
+	}
+	for _, tt := range tests {
+		keys, err := parseSortKeys(tt.keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sorted := append([]Task(nil), tasks...)
+		sortTasksBy(sorted, keys, names)
+		var ids []int
+		for _, task := range sorted {
+			ids = append(ids, task.ID)
+		}
+		if got := fmt.Sprint(ids); got != tt.want {
+			t.Errorf("sort %q: %s, want %s", tt.keys, got, tt.want)
+		}
+	}
+}
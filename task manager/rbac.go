@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Access to a project depends on the caller's role in it:
+//
+//	viewer     read the project, its tasks, kanban board and reports
+//	commenter  also comment on its tasks
+//	editor     also create, change, move and delete tasks and track time
+//	owner      also rename or delete the project and manage its members
+//
+// The creator of a project is its owner. Signed-in users who are not
+// members get the project's default role, which is none for projects made
+// in the web server. Projects without any members (made before roles
+// existed, or from the CLI) are given an owner once accounts exist: their
+// creator, or else the first account. Everyone else gets defaultMemberRole
+// there, as they could change those projects before. Tasks without a
+// project are open to everyone; a project that cannot be found, live or in
+// the trash, is open to no one.
+//
+// authorize in routeHandler checks the single project a request touches.
+// Listings that span projects are narrowed with visibleData instead.
+
+type Role string
+
+const (
+	RoleViewer    Role = "viewer"
+	RoleCommenter Role = "commenter"
+	RoleEditor    Role = "editor"
+	RoleOwner     Role = "owner"
+)
+
+// defaultMemberRole is what non-members get in projects that had no members.
+const defaultMemberRole = RoleEditor
+
+var roleRanks = map[Role]int{RoleViewer: 1, RoleCommenter: 2, RoleEditor: 3, RoleOwner: 4}
+
+// Allows reports whether someone with role r may do what needs role need.
+func (r Role) Allows(need Role) bool {
+	return roleRanks[r] >= roleRanks[need]
+}
+
+func parseRole(s string) (Role, error) {
+	role := Role(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := roleRanks[role]; !ok {
+		return "", &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid role %q (use owner, editor, commenter or viewer)", s)}
+	}
+	return role, nil
+}
+
+// parseDefaultRole reads the role of a project's non-members; "none" (or
+// nothing) keeps them out.
+func parseDefaultRole(s string) (Role, error) {
+	if s = strings.TrimSpace(s); s == "" || strings.EqualFold(s, "none") {
+		return "", nil
+	}
+	return parseRole(s)
+}
+
+type ProjectMember struct {
+	Username string `json:"username"`
+	Role     Role   `json:"role"`
+}
+
+// APIKey lets a script act as a user without signing in. Like sessions,
+// only a hash of the key is stored.
+type APIKey struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Name      string    `json:"name,omitempty"`
+	Prefix    string    `json:"prefix"` // first characters, to tell keys apart
+	KeyHash   string    `json:"key_hash,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const apiKeyPrefix = "tmk_"
+
+// newAPIKey creates a key for the user and returns it; it cannot be shown
+// again later.
+func newAPIKey(appData *AppData, userID int, name string) (string, APIKey, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", APIKey{}, err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	id := 1
+	for _, k := range appData.APIKeys {
+		if k.ID >= id {
+			id = k.ID + 1
+		}
+	}
+	apiKey := APIKey{
+		ID:        id,
+		UserID:    userID,
+		Name:      strings.TrimSpace(name),
+		Prefix:    key[:len(apiKeyPrefix)+6],
+		KeyHash:   hashToken(key),
+		CreatedAt: time.Now(),
+	}
+	appData.APIKeys = append(appData.APIKeys, apiKey)
+	return key, apiKey, nil
+}
+
+// userAPIKeys lists a user's keys without their hashes.
+func userAPIKeys(appData *AppData, userID int) []APIKey {
+	keys := []APIKey{}
+	for _, k := range appData.APIKeys {
+		if k.UserID == userID {
+			k.KeyHash = ""
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func revokeAPIKey(appData *AppData, userID, keyID int) error {
+	for i, k := range appData.APIKeys {
+		if k.ID == keyID && k.UserID == userID {
+			appData.APIKeys = append(appData.APIKeys[:i], appData.APIKeys[i+1:]...)
+			return nil
+		}
+	}
+	return &apiError{Status: http.StatusNotFound, Message: "API key not found"}
+}
+
+// projectRole returns the user's role in a project, or "" for none.
+func projectRole(project *Project, username string) Role {
+	for _, m := range project.Members {
+		if strings.EqualFold(m.Username, username) {
+			return m.Role
+		}
+	}
+	return project.DefaultRole
+}
+
+// assignProjectOwners gives every project without members an owner and
+// defaultMemberRole for everyone else. before holds the projects as they
+// were before an update, so one it created belongs to actor; other owners
+// come from the activity log, or are the first account. Nothing changes
+// while there are no accounts.
+func assignProjectOwners(appData *AppData, before map[int]string, actor string) {
+	if len(appData.Users) == 0 {
+		return
+	}
+	admin := appData.Users[0]
+	for _, u := range appData.Users {
+		if u.ID < admin.ID {
+			admin = u
+		}
+	}
+	creator := func(p Project) string {
+		if _, ok := before[p.ID]; !ok {
+			if u := findUser(appData, actor); u != nil {
+				return u.Username
+			}
+		}
+		for _, a := range appData.Activity {
+			if a.Entity == "project" && a.EntityID == p.ID && a.Action == "created" {
+				if u := findUser(appData, a.Actor); u != nil {
+					return u.Username
+				}
+				break
+			}
+		}
+		return admin.Username
+	}
+	for i := range appData.Projects {
+		p := &appData.Projects[i]
+		if len(p.Members) == 0 {
+			p.Members = []ProjectMember{{Username: creator(*p), Role: RoleOwner}}
+			p.DefaultRole = defaultMemberRole
+		}
+	}
+}
+
+// resolveProject finds a project, also in the trash, so access to what was
+// in it is still decided by its members.
+func resolveProject(appData *AppData, projectID int) *Project {
+	if project := findProject(appData, projectID, ""); project != nil {
+		return project
+	}
+	for _, item := range appData.Trash {
+		if item.Project != nil && item.Project.ID == projectID {
+			return item.Project
+		}
+	}
+	return nil
+}
+
+// checkProjectRole fails unless the user has at least role need in the
+// project. A project that cannot be resolved is reported as not found.
+// Without any accounts there are no roles to check.
+func checkProjectRole(appData *AppData, projectID int, username string, need Role) error {
+	if projectID == 0 || len(appData.Users) == 0 {
+		return nil
+	}
+	project := resolveProject(appData, projectID)
+	if project == nil {
+		return &apiError{Status: http.StatusNotFound, Message: fmt.Sprintf("Project #%d not found", projectID)}
+	}
+	role := projectRole(project, username)
+	if role == "" {
+		return &apiError{Status: http.StatusForbidden, Message: fmt.Sprintf("You are not a member of project %q", project.Name)}
+	}
+	if !role.Allows(need) {
+		return &apiError{Status: http.StatusForbidden, Message: fmt.Sprintf("This needs the %s role in project %q; your role is %s", need, project.Name, role)}
+	}
+	return nil
+}
+
+// taskProject returns the project of a task, or of a deleted task going by
+// its history.
+func taskProject(appData *AppData, taskID int) int {
+	if t := findTask(appData, taskID); t != nil {
+		return t.ProjectID
+	}
+	for i := len(appData.Activity) - 1; i >= 0; i-- {
+		if a := appData.Activity[i]; a.Entity == "task" && a.EntityID == taskID {
+			return a.ProjectID
+		}
+	}
+	return 0
+}
+
+// pathID reads the number following prefix in path, e.g. 7 in
+// /api/tasks/7/checklist.
+func pathID(path, prefix string) int {
+	rest := strings.TrimPrefix(path, prefix)
+	id, _ := strconv.Atoi(strings.SplitN(rest, "/", 2)[0])
+	return id
+}
+
+// peekBody decodes the JSON body into v and puts it back for the handler.
+func peekBody(r *http.Request, v interface{}) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err == nil {
+		json.Unmarshal(body, v)
+	}
+}
+
+// authorize checks that the caller may make request r. Requests that do not
+// touch one particular project pass; their handlers use visibleData.
+func authorize(r *http.Request, user User) error {
+	appData, err := loadAppData()
+	if err != nil {
+		return err
+	}
+	path := r.URL.Path
+	read := r.Method == "GET"
+	check := func(projectID int, need Role) error {
+		return checkProjectRole(appData, projectID, user.Username, need)
+	}
+	need := func(write Role) Role {
+		if read {
+			return RoleViewer
+		}
+		return write
+	}
+
+	switch {
+	case strings.HasPrefix(path, "/api/projects/"):
+		return check(pathID(path, "/api/projects/"), need(RoleOwner))
+
+	case path == "/api/tasks" && r.Method == "POST":
+		var body struct {
+			ProjectID int `json:"project_id"`
+			ParentID  int `json:"parent_id"`
+		}
+		peekBody(r, &body)
+		if body.ParentID != 0 {
+			body.ProjectID = taskProject(appData, body.ParentID)
+		}
+		return check(body.ProjectID, RoleEditor)
+
+	case strings.HasPrefix(path, "/api/tasks/") && strings.HasSuffix(path, "/snooze"):
+		return check(taskProject(appData, pathID(path, "/api/tasks/")), RoleViewer)
+
+	case strings.HasPrefix(path, "/api/tasks/"):
+		if err := check(taskProject(appData, pathID(path, "/api/tasks/")), need(RoleEditor)); err != nil || read {
+			return err
+		}
+		// Moving a task also needs editing rights where it lands.
+		var body struct {
+			ProjectID int `json:"project_id"`
+		}
+		if r.Method == "PUT" {
+			peekBody(r, &body)
+		}
+		return check(body.ProjectID, RoleEditor)
+
+	case path == "/api/kanban", path == calendarPath:
+		id, _ := strconv.Atoi(r.URL.Query().Get("project_id"))
+		return check(id, RoleViewer)
+
+	case path == "/api/kanban/move", path == "/api/time/start":
+		var body struct {
+			TaskID int `json:"task_id"`
+		}
+		peekBody(r, &body)
+		return check(taskProject(appData, body.TaskID), RoleEditor)
+
+	case strings.HasPrefix(path, "/api/time/"):
+		id := pathID(path, "/api/time/")
+		for _, e := range appData.TimeEntries {
+			if e.ID == id {
+				if err := check(taskProject(appData, e.TaskID), RoleEditor); err != nil {
+					return err
+				}
+			}
+		}
+		// Moving an entry to another task needs editing rights there.
+		var body struct {
+			TaskID int `json:"task_id"`
+		}
+		if r.Method == "PUT" {
+			peekBody(r, &body)
+		}
+		return check(taskProject(appData, body.TaskID), RoleEditor)
+
+	case path == "/api/time" && r.Method == "POST":
+		var body struct {
+			TaskID int `json:"task_id"`
+		}
+		peekBody(r, &body)
+		return check(taskProject(appData, body.TaskID), RoleEditor)
+
+	case path == "/api/webhooks" && r.Method == "POST":
+		var body struct {
+			ProjectID int `json:"project_id"`
+		}
+		peekBody(r, &body)
+		return check(body.ProjectID, RoleOwner)
+
+	case strings.HasPrefix(path, "/api/webhooks/"):
+		if h := findWebhook(appData, pathID(path, "/api/webhooks/")); h != nil {
+			return check(h.ProjectID, RoleOwner)
+		}
+
+	case strings.HasPrefix(path, "/api/trash/"):
+		if item := findTrashItem(appData, pathID(path, "/api/trash/")); item != nil {
+			return checkTrashAccess(appData, item, user.Username)
+		}
+
+	case path == "/api/comments" || path == "/api/time":
+		if read {
+			id, _ := strconv.Atoi(r.URL.Query().Get("task_id"))
+			return check(taskProject(appData, id), RoleViewer)
+		}
+		var body struct {
+			TaskID int `json:"task_id"`
+		}
+		peekBody(r, &body)
+		return check(taskProject(appData, body.TaskID), RoleCommenter)
+	}
+	return nil
+}
+
+// visibleData narrows appData to the projects the caller of r can see, for
+// listings and reports that span projects. The result must not be saved.
+func visibleData(r *http.Request, appData *AppData) *AppData {
+	user, ok := requestUser(r)
+	if !ok {
+		return appData
+	}
+	scoped := *appData
+	visible := map[int]bool{0: true}
+	scoped.Projects = []Project{}
+	for i := range appData.Projects {
+		if projectRole(&appData.Projects[i], user.Username) != "" {
+			visible[appData.Projects[i].ID] = true
+			scoped.Projects = append(scoped.Projects, appData.Projects[i])
+		}
+	}
+	scoped.Tasks = []Task{}
+	taskVisible := map[int]bool{}
+	for _, t := range appData.Tasks {
+		if visible[t.ProjectID] {
+			taskVisible[t.ID] = true
+			scoped.Tasks = append(scoped.Tasks, t)
+		}
+	}
+	scoped.TimeEntries = []TimeEntry{}
+	for _, e := range appData.TimeEntries {
+		if taskVisible[e.TaskID] {
+			scoped.TimeEntries = append(scoped.TimeEntries, e)
+		}
+	}
+	return &scoped
+}
+
+// canSeeTask is visibleData for a single task.
+func canSeeTask(r *http.Request, appData *AppData) func(Task) bool {
+	user, ok := requestUser(r)
+	return func(t Task) bool {
+		if !ok || t.ProjectID == 0 {
+			return true
+		}
+		project := resolveProject(appData, t.ProjectID)
+		return project != nil && projectRole(project, user.Username) != ""
+	}
+}
+
+// checkBlockerAccess fails unless the caller of r can see every one of the
+// blocking tasks, so a dependency cannot reach into (or be used to probe)
+// another project. Hidden tasks are reported like missing ones.
+func checkBlockerAccess(r *http.Request, appData *AppData, blockerIDs ...int) error {
+	visible := canSeeTask(r, appData)
+	for _, id := range blockerIDs {
+		if t := findTask(appData, id); t != nil && !visible(*t) {
+			return &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("blocking task #%d not found", id)}
+		}
+	}
+	return nil
+}
+
+// setProjectMember adds a member or changes their role. A project always
+// keeps at least one owner.
+func setProjectMember(appData *AppData, project *Project, username string, role Role) error {
+	user := findUser(appData, username)
+	if user == nil {
+		return &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("user %q not found", username)}
+	}
+	for i, m := range project.Members {
+		if strings.EqualFold(m.Username, user.Username) {
+			project.Members[i].Role = role
+			return checkHasOwner(project)
+		}
+	}
+	project.Members = append(project.Members, ProjectMember{Username: user.Username, Role: role})
+	return checkHasOwner(project)
+}
+
+func removeProjectMember(project *Project, username string) error {
+	for i, m := range project.Members {
+		if strings.EqualFold(m.Username, username) {
+			project.Members = append(project.Members[:i], project.Members[i+1:]...)
+			if len(project.Members) == 0 {
+				return &apiError{Status: http.StatusConflict, Message: "A project needs at least one owner"}
+			}
+			return checkHasOwner(project)
+		}
+	}
+	return &apiError{Status: http.StatusNotFound, Message: fmt.Sprintf("%s is not a member of this project", username)}
+}
+
+func checkHasOwner(project *Project) error {
+	for _, m := range project.Members {
+		if m.Role == RoleOwner {
+			return nil
+		}
+	}
+	return &apiError{Status: http.StatusConflict, Message: "A project needs at least one owner"}
+}
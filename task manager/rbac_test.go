@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// rbacTestData has one member of project 1 per role, an outsider, and a
+// private project 2 only its owner can see.
+func rbacTestData() *AppData {
+	return signInAll(&AppData{
+		Users: []User{
+			{ID: 1, Username: "owen"}, {ID: 2, Username: "eddie"}, {ID: 3, Username: "cora"},
+			{ID: 4, Username: "vic"}, {ID: 5, Username: "olga"},
+		},
+		Projects: []Project{
+			{ID: 1, Name: "Team", Members: []ProjectMember{
+				{Username: "owen", Role: RoleOwner}, {Username: "eddie", Role: RoleEditor},
+				{Username: "cora", Role: RoleCommenter}, {Username: "vic", Role: RoleViewer},
+			}},
+			{ID: 2, Name: "Private", Members: []ProjectMember{{Username: "owen", Role: RoleOwner}}},
+		},
+		Tasks: []Task{{ID: 1, ProjectID: 1, Description: "Shared"}, {ID: 2, ProjectID: 2, Description: "Secret"}},
+	})
+}
+
+func TestRouteRoles(t *testing.T) {
+	// users from least to most rights; "olga" is not a member at all.
+	users := []string{"olga", "vic", "cora", "eddie", "owen"}
+	rank := map[Role]int{RoleViewer: 1, RoleCommenter: 2, RoleEditor: 3, RoleOwner: 4}
+
+	tests := []struct {
+		method, path, body string
+		need               Role
+	}{
+		{"GET", "/api/tasks/1", "", RoleViewer},
+		{"GET", "/api/tasks/1/dependencies", "", RoleViewer},
+		{"GET", "/api/projects/1/members", "", RoleViewer},
+		{"GET", "/api/kanban?project_id=1", "", RoleViewer},
+		{"GET", "/api/comments?task_id=1", "", RoleViewer},
+		{"POST", "/api/comments", `{"task_id": 1, "text": "hi"}`, RoleCommenter},
+		{"POST", "/api/tasks", `{"description": "New", "project_id": 1}`, RoleEditor},
+		{"PUT", "/api/tasks/1", `{"description": "Edited"}`, RoleEditor},
+		{"PUT", "/api/tasks/1/done", "", RoleEditor},
+		{"POST", "/api/time", `{"task_id": 1, "start_time": "2026-10-14T09:00:00Z", "end_time": "2026-10-14T10:00:00Z"}`, RoleEditor},
+		{"DELETE", "/api/tasks/1", "", RoleEditor},
+		{"PUT", "/api/projects/1", `{"name": "Renamed"}`, RoleOwner},
+		{"PUT", "/api/projects/1/members", `{"username": "olga", "role": "viewer"}`, RoleOwner},
+		{"POST", "/api/webhooks", `{"project_id": 1, "url": "https://hooks.example.com/"}`, RoleOwner},
+		// Moving a task needs editing rights where it lands, which only
+		// the owner of project 1 has in project 2.
+		{"PUT", "/api/tasks/1", `{"project_id": 2}`, RoleOwner},
+	}
+	for _, tt := range tests {
+		for i, user := range users {
+			useTestStore(t, rbacTestData())
+			w := serveAs(user, tt.method, tt.path, tt.body)
+			allowed := i >= rank[tt.need]
+			if got := w.Code < 300; got != allowed || (!allowed && w.Code != http.StatusForbidden) {
+				t.Errorf("%s %s as %s: status %d (%s), want allowed %v",
+					tt.method, tt.path, user, w.Code, w.Body, allowed)
+			}
+		}
+	}
+}
+
+func TestRoutesShowOnlyVisibleData(t *testing.T) {
+	useTestStore(t, rbacTestData())
+	tests := []struct {
+		user, path, want string
+	}{
+		{"owen", "/api/tasks", "[1 2]"},
+		{"vic", "/api/tasks", "[1]"},
+		{"olga", "/api/tasks", "[]"},
+		{"owen", "/api/projects", "[1 2]"},
+		{"cora", "/api/projects", "[1]"},
+		{"olga", "/api/projects", "[]"},
+	}
+	for _, tt := range tests {
+		w := serveAs(tt.user, "GET", tt.path, "")
+		var resp struct {
+			Data json.RawMessage `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		var items []struct {
+			ID int `json:"id"`
+		}
+		if tt.path == "/api/tasks" {
+			var page struct{ Tasks json.RawMessage }
+			json.Unmarshal(resp.Data, &page)
+			resp.Data = page.Tasks
+		}
+		json.Unmarshal(resp.Data, &items)
+		ids := []int{}
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		if got := fmt.Sprint(ids); w.Code != http.StatusOK || got != tt.want {
+			t.Errorf("GET %s as %s: status %d, ids %s, want %s", tt.path, tt.user, w.Code, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence describes how a task repeats. It mirrors the subset of RFC 5545
+// RRULE that we support: FREQ, INTERVAL, BYDAY (plain weekdays only),
+// BYMONTHDAY (a single day, -1 for the last day), COUNT and UNTIL.
+type Recurrence struct {
+	Freq     string     `json:"freq"` // daily, weekly, monthly or yearly
+	Interval int        `json:"interval,omitempty"`
+	Weekdays []string   `json:"weekdays,omitempty"`  // RRULE day codes: MO, TU, ...
+	MonthDay int        `json:"month_day,omitempty"` // 1-31, or -1 for the last day
+	Count    int        `json:"count,omitempty"`     // occurrences left, including this one
+	Until    *time.Time `json:"until,omitempty"`
+}
+
+var weekdayCodes = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+var weekdayNames = map[string]string{
+	"su": "SU", "sun": "SU", "sunday": "SU",
+	"mo": "MO", "mon": "MO", "monday": "MO",
+	"tu": "TU", "tue": "TU", "tues": "TU", "tuesday": "TU",
+	"we": "WE", "wed": "WE", "wednesday": "WE",
+	"th": "TH", "thu": "TH", "thur": "TH", "thurs": "TH", "thursday": "TH",
+	"fr": "FR", "fri": "FR", "friday": "FR",
+	"sa": "SA", "sat": "SA", "saturday": "SA",
+}
+
+func recurrenceError(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+// parseRecurrence understands the forms accepted by "create --repeat":
+//
+//	daily | weekly | monthly | yearly | weekdays
+//	every 2 days | every 3 weeks | every month
+//	weekly on mon,wed,fri | every tue
+//	monthly on 15 | monthly on day 1 | monthly on last
+//	FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10 (optionally prefixed with RRULE:)
+func parseRecurrence(s string) (*Recurrence, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	upper := strings.ToUpper(s)
+	if strings.HasPrefix(upper, "RRULE:") || strings.HasPrefix(upper, "FREQ=") {
+		return parseRRule(strings.TrimPrefix(upper, "RRULE:"))
+	}
+
+	words := strings.Fields(strings.ToLower(strings.NewReplacer(",", " ", ";", " ").Replace(s)))
+	if len(words) == 0 {
+		return nil, recurrenceError("%q: repeat what?", s)
+	}
+	rec := &Recurrence{Interval: 1}
+
+	switch words[0] {
+	case "daily":
+		rec.Freq = "daily"
+	case "weekly":
+		rec.Freq = "weekly"
+	case "monthly":
+		rec.Freq = "monthly"
+	case "yearly", "annually":
+		rec.Freq = "yearly"
+	case "weekdays":
+		rec.Freq = "weekly"
+		rec.Weekdays = []string{"MO", "TU", "WE", "TH", "FR"}
+	case "every":
+		if len(words) < 2 {
+			return nil, recurrenceError("%q: every what?", s)
+		}
+		rest := words[1:]
+		if n, err := strconv.Atoi(rest[0]); err == nil {
+			if n < 1 {
+				return nil, recurrenceError("%q: interval must be at least 1", s)
+			}
+			rec.Interval = n
+			rest = rest[1:]
+		}
+		if len(rest) == 0 {
+			return nil, recurrenceError("%q: every %d what?", s, rec.Interval)
+		}
+		if _, ok := weekdayNames[rest[0]]; ok {
+			// "every mon wed"
+			rec.Freq = "weekly"
+			words = append([]string{"weekly", "on"}, rest...)
+			break
+		}
+		switch strings.TrimSuffix(rest[0], "s") {
+		case "day":
+			rec.Freq = "daily"
+		case "week":
+			rec.Freq = "weekly"
+		case "month":
+			rec.Freq = "monthly"
+		case "year":
+			rec.Freq = "yearly"
+		case "weekday":
+			rec.Freq = "weekly"
+			rec.Weekdays = []string{"MO", "TU", "WE", "TH", "FR"}
+		default:
+			return nil, recurrenceError("%q: unknown unit %q", s, rest[0])
+		}
+		words = append([]string{rec.Freq}, rest[1:]...)
+	default:
+		return nil, recurrenceError("unknown repeat %q (try daily, weekly on mon,fri, monthly on 15 or an RRULE)", s)
+	}
+
+	// Optional "on ..." clause.
+	rest := words[1:]
+	if len(rest) > 0 && rest[0] == "on" {
+		rest = rest[1:]
+		if len(rest) == 0 {
+			return nil, recurrenceError("%q: on what?", s)
+		}
+		switch rec.Freq {
+		case "weekly":
+			for _, w := range rest {
+				code, ok := weekdayNames[w]
+				if !ok {
+					return nil, recurrenceError("%q: unknown weekday %q", s, w)
+				}
+				rec.Weekdays = append(rec.Weekdays, code)
+			}
+			rest = nil
+		case "monthly":
+			if rest[0] == "day" && len(rest) > 1 {
+				rest = rest[1:]
+			}
+			day := strings.TrimRight(rest[0], "stndrh")
+			if rest[0] == "last" {
+				rec.MonthDay = -1
+			} else if n, err := strconv.Atoi(day); err == nil && n >= 1 && n <= 31 {
+				rec.MonthDay = n
+			} else {
+				return nil, recurrenceError("%q: day of month must be 1-31 or last", s)
+			}
+			rest = rest[1:]
+		default:
+			return nil, recurrenceError("%q: \"on\" only applies to weekly and monthly", s)
+		}
+	}
+	if len(rest) > 0 {
+		return nil, recurrenceError("%q: unexpected %q", s, strings.Join(rest, " "))
+	}
+	rec.normalize()
+	return rec, nil
+}
+
+// parseRRule parses an upper-cased "FREQ=...;..." rule.
+func parseRRule(rule string) (*Recurrence, error) {
+	rec := &Recurrence{Interval: 1}
+	for _, part := range strings.Split(strings.Trim(rule, ";"), ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, recurrenceError("RRULE: bad part %q", part)
+		}
+		switch key {
+		case "FREQ":
+			switch value {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				rec.Freq = strings.ToLower(value)
+			default:
+				return nil, recurrenceError("RRULE: FREQ=%s is not supported", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, recurrenceError("RRULE: bad INTERVAL %q", value)
+			}
+			rec.Interval = n
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				if indexOf(weekdayCodes, day) < 0 {
+					return nil, recurrenceError("RRULE: BYDAY=%s is not supported (plain weekdays only)", day)
+				}
+				rec.Weekdays = append(rec.Weekdays, day)
+			}
+		case "BYMONTHDAY":
+			n, err := strconv.Atoi(value)
+			if err != nil || n == 0 || n < -1 || n > 31 {
+				return nil, recurrenceError("RRULE: BYMONTHDAY=%s is not supported (1-31 or -1)", value)
+			}
+			rec.MonthDay = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, recurrenceError("RRULE: bad COUNT %q", value)
+			}
+			rec.Count = n
+		case "UNTIL":
+			until, err := parseRRuleTime(value)
+			if err != nil {
+				return nil, recurrenceError("RRULE: bad UNTIL %q", value)
+			}
+			rec.Until = &until
+		case "WKST":
+			// Weeks always start on Monday here, which is the RFC default.
+		default:
+			return nil, recurrenceError("RRULE: %s is not supported", key)
+		}
+	}
+	if rec.Freq == "" {
+		return nil, recurrenceError("RRULE: FREQ is required")
+	}
+	if rec.Count > 0 && rec.Until != nil {
+		return nil, recurrenceError("RRULE: COUNT and UNTIL cannot both be set")
+	}
+	if len(rec.Weekdays) > 0 && rec.Freq != "weekly" {
+		return nil, recurrenceError("RRULE: BYDAY is only supported with FREQ=WEEKLY")
+	}
+	if rec.MonthDay != 0 && rec.Freq != "monthly" {
+		return nil, recurrenceError("RRULE: BYMONTHDAY is only supported with FREQ=MONTHLY")
+	}
+	rec.normalize()
+	return rec, nil
+}
+
+func parseRRuleTime(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			if strings.HasSuffix(value, "Z") {
+				t, _ = time.Parse(layout, value)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad time %q", value)
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// normalize sorts and de-duplicates weekdays so equal rules compare equal.
+func (rec *Recurrence) normalize() {
+	if rec.Interval <= 1 {
+		rec.Interval = 0
+	}
+	if len(rec.Weekdays) == 0 {
+		rec.Weekdays = nil
+		return
+	}
+	var days []string
+	for _, code := range weekdayCodes[1:] {
+		if indexOf(rec.Weekdays, code) >= 0 {
+			days = append(days, code)
+		}
+	}
+	if indexOf(rec.Weekdays, "SU") >= 0 {
+		days = append(days, "SU")
+	}
+	rec.Weekdays = days
+}
+
+func (rec Recurrence) interval() int {
+	if rec.Interval < 1 {
+		return 1
+	}
+	return rec.Interval
+}
+
+// RRule renders the rule in RFC 5545 form, without the "RRULE:" prefix.
+func (rec Recurrence) RRule() string {
+	parts := []string{"FREQ=" + strings.ToUpper(rec.Freq)}
+	if rec.interval() > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", rec.interval()))
+	}
+	if len(rec.Weekdays) > 0 {
+		parts = append(parts, "BYDAY="+strings.Join(rec.Weekdays, ","))
+	}
+	if rec.MonthDay != 0 {
+		parts = append(parts, fmt.Sprintf("BYMONTHDAY=%d", rec.MonthDay))
+	}
+	if rec.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", rec.Count))
+	}
+	if rec.Until != nil {
+		parts = append(parts, "UNTIL="+rec.Until.UTC().Format("20060102T150405Z"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// String describes the rule for people, e.g. "every 2 weeks on Mon, Thu".
+func (rec Recurrence) String() string {
+	units := map[string]string{"daily": "day", "weekly": "week", "monthly": "month", "yearly": "year"}
+	s := "every " + units[rec.Freq]
+	if n := rec.interval(); n > 1 {
+		s = fmt.Sprintf("every %d %ss", n, units[rec.Freq])
+	}
+	if len(rec.Weekdays) > 0 {
+		names := make([]string, len(rec.Weekdays))
+		for i, code := range rec.Weekdays {
+			names[i] = time.Weekday(indexOf(weekdayCodes, code)).String()[:3]
+		}
+		s += " on " + strings.Join(names, ", ")
+	}
+	switch {
+	case rec.MonthDay == -1:
+		s += " on the last day"
+	case rec.MonthDay > 0:
+		s += fmt.Sprintf(" on day %d", rec.MonthDay)
+	}
+	if rec.Count > 0 {
+		s += fmt.Sprintf(" (%d left)", rec.Count)
+	}
+	if rec.Until != nil {
+		s += " until " + rec.Until.Format("2006-01-02")
+	}
+	return s
+}
+
+// monthDate returns day of the given month, clamping to the month's last
+// day so "day 31" still happens in shorter months.
+func monthDate(year int, month time.Month, day int, clock time.Time) time.Time {
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, clock.Location()).Day()
+	if day == -1 || day > last {
+		day = last
+	}
+	return time.Date(year, month, day, clock.Hour(), clock.Minute(), clock.Second(), 0, clock.Location())
+}
+
+// startOfWeek returns the Monday of t's week at midnight.
+func startOfWeek(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location())
+}
+
+// Next returns the first occurrence strictly after base, keeping base's time
+// of day. Weekly and monthly intervals count from base's week or month.
+func (rec Recurrence) Next(base time.Time) time.Time {
+	n := rec.interval()
+	switch rec.Freq {
+	case "daily":
+		return base.AddDate(0, 0, n)
+
+	case "weekly":
+		if len(rec.Weekdays) == 0 {
+			return base.AddDate(0, 0, 7*n)
+		}
+		week := startOfWeek(base)
+		for day := base.AddDate(0, 0, 1); ; day = day.AddDate(0, 0, 1) {
+			weeks := int(startOfWeek(day).Sub(week).Hours()/24+0.5) / 7
+			if weeks%n == 0 && indexOf(rec.Weekdays, weekdayCodes[day.Weekday()]) >= 0 {
+				return day
+			}
+		}
+
+	case "monthly":
+		day := rec.MonthDay
+		if day == 0 {
+			day = base.Day()
+		}
+		for k := 0; ; k += n {
+			first := time.Date(base.Year(), base.Month()+time.Month(k), 1, 0, 0, 0, 0, base.Location())
+			next := monthDate(first.Year(), first.Month(), day, base)
+			if next.After(base) {
+				return next
+			}
+		}
+
+	case "yearly":
+		return monthDate(base.Year()+n, base.Month(), base.Day(), base)
+	}
+	return base.AddDate(0, 0, 1)
+}
+
+// First returns the first occurrence on or after the day of now, used as the
+// due date when a recurring task is created without one.
+func (rec Recurrence) First(now time.Time) time.Time {
+	endOfDay := time.Date(now.Year(), now.Month(), now.Day(), 23, 59, 59, 0, now.Location())
+	if len(rec.Weekdays) == 0 && rec.MonthDay == 0 {
+		return endOfDay
+	}
+	// Ask for the next occurrence after yesterday, ignoring the interval,
+	// which only matters from the first occurrence on.
+	first := rec
+	first.Interval = 0
+	return first.Next(endOfDay.AddDate(0, 0, -1))
+}
+
+// validate rejects rules that can never produce an occurrence.
+func (rec Recurrence) validate() error {
+	switch rec.Freq {
+	case "daily", "weekly", "monthly", "yearly":
+	default:
+		return recurrenceError("unknown repeat frequency %q", rec.Freq)
+	}
+	for _, day := range rec.Weekdays {
+		if indexOf(weekdayCodes, day) < 0 {
+			return recurrenceError("unknown weekday %q", day)
+		}
+	}
+	if rec.MonthDay < -1 || rec.MonthDay > 31 {
+		return recurrenceError("day of month must be 1-31 or -1")
+	}
+	return nil
+}
+
+// spawnNextOccurrence creates the next occurrence of a recurring task that
+// has just been completed. The rule moves to the new task so completing the
+// old one again cannot spawn a second copy. It returns nil when the task
+// does not repeat or the rule has run out.
+func spawnNextOccurrence(appData *AppData, id int) (*Task, error) {
+	done := findTask(appData, id)
+	if done == nil || done.Recurrence == nil || !done.Done {
+		return nil, nil
+	}
+	rec := *done.Recurrence
+	done.Recurrence = nil
+	series := done.SeriesID
+	if series == 0 {
+		series = done.ID
+		done.SeriesID = series
+	}
+
+	if rec.Count == 1 {
+		return nil, nil
+	}
+	if rec.Count > 1 {
+		rec.Count--
+	}
+
+	// Advance from the due date, or from completion for tasks without one,
+	// skipping occurrences that are already in the past.
+	base := time.Now()
+	if done.DueDate != nil {
+		base = *done.DueDate
+	}
+	next := rec.Next(base)
+	for !next.After(time.Now()) {
+		next = rec.Next(next)
+	}
+	// UNTIL is compared by calendar day, since due dates carry an
+	// end-of-day time that a date-only UNTIL would otherwise cut off.
+	if rec.Until != nil && next.Format("2006-01-02") > rec.Until.In(next.Location()).Format("2006-01-02") {
+		return nil, nil
+	}
+
+	var priority = done.Priority
+	input := TaskInput{
+		Description:    done.Description,
+		ProjectID:      done.ProjectID,
+		ParentID:       done.ParentID,
+		Category:       done.Category,
+		Priority:       &priority,
+		DueDate:        &next,
+		Tags:           done.Tags,
+		Assignee:       done.Assignee,
+		EstimatedHours: done.EstimatedHours,
+	}
+	var checklist []ChecklistItem
+	for _, item := range done.Checklist {
+		item.Done = false
+		checklist = append(checklist, item)
+	}
+
+	task, err := createTask(appData, input)
+	if err != nil {
+		return nil, err
+	}
+	task.Recurrence = &rec
+	task.SeriesID = series
+	task.Checklist = checklist
+	return task, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestParseRecurrence(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // jsonString of the result; "" for an error
+	}{
+		{"", "null"},
+		{"   ", "null"},
+		{"daily", `{"freq":"daily"}`},
+		{"every 2 weeks", `{"freq":"weekly","interval":2}`},
+		{"weekly on mon,fri", `{"freq":"weekly","weekdays":["MO","FR"]}`},
+		{"every tue", `{"freq":"weekly","weekdays":["TU"]}`},
+		{"weekdays", `{"freq":"weekly","weekdays":["MO","TU","WE","TH","FR"]}`},
+		{"monthly on last", `{"freq":"monthly","month_day":-1}`},
+		{"monthly on day 15", `{"freq":"monthly","month_day":15}`},
+		{"RRULE:FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10", `{"freq":"weekly","weekdays":["MO","WE"],"count":10}`},
+		{",", ""},
+		{" ; ", ""},
+		{",;,", ""},
+		{"every", ""},
+		{"every 0 days", ""},
+		{"fortnightly", ""},
+		{"monthly on 32", ""},
+		{"daily on mon", ""},
+	}
+	for _, tt := range tests {
+		rec, err := parseRecurrence(tt.in)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("parseRecurrence(%q) = %s, want an error", tt.in, jsonString(rec))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRecurrence(%q): %v", tt.in, err)
+			continue
+		}
+		if got := jsonString(rec); got != tt.want {
+			t.Errorf("parseRecurrence(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
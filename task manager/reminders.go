@@ -0,0 +1,463 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// While the server runs, reminderLoop checks every minute for open tasks
+// with a due date and reminds their assignee at the offsets in the
+// assignee's preferences: "1d" is a day before, "0" when due and "-2h" two
+// hours late. Tasks whose assignee has no account are reminded with the
+// preferences stored for the empty username. Date-only due dates count
+// from reminderHour that day rather than from midnight.
+//
+// Sent reminders are logged in the data file so a restart doesn't repeat
+// them. After downtime only the latest missed offset is sent, and only if
+// it is less than reminderCatchUp late. A snoozed task stays quiet until
+// the snooze ends, then is reminded once.
+
+const (
+	reminderInterval = time.Minute
+	reminderCatchUp  = 24 * time.Hour
+	reminderHour     = 9
+)
+
+var (
+	defaultReminderOffsets = []string{"1d", "1h", "0"}
+	reminderChannels       = []string{"stdout", "smtp", "webhook"}
+	offsetPattern          = regexp.MustCompile(`^(-?\d+)([mhdw])$`)
+)
+
+// ReminderPrefs are a user's reminder settings.
+type ReminderPrefs struct {
+	Username   string   `json:"username"`
+	Offsets    []string `json:"offsets"`
+	Channels   []string `json:"channels"`
+	Email      string   `json:"email,omitempty"`
+	WebhookURL string   `json:"webhook_url,omitempty"`
+	Muted      bool     `json:"muted,omitempty"`
+}
+
+// SentReminder records that the reminder at Offset before DueAt was sent.
+// A new due date gets new reminders.
+type SentReminder struct {
+	TaskID   int       `json:"task_id"`
+	Username string    `json:"username,omitempty"`
+	DueAt    time.Time `json:"due_at"`
+	Offset   string    `json:"offset"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+type Snooze struct {
+	TaskID   int       `json:"task_id"`
+	Username string    `json:"username,omitempty"`
+	Until    time.Time `json:"until"`
+}
+
+// RemindersInfo is what GET /api/me/reminders and "remind list" return.
+type RemindersInfo struct {
+	Prefs    ReminderPrefs  `json:"prefs"`
+	Upcoming []Notification `json:"upcoming"`
+	Snoozes  []Snooze       `json:"snoozes"`
+}
+
+// parseReminderOffset reads an offset before the due date: a whole number
+// of minutes, hours, days or weeks, negative for after it.
+func parseReminderOffset(s string) (time.Duration, error) {
+	if s == "0" {
+		return 0, nil
+	}
+	m := offsetPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, fmt.Errorf("invalid reminder offset %q (use e.g. 30m, 2h, 1d, 1w or -1d)", s)
+	}
+	n, _ := strconv.Atoi(m[1])
+	unit := map[string]time.Duration{"m": time.Minute, "h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour}[m[2]]
+	return time.Duration(n) * unit, nil
+}
+
+// normalizeOffsets validates offsets and sorts them earliest reminder first.
+func normalizeOffsets(offsets []string) ([]string, error) {
+	durations := map[string]time.Duration{}
+	var out []string
+	for _, s := range offsets {
+		s = strings.ToLower(strings.TrimSpace(s))
+		d, err := parseReminderOffset(s)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := durations[s]; !dup {
+			durations[s] = d
+			out = append(out, s)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return durations[out[i]] > durations[out[j]] })
+	return out, nil
+}
+
+// reminderPrefs returns the user's preferences, filling in the defaults.
+// TASKMANAGER_REMINDER_OFFSETS overrides the default offsets.
+func reminderPrefs(appData *AppData, username string) ReminderPrefs {
+	prefs := ReminderPrefs{Username: username}
+	for _, p := range appData.ReminderPrefs {
+		if strings.EqualFold(p.Username, username) {
+			prefs = p
+			break
+		}
+	}
+	if len(prefs.Offsets) == 0 {
+		prefs.Offsets = defaultReminderOffsets
+		if env := os.Getenv("TASKMANAGER_REMINDER_OFFSETS"); env != "" {
+			if offsets, err := normalizeOffsets(strings.Split(env, ",")); err == nil {
+				prefs.Offsets = offsets
+			}
+		}
+	}
+	if len(prefs.Channels) == 0 {
+		prefs.Channels = []string{"stdout"}
+	}
+	return prefs
+}
+
+// setReminderPrefs validates and stores a user's preferences.
+func setReminderPrefs(appData *AppData, prefs ReminderPrefs) error {
+	offsets, err := normalizeOffsets(prefs.Offsets)
+	if err != nil {
+		return queryError("%v", err)
+	}
+	prefs.Offsets = offsets
+	for i, ch := range prefs.Channels {
+		ch = strings.ToLower(strings.TrimSpace(ch))
+		if !containsString(reminderChannels, ch) {
+			return queryError("unknown channel %q (use %s)", ch, strings.Join(reminderChannels, ", "))
+		}
+		prefs.Channels[i] = ch
+	}
+	if containsString(prefs.Channels, "smtp") && !strings.Contains(prefs.Email, "@") {
+		return queryError("the smtp channel needs an email address")
+	}
+	if prefs.WebhookURL != "" {
+		if err := checkUserWebhookURL(prefs.WebhookURL); err != nil {
+			return queryError("%v", err)
+		}
+	}
+	for i, p := range appData.ReminderPrefs {
+		if strings.EqualFold(p.Username, prefs.Username) {
+			appData.ReminderPrefs[i] = prefs
+			return nil
+		}
+	}
+	appData.ReminderPrefs = append(appData.ReminderPrefs, prefs)
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// reminderRecipient is the account a task's reminders go to, or "" when
+// its assignee has none.
+func reminderRecipient(appData *AppData, t Task) string {
+	if t.Assignee == "" {
+		return ""
+	}
+	if user := findUser(appData, t.Assignee); user != nil {
+		return user.Username
+	}
+	return ""
+}
+
+// reminderDueAt is the moment reminder offsets count from.
+func reminderDueAt(due time.Time) time.Time {
+	if hasClockTime(due) {
+		return due
+	}
+	return time.Date(due.Year(), due.Month(), due.Day(), reminderHour, 0, 0, 0, due.Location())
+}
+
+func findSnooze(appData *AppData, taskID int, username string) int {
+	for i, s := range appData.Snoozes {
+		if s.TaskID == taskID && strings.EqualFold(s.Username, username) {
+			return i
+		}
+	}
+	return -1
+}
+
+// snoozeTask silences a task's reminders until the given time.
+func snoozeTask(appData *AppData, taskID int, username string, until time.Time) error {
+	task := findTask(appData, taskID)
+	if task == nil {
+		return &apiError{Status: http.StatusNotFound, Message: fmt.Sprintf("task #%d not found", taskID)}
+	}
+	if task.Done || task.DueDate == nil {
+		return queryError("task #%d has no reminders to snooze", taskID)
+	}
+	if !until.After(time.Now()) {
+		return queryError("snooze until %s is not in the future", until.Format("2006-01-02 15:04"))
+	}
+	if i := findSnooze(appData, taskID, username); i >= 0 {
+		appData.Snoozes[i].Until = until
+		return nil
+	}
+	appData.Snoozes = append(appData.Snoozes, Snooze{TaskID: taskID, Username: username, Until: until})
+	return nil
+}
+
+func unsnoozeTask(appData *AppData, taskID int, username string) bool {
+	i := findSnooze(appData, taskID, username)
+	if i < 0 {
+		return false
+	}
+	appData.Snoozes = append(appData.Snoozes[:i], appData.Snoozes[i+1:]...)
+	return true
+}
+
+// parseSnooze reads how long to snooze: an offset such as "2h", or a date
+// such as "tomorrow 9am".
+func parseSnooze(s string, now time.Time) (time.Time, error) {
+	if d, err := parseReminderOffset(s); err == nil && d > 0 {
+		return now.Add(d), nil
+	}
+	t, err := parseDateAt(s, now)
+	if err != nil {
+		return time.Time{}, queryError("invalid snooze %q (use e.g. 2h or \"tomorrow 9am\")", s)
+	}
+	if !hasClockTime(*t) {
+		return reminderDueAt(*t), nil
+	}
+	return *t, nil
+}
+
+// collectReminders returns the reminders to send at now and records them
+// as sent. It also ends expired snoozes and forgets what belongs to tasks
+// that are done, gone or due at another time. changed reports whether
+// appData was modified.
+func collectReminders(appData *AppData, now time.Time) (notes []Notification, changed bool) {
+	sent := map[string]bool{}
+	key := func(taskID int, username string, dueAt time.Time, offset string) string {
+		return fmt.Sprintf("%d|%s|%d|%s", taskID, strings.ToLower(username), dueAt.Unix(), offset)
+	}
+	current := map[int]time.Time{}
+	for _, t := range appData.Tasks {
+		if !t.Done && t.DueDate != nil {
+			current[t.ID] = reminderDueAt(*t.DueDate)
+		}
+	}
+	kept := appData.SentReminders[:0]
+	for _, s := range appData.SentReminders {
+		if dueAt, ok := current[s.TaskID]; ok && dueAt.Equal(s.DueAt) {
+			sent[key(s.TaskID, s.Username, s.DueAt, s.Offset)] = true
+			kept = append(kept, s)
+		}
+	}
+	changed = len(kept) < len(appData.SentReminders)
+	appData.SentReminders = kept
+
+	snoozes := appData.Snoozes[:0]
+	for _, s := range appData.Snoozes {
+		if _, ok := current[s.TaskID]; ok {
+			snoozes = append(snoozes, s)
+		}
+	}
+	changed = changed || len(snoozes) < len(appData.Snoozes)
+	appData.Snoozes = snoozes
+
+	for _, t := range appData.Tasks {
+		dueAt, ok := current[t.ID]
+		if !ok {
+			continue
+		}
+		username := reminderRecipient(appData, t)
+		prefs := reminderPrefs(appData, username)
+		snoozed := false
+		if i := findSnooze(appData, t.ID, username); i >= 0 {
+			if now.Before(appData.Snoozes[i].Until) {
+				continue
+			}
+			unsnoozeTask(appData, t.ID, username)
+			snoozed, changed = true, true
+		}
+
+		// Mark every offset that has passed as sent, and send the latest.
+		var latest string
+		var latestAt time.Time
+		for _, offset := range prefs.Offsets {
+			d, err := parseReminderOffset(offset)
+			at := dueAt.Add(-d)
+			if err != nil || at.After(now) || sent[key(t.ID, username, dueAt, offset)] {
+				continue
+			}
+			appData.SentReminders = append(appData.SentReminders, SentReminder{
+				TaskID: t.ID, Username: username, DueAt: dueAt, Offset: offset, SentAt: now,
+			})
+			changed = true
+			if now.Sub(at) < reminderCatchUp {
+				latest, latestAt = offset, at
+			}
+		}
+		if prefs.Muted || latest == "" && !snoozed {
+			continue
+		}
+		if latest == "" {
+			latest, latestAt = "snoozed", now
+		}
+		n := newNotification(appData, t, dueAt, now, prefs)
+		n.Offset, n.At, n.Snoozed = latest, latestAt, snoozed
+		notes = append(notes, n)
+	}
+	return notes, changed
+}
+
+// upcomingReminders lists the user's reminders due before until.
+func upcomingReminders(appData *AppData, username string, now, until time.Time) []Notification {
+	prefs := reminderPrefs(appData, username)
+	notes := []Notification{}
+	for _, t := range appData.Tasks {
+		if t.Done || t.DueDate == nil || !strings.EqualFold(reminderRecipient(appData, t), username) {
+			continue
+		}
+		dueAt := reminderDueAt(*t.DueDate)
+		snoozedUntil := time.Time{}
+		if i := findSnooze(appData, t.ID, username); i >= 0 {
+			snoozedUntil = appData.Snoozes[i].Until
+			if snoozedUntil.Before(until) {
+				n := newNotification(appData, t, dueAt, snoozedUntil, prefs)
+				n.Offset, n.At, n.Snoozed = "snoozed", snoozedUntil, true
+				notes = append(notes, n)
+			}
+		}
+		for _, offset := range prefs.Offsets {
+			d, _ := parseReminderOffset(offset)
+			at := dueAt.Add(-d)
+			if at.After(now) && at.Before(until) && at.After(snoozedUntil) {
+				n := newNotification(appData, t, dueAt, at, prefs)
+				n.Offset, n.At = offset, at
+				notes = append(notes, n)
+			}
+		}
+	}
+	sort.SliceStable(notes, func(i, j int) bool { return notes[i].At.Before(notes[j].At) })
+	return notes
+}
+
+// remindersInfo collects a user's preferences, upcoming reminders and
+// snoozes for the next week.
+func remindersInfo(appData *AppData, username string, now time.Time) RemindersInfo {
+	info := RemindersInfo{
+		Prefs:    reminderPrefs(appData, username),
+		Upcoming: upcomingReminders(appData, username, now, now.AddDate(0, 0, 7)),
+		Snoozes:  []Snooze{},
+	}
+	for _, s := range appData.Snoozes {
+		if strings.EqualFold(s.Username, username) {
+			info.Snoozes = append(info.Snoozes, s)
+		}
+	}
+	return info
+}
+
+// newNotification words the reminder for a task as seen at the given time.
+func newNotification(appData *AppData, t Task, dueAt, at time.Time, prefs ReminderPrefs) Notification {
+	left := dueAt.Sub(at).Round(time.Minute)
+	var when string
+	switch {
+	case left == 0:
+		when = "is due now"
+	case left > 0:
+		when = "is due in " + formatSpan(left)
+	default:
+		when = "is " + formatSpan(-left) + " overdue"
+	}
+	details := []string{"due " + formatDue(*t.DueDate), "priority " + strings.ToLower(t.Priority.String())}
+	if project := findProject(appData, t.ProjectID, ""); project != nil {
+		details = append(details, "project "+project.Name)
+	}
+	return Notification{
+		Username: prefs.Username,
+		Title:    fmt.Sprintf("Task #%d %s", t.ID, when),
+		Message:  fmt.Sprintf("%s (%s)", t.Description, strings.Join(details, ", ")),
+		Task:     t,
+		DueAt:    dueAt,
+		prefs:    prefs,
+	}
+}
+
+// formatSpan writes a duration in its largest whole unit.
+func formatSpan(d time.Duration) string {
+	unit, n := "minute", int(d/time.Minute)
+	switch {
+	case d >= 7*24*time.Hour && d%(7*24*time.Hour) == 0:
+		unit, n = "week", int(d/(7*24*time.Hour))
+	case d >= 24*time.Hour:
+		unit, n = "day", int(d/(24*time.Hour))
+	case d >= time.Hour:
+		unit, n = "hour", int(d/time.Hour)
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
+
+// deliverReminders sends each reminder over the user's channels. Failures
+// are logged; the reminder counts as sent either way.
+func deliverReminders(notifiers map[string]Notifier, notes []Notification) {
+	for _, n := range notes {
+		for _, channel := range n.prefs.Channels {
+			notifier, ok := notifiers[channel]
+			if !ok {
+				log.Printf("reminder for task #%d: channel %s is not configured", n.Task.ID, channel)
+				continue
+			}
+			if err := notifier.Notify(n); err != nil {
+				log.Printf("reminder for task #%d via %s: %v", n.Task.ID, channel, err)
+			}
+		}
+	}
+}
+
+// checkReminders sends the reminders that are due and returns how many.
+func checkReminders(notifiers map[string]Notifier, now time.Time) (int, error) {
+	appData, err := loadAppData()
+	if err != nil {
+		return 0, err
+	}
+	if _, changed := collectReminders(appData, now); !changed {
+		return 0, nil
+	}
+	var notes []Notification
+	err = updateAppDataAs("system", func(appData *AppData) error {
+		notes, _ = collectReminders(appData, now)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	deliverReminders(notifiers, notes)
+	return len(notes), nil
+}
+
+// reminderLoop sends reminders while the server runs.
+func reminderLoop() {
+	notifiers := loadNotifiers()
+	for {
+		if _, err := checkReminders(notifiers, time.Now()); err != nil {
+			log.Printf("reminders: %v", err)
+		}
+		time.Sleep(reminderInterval)
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/peterh/liner"
+)
+
+// errIncompleteInput means the line ends inside a quote or with a trailing
+// backslash, and the REPL should read a continuation line.
+var errIncompleteInput = errors.New("incomplete input")
+
+// tokenize splits a command line roughly the way a shell does: whitespace
+// separates words, single quotes are literal, double quotes allow \" and \\,
+// and a backslash outside quotes escapes the next character. An unquoted
+// "--flag=value" becomes two words so every parser only has to handle
+// "--flag value".
+func tokenize(line string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune // 0, '\'' or '"'
+		escaped bool
+		split   bool // current word may still be split at its first '='
+	)
+
+	flush := func() {
+		if !inWord {
+			return
+		}
+		w := word.String()
+		if split && strings.HasPrefix(w, "--") {
+			if name, value, ok := strings.Cut(w, "="); ok {
+				words = append(words, name, value)
+				word.Reset()
+				inWord = false
+				return
+			}
+		}
+		words = append(words, w)
+		word.Reset()
+		inWord = false
+	}
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			// A backslash-newline is a line continuation and disappears.
+			if r != '\n' {
+				word.WriteRune(r)
+			}
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			if !inWord {
+				inWord, split = true, true
+			}
+			escaped = true
+		case r == '\'' || r == '"':
+			if !inWord {
+				// A word that starts quoted is taken literally.
+				inWord, split = true, false
+			} else if split && !strings.Contains(word.String(), "=") {
+				split = false
+			}
+			quote = r
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			flush()
+		default:
+			if !inWord {
+				inWord, split = true, true
+			}
+			word.WriteRune(r)
+		}
+	}
+
+	if escaped || quote != 0 {
+		return nil, errIncompleteInput
+	}
+	flush()
+	return words, nil
+}
+
+// lineReader is where the REPL gets its input. The terminal implementation
+// adds history and tab completion; the plain one reads any io.Reader so the
+// REPL can be driven from a pipe or a test.
+type lineReader interface {
+	ReadLine(prompt string) (string, error) // io.EOF when input ends
+	AddHistory(line string)
+	Close() error
+}
+
+type plainLineReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func newPlainLineReader(in io.Reader, out io.Writer) *plainLineReader {
+	return &plainLineReader{scanner: bufio.NewScanner(in), out: out}
+}
+
+func (r *plainLineReader) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+func (r *plainLineReader) AddHistory(line string) {}
+
+func (r *plainLineReader) Close() error {
+	return nil
+}
+
+// terminalLineReader wraps liner for arrow-key history, which is kept in
+// ~/.taskmanager_history, and tab completion.
+type terminalLineReader struct {
+	state       *liner.State
+	historyPath string
+}
+
+func newTerminalLineReader() *terminalLineReader {
+	state := liner.NewLiner()
+	state.SetCtrlCAborts(true)
+	state.SetCompleter(completeLine)
+
+	r := &terminalLineReader{state: state}
+	if home, err := os.UserHomeDir(); err == nil {
+		r.historyPath = filepath.Join(home, ".taskmanager_history")
+		if f, err := os.Open(r.historyPath); err == nil {
+			state.ReadHistory(f)
+			f.Close()
+		}
+	}
+	return r
+}
+
+func (r *terminalLineReader) ReadLine(prompt string) (string, error) {
+	// liner measures the prompt itself, so it cannot contain colour codes.
+	line, err := r.state.Prompt(stripANSI(prompt))
+	if errors.Is(err, liner.ErrPromptAborted) {
+		return "", nil
+	}
+	return line, err
+}
+
+func (r *terminalLineReader) AddHistory(line string) {
+	r.state.AppendHistory(line)
+}
+
+func (r *terminalLineReader) Close() error {
+	if r.historyPath != "" {
+		if f, err := os.Create(r.historyPath); err == nil {
+			r.state.WriteHistory(f)
+			f.Close()
+		}
+	}
+	return r.state.Close()
+}
+
+// newLineReader picks the terminal reader when stdin is a terminal and the
+// plain one otherwise.
+func newLineReader() lineReader {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 && liner.TerminalSupported() {
+		return newTerminalLineReader()
+	}
+	return newPlainLineReader(os.Stdin, os.Stdout)
+}
+
+func stripANSI(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\033' {
+			for i < len(s) && s[i] != 'm' {
+				i++
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// readCommand reads one logical command, asking for continuation lines
+// while a quote is open or a line ends in a backslash.
+func readCommand(r lineReader, prompt string) (string, []string, error) {
+	line, err := r.ReadLine(prompt)
+	if err != nil {
+		return "", nil, err
+	}
+	for {
+		words, err := tokenize(line)
+		if err != errIncompleteInput {
+			return line, words, err
+		}
+		more, err := r.ReadLine("... ")
+		if err != nil {
+			return "", nil, err
+		}
+		line += "\n" + more
+	}
+}
+
+var replCommands = []string{
+	"add", "create", "list", "view", "done", "undone", "delete", "priority", "due",
+	"search", "category", "stats", "projects", "timer", "checklist", "deps", "critical-path",
+	"activity", "members", "trash", "export", "import", "calendar", "remind", "webhook", "user",
+	"timesheet", "analytics", "undo", "redo", "history", "help", "clear", "quit", "exit",
+}
+
+// replAliases are shorthands the REPL accepts for runCommand arguments.
+var replAliases = map[string][]string{
+	"del":      {"delete"},
+	"category": {"list", "--category"},
+	"cat":      {"list", "--category"},
+}
+
+// replArgs turns the words of a REPL line into arguments for runCommand,
+// or nil if they do not name one of its commands. The REPL's own commands
+// (undo, history, help, ...) are handled before this.
+func replArgs(words []string) []string {
+	if alias, ok := replAliases[words[0]]; ok {
+		return append(append([]string(nil), alias...), words[1:]...)
+	}
+	if cliCommands[words[0]] {
+		return words
+	}
+	return nil
+}
+
+// completeLine is the terminal's completer; it reads the tasks from the
+// store when the word under the cursor needs them.
+func completeLine(line string) []string {
+	return completeWith(line, completionData)
+}
+
+// completeWith returns every full line that completes the word under the
+// cursor: command names, task IDs, categories, tags and priority levels.
+// data is only called for completions that need IDs, categories or tags.
+func completeWith(line string, data func() completions) []string {
+	words, err := tokenize(line)
+	if err != nil {
+		return nil
+	}
+	prefix := ""
+	if len(line) > 0 && !strings.ContainsRune(" \t", rune(line[len(line)-1])) && len(words) > 0 {
+		prefix = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+	head := line[:len(line)-len(prefix)]
+
+	var candidates []string
+	switch {
+	case len(words) == 0:
+		candidates = replCommands
+	case len(words) == 1 && taskIDCommands[words[0]]:
+		candidates = data().ids
+	case len(words) == 1 && (words[0] == "category" || words[0] == "cat"):
+		candidates = data().categories
+	case len(words) == 2 && words[0] == "priority":
+		candidates = []string{"low", "medium", "high", "urgent"}
+	default:
+		switch words[len(words)-1] {
+		case "--category", "-c":
+			candidates = data().categories
+		case "--priority", "-p":
+			candidates = []string{"low", "medium", "high", "urgent"}
+		case "--tags", "-t":
+			// Complete only the last tag of a comma-separated list.
+			done := ""
+			if i := strings.LastIndex(prefix, ","); i >= 0 {
+				done, prefix = prefix[:i+1], prefix[i+1:]
+				head += done
+			}
+			candidates = data().tags
+		}
+	}
+
+	var out []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			out = append(out, head+quoteWord(c)+" ")
+		}
+	}
+	return out
+}
+
+// quoteWord quotes s if tokenize would otherwise split or unescape it.
+func quoteWord(s string) string {
+	if !strings.ContainsAny(s, " \t'\"\\") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+var taskIDCommands = map[string]bool{
+	"view": true, "done": true, "undone": true, "delete": true, "del": true,
+	"priority": true, "due": true, "checklist": true, "deps": true,
+}
+
+type completions struct {
+	ids, categories, tags []string
+}
+
+func completionData() completions {
+	var c completions
+	tasks, err := loadTasks()
+	if err != nil {
+		return c
+	}
+	categories := map[string]bool{}
+	tags := map[string]bool{}
+	for _, t := range tasks {
+		c.ids = append(c.ids, strconv.Itoa(t.ID))
+		if t.Category != "" {
+			categories[t.Category] = true
+		}
+		for _, tag := range t.Tags {
+			tags[tag] = true
+		}
+	}
+	for cat := range categories {
+		c.categories = append(c.categories, cat)
+	}
+	for tag := range tags {
+		c.tags = append(c.tags, tag)
+	}
+	sort.Strings(c.categories)
+	sort.Strings(c.tags)
+	return c
+}
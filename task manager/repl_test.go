@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReplArgs(t *testing.T) {
+	tests := []struct {
+		words []string
+		want  []string
+	}{
+		{[]string{"add", "Buy milk", "--priority", "high"}, []string{"add", "Buy milk", "--priority", "high"}},
+		{[]string{"del", "3"}, []string{"delete", "3"}},
+		{[]string{"cat", "home"}, []string{"list", "--category", "home"}},
+		{[]string{"category", "home"}, []string{"list", "--category", "home"}},
+		{[]string{"timer", "status"}, []string{"timer", "status"}},
+		{[]string{"server"}, nil},
+		{[]string{"bogus", "1"}, nil},
+	}
+	for _, tt := range tests {
+		if got := replArgs(tt.words); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+			t.Errorf("replArgs(%q) = %q, want %q", tt.words, got, tt.want)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"   \t ", nil},
+		{"add Buy milk", []string{"add", "Buy", "milk"}},
+		{"  list   --status  todo ", []string{"list", "--status", "todo"}},
+		{`add "Buy milk"`, []string{"add", "Buy milk"}},
+		{`add 'Buy "oat" milk'`, []string{"add", `Buy "oat" milk`}},
+		{`add "say \"hi\" \\ bye"`, []string{"add", `say "hi" \ bye`}},
+		{`add 'no \n escapes'`, []string{"add", `no \n escapes`}},
+		{`add Buy\ milk`, []string{"add", "Buy milk"}},
+		{`add it\'s`, []string{"add", "it's"}},
+		{`add pre"quoted part"post`, []string{"add", "prequoted partpost"}},
+		{`add ""`, []string{"add", ""}},
+		{"add first\\\nsecond", []string{"add", "firstsecond"}},
+		{"create --desc=Ship --due=tomorrow", []string{"create", "--desc", "Ship", "--due", "tomorrow"}},
+		{`create --desc="Ship it" -p=high`, []string{"create", "--desc", "Ship it", "-p=high"}},
+		{`create "--desc=literal"`, []string{"create", "--desc=literal"}},
+		{"create --tags=a=b", []string{"create", "--tags", "a=b"}},
+		{"add 😀 café", []string{"add", "😀", "café"}},
+	}
+	for _, tt := range tests {
+		got, err := tokenize(tt.in)
+		if err != nil {
+			t.Errorf("tokenize(%q): %v", tt.in, err)
+			continue
+		}
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTokenizeIncomplete(t *testing.T) {
+	for _, in := range []string{
+		`add "Buy milk`,
+		`add 'Buy milk`,
+		`add Buy\`,
+		`add "ends in \"`,
+		`add "one" 'two`,
+	} {
+		if got, err := tokenize(in); err != errIncompleteInput {
+			t.Errorf("tokenize(%q) = %q, %v; want errIncompleteInput", in, got, err)
+		}
+	}
+}
+
+func TestCompleteWith(t *testing.T) {
+	loads := 0
+	data := func() completions {
+		loads++
+		return completions{
+			ids:        []string{"1", "12", "2"},
+			categories: []string{"home", "home office", "work"},
+			tags:       []string{"backend", "bug", "ui"},
+		}
+	}
+	var commands []string
+	for _, c := range replCommands {
+		commands = append(commands, c+" ")
+	}
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", commands},
+		{"de", []string{"delete ", "deps "}},
+		{"crit", []string{"critical-path "}},
+		{"xyz", nil},
+		{"view ", []string{"view 1 ", "view 12 ", "view 2 "}},
+		{"view 1", []string{"view 1 ", "view 12 "}},
+		{"done 2", []string{"done 2 "}},
+		{"priority 3 u", []string{"priority 3 urgent "}},
+		{"cat h", []string{"cat home ", `cat "home office" `}},
+		{"create --category w", []string{"create --category work "}},
+		{"create -p ", []string{"create -p low ", "create -p medium ", "create -p high ", "create -p urgent "}},
+		{"create --tags b", []string{"create --tags backend ", "create --tags bug "}},
+		{"create --tags ui,b", []string{"create --tags ui,backend ", "create --tags ui,bug "}},
+		{"add --desc ", nil},
+		{`add "open quote`, nil},
+	}
+	for _, tt := range tests {
+		got := completeWith(tt.line, data)
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+			t.Errorf("completeWith(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+
+	loads = 0
+	completeWith("sta", data)
+	if loads != 0 {
+		t.Errorf("completing a command loaded the tasks %d times", loads)
+	}
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The search index maps stemmed words to the tasks they occur in, per field.
+// It is kept in memory and brought up to date incrementally: a task is
+// re-indexed only when its Version (or identity) changed since it was last
+// seen, so the cost of a mutation is proportional to what it touched. Each
+// search also syncs against the data it is given, which picks up changes
+// made by another process sharing the data file.
+
+// Fields are weighted so a hit in the description outranks one in a comment.
+var searchFields = []struct {
+	name   string
+	weight float64
+}{
+	{"description", 3},
+	{"tags", 2.5},
+	{"category", 2},
+	{"comments", 1},
+}
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "in": true, "is": true, "it": true, "of": true, "on": true, "or": true,
+	"the": true, "this": true, "to": true, "with": true,
+}
+
+// SearchResult is one matching task with the parts of it that matched.
+type SearchResult struct {
+	Task     Task            `json:"task"`
+	Score    float64         `json:"score"`
+	Snippets []SearchSnippet `json:"snippets"`
+}
+
+// SearchSnippet is an excerpt of a matching field. Highlights are byte
+// ranges into Text; HTML is the same excerpt escaped with <mark> around them.
+type SearchSnippet struct {
+	Field      string   `json:"field"`
+	CommentID  int      `json:"comment_id,omitempty"`
+	Text       string   `json:"text"`
+	HTML       string   `json:"html"`
+	Highlights [][2]int `json:"highlights"`
+}
+
+// SearchResults is a page of ranked results.
+type SearchResults struct {
+	Query   string         `json:"query"`
+	Total   int            `json:"total"`
+	Offset  int            `json:"offset"`
+	Results []SearchResult `json:"results"`
+}
+
+type indexedDoc struct {
+	version int
+	created int64            // tells a re-created task apart from the old one with the same ID
+	counts  map[string][]int // term -> occurrences per searchFields entry
+	words   map[string]bool  // the words as written, before stemming
+}
+
+type searchIndex struct {
+	mu       sync.Mutex
+	built    bool
+	docs     map[int]*indexedDoc
+	postings map[string]map[int]bool // term -> task IDs
+	vocab    []string                // sorted terms, for prefix matches
+	forms    map[string]int          // word as written -> number of tasks using it
+	words    []string                // sorted forms, for prefixes longer than a stem
+	dirty    bool                    // vocab and words need rebuilding
+}
+
+// taskIndex is the process-wide index. It is built on the first search, so
+// one-shot CLI commands that never search do not pay for it.
+var taskIndex = newSearchIndex()
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		docs:     map[int]*indexedDoc{},
+		postings: map[string]map[int]bool{},
+		forms:    map[string]int{},
+	}
+}
+
+// stem reduces an English word to a crude common root so "deploying",
+// "deployed" and "deployment" all index as "deploy". It only has to be
+// consistent between indexing and searching, not linguistically right.
+func stem(w string) string {
+	if utf8.RuneCountInString(w) <= 3 {
+		return w
+	}
+	hasVowel := func(s string) bool { return strings.ContainsAny(s, "aeiouy") }
+
+	switch {
+	case strings.HasSuffix(w, "sses"):
+		w = w[:len(w)-2]
+	case strings.HasSuffix(w, "ies"):
+		w = w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss") && !strings.HasSuffix(w, "us") && !strings.HasSuffix(w, "is"):
+		w = w[:len(w)-1]
+	}
+
+	for _, suffix := range []string{"ingly", "edly", "ing", "ed"} {
+		if base := strings.TrimSuffix(w, suffix); base != w && len(base) >= 3 && hasVowel(base) {
+			w = base
+			// running -> run, but keep "ll", "ss", "zz" as in "filling"
+			if n := len(w); n >= 2 && w[n-1] == w[n-2] && !strings.ContainsRune("aeioulsz", rune(w[n-1])) {
+				w = w[:n-1]
+			}
+			break
+		}
+	}
+
+	for _, rule := range [][2]string{
+		{"ational", "ate"}, {"ation", "ate"}, {"ness", ""}, {"ment", ""}, {"ly", ""}, {"er", ""},
+	} {
+		if base := strings.TrimSuffix(w, rule[0]); base != w && len(base) >= 4 {
+			w = base + rule[1]
+			break
+		}
+	}
+
+	// Drop a final "e" so "update" and "updating" meet at "updat".
+	if len(w) > 4 && strings.HasSuffix(w, "e") {
+		w = w[:len(w)-1]
+	}
+	return w
+}
+
+// searchToken is a word in some text with its byte offsets.
+type searchToken struct {
+	word       string // lower-cased
+	start, end int
+}
+
+func tokenizeText(text string) []searchToken {
+	var tokens []searchToken
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, searchToken{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, searchToken{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// fieldTexts returns the searchable text of t, one entry per searchFields
+// entry (comments are handled separately so snippets can point at them).
+func fieldTexts(t Task) []string {
+	return []string{t.Description, strings.Join(t.Tags, " "), t.Category, ""}
+}
+
+func (idx *searchIndex) indexTask(t Task) {
+	doc := &indexedDoc{version: t.Version, created: t.CreatedAt.UnixNano(), counts: map[string][]int{}, words: map[string]bool{}}
+	add := func(field int, text string) {
+		for _, tok := range tokenizeText(text) {
+			if stopWords[tok.word] {
+				continue
+			}
+			doc.words[tok.word] = true
+			term := stem(tok.word)
+			if doc.counts[term] == nil {
+				doc.counts[term] = make([]int, len(searchFields))
+			}
+			doc.counts[term][field]++
+		}
+	}
+	for field, text := range fieldTexts(t) {
+		add(field, text)
+	}
+	for _, c := range t.Comments {
+		add(len(searchFields)-1, c.Text)
+	}
+
+	for term := range doc.counts {
+		if idx.postings[term] == nil {
+			idx.postings[term] = map[int]bool{}
+			idx.dirty = true
+		}
+		idx.postings[term][t.ID] = true
+	}
+	for word := range doc.words {
+		if idx.forms[word] == 0 {
+			idx.dirty = true
+		}
+		idx.forms[word]++
+	}
+	idx.docs[t.ID] = doc
+}
+
+func (idx *searchIndex) removeTask(id int) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for term := range doc.counts {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+			idx.dirty = true
+		}
+	}
+	for word := range doc.words {
+		if idx.forms[word]--; idx.forms[word] == 0 {
+			delete(idx.forms, word)
+			idx.dirty = true
+		}
+	}
+	delete(idx.docs, id)
+}
+
+// sync re-indexes tasks that changed since they were last indexed and
+// drops deleted ones. The caller holds idx.mu.
+func (idx *searchIndex) sync(appData *AppData) {
+	seen := make(map[int]bool, len(appData.Tasks))
+	for _, t := range appData.Tasks {
+		seen[t.ID] = true
+		if doc, ok := idx.docs[t.ID]; ok && doc.version == t.Version && doc.created == t.CreatedAt.UnixNano() {
+			continue
+		}
+		idx.removeTask(t.ID)
+		idx.indexTask(t)
+	}
+	for id := range idx.docs {
+		if !seen[id] {
+			idx.removeTask(id)
+		}
+	}
+	if idx.dirty {
+		idx.vocab = idx.vocab[:0]
+		for term := range idx.postings {
+			idx.vocab = append(idx.vocab, term)
+		}
+		sort.Strings(idx.vocab)
+		idx.words = idx.words[:0]
+		for word := range idx.forms {
+			idx.words = append(idx.words, word)
+		}
+		sort.Strings(idx.words)
+		idx.dirty = false
+	}
+	idx.built = true
+}
+
+// Update applies a mutation to an index that has already been built. It is
+// called after every successful updateAppData.
+func (idx *searchIndex) Update(appData *AppData) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.built {
+		idx.sync(appData)
+	}
+}
+
+// queryTerm is one word of a search with the indexed terms it matches.
+type queryTerm struct {
+	word    string
+	stemmed string
+	matches map[string]float64 // indexed term -> weight (1 exact, less for prefixes)
+}
+
+// expand finds the indexed terms for a query word: its stem exactly, and any
+// term starting with the word itself so "auth" finds "authentication".
+// Prefixes are also looked up among the words as written, since a prefix
+// can be longer than the stem: "monthl" is no prefix of "month", the stem
+// of "monthly".
+func (idx *searchIndex) expand(word string) queryTerm {
+	q := queryTerm{word: word, stemmed: stem(word), matches: map[string]float64{}}
+	if _, ok := idx.postings[q.stemmed]; ok {
+		q.matches[q.stemmed] = 1
+	}
+	for _, prefix := range []string{word, q.stemmed} {
+		i := sort.SearchStrings(idx.vocab, prefix)
+		for ; i < len(idx.vocab) && strings.HasPrefix(idx.vocab[i], prefix); i++ {
+			if _, ok := q.matches[idx.vocab[i]]; !ok {
+				q.matches[idx.vocab[i]] = 0.5
+			}
+		}
+	}
+	i := sort.SearchStrings(idx.words, word)
+	for ; i < len(idx.words) && strings.HasPrefix(idx.words[i], word); i++ {
+		if term := stem(idx.words[i]); q.matches[term] == 0 {
+			q.matches[term] = 0.5
+		}
+	}
+	return q
+}
+
+// Search ranks the tasks in appData that contain every word of text and
+// pass filter (nil for none), using TF-IDF with field weights.
+func (idx *searchIndex) Search(appData *AppData, text string, filter func(Task) bool) []SearchResult {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.sync(appData)
+
+	var terms []queryTerm
+	for _, tok := range tokenizeText(text) {
+		if stopWords[tok.word] {
+			continue
+		}
+		terms = append(terms, idx.expand(tok.word))
+	}
+	if len(terms) == 0 {
+		return []SearchResult{}
+	}
+
+	n := float64(len(idx.docs))
+	scores := map[int]float64{}
+	for i, term := range terms {
+		termScores := map[int]float64{}
+		for indexed, weight := range term.matches {
+			ids := idx.postings[indexed]
+			idf := math.Log(1 + n/float64(len(ids)))
+			for id := range ids {
+				s := 0.0
+				for field, count := range idx.docs[id].counts[indexed] {
+					if count > 0 {
+						s += searchFields[field].weight * (1 + math.Log(float64(count)))
+					}
+				}
+				if s *= weight * idf; s > termScores[id] {
+					termScores[id] = s // best of the term's expansions
+				}
+			}
+		}
+		// Every word has to match.
+		if i == 0 {
+			scores = termScores
+			continue
+		}
+		for id := range scores {
+			if s, ok := termScores[id]; ok {
+				scores[id] += s
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	results := []SearchResult{}
+	for _, t := range appData.Tasks {
+		score, ok := scores[t.ID]
+		if !ok || (filter != nil && !filter(t)) {
+			continue
+		}
+		results = append(results, SearchResult{
+			Task:     t,
+			Score:    math.Round(score*1000) / 1000,
+			Snippets: taskSnippets(t, terms),
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Task.ID < results[j].Task.ID
+	})
+	return results
+}
+
+// matchesTerm reports whether a word of the text counts as a hit for any
+// of the query terms, using the same rules as expand.
+func matchesTerm(word string, terms []queryTerm) bool {
+	s := stem(word)
+	for _, q := range terms {
+		if s == q.stemmed || strings.HasPrefix(s, q.word) || strings.HasPrefix(s, q.stemmed) || strings.HasPrefix(word, q.word) {
+			return true
+		}
+	}
+	return false
+}
+
+const snippetRadius = 40 // bytes of context either side of the first hit
+
+// taskSnippets returns a highlighted excerpt for each field of t that
+// contains a hit, one per matching comment.
+func taskSnippets(t Task, terms []queryTerm) []SearchSnippet {
+	snippets := []SearchSnippet{}
+	add := func(field string, commentID int, text string) {
+		if s, ok := makeSnippet(text, terms); ok {
+			s.Field, s.CommentID = field, commentID
+			snippets = append(snippets, s)
+		}
+	}
+	for field, text := range fieldTexts(t) {
+		add(searchFields[field].name, 0, text)
+	}
+	for _, c := range t.Comments {
+		add("comment", c.ID, c.Text)
+	}
+	return snippets
+}
+
+func makeSnippet(text string, terms []queryTerm) (SearchSnippet, bool) {
+	var hits []searchToken
+	for _, tok := range tokenizeText(text) {
+		if !stopWords[tok.word] && matchesTerm(tok.word, terms) {
+			hits = append(hits, tok)
+		}
+	}
+	if len(hits) == 0 {
+		return SearchSnippet{}, false
+	}
+
+	// Cut a window around the first hit, on word boundaries where possible.
+	start, end := hits[0].start-snippetRadius, hits[0].end+snippetRadius
+	if start <= 0 {
+		start = 0
+	} else if i := strings.IndexByte(text[start:hits[0].start], ' '); i >= 0 {
+		start += i + 1
+	}
+	if end >= len(text) {
+		end = len(text)
+	} else if i := strings.LastIndexByte(text[hits[0].end:end], ' '); i >= 0 {
+		end = hits[0].end + i
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	prefix, suffix := "", ""
+	if start > 0 {
+		prefix = "…"
+	}
+	if end < len(text) {
+		suffix = "…"
+	}
+
+	s := SearchSnippet{Text: prefix + text[start:end] + suffix, Highlights: [][2]int{}}
+	var b strings.Builder
+	b.WriteString(prefix)
+	pos := start
+	for _, h := range hits {
+		if h.start < start || h.end > end {
+			continue
+		}
+		offset := len(prefix) - start
+		s.Highlights = append(s.Highlights, [2]int{h.start + offset, h.end + offset})
+		b.WriteString(html.EscapeString(text[pos:h.start]))
+		b.WriteString("<mark>" + html.EscapeString(text[h.start:h.end]) + "</mark>")
+		pos = h.end
+	}
+	b.WriteString(html.EscapeString(text[pos:end]) + suffix)
+	s.HTML = b.String()
+	return s, true
+}
+
+// searchTasks runs a search made of free words, which go through the
+// index, and query-language terms such as status:todo, which filter the
+// results. visible, when not nil, hides tasks the caller may not see.
+// Results are paged by offset and limit (0 for all).
+func searchTasks(appData *AppData, terms []string, offset, limit int, user string, visible func(Task) bool) (SearchResults, error) {
+	var words, filters []string
+	for _, term := range terms {
+		if isFilterTerm(term) {
+			filters = append(filters, term)
+		} else {
+			words = append(words, term)
+		}
+	}
+	if len(words) == 0 {
+		return SearchResults{}, queryError("search needs at least one word to look for")
+	}
+	if offset < 0 || limit < 0 {
+		return SearchResults{}, queryError("offset and limit cannot be negative")
+	}
+	q, err := parseTaskQuery(appData, filters, time.Now(), user)
+	if err != nil {
+		return SearchResults{}, err
+	}
+
+	match := q.Match
+	if visible != nil {
+		match = func(t Task) bool { return visible(t) && q.Match(t) }
+	}
+	found := taskIndex.Search(appData, strings.Join(words, " "), match)
+	results := SearchResults{Query: strings.Join(terms, " "), Total: len(found), Offset: offset}
+	if offset > len(found) {
+		offset = len(found)
+	}
+	found = found[offset:]
+	if limit > 0 && len(found) > limit {
+		found = found[:limit]
+	}
+	results.Results = found
+	return results, nil
+}
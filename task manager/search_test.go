@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSearchPrefixes(t *testing.T) {
+	appData := &AppData{Tasks: []Task{
+		{ID: 1, Description: "Monthly report"},
+		{ID: 2, Description: "Fix the authentication flow"},
+		{ID: 3, Description: "Deploying the new build", Tags: []string{"release"}},
+	}}
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"monthly", []int{1}},
+		{"monthl", []int{1}}, // longer than the stem "month"
+		{"month", []int{1}},
+		{"auth", []int{2}},
+		{"authenticat", []int{2}},
+		{"deploym", nil},
+		{"deployin", []int{3}},
+		{"rel", []int{3}},
+	}
+	idx := newSearchIndex()
+	for _, tt := range tests {
+		var got []int
+		for _, r := range idx.Search(appData, tt.query, nil) {
+			got = append(got, r.Task.ID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("search %q = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	// Words of a removed task stop matching.
+	appData.Tasks = appData.Tasks[1:]
+	if got := idx.Search(appData, "monthl", nil); len(got) != 0 {
+		t.Errorf("search after removal = %+v", got)
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"sort"
@@ -20,6 +22,7 @@ type APIResponse struct {
 type CreateTaskRequest struct {
 	Description    string   `json:"description"`
 	ProjectID      int      `json:"project_id"`
+	ParentID       int      `json:"parent_id,omitempty"`
 	Category       string   `json:"category,omitempty"`
 	Priority       string   `json:"priority,omitempty"`
 	Status         string   `json:"status,omitempty"`
@@ -27,11 +30,14 @@ type CreateTaskRequest struct {
 	Tags           []string `json:"tags,omitempty"`
 	Assignee       string   `json:"assignee,omitempty"`
 	EstimatedHours float64  `json:"estimated_hours,omitempty"`
+	BlockedBy      []int    `json:"blocked_by,omitempty"`
+	Repeat         string   `json:"repeat,omitempty"` // e.g. "weekly on mon" or "FREQ=MONTHLY;BYMONTHDAY=1"
 }
 
 type UpdateTaskRequest struct {
 	Description    string   `json:"description,omitempty"`
 	ProjectID      int      `json:"project_id,omitempty"`
+	ParentID       *int     `json:"parent_id,omitempty"` // 0 makes the task top-level
 	Category       string   `json:"category,omitempty"`
 	Priority       string   `json:"priority,omitempty"`
 	Status         string   `json:"status,omitempty"`
@@ -40,23 +46,56 @@ type UpdateTaskRequest struct {
 	Assignee       string   `json:"assignee,omitempty"`
 	EstimatedHours float64  `json:"estimated_hours,omitempty"`
 	Position       int      `json:"position,omitempty"`
+	Force          bool     `json:"force,omitempty"`  // change status even if blockers are unfinished
+	Repeat         string   `json:"repeat,omitempty"` // a repeat rule, or "none" to stop repeating
 }
 
 type CreateProjectRequest struct {
 	Name        string `json:"name"`
 	Description string `json:"description,omitempty"`
 	Color       string `json:"color,omitempty"`
+
+	HourlyRate  *float64 `json:"hourly_rate,omitempty"`
+	Billable    *bool    `json:"billable,omitempty"`
+	DefaultRole *string  `json:"default_role,omitempty"` // of non-members; "none" for no access
 }
 
 type TimeTrackingRequest struct {
-	TaskID int    `json:"task_id"`
-	Note   string `json:"note,omitempty"`
+	TaskID   int    `json:"task_id"`
+	Note     string `json:"note,omitempty"`
+	AutoStop *bool  `json:"auto_stop,omitempty"` // stop the caller's running timer (default true)
 }
 
-func enableCORS(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+// enableCORS lets the origins in corsOrigins call the API from a browser.
+// Listed origins may send the session cookie; "*" allows any origin but
+// only with bearer tokens.
+func enableCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	allowed, credentials := false, false
+	for _, o := range corsOrigins {
+		if o == "*" {
+			allowed = true
+		} else if strings.EqualFold(o, origin) {
+			allowed, credentials = true, true
+			break
+		}
+	}
+	w.Header().Set("Vary", "Origin")
+	if !allowed {
+		return
+	}
+	if credentials {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, If-Match, Authorization")
+	w.Header().Set("Access-Control-Expose-Headers", "ETag")
 }
 
 func respondJSON(w http.ResponseWriter, status int, payload interface{}) {
@@ -71,14 +110,50 @@ func respondJSON(w http.ResponseWriter, status int, payload interface{}) {
 	w.Write(response)
 }
 
+// apiError lets code running inside updateAppData abort the update with a
+// specific HTTP status and message. Data, if set, is returned alongside.
+type apiError struct {
+	Status  int
+	Message string
+	Data    interface{}
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// respondError reports an error returned by updateAppData. Errors that are
+// not an *apiError are storage failures and get a 500 with fallback.
+func respondError(w http.ResponseWriter, err error, fallback string) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		respondJSON(w, apiErr.Status, APIResponse{
+			Success: false,
+			Message: apiErr.Message,
+			Data:    apiErr.Data,
+		})
+		return
+	}
+	respondJSON(w, http.StatusInternalServerError, APIResponse{
+		Success: false,
+		Message: fallback,
+	})
+}
+
 func handleGetTasks(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	tasks, err := loadTasks()
+	opts, err := taskQueryFromRequest(r)
+	if err != nil {
+		respondError(w, err, "Invalid query")
+		return
+	}
+
+	appData, err := loadAppData()
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
@@ -86,134 +161,117 @@ func handleGetTasks(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	appData = visibleData(r, appData)
+
+	page, err := findTasks(appData, opts)
+	if err != nil {
+		respondError(w, err, "Failed to load tasks")
+		return
+	}
 
 	respondJSON(w, http.StatusOK, APIResponse{
 		Success: true,
-		Data:    tasks,
+		Data:    page,
 	})
 }
 
-func handleCreateTask(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+// handleSearch serves GET /api/search?q=...&limit=&offset=: ranked
+// full-text results with highlighted snippets.
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	var req CreateTaskRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondJSON(w, http.StatusBadRequest, APIResponse{
-			Success: false,
-			Message: "Invalid request body",
-		})
+	opts, err := taskQueryFromRequest(r)
+	if err != nil {
+		respondError(w, err, "Invalid query")
 		return
 	}
+	if r.URL.Query().Get("limit") == "" {
+		opts.Limit = 20
+	}
 
-	if strings.TrimSpace(req.Description) == "" {
-		respondJSON(w, http.StatusBadRequest, APIResponse{
+	appData, err := loadAppData()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Message: "Description cannot be empty",
+			Message: "Failed to load tasks",
 		})
 		return
 	}
 
-	priority := Medium
-	if req.Priority != "" {
-		priority = parsePriority(req.Priority)
+	results, err := searchTasks(appData, opts.Query, opts.Offset, opts.Limit, opts.User, canSeeTask(r, appData))
+	if err != nil {
+		respondError(w, err, "Search failed")
+		return
 	}
 
-	status := StatusTodo
-	if req.Status != "" {
-		status = TaskStatus(req.Status)
-	}
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    results,
+	})
+}
 
-	var dueDate *time.Time
-	if req.DueDate != "" {
-		parsed, err := parseDate(req.DueDate)
-		if err != nil {
-			respondJSON(w, http.StatusBadRequest, APIResponse{
-				Success: false,
-				Message: "Invalid due date format",
-			})
-			return
-		}
-		dueDate = parsed
-	}
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
 
-	appData, err := loadAppData()
+// taskQueryFromRequest reads ?q=, ?sort=, ?limit= and ?offset= (or ?page=,
+// counted from 1) for GET /api/tasks.
+func taskQueryFromRequest(r *http.Request) (TaskQueryOptions, error) {
+	params := r.URL.Query()
+	user, _ := requestUser(r)
+	opts := TaskQueryOptions{Sort: params.Get("sort"), Limit: defaultPageSize, User: user.Username}
+
+	terms, err := tokenize(params.Get("q"))
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to load data",
-		})
-		return
+		return opts, &apiError{Status: http.StatusBadRequest, Message: "Invalid query: " + err.Error()}
 	}
+	opts.Query = terms
 
-	projectID := req.ProjectID
-	if projectID == 0 {
-		// Create or get default project
-		var defaultProject *Project
-		for i := range appData.Projects {
-			if appData.Projects[i].Name == "Default" {
-				defaultProject = &appData.Projects[i]
-				break
-			}
+	number := func(name string) (int, error) {
+		n, err := strconv.Atoi(params.Get(name))
+		if err != nil || n < 0 {
+			return 0, &apiError{Status: http.StatusBadRequest, Message: name + " must be a non-negative number"}
 		}
-		if defaultProject == nil {
-			newProject := Project{
-				ID:        nextProjectID(appData.Projects),
-				Name:      "Default",
-				Color:     "#6366f1",
-				CreatedAt: time.Now(),
-				UpdatedAt: time.Now(),
-			}
-			appData.Projects = append(appData.Projects, newProject)
-			defaultProject = &newProject
+		return n, nil
+	}
+	if params.Get("limit") != "" {
+		if opts.Limit, err = number("limit"); err != nil {
+			return opts, err
+		}
+		if opts.Limit == 0 || opts.Limit > maxPageSize {
+			opts.Limit = maxPageSize
 		}
-		projectID = defaultProject.ID
 	}
-
-	task := Task{
-		ID:             nextID(appData.Tasks),
-		ProjectID:      projectID,
-		Description:    req.Description,
-		Category:       req.Category,
-		Priority:       priority,
-		Status:         status,
-		DueDate:        dueDate,
-		Tags:           req.Tags,
-		Assignee:       req.Assignee,
-		EstimatedHours: req.EstimatedHours,
-		Done:           false,
-		CreatedAt:      time.Now(),
-		Position:       len(appData.Tasks),
+	if params.Get("offset") != "" {
+		if opts.Offset, err = number("offset"); err != nil {
+			return opts, err
+		}
 	}
-
-	appData.Tasks = append(appData.Tasks, task)
-	if err := saveAppData(appData); err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to save task",
-		})
-		return
+	if params.Get("page") != "" {
+		page, err := number("page")
+		if err != nil {
+			return opts, err
+		}
+		if page > 0 {
+			opts.Offset = (page - 1) * opts.Limit
+		}
 	}
-
-	respondJSON(w, http.StatusCreated, APIResponse{
-		Success: true,
-		Message: "Task created successfully",
-		Data:    task,
-	})
+	return opts, nil
 }
 
-func handleMarkDone(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+func handleGetTask(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
 	idStr := strings.TrimPrefix(r.URL.Path, "/api/tasks/")
-	idStr = strings.TrimSuffix(idStr, "/done")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		respondJSON(w, http.StatusBadRequest, APIResponse{
@@ -232,48 +290,112 @@ func handleMarkDone(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	found := false
-	now := time.Now()
-	for i := range tasks {
-		if tasks[i].ID == id {
-			tasks[i].Done = true
-			tasks[i].CompletedAt = &now
-			found = true
-			break
+	for _, t := range tasks {
+		if t.ID == id {
+			w.Header().Set("ETag", etag(t.Version))
+			respondJSON(w, http.StatusOK, APIResponse{
+				Success: true,
+				Data:    t,
+			})
+			return
 		}
 	}
 
-	if !found {
-		respondJSON(w, http.StatusNotFound, APIResponse{
-			Success: false,
-			Message: "Task not found",
-		})
+	respondJSON(w, http.StatusNotFound, APIResponse{
+		Success: false,
+		Message: "Task not found",
+	})
+}
+
+func handleCreateTask(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	if err := saveTasks(tasks); err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
+	var req CreateTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
 			Success: false,
-			Message: "Failed to save tasks",
+			Message: "Invalid request body",
 		})
 		return
 	}
 
-	respondJSON(w, http.StatusOK, APIResponse{
+	input, err := taskInputFromRequest(req)
+	if err != nil {
+		respondError(w, err, "Invalid request")
+		return
+	}
+
+	caller, _ := requestUser(r)
+	var task *Task
+	err = updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		var err error
+		if input.Assignee, err = newTaskAssignee(appData, input.Assignee, caller); err != nil {
+			return err
+		}
+		if err := checkBlockerAccess(r, appData, input.BlockedBy...); err != nil {
+			return err
+		}
+		task, err = createTask(appData, input)
+		return err
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save task")
+		return
+	}
+
+	w.Header().Set("ETag", etag(task.Version))
+	respondJSON(w, http.StatusCreated, APIResponse{
 		Success: true,
-		Message: "Task marked as done",
+		Message: "Task created successfully",
+		Data:    task,
 	})
 }
 
-func handleMarkUndone(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+// taskInputFromRequest converts a create request for createTask.
+func taskInputFromRequest(req CreateTaskRequest) (TaskInput, error) {
+	input := TaskInput{
+		Description:    req.Description,
+		ProjectID:      req.ProjectID,
+		ParentID:       req.ParentID,
+		Category:       req.Category,
+		Status:         req.Status,
+		Tags:           req.Tags,
+		Assignee:       req.Assignee,
+		EstimatedHours: req.EstimatedHours,
+		BlockedBy:      req.BlockedBy,
+	}
+	if req.Priority != "" {
+		priority := parsePriority(req.Priority)
+		input.Priority = &priority
+	}
+	if req.DueDate != "" {
+		parsed, err := parseDate(req.DueDate)
+		if err != nil {
+			return input, &apiError{Status: http.StatusBadRequest, Message: "Invalid due date " + err.Error()}
+		}
+		input.DueDate = parsed
+	}
+	rec, err := parseRecurrence(req.Repeat)
+	if err != nil {
+		return input, err
+	}
+	input.Recurrence = rec
+	return input, nil
+}
+
+func handleMarkDone(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
 	idStr := strings.TrimPrefix(r.URL.Path, "/api/tasks/")
-	idStr = strings.TrimSuffix(idStr, "/undone")
+	idStr = strings.TrimSuffix(idStr, "/done")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		respondJSON(w, http.StatusBadRequest, APIResponse{
@@ -283,49 +405,91 @@ func handleMarkUndone(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tasks, err := loadTasks()
+	var task, next *Task
+	err = updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		for i := range appData.Tasks {
+			if appData.Tasks[i].ID == id {
+				if err := checkIfMatch(r, appData.Tasks[i].Version, appData.Tasks[i]); err != nil {
+					return err
+				}
+				if err := checkBlockers(appData, appData.Tasks[i], StatusDone, r.URL.Query().Get("force") == "true"); err != nil {
+					return err
+				}
+				setTaskStatus(&appData.Tasks[i], StatusDone)
+				var err error
+				if next, err = spawnNextOccurrence(appData, id); err != nil {
+					return err
+				}
+				task = findTask(appData, id)
+				return nil
+			}
+		}
+		return &apiError{Status: http.StatusNotFound, Message: "Task not found"}
+	})
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to load tasks",
-		})
+		respondError(w, err, "Failed to save tasks")
 		return
 	}
 
-	found := false
-	for i := range tasks {
-		if tasks[i].ID == id {
-			tasks[i].Done = false
-			tasks[i].CompletedAt = nil
-			found = true
-			break
-		}
+	message := "Task marked as done"
+	if next != nil {
+		message = fmt.Sprintf("Task marked as done; next occurrence #%d is due %s", next.ID, formatDue(*next.DueDate))
 	}
+	w.Header().Set("ETag", etag(task.Version))
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: message,
+		Data:    task,
+	})
+}
 
-	if !found {
-		respondJSON(w, http.StatusNotFound, APIResponse{
-			Success: false,
-			Message: "Task not found",
-		})
+func handleMarkUndone(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	if err := saveTasks(tasks); err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/tasks/")
+	idStr = strings.TrimSuffix(idStr, "/undone")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
 			Success: false,
-			Message: "Failed to save tasks",
+			Message: "Invalid task ID",
 		})
 		return
 	}
 
+	var task *Task
+	err = updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		for i := range appData.Tasks {
+			if appData.Tasks[i].ID == id {
+				if err := checkIfMatch(r, appData.Tasks[i].Version, appData.Tasks[i]); err != nil {
+					return err
+				}
+				setTaskStatus(&appData.Tasks[i], StatusTodo)
+				task = &appData.Tasks[i]
+				return nil
+			}
+		}
+		return &apiError{Status: http.StatusNotFound, Message: "Task not found"}
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save tasks")
+		return
+	}
+
+	w.Header().Set("ETag", etag(task.Version))
 	respondJSON(w, http.StatusOK, APIResponse{
 		Success: true,
 		Message: "Task marked as undone",
+		Data:    task,
 	})
 }
 
 func handleDeleteTask(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
@@ -341,50 +505,33 @@ func handleDeleteTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	appData, err := loadAppData()
-	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to load data",
-		})
-		return
-	}
-
-	out := appData.Tasks[:0]
-	found := false
-	for _, t := range appData.Tasks {
-		if t.ID == id {
-			found = true
-			continue
+	var item *TrashItem
+	err = updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		task := findTask(appData, id)
+		if task == nil {
+			return &apiError{Status: http.StatusNotFound, Message: "Task not found"}
 		}
-		out = append(out, t)
-	}
-
-	if !found {
-		respondJSON(w, http.StatusNotFound, APIResponse{
-			Success: false,
-			Message: "Task not found",
-		})
-		return
-	}
-
-	appData.Tasks = out
-	if err := saveAppData(appData); err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to save data",
-		})
+		if err := checkIfMatch(r, task.Version, *task); err != nil {
+			return err
+		}
+		var err error
+		item, err = trashTask(appData, id, requestActor(r))
+		return err
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save data")
 		return
 	}
 
 	respondJSON(w, http.StatusOK, APIResponse{
 		Success: true,
-		Message: "Task deleted successfully",
+		Message: "Task moved to the trash",
+		Data:    item,
 	})
 }
 
 func handleUpdateTask(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
@@ -400,6 +547,7 @@ func handleUpdateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	caller, _ := requestUser(r)
 	var req UpdateTaskRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondJSON(w, http.StatusBadRequest, APIResponse{
@@ -409,26 +557,75 @@ func handleUpdateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	appData, err := loadAppData()
+	var dueDate *time.Time
+	clearDueDate := req.DueDate == "null" || req.DueDate == "clear"
+	if req.DueDate != "" && !clearDueDate {
+		parsed, err := parseDate(req.DueDate)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: "Invalid due date " + err.Error(),
+			})
+			return
+		}
+		dueDate = parsed
+	}
+
+	var status TaskStatus
+	if req.Status != "" {
+		if status, err = parseStatus(req.Status); err != nil {
+			respondError(w, err, "Invalid status")
+			return
+		}
+	}
+
+	tags, err := normalizeTags(req.Tags)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to load data",
-		})
+		respondError(w, err, "Invalid tags")
 		return
 	}
 
-	found := false
-	for i := range appData.Tasks {
-		if appData.Tasks[i].ID == id {
-			// Update description
-			if req.Description != "" {
-				appData.Tasks[i].Description = req.Description
-			}
+	var recurrence *Recurrence
+	clearRecurrence := req.Repeat == "none" || req.Repeat == "clear"
+	if !clearRecurrence {
+		if recurrence, err = parseRecurrence(req.Repeat); err != nil {
+			respondError(w, err, "Invalid repeat rule")
+			return
+		}
+	}
+
+	var task *Task
+	err = updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		for i := range appData.Tasks {
+			if appData.Tasks[i].ID != id {
+				continue
+			}
+			if err := checkIfMatch(r, appData.Tasks[i].Version, appData.Tasks[i]); err != nil {
+				return err
+			}
+
+			// Update description
+			if req.Description != "" {
+				appData.Tasks[i].Description = req.Description
+			}
 
 			// Update project
-			if req.ProjectID > 0 {
-				appData.Tasks[i].ProjectID = req.ProjectID
+			if req.ProjectID > 0 && req.ProjectID != appData.Tasks[i].ProjectID {
+				if findProject(appData, req.ProjectID, "") == nil {
+					return &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("project #%d not found", req.ProjectID)}
+				}
+				// Subtasks follow their parent into the new project.
+				moveSubtree(appData, id, req.ProjectID)
+			}
+
+			// Update parent
+			if req.ParentID != nil {
+				appData.Tasks[i].ParentID = *req.ParentID
+			}
+			if appData.Tasks[i].ParentID != 0 && (req.ParentID != nil || req.ProjectID > 0) {
+				if err := validateParent(appData, id, appData.Tasks[i].ParentID, appData.Tasks[i].ProjectID); err != nil {
+					return err
+				}
 			}
 
 			// Update category
@@ -441,14 +638,29 @@ func handleUpdateTask(w http.ResponseWriter, r *http.Request) {
 				appData.Tasks[i].Priority = parsePriority(req.Priority)
 			}
 
+			// Update recurrence
+			if clearRecurrence {
+				appData.Tasks[i].Recurrence = nil
+			} else if recurrence != nil {
+				appData.Tasks[i].Recurrence = recurrence
+			}
+
 			// Update status
-			if req.Status != "" {
-				appData.Tasks[i].Status = TaskStatus(req.Status)
+			if status != "" {
+				if err := checkBlockers(appData, appData.Tasks[i], status, req.Force); err != nil {
+					return err
+				}
+				setTaskStatus(&appData.Tasks[i], status)
 			}
 
 			// Update assignee
-			if req.Assignee != "" {
-				appData.Tasks[i].Assignee = req.Assignee
+			// An assignee from before accounts existed can be kept as it is.
+			if req.Assignee != "" && req.Assignee != appData.Tasks[i].Assignee {
+				assignee, err := resolveAssignee(appData, req.Assignee, caller)
+				if err != nil {
+					return err
+				}
+				appData.Tasks[i].Assignee = assignee
 			}
 
 			// Update estimated hours
@@ -462,56 +674,44 @@ func handleUpdateTask(w http.ResponseWriter, r *http.Request) {
 			}
 
 			// Update due date
-			if req.DueDate != "" {
-				if req.DueDate == "null" || req.DueDate == "clear" {
-					appData.Tasks[i].DueDate = nil
-				} else {
-					parsed, err := parseDate(req.DueDate)
-					if err != nil {
-						respondJSON(w, http.StatusBadRequest, APIResponse{
-							Success: false,
-							Message: "Invalid due date format",
-						})
-						return
-					}
-					appData.Tasks[i].DueDate = parsed
-				}
+			if clearDueDate {
+				appData.Tasks[i].DueDate = nil
+			} else if dueDate != nil {
+				appData.Tasks[i].DueDate = dueDate
 			}
 
 			// Update tags
-			if req.Tags != nil {
-				appData.Tasks[i].Tags = req.Tags
+			if tags != nil {
+				appData.Tasks[i].Tags = tags
 			}
 
-			found = true
-			break
-		}
-	}
-
-	if !found {
-		respondJSON(w, http.StatusNotFound, APIResponse{
-			Success: false,
-			Message: "Task not found",
-		})
-		return
-	}
+			// Finishing a recurring task spawns its next occurrence.
+			if status == StatusDone {
+				if _, err := spawnNextOccurrence(appData, id); err != nil {
+					return err
+				}
+			}
 
-	if err := saveAppData(appData); err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to save data",
-		})
+			task = findTask(appData, id)
+			return nil
+		}
+		return &apiError{Status: http.StatusNotFound, Message: "Task not found"}
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save data")
 		return
 	}
 
+	w.Header().Set("ETag", etag(task.Version))
 	respondJSON(w, http.StatusOK, APIResponse{
 		Success: true,
 		Message: "Task updated successfully",
+		Data:    task,
 	})
 }
 
 func handleGetStats(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
@@ -525,6 +725,7 @@ func handleGetStats(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	appData = visibleData(r, appData)
 
 	tasks := appData.Tasks
 	projects := appData.Projects
@@ -578,7 +779,7 @@ func handleGetStats(w http.ResponseWriter, r *http.Request) {
 
 // Project Handlers
 func handleGetProjects(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
@@ -592,6 +793,7 @@ func handleGetProjects(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	appData = visibleData(r, appData)
 
 	respondJSON(w, http.StatusOK, APIResponse{
 		Success: true,
@@ -599,8 +801,51 @@ func handleGetProjects(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func handleGetProject(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/projects/")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid project ID",
+		})
+		return
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Failed to load projects",
+		})
+		return
+	}
+
+	for _, p := range appData.Projects {
+		if p.ID == id {
+			w.Header().Set("ETag", etag(p.Version))
+			respondJSON(w, http.StatusOK, APIResponse{
+				Success: true,
+				Data:    p,
+			})
+			return
+		}
+	}
+
+	respondJSON(w, http.StatusNotFound, APIResponse{
+		Success: false,
+		Message: "Project not found",
+	})
+}
+
 func handleCreateProject(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
@@ -623,38 +868,49 @@ func handleCreateProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	appData, err := loadAppData()
-	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to load data",
-		})
-		return
-	}
-
 	color := req.Color
 	if color == "" {
 		color = "#6366f1"
 	}
-
-	project := Project{
-		ID:          nextProjectID(appData.Projects),
-		Name:        req.Name,
-		Description: req.Description,
-		Color:       color,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+	if req.HourlyRate != nil && *req.HourlyRate < 0 {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Hourly rate cannot be negative",
+		})
+		return
+	}
+	var defaultRole Role
+	if req.DefaultRole != nil {
+		var err error
+		if defaultRole, err = parseDefaultRole(*req.DefaultRole); err != nil {
+			respondError(w, err, "Invalid role")
+			return
+		}
 	}
 
-	appData.Projects = append(appData.Projects, project)
-	if err := saveAppData(appData); err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to save project",
+	caller, _ := requestUser(r)
+	var project *Project
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		appData.Projects = append(appData.Projects, Project{
+			ID:          newProjectID(appData),
+			Name:        req.Name,
+			Description: req.Description,
+			Color:       color,
+			Members:     []ProjectMember{{Username: caller.Username, Role: RoleOwner}},
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
 		})
+		project = &appData.Projects[len(appData.Projects)-1]
+		project.DefaultRole = defaultRole
+		setProjectBilling(project, req.HourlyRate, req.Billable)
+		return nil
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save project")
 		return
 	}
 
+	w.Header().Set("ETag", etag(project.Version))
 	respondJSON(w, http.StatusCreated, APIResponse{
 		Success: true,
 		Message: "Project created successfully",
@@ -663,7 +919,7 @@ func handleCreateProject(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleDeleteProject(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
@@ -679,60 +935,33 @@ func handleDeleteProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	appData, err := loadAppData()
-	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to load data",
-		})
-		return
-	}
-
-	// Remove project
-	out := appData.Projects[:0]
-	found := false
-	for _, p := range appData.Projects {
-		if p.ID == id {
-			found = true
-			continue
+	var item *TrashItem
+	err = updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		project := findProject(appData, id, "")
+		if project == nil {
+			return &apiError{Status: http.StatusNotFound, Message: "Project not found"}
 		}
-		out = append(out, p)
-	}
-
-	if !found {
-		respondJSON(w, http.StatusNotFound, APIResponse{
-			Success: false,
-			Message: "Project not found",
-		})
-		return
-	}
-
-	// Also remove tasks in this project
-	tasks := appData.Tasks[:0]
-	for _, t := range appData.Tasks {
-		if t.ProjectID != id {
-			tasks = append(tasks, t)
+		if err := checkIfMatch(r, project.Version, *project); err != nil {
+			return err
 		}
-	}
-
-	appData.Projects = out
-	appData.Tasks = tasks
-	if err := saveAppData(appData); err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to save data",
-		})
+		var err error
+		item, err = trashProject(appData, id, requestActor(r))
+		return err
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save data")
 		return
 	}
 
 	respondJSON(w, http.StatusOK, APIResponse{
 		Success: true,
-		Message: "Project deleted successfully",
+		Message: "Project and its tasks moved to the trash",
+		Data:    item,
 	})
 }
 
 func handleUpdateProject(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
@@ -764,55 +993,62 @@ func handleUpdateProject(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-
-	appData, err := loadAppData()
-	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
+	if req.HourlyRate != nil && *req.HourlyRate < 0 {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
 			Success: false,
-			Message: "Failed to load data",
+			Message: "Hourly rate cannot be negative",
 		})
 		return
 	}
-
-	// Find and update project
-	found := false
-	for i, p := range appData.Projects {
-		if p.ID == id {
-			appData.Projects[i].Name = req.Name
-			appData.Projects[i].Description = req.Description
-			if req.Color != "" {
-				appData.Projects[i].Color = req.Color
-			}
-			found = true
-			break
+	var defaultRole Role
+	if req.DefaultRole != nil {
+		var err error
+		if defaultRole, err = parseDefaultRole(*req.DefaultRole); err != nil {
+			respondError(w, err, "Invalid role")
+			return
 		}
 	}
 
-	if !found {
-		respondJSON(w, http.StatusNotFound, APIResponse{
-			Success: false,
-			Message: "Project not found",
-		})
-		return
-	}
-
-	if err := saveAppData(appData); err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to save data",
-		})
+	var project *Project
+	err = updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		// Find and update project
+		for i, p := range appData.Projects {
+			if p.ID == id {
+				if err := checkIfMatch(r, p.Version, p); err != nil {
+					return err
+				}
+				appData.Projects[i].Name = req.Name
+				appData.Projects[i].Description = req.Description
+				if req.Color != "" {
+					appData.Projects[i].Color = req.Color
+				}
+				setProjectBilling(&appData.Projects[i], req.HourlyRate, req.Billable)
+				if req.DefaultRole != nil {
+					appData.Projects[i].DefaultRole = defaultRole
+				}
+				appData.Projects[i].UpdatedAt = time.Now()
+				project = &appData.Projects[i]
+				return nil
+			}
+		}
+		return &apiError{Status: http.StatusNotFound, Message: "Project not found"}
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save data")
 		return
 	}
 
+	w.Header().Set("ETag", etag(project.Version))
 	respondJSON(w, http.StatusOK, APIResponse{
 		Success: true,
 		Message: "Project updated successfully",
+		Data:    project,
 	})
 }
 
 // Kanban Handlers
 func handleGetKanban(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
@@ -832,7 +1068,7 @@ func handleGetKanban(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	tasks, err := loadTasks()
+	appData, err := loadAppData()
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
@@ -840,6 +1076,9 @@ func handleGetKanban(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	appData = visibleData(r, appData)
+	tasks := appData.Tasks
+	tree := newTaskTree(appData)
 
 	// Filter by project if specified
 	if projectID > 0 {
@@ -853,7 +1092,7 @@ func handleGetKanban(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Group tasks by status
-	kanban := map[string][]Task{
+	kanban := map[string][]TaskView{
 		"backlog":     {},
 		"todo":        {},
 		"in_progress": {},
@@ -870,7 +1109,7 @@ func handleGetKanban(w http.ResponseWriter, r *http.Request) {
 				status = "todo"
 			}
 		}
-		kanban[status] = append(kanban[status], task)
+		kanban[status] = append(kanban[status], tree.View(task.ID))
 	}
 
 	// Sort by position within each column
@@ -887,7 +1126,7 @@ func handleGetKanban(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleMoveTask(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
@@ -897,6 +1136,7 @@ func handleMoveTask(w http.ResponseWriter, r *http.Request) {
 		TaskID    int    `json:"task_id"`
 		NewStatus string `json:"new_status"`
 		Position  int    `json:"position"`
+		Force     bool   `json:"force"` // move even if blockers are unfinished
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -907,59 +1147,52 @@ func handleMoveTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	appData, err := loadAppData()
+	status, err := parseStatus(req.NewStatus)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to load data",
-		})
+		respondError(w, err, "Invalid status")
 		return
 	}
 
 	// Update task status and position
-	found := false
-	for i := range appData.Tasks {
-		if appData.Tasks[i].ID == req.TaskID {
-			appData.Tasks[i].Status = TaskStatus(req.NewStatus)
-			appData.Tasks[i].Position = req.Position
-			if req.NewStatus == "done" {
-				appData.Tasks[i].Done = true
-				now := time.Now()
-				appData.Tasks[i].CompletedAt = &now
-			} else {
-				appData.Tasks[i].Done = false
-				appData.Tasks[i].CompletedAt = nil
+	var task *Task
+	err = updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		for i := range appData.Tasks {
+			if appData.Tasks[i].ID == req.TaskID {
+				if err := checkIfMatch(r, appData.Tasks[i].Version, appData.Tasks[i]); err != nil {
+					return err
+				}
+				if err := checkBlockers(appData, appData.Tasks[i], status, req.Force); err != nil {
+					return err
+				}
+				setTaskStatus(&appData.Tasks[i], status)
+				appData.Tasks[i].Position = req.Position
+				if status == StatusDone {
+					if _, err := spawnNextOccurrence(appData, req.TaskID); err != nil {
+						return err
+					}
+				}
+				task = findTask(appData, req.TaskID)
+				return nil
 			}
-			found = true
-			break
 		}
-	}
-
-	if !found {
-		respondJSON(w, http.StatusNotFound, APIResponse{
-			Success: false,
-			Message: "Task not found",
-		})
-		return
-	}
-
-	if err := saveAppData(appData); err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to save data",
-		})
+		return &apiError{Status: http.StatusNotFound, Message: "Task not found"}
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save data")
 		return
 	}
 
+	w.Header().Set("ETag", etag(task.Version))
 	respondJSON(w, http.StatusOK, APIResponse{
 		Success: true,
 		Message: "Task moved successfully",
+		Data:    task,
 	})
 }
 
 // Time Tracking Handlers
 func handleStartTimer(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
@@ -974,6 +1207,37 @@ func handleStartTimer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, _ := requestUser(r)
+	var timeEntry, stopped *TimeEntry
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		var err error
+		timeEntry, stopped, err = startTimerEntry(appData, req.TaskID, user.Username, req.Note, req.AutoStop == nil || *req.AutoStop)
+		return err
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save time entry")
+		return
+	}
+
+	message := "Timer started"
+	if stopped != nil {
+		message = fmt.Sprintf("Timer started; stopped timer #%d on task #%d", stopped.ID, stopped.TaskID)
+	}
+	respondJSON(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: message,
+		Data:    timeEntry,
+	})
+}
+
+// handleCurrentTimer returns the caller's running timer, or null.
+func handleCurrentTimer(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
 	appData, err := loadAppData()
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, APIResponse{
@@ -983,54 +1247,1955 @@ func handleStartTimer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if there's already an active timer
-	for _, entry := range appData.TimeEntries {
-		if entry.EndTime == nil {
-			respondJSON(w, http.StatusBadRequest, APIResponse{
-				Success: false,
-				Message: "There's already an active timer running",
-			})
-			return
+	user, _ := requestUser(r)
+	running := runningTimer(appData, user.Username)
+	if running == nil {
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Message: "No timer is running",
+		})
+		return
+	}
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    running,
+	})
+}
+
+// timerPathID resolves the entry of /api/time/{id}/..., where the id may be
+// "current" for the caller's running timer.
+func timerPathID(appData *AppData, path, user string) (int, error) {
+	idStr := strings.SplitN(strings.TrimPrefix(path, "/api/time/"), "/", 2)[0]
+	if idStr == "current" {
+		running := runningTimer(appData, user)
+		if running == nil {
+			return 0, &apiError{Status: http.StatusNotFound, Message: "No timer is running"}
+		}
+		return running.ID, nil
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return 0, &apiError{Status: http.StatusBadRequest, Message: "Invalid time entry ID"}
+	}
+	if e := findTimeEntry(appData, id); e != nil {
+		if err := checkTimeEntryOwner(appData, *e, user); err != nil {
+			return 0, err
 		}
 	}
+	return id, nil
+}
 
-	timeEntry := TimeEntry{
-		ID:        nextTimeEntryID(appData.TimeEntries),
-		TaskID:    req.TaskID,
-		StartTime: time.Now(),
-		Note:      req.Note,
+// handleStopTimer, handlePauseTimer and handleResumeTimer also take
+// "current" as the ID, for the caller's running timer.
+func handleStopTimer(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
 	}
 
-	appData.TimeEntries = append(appData.TimeEntries, timeEntry)
-	if err := saveAppData(appData); err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to save time entry",
-		})
+	user, _ := requestUser(r)
+	var entry *TimeEntry
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		id, err := timerPathID(appData, r.URL.Path, user.Username)
+		if err != nil {
+			return err
+		}
+		entry, err = stopTimerEntry(appData, id)
+		return err
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save data")
 		return
 	}
 
-	respondJSON(w, http.StatusCreated, APIResponse{
+	respondJSON(w, http.StatusOK, APIResponse{
 		Success: true,
-		Message: "Timer started",
-		Data:    timeEntry,
+		Message: "Timer stopped",
+		Data:    entry,
 	})
 }
 
-func handleStopTimer(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+func handlePauseTimer(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	user, _ := requestUser(r)
+	resume := strings.HasSuffix(r.URL.Path, "/resume")
+	var entry *TimeEntry
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		id, err := timerPathID(appData, r.URL.Path, user.Username)
+		if err != nil {
+			return err
+		}
+		if resume {
+			entry, err = resumeTimerEntry(appData, id)
+		} else {
+			entry, err = pauseTimerEntry(appData, id)
+		}
+		return err
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save data")
+		return
+	}
+
+	message := "Timer paused"
+	if resume {
+		message = "Timer resumed"
+	}
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: message,
+		Data:    entry,
+	})
+}
+
+// handleAddTimeEntry records time tracked without a timer, for the caller.
+func handleAddTimeEntry(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var entry TimeEntry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	user, _ := requestUser(r)
+	entry.User = user.Username
+	var added *TimeEntry
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		var err error
+		added, err = addTimeEntry(appData, entry)
+		return err
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save time entry")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Time entry added",
+		Data:    added,
+	})
+}
+
+func handleEditTimeEntry(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var patch TimeEntryPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	user, _ := requestUser(r)
+	var entry *TimeEntry
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		id, err := timerPathID(appData, r.URL.Path, user.Username)
+		if err != nil {
+			return err
+		}
+		entry, err = editTimeEntry(appData, id, patch)
+		return err
+	})
+	if err != nil {
+		respondError(w, err, "Failed to update time entry")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Time entry updated",
+		Data:    entry,
+	})
+}
+
+// handleSplitTimeEntry cuts an entry in two at {"at": time}.
+func handleSplitTimeEntry(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var body struct {
+		At time.Time `json:"at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.At.IsZero() {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: `Expected {"at": "<RFC 3339 time>"}`,
+		})
+		return
+	}
+
+	user, _ := requestUser(r)
+	var parts []TimeEntry
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		id, err := timerPathID(appData, r.URL.Path, user.Username)
+		if err != nil {
+			return err
+		}
+		first, second, err := splitTimeEntry(appData, id, body.At)
+		parts = []TimeEntry{first, second}
+		return err
+	})
+	if err != nil {
+		respondError(w, err, "Failed to split time entry")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Split into #%d and #%d", parts[0].ID, parts[1].ID),
+		Data:    parts,
+	})
+}
+
+func handleDeleteTimeEntry(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	user, _ := requestUser(r)
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		id, err := timerPathID(appData, r.URL.Path, user.Username)
+		if err != nil {
+			return err
+		}
+		if !deleteTimeEntry(appData, id) {
+			return &apiError{Status: http.StatusNotFound, Message: "Time entry not found"}
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(w, err, "Failed to delete time entry")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Time entry deleted",
+	})
+}
+
+func handleGetTimeEntries(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	taskIDStr := r.URL.Query().Get("task_id")
+	var taskID int
+	if taskIDStr != "" {
+		var err error
+		taskID, err = strconv.Atoi(taskIDStr)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: "Invalid task ID",
+			})
+			return
+		}
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Failed to load data",
+		})
+		return
+	}
+	appData = visibleData(r, appData)
+
+	entries := appData.TimeEntries
+	if taskID > 0 {
+		filtered := []TimeEntry{}
+		for _, e := range entries {
+			if e.TaskID == taskID {
+				filtered = append(filtered, e)
+			}
+		}
+		entries = filtered
+	}
+
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    entries,
+	})
+}
+
+// Reports Handler
+func handleGetReports(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Failed to load data",
+		})
+		return
+	}
+	appData = visibleData(r, appData)
+
+	// Calculate various metrics
+	totalTasks := len(appData.Tasks)
+	completedTasks := 0
+	totalEstimatedHours := 0.0
+	totalTrackedSeconds := 0
+	tasksByProject := make(map[string]int)
+	tasksByStatus := make(map[string]int)
+	tasksByPriority := make(map[string]int)
+	overdueTasks := 0
+
+	for _, task := range appData.Tasks {
+		if task.Done {
+			completedTasks++
+		}
+		totalEstimatedHours += task.EstimatedHours
+
+		// Count by status
+		status := string(task.Status)
+		if status == "" {
+			status = "todo"
+		}
+		tasksByStatus[status]++
+
+		// Count by priority
+		tasksByPriority[strings.ToLower(task.Priority.String())]++
+
+		// Check overdue
+		if !task.Done && task.DueDate != nil && task.DueDate.Before(time.Now()) {
+			overdueTasks++
+		}
+
+		// Find project name
+		for _, proj := range appData.Projects {
+			if proj.ID == task.ProjectID {
+				tasksByProject[proj.Name]++
+				break
+			}
+		}
+	}
+
+	// Calculate time tracking stats
+	for _, entry := range appData.TimeEntries {
+		totalTrackedSeconds += entry.Duration
+	}
+
+	// Roll subtasks up into their top-level parent: estimates and tracked time
+	// of the whole tree, and how much of the estimate is already done.
+	tree := newTaskTree(appData)
+	rollups := []map[string]interface{}{}
+	for _, task := range appData.Tasks {
+		if _, isChild := tree.byID[task.ParentID]; isChild || len(tree.children[task.ID]) == 0 {
+			continue
+		}
+		progress := tree.Progress(task.ID)
+		rollups = append(rollups, map[string]interface{}{
+			"task_id":              task.ID,
+			"description":          task.Description,
+			"subtasks_total":       progress.SubtasksTotal,
+			"subtasks_done":        progress.SubtasksDone,
+			"percent":              progress.Percent,
+			"estimated_hours":      progress.EstimatedHours,
+			"done_estimated_hours": progress.DoneEstimateHours,
+			"tracked_hours":        progress.TrackedHours,
+		})
+	}
+
+	completionRate := 0.0
+	if totalTasks > 0 {
+		completionRate = float64(completedTasks) / float64(totalTasks) * 100
+	}
+
+	report := map[string]interface{}{
+		"summary": map[string]interface{}{
+			"total_tasks":           totalTasks,
+			"completed_tasks":       completedTasks,
+			"completion_rate":       completionRate,
+			"overdue_tasks":         overdueTasks,
+			"total_estimated_hours": totalEstimatedHours,
+			"total_tracked_hours":   float64(totalTrackedSeconds) / 3600,
+			"total_projects":        len(appData.Projects),
+		},
+		"by_project":  tasksByProject,
+		"by_status":   tasksByStatus,
+		"by_priority": tasksByPriority,
+		"subtasks":    rollups,
+	}
+
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    report,
+	})
+}
+
+// handleAnalytics serves GET /api/reports/analytics: estimate accuracy,
+// weekly throughput, cycle and lead time of the tasks completed between
+// ?from= and ?to=, optionally of one ?project_id= (or ?project=) or
+// ?assignee= (me for the caller).
+func handleAnalytics(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Failed to load data",
+		})
+		return
+	}
+	appData = visibleData(r, appData)
+
+	query := r.URL.Query()
+	values := map[string]string{
+		"from":     query.Get("from"),
+		"to":       query.Get("to"),
+		"project":  query.Get("project"),
+		"assignee": query.Get("assignee"),
+	}
+	if v := query.Get("project_id"); v != "" {
+		values["project"] = v
+	}
+	if strings.EqualFold(values["assignee"], "me") {
+		user, _ := requestUser(r)
+		values["assignee"] = user.Username
+	}
+	opts, err := parseAnalyticsOptions(appData, values, time.Now())
+	if err != nil {
+		respondError(w, err, "Invalid query")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    buildAnalytics(appData, opts),
+	})
+}
+
+// handleTimesheet serves GET /api/reports/timesheet: tracked time between
+// ?from= and ?to= grouped by ?group=day,week,user,project,task, with
+// ?round=15m&rounding=up|down|nearest, ?project_id= (or ?project=), ?user=
+// (me for the caller) and ?billable=true|false. ?format=csv or html returns
+// a file instead of JSON; html is an invoice of the billable rows, headed
+// with ?client= and ?number=.
+func handleTimesheet(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	query := r.URL.Query()
+	format := "json"
+	if v := query.Get("format"); v != "" {
+		var err error
+		if format, err = parseTimesheetFormat(v); err != nil {
+			respondError(w, err, "Invalid format")
+			return
+		}
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Failed to load data",
+		})
+		return
+	}
+	appData = visibleData(r, appData)
+
+	values := map[string]string{}
+	for _, name := range []string{"from", "to", "group", "round", "rounding", "project", "user", "billable"} {
+		values[name] = query.Get(name)
+	}
+	if v := query.Get("project_id"); v != "" {
+		values["project"] = v
+	}
+	if strings.EqualFold(values["user"], "me") {
+		user, _ := requestUser(r)
+		values["user"] = user.Username
+	}
+	opts, err := parseTimesheetOptions(appData, values, time.Now())
+	if err != nil {
+		respondError(w, err, "Invalid query")
+		return
+	}
+	sheet := buildTimesheet(appData, opts)
+	if format == "json" {
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    sheet,
+		})
+		return
+	}
+
+	content, err := renderTimesheet(sheet, format, query.Get("client"), query.Get("number"))
+	if err != nil {
+		respondError(w, err, "Failed to render timesheet")
+		return
+	}
+	name := fmt.Sprintf("timesheet-%s-%s", sheet.From.Format("2006-01-02"), sheet.Until().Format("2006-01-02"))
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.csv\"", name))
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
+
+// Comment Handlers
+func handleGetComments(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	taskIDStr := r.URL.Query().Get("task_id")
+	if taskIDStr == "" {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "task_id is required",
+		})
+		return
+	}
+
+	taskID, err := strconv.Atoi(taskIDStr)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid task ID",
+		})
+		return
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Failed to load data",
+		})
+		return
+	}
+
+	// Find task and return its comments
+	for _, task := range appData.Tasks {
+		if task.ID == taskID {
+			comments := task.Comments
+			if comments == nil {
+				comments = []Comment{}
+			}
+			respondJSON(w, http.StatusOK, APIResponse{
+				Success: true,
+				Data:    comments,
+			})
+			return
+		}
+	}
+
+	respondJSON(w, http.StatusNotFound, APIResponse{
+		Success: false,
+		Message: "Task not found",
+	})
+}
+
+func handleAddComment(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var req struct {
+		TaskID int    `json:"task_id"`
+		Text   string `json:"text"`
+	}
+	user, _ := requestUser(r)
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	if req.TaskID == 0 || req.Text == "" {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "task_id and text are required",
+		})
+		return
+	}
+
+	// Find task and add comment
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		for i, task := range appData.Tasks {
+			if task.ID == req.TaskID {
+				// Generate comment ID
+				commentID := 1
+				for _, c := range task.Comments {
+					if c.ID >= commentID {
+						commentID = c.ID + 1
+					}
+				}
+
+				comment := Comment{
+					ID:        commentID,
+					TaskID:    req.TaskID,
+					Author:    user.Username,
+					Text:      req.Text,
+					CreatedAt: time.Now(),
+				}
+				appData.Tasks[i].Comments = append(appData.Tasks[i].Comments, comment)
+				return nil
+			}
+		}
+		return &apiError{Status: http.StatusNotFound, Message: "Task not found"}
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save data")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Comment added successfully",
+	})
+}
+
+// Subtask and Checklist Handlers
+
+// parseTaskSubpath splits "/api/tasks/{id}/{section}[/{item}]" into the
+// task ID and the item ID (0 when absent).
+func parseTaskSubpath(path, section string) (int, int, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, "/api/tasks/"), "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[1] != section {
+		return 0, 0, errors.New("invalid path")
+	}
+	taskID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, errors.New("invalid task ID")
+	}
+	itemID := 0
+	if len(parts) == 3 {
+		if itemID, err = strconv.Atoi(parts[2]); err != nil {
+			return 0, 0, errors.New("invalid item ID")
+		}
+	}
+	return taskID, itemID, nil
+}
+
+func handleGetSubtasks(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id, _, err := parseTaskSubpath(r.URL.Path, "subtasks")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid task ID",
+		})
+		return
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Failed to load tasks",
+		})
+		return
+	}
+
+	tree := newTaskTree(appData)
+	if _, ok := tree.byID[id]; !ok {
+		respondJSON(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Task not found",
+		})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    tree.Detail(id),
+	})
+}
+
+func handleCreateSubtask(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	parentID, _, err := parseTaskSubpath(r.URL.Path, "subtasks")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid task ID",
+		})
+		return
+	}
+
+	var req CreateTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		})
+		return
+	}
+	req.ParentID = parentID
+
+	input, err := taskInputFromRequest(req)
+	if err != nil {
+		respondError(w, err, "Invalid request")
+		return
+	}
+
+	caller, _ := requestUser(r)
+	var task *Task
+	err = updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		if findTask(appData, parentID) == nil {
+			return &apiError{Status: http.StatusNotFound, Message: "Task not found"}
+		}
+		var err error
+		if input.Assignee, err = newTaskAssignee(appData, input.Assignee, caller); err != nil {
+			return err
+		}
+		if err := checkBlockerAccess(r, appData, input.BlockedBy...); err != nil {
+			return err
+		}
+		task, err = createTask(appData, input)
+		return err
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save task")
+		return
+	}
+
+	w.Header().Set("ETag", etag(task.Version))
+	respondJSON(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Subtask created successfully",
+		Data:    task,
+	})
+}
+
+func handleGetChecklist(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id, _, err := parseTaskSubpath(r.URL.Path, "checklist")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid task ID",
+		})
+		return
+	}
+
+	tasks, err := loadTasks()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Failed to load tasks",
+		})
+		return
+	}
+
+	for _, t := range tasks {
+		if t.ID == id {
+			items := t.Checklist
+			if items == nil {
+				items = []ChecklistItem{}
+			}
+			w.Header().Set("ETag", etag(t.Version))
+			respondJSON(w, http.StatusOK, APIResponse{
+				Success: true,
+				Data:    items,
+			})
+			return
+		}
+	}
+
+	respondJSON(w, http.StatusNotFound, APIResponse{
+		Success: false,
+		Message: "Task not found",
+	})
+}
+
+// handleChangeChecklist serves POST /api/tasks/{id}/checklist (add an item)
+// and PUT or DELETE /api/tasks/{id}/checklist/{item}. Checklist items are
+// part of their task, so If-Match is checked against the task's version.
+func handleChangeChecklist(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	taskID, itemID, err := parseTaskSubpath(r.URL.Path, "checklist")
+	if err != nil || (r.Method == "POST") != (itemID == 0) {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid checklist path",
+		})
+		return
+	}
+
+	var req struct {
+		Text *string `json:"text"`
+		Done *bool   `json:"done"`
+	}
+	if r.Method != "DELETE" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: "Invalid request body",
+			})
+			return
+		}
+	}
+
+	var task *Task
+	var item ChecklistItem
+	err = updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		task = findTask(appData, taskID)
+		if task == nil {
+			return &apiError{Status: http.StatusNotFound, Message: "Task not found"}
+		}
+		if err := checkIfMatch(r, task.Version, *task); err != nil {
+			return err
+		}
+
+		switch r.Method {
+		case "POST":
+			text := ""
+			if req.Text != nil {
+				text = *req.Text
+			}
+			added, err := addChecklistItem(task, text)
+			if err != nil {
+				return err
+			}
+			if req.Done != nil {
+				task.Checklist[len(task.Checklist)-1].Done = *req.Done
+				added.Done = *req.Done
+			}
+			item = added
+		case "PUT":
+			found, err := findChecklistItem(task, itemID)
+			if err != nil {
+				return err
+			}
+			if req.Text != nil {
+				text := strings.TrimSpace(*req.Text)
+				if text == "" {
+					return &apiError{Status: http.StatusBadRequest, Message: "checklist text cannot be empty"}
+				}
+				found.Text = text
+			}
+			if req.Done != nil {
+				found.Done = *req.Done
+			}
+			item = *found
+		case "DELETE":
+			return removeChecklistItem(task, itemID)
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save data")
+		return
+	}
+
+	w.Header().Set("ETag", etag(task.Version))
+	switch r.Method {
+	case "POST":
+		respondJSON(w, http.StatusCreated, APIResponse{
+			Success: true,
+			Message: "Checklist item added",
+			Data:    item,
+		})
+	case "PUT":
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Message: "Checklist item updated",
+			Data:    item,
+		})
+	default:
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Message: "Checklist item deleted",
+		})
+	}
+}
+
+// Dependency Handlers
+
+func handleGetDependencies(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id, _, err := parseTaskSubpath(r.URL.Path, "dependencies")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid task ID",
+		})
+		return
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Failed to load tasks",
+		})
+		return
+	}
+
+	graph, err := dependencyGraph(appData, id, canSeeTask(r, appData))
+	if err != nil {
+		respondError(w, err, "Failed to build dependency graph")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    graph,
+	})
+}
+
+// handleChangeDependency serves POST /api/tasks/{id}/dependencies with
+// {"blocked_by": n} and DELETE /api/tasks/{id}/dependencies/{n}.
+func handleChangeDependency(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	taskID, blockerID, err := parseTaskSubpath(r.URL.Path, "dependencies")
+	if err != nil || (r.Method == "POST") != (blockerID == 0) {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid dependency path",
+		})
+		return
+	}
+
+	if r.Method == "POST" {
+		var req struct {
+			BlockedBy int `json:"blocked_by"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.BlockedBy == 0 {
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: "blocked_by is required",
+			})
+			return
+		}
+		blockerID = req.BlockedBy
+	}
+
+	var graph DependencyGraph
+	err = updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		task := findTask(appData, taskID)
+		if task == nil {
+			return &apiError{Status: http.StatusNotFound, Message: "Task not found"}
+		}
+		if err := checkIfMatch(r, task.Version, *task); err != nil {
+			return err
+		}
+		var err error
+		if r.Method == "POST" {
+			if err := checkBlockerAccess(r, appData, blockerID); err != nil {
+				return err
+			}
+			err = addDependency(appData, taskID, blockerID)
+		} else {
+			err = removeDependency(appData, taskID, blockerID)
+		}
+		if err != nil {
+			return err
+		}
+		graph, err = dependencyGraph(appData, taskID, canSeeTask(r, appData))
+		return err
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save data")
+		return
+	}
+
+	message := "Dependency added"
+	if r.Method == "DELETE" {
+		message = "Dependency removed"
+	}
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: message,
+		Data:    graph,
+	})
+}
+
+func handleGetCriticalPath(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/projects/")
+	idStr = strings.TrimSuffix(idStr, "/critical-path")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid project ID",
+		})
+		return
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Failed to load data",
+		})
+		return
+	}
+
+	if findProject(appData, id, "") == nil {
+		respondJSON(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Project not found",
+		})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    criticalPath(appData, id),
+	})
+}
+
+// Auth Handlers
+
+type credentialsRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Name     string `json:"name"`
+}
+
+// sessionResponse is returned on login and registration. The token is for
+// clients that send a bearer header; browsers get the cookie too.
+type sessionResponse struct {
+	User      UserInfo  `json:"user"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func handleRegister(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var req credentialsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	var resp sessionResponse
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		if !signupOpen(appData) {
+			return &apiError{Status: http.StatusForbidden, Message: "Registration is closed; ask an administrator to create your account"}
+		}
+		user, err := createUser(appData, req.Username, req.Name, req.Password)
+		if err != nil {
+			return err
+		}
+		resp.User = user.Info()
+		token, session, err := newSession(appData, user.ID)
+		resp.Token, resp.ExpiresAt = token, session.ExpiresAt
+		return err
+	})
+	if err != nil {
+		respondError(w, err, "Failed to create account")
+		return
+	}
+
+	setSessionCookie(w, r, resp.Token, resp.ExpiresAt)
+	respondJSON(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Account created",
+		Data:    resp,
+	})
+}
+
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var req credentialsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	// The password is checked without holding the store's write lock; the
+	// update only starts the session, and fails if the account was removed
+	// or its password changed in between.
+	appData, err := loadAppData()
+	if err != nil {
+		respondError(w, err, "Failed to sign in")
+		return
+	}
+	checked, err := checkPassword(appData, req.Username, req.Password)
+	if err != nil {
+		respondError(w, err, "Failed to sign in")
+		return
+	}
+
+	var resp sessionResponse
+	err = updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		user := findUserByID(appData, checked.ID)
+		if user == nil || user.PasswordHash != checked.PasswordHash {
+			return &apiError{Status: http.StatusUnauthorized, Message: "wrong username or password"}
+		}
+		resp.User = user.Info()
+		token, session, err := newSession(appData, user.ID)
+		resp.Token, resp.ExpiresAt = token, session.ExpiresAt
+		return err
+	})
+	if err != nil {
+		respondError(w, err, "Failed to sign in")
+		return
+	}
+
+	setSessionCookie(w, r, resp.Token, resp.ExpiresAt)
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Signed in",
+		Data:    resp,
+	})
+}
+
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if token := requestToken(r); token != "" {
+		err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+			endSession(appData, token)
+			return nil
+		})
+		if err != nil {
+			respondError(w, err, "Failed to sign out")
+			return
+		}
+	}
+
+	setSessionCookie(w, r, "", time.Unix(0, 0))
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Signed out",
+	})
+}
+
+func handleMe(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	user, _ := requestUser(r)
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    user.Info(),
+	})
+}
+
+// handleGetUsers lists accounts, for picking assignees.
+func handleGetUsers(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Failed to load users",
+		})
+		return
+	}
+
+	users := []UserInfo{}
+	for _, u := range appData.Users {
+		users = append(users, u.Info())
+	}
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    users,
+	})
+}
+
+// Import/Export Handlers
+
+// maxImportSize caps the body of POST /api/import.
+const maxImportSize = 10 << 20
+
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	format, err := parseExportFormat(format)
+	if err != nil {
+		respondError(w, err, "Invalid format")
+		return
+	}
+	projectID := 0
+	if v := r.URL.Query().Get("project_id"); v != "" {
+		if projectID, err = strconv.Atoi(v); err != nil {
+			respondError(w, queryError("project_id must be a number"), "Invalid query")
+			return
+		}
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Failed to load data",
+		})
+		return
+	}
+	data, err := collectExport(visibleData(r, appData), projectID)
+	if err != nil {
+		respondError(w, err, "Failed to export")
+		return
+	}
+	content, err := renderExport(data, format)
+	if err != nil {
+		respondError(w, err, "Failed to export")
+		return
+	}
+
+	contentType, ext := exportContentType(format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"tasks-%s.%s\"", data.ExportedAt.Format("2006-01-02"), ext))
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
+
+// handleImport adds the tasks in the request body, given in the format
+// named by ?format=. With ?dry_run=true it only reports what would happen.
+// Tasks can only go into projects the caller may edit; projects the import
+// creates are owned by the caller.
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	query := r.URL.Query()
+	format, err := parseExportFormat(query.Get("format"))
+	if err != nil {
+		respondError(w, err, "Invalid format")
+		return
+	}
+	opts := ImportOptions{DryRun: query.Get("dry_run") == "true" || query.Get("dry_run") == "1"}
+	if v := query.Get("project_id"); v != "" {
+		if opts.ProjectID, err = strconv.Atoi(v); err != nil {
+			respondError(w, queryError("project_id must be a number"), "Invalid query")
+			return
+		}
+	}
+	user, _ := requestUser(r)
+	opts.Owner = user.Username
+
+	content, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		respondJSON(w, http.StatusRequestEntityTooLarge, APIResponse{
+			Success: false,
+			Message: "Import is too large",
+		})
+		return
+	}
+	data, err := parseImport(content, format)
+	if err != nil {
+		respondError(w, err, "Invalid import")
+		return
+	}
+
+	var report ImportReport
+	run := func(appData *AppData) error {
+		var err error
+		if report, err = importData(appData, data, opts); err != nil {
+			return err
+		}
+		for _, p := range report.Projects {
+			if !p.Created {
+				if err := checkProjectRole(appData, p.ID, user.Username, RoleEditor); err != nil {
+					return err
+				}
+			}
+		}
+		if opts.ProjectID != 0 {
+			return checkProjectRole(appData, opts.ProjectID, user.Username, RoleEditor)
+		}
+		return nil
+	}
+	if opts.DryRun {
+		var appData *AppData
+		if appData, err = loadAppData(); err == nil {
+			err = run(appData)
+		}
+	} else {
+		err = updateAppDataAs(requestActor(r), run)
+	}
+	if err != nil {
+		respondError(w, err, "Failed to import")
+		return
+	}
+
+	message := fmt.Sprintf("Imported %d task(s)", report.Tasks)
+	if opts.DryRun {
+		message = fmt.Sprintf("Dry run: %d task(s) would be imported", report.Tasks)
+	}
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: message,
+		Data:    report,
+	})
+}
+
+// Calendar Handlers
+
+// handleCalendar serves the iCalendar feed. Query: token (for calendar
+// apps), project_id, assignee ("me" for the caller) and kind=event|todo.
+func handleCalendar(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	query := r.URL.Query()
+	opts := CalendarOptions{Name: "Tasks", Assignee: strings.TrimSpace(query.Get("assignee"))}
+	switch query.Get("kind") {
+	case "", "event":
+	case "todo":
+		opts.Todo = true
+	default:
+		respondError(w, queryError("kind must be event or todo"), "Invalid query")
+		return
+	}
+	if v := query.Get("project_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			respondError(w, queryError("project_id must be a number"), "Invalid query")
+			return
+		}
+		opts.ProjectID = id
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Failed to load data",
+		})
+		return
+	}
+	user, _ := requestUser(r)
+	if strings.EqualFold(opts.Assignee, "me") {
+		opts.Assignee = user.Username
+	}
+	if opts.ProjectID != 0 {
+		project := findProject(appData, opts.ProjectID, "")
+		if project == nil {
+			respondJSON(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Message: "Project not found",
+			})
+			return
+		}
+		opts.Name = project.Name
+	}
+	if opts.Assignee != "" {
+		opts.Name += " – " + opts.Assignee
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="tasks.ics"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, renderCalendar(visibleData(r, appData), opts))
+}
+
+// handleCreateCalendarToken gives the caller a new calendar token and the
+// feed URL to subscribe to; any earlier token stops working.
+func handleCreateCalendarToken(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	user, _ := requestUser(r)
+	var token string
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		var err error
+		token, err = newCalendarToken(appData, user.ID)
+		return err
+	})
+	if err != nil {
+		respondError(w, err, "Failed to create calendar token")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Calendar token created; subscribe to the URL, it will not be shown again",
+		Data: map[string]string{
+			"token": token,
+			"url":   calendarURL(r, token),
+		},
+	})
+}
+
+func handleRevokeCalendarToken(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	user, _ := requestUser(r)
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		if !revokeCalendarToken(appData, user.ID) {
+			return &apiError{Status: http.StatusNotFound, Message: "No calendar token to revoke"}
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(w, err, "Failed to revoke calendar token")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Calendar token revoked",
+	})
+}
+
+// Reminder Handlers
+func handleGetReminders(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Failed to load data",
+		})
+		return
+	}
+
+	user, _ := requestUser(r)
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    remindersInfo(visibleData(r, appData), user.Username, time.Now()),
+	})
+}
+
+func handleUpdateReminders(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var prefs ReminderPrefs
+	if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	user, _ := requestUser(r)
+	prefs.Username = user.Username
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		return setReminderPrefs(appData, prefs)
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save reminder preferences")
+		return
+	}
+
+	appData, _ := loadAppData()
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Reminder preferences saved",
+		Data:    reminderPrefs(appData, user.Username),
+	})
+}
+
+// handleSnoozeTask snoozes (POST, {"until": "2h"}) or unsnoozes (DELETE)
+// the caller's reminders for a task. Reminders of tasks without an
+// account to go to can be snoozed by anyone who sees the task.
+func handleSnoozeTask(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var body struct {
+		Until string `json:"until"`
+	}
+	if r.Method == "POST" {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Until == "" {
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: `Expected {"until": "2h"} or a date`,
+			})
+			return
+		}
+	}
+
+	id := pathID(r.URL.Path, "/api/tasks/")
+	user, _ := requestUser(r)
+	var snooze Snooze
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		task := findTask(appData, id)
+		if task == nil {
+			return &apiError{Status: http.StatusNotFound, Message: "Task not found"}
+		}
+		recipient := reminderRecipient(appData, *task)
+		if recipient != "" && !strings.EqualFold(recipient, user.Username) {
+			return &apiError{Status: http.StatusForbidden, Message: fmt.Sprintf("Reminders for task #%d go to %s", id, recipient)}
+		}
+		if r.Method == "DELETE" {
+			if !unsnoozeTask(appData, id, recipient) {
+				return &apiError{Status: http.StatusNotFound, Message: "Task is not snoozed"}
+			}
+			return nil
+		}
+		until, err := parseSnooze(body.Until, time.Now())
+		if err != nil {
+			return err
+		}
+		if err := snoozeTask(appData, id, recipient, until); err != nil {
+			return err
+		}
+		snooze = appData.Snoozes[findSnooze(appData, id, recipient)]
+		return nil
+	})
+	if err != nil {
+		respondError(w, err, "Failed to snooze task")
+		return
+	}
+
+	if r.Method == "DELETE" {
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Message: "Snooze removed",
+		})
+		return
+	}
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Reminders snoozed until " + snooze.Until.Format("2006-01-02 15:04"),
+		Data:    snooze,
+	})
+}
+
+// Webhook Handlers
+func handleGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Failed to load data",
+		})
+		return
+	}
+
+	projectID, _ := strconv.Atoi(r.URL.Query().Get("project_id"))
+	user, _ := requestUser(r)
+	hooks := []Webhook{}
+	for _, h := range appData.Webhooks {
+		if (projectID == 0 || h.ProjectID == projectID) &&
+			checkProjectRole(appData, h.ProjectID, user.Username, RoleOwner) == nil {
+			hooks = append(hooks, h.Public())
+		}
+	}
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    hooks,
+	})
+}
+
+// handleCreateWebhook registers a webhook. The secret for checking
+// signatures is only returned here.
+func handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var hook Webhook
+	if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	hook.CreatedBy = requestActor(r)
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		var err error
+		hook, err = addWebhook(appData, hook)
+		return err
+	})
+	if err != nil {
+		respondError(w, err, "Failed to create webhook")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Webhook created; keep the secret, it will not be shown again",
+		Data:    hook,
+	})
+}
+
+func handleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var body struct {
+		URL    *string  `json:"url"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	id := pathID(r.URL.Path, "/api/webhooks/")
+	var hook Webhook
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		h := findWebhook(appData, id)
+		if h == nil {
+			return &apiError{Status: http.StatusNotFound, Message: "Webhook not found"}
+		}
+		updated := *h
+		if body.URL != nil {
+			updated.URL = *body.URL
+		}
+		if body.Events != nil {
+			updated.Events = body.Events
+		}
+		if body.Active != nil {
+			updated.Active = *body.Active
+		}
+		if err := validateWebhook(&updated); err != nil {
+			return err
+		}
+		*h = updated
+		hook = updated.Public()
+		return nil
+	})
+	if err != nil {
+		respondError(w, err, "Failed to update webhook")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Webhook updated",
+		Data:    hook,
+	})
+}
+
+func handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id := pathID(r.URL.Path, "/api/webhooks/")
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		if !removeWebhook(appData, id) {
+			return &apiError{Status: http.StatusNotFound, Message: "Webhook not found"}
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(w, err, "Failed to delete webhook")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Webhook deleted",
+	})
+}
+
+// handleGetWebhookDeliveries returns the delivery log, newest first.
+func handleGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Failed to load data",
+		})
+		return
+	}
+
+	id := pathID(r.URL.Path, "/api/webhooks/")
+	if findWebhook(appData, id) == nil {
+		respondJSON(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Webhook not found",
+		})
+		return
+	}
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    webhookDeliveries(appData, id),
+	})
+}
+
+// handleRedeliverWebhook queues POST /api/webhooks/{id}/deliveries/{n}/redeliver.
+func handleRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id := pathID(r.URL.Path, "/api/webhooks/")
+	deliveryID := pathID(r.URL.Path, fmt.Sprintf("/api/webhooks/%d/deliveries/", id))
+	var delivery WebhookDelivery
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		d, err := redeliver(appData, id, deliveryID)
+		if err != nil {
+			return err
+		}
+		delivery = *d
+		return nil
+	})
+	if err != nil {
+		respondError(w, err, "Failed to redeliver")
+		return
+	}
+
+	respondJSON(w, http.StatusAccepted, APIResponse{
+		Success: true,
+		Message: "Delivery queued",
+		Data:    delivery,
+	})
+}
+
+// Trash Handlers
+func handleGetTrash(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	appData, err := loadAppData()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Failed to load data",
+		})
+		return
+	}
+
+	user, _ := requestUser(r)
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    visibleTrash(appData, user.Username),
+	})
+}
+
+func handleRestoreTrash(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id := pathID(r.URL.Path, "/api/trash/")
+	var item TrashItem
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		var err error
+		item, err = restoreTrashItem(appData, id)
+		return err
+	})
+	if err != nil {
+		respondError(w, err, "Failed to restore")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Restored %s %q", item.Kind, item.Title),
+		Data:    item,
+	})
+}
+
+// handlePurgeTrash deletes a trash item for good.
+func handlePurgeTrash(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id := pathID(r.URL.Path, "/api/trash/")
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		if !removeTrashItem(appData, id) {
+			return &apiError{Status: http.StatusNotFound, Message: fmt.Sprintf("trash item %d not found", id)}
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save data")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Deleted permanently",
+	})
+}
+
+// Activity Handlers
+
+// handleGetTaskHistory serves GET /api/tasks/{id}/history, oldest first.
+func handleGetTaskHistory(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	idStr := strings.TrimPrefix(r.URL.Path, "/api/time/")
-	idStr = strings.TrimSuffix(idStr, "/stop")
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
+	id := pathID(r.URL.Path, "/api/tasks/")
+	if id == 0 {
 		respondJSON(w, http.StatusBadRequest, APIResponse{
 			Success: false,
-			Message: "Invalid time entry ID",
+			Message: "Invalid task ID",
 		})
 		return
 	}
@@ -1044,57 +3209,45 @@ func handleStopTimer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	found := false
-	now := time.Now()
-	for i := range appData.TimeEntries {
-		if appData.TimeEntries[i].ID == id {
-			appData.TimeEntries[i].EndTime = &now
-			duration := int(now.Sub(appData.TimeEntries[i].StartTime).Seconds())
-			appData.TimeEntries[i].Duration = duration
-			found = true
-			break
-		}
-	}
-
-	if !found {
+	history := taskHistory(appData, id)
+	if len(history) == 0 && findTask(appData, id) == nil {
 		respondJSON(w, http.StatusNotFound, APIResponse{
 			Success: false,
-			Message: "Time entry not found",
-		})
-		return
-	}
-
-	if err := saveAppData(appData); err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to save data",
+			Message: "Task not found",
 		})
 		return
 	}
 
 	respondJSON(w, http.StatusOK, APIResponse{
 		Success: true,
-		Message: "Timer stopped",
+		Data:    history,
 	})
 }
 
-func handleGetTimeEntries(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+// handleGetProjectActivity serves GET /api/projects/{id}/activity, the
+// project's feed newest first, paged with ?limit= (default 50) and ?offset=.
+func handleGetProjectActivity(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	taskIDStr := r.URL.Query().Get("task_id")
-	var taskID int
-	if taskIDStr != "" {
-		var err error
-		taskID, err = strconv.Atoi(taskIDStr)
-		if err != nil {
-			respondJSON(w, http.StatusBadRequest, APIResponse{
-				Success: false,
-				Message: "Invalid task ID",
-			})
+	id, err := projectFromPath(r)
+	if err != nil {
+		respondError(w, err, "Invalid project ID")
+		return
+	}
+	limit, offset := 50, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			respondError(w, queryError("limit must be a non-negative number"), "Invalid query")
+			return
+		}
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			respondError(w, queryError("offset must be a non-negative number"), "Invalid query")
 			return
 		}
 	}
@@ -1108,193 +3261,153 @@ func handleGetTimeEntries(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	entries := appData.TimeEntries
-	if taskID > 0 {
-		filtered := []TimeEntry{}
-		for _, e := range entries {
-			if e.TaskID == taskID {
-				filtered = append(filtered, e)
-			}
-		}
-		entries = filtered
-	}
-
 	respondJSON(w, http.StatusOK, APIResponse{
 		Success: true,
-		Data:    entries,
+		Data:    projectActivity(appData, id, offset, limit),
 	})
 }
 
-// Reports Handler
-func handleGetReports(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+// Membership Handlers
+
+// projectFromPath reads the project ID from /api/projects/{id}/...
+func projectFromPath(r *http.Request) (int, error) {
+	id := pathID(r.URL.Path, "/api/projects/")
+	if id == 0 {
+		return 0, &apiError{Status: http.StatusBadRequest, Message: "Invalid project ID"}
+	}
+	return id, nil
+}
+
+func handleGetMembers(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
+	id, err := projectFromPath(r)
+	if err != nil {
+		respondError(w, err, "Invalid project ID")
+		return
+	}
+
 	appData, err := loadAppData()
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Message: "Failed to load data",
+			Message: "Failed to load projects",
 		})
 		return
 	}
 
-	// Calculate various metrics
-	totalTasks := len(appData.Tasks)
-	completedTasks := 0
-	totalEstimatedHours := 0.0
-	totalTrackedSeconds := 0
-	tasksByProject := make(map[string]int)
-	tasksByStatus := make(map[string]int)
-	tasksByPriority := make(map[string]int)
-	overdueTasks := 0
-
-	for _, task := range appData.Tasks {
-		if task.Done {
-			completedTasks++
-		}
-		totalEstimatedHours += task.EstimatedHours
-
-		// Count by status
-		status := string(task.Status)
-		if status == "" {
-			status = "todo"
-		}
-		tasksByStatus[status]++
-
-		// Count by priority
-		tasksByPriority[strings.ToLower(task.Priority.String())]++
-
-		// Check overdue
-		if !task.Done && task.DueDate != nil && task.DueDate.Before(time.Now()) {
-			overdueTasks++
-		}
-
-		// Find project name
-		for _, proj := range appData.Projects {
-			if proj.ID == task.ProjectID {
-				tasksByProject[proj.Name]++
-				break
-			}
-		}
-	}
-
-	// Calculate time tracking stats
-	for _, entry := range appData.TimeEntries {
-		totalTrackedSeconds += entry.Duration
-	}
-
-	completionRate := 0.0
-	if totalTasks > 0 {
-		completionRate = float64(completedTasks) / float64(totalTasks) * 100
+	project := findProject(appData, id, "")
+	if project == nil {
+		respondJSON(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Project not found",
+		})
+		return
 	}
 
-	report := map[string]interface{}{
-		"summary": map[string]interface{}{
-			"total_tasks":           totalTasks,
-			"completed_tasks":       completedTasks,
-			"completion_rate":       completionRate,
-			"overdue_tasks":         overdueTasks,
-			"total_estimated_hours": totalEstimatedHours,
-			"total_tracked_hours":   float64(totalTrackedSeconds) / 3600,
-			"total_projects":        len(appData.Projects),
-		},
-		"by_project":  tasksByProject,
-		"by_status":   tasksByStatus,
-		"by_priority": tasksByPriority,
+	members := project.Members
+	if members == nil {
+		members = []ProjectMember{}
 	}
-
 	respondJSON(w, http.StatusOK, APIResponse{
 		Success: true,
-		Data:    report,
+		Data:    members,
 	})
 }
 
-// Comment Handlers
-func handleGetComments(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+// handleSetMember adds a member to a project or changes their role.
+func handleSetMember(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	taskIDStr := r.URL.Query().Get("task_id")
-	if taskIDStr == "" {
-		respondJSON(w, http.StatusBadRequest, APIResponse{
-			Success: false,
-			Message: "task_id is required",
-		})
+	id, err := projectFromPath(r)
+	if err != nil {
+		respondError(w, err, "Invalid project ID")
 		return
 	}
 
-	taskID, err := strconv.Atoi(taskIDStr)
-	if err != nil {
+	var req ProjectMember
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondJSON(w, http.StatusBadRequest, APIResponse{
 			Success: false,
-			Message: "Invalid task ID",
+			Message: "Invalid request body",
 		})
 		return
 	}
-
-	appData, err := loadAppData()
+	role, err := parseRole(string(req.Role))
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to load data",
-		})
+		respondError(w, err, "Invalid role")
 		return
 	}
 
-	// Find task and return its comments
-	for _, task := range appData.Tasks {
-		if task.ID == taskID {
-			comments := task.Comments
-			if comments == nil {
-				comments = []Comment{}
-			}
-			respondJSON(w, http.StatusOK, APIResponse{
-				Success: true,
-				Data:    comments,
-			})
-			return
+	var members []ProjectMember
+	err = updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		project := findProject(appData, id, "")
+		if project == nil {
+			return &apiError{Status: http.StatusNotFound, Message: "Project not found"}
+		}
+		if err := setProjectMember(appData, project, req.Username, role); err != nil {
+			return err
 		}
+		members = project.Members
+		return nil
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save data")
+		return
 	}
 
-	respondJSON(w, http.StatusNotFound, APIResponse{
-		Success: false,
-		Message: "Task not found",
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("%s is now %s of the project", req.Username, role),
+		Data:    members,
 	})
 }
 
-func handleAddComment(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+func handleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	var req struct {
-		TaskID int    `json:"task_id"`
-		Author string `json:"author"`
-		Text   string `json:"text"`
+	id, err := projectFromPath(r)
+	if err != nil {
+		respondError(w, err, "Invalid project ID")
+		return
 	}
+	username := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondJSON(w, http.StatusBadRequest, APIResponse{
-			Success: false,
-			Message: "Invalid request body",
-		})
+	err = updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		project := findProject(appData, id, "")
+		if project == nil {
+			return &apiError{Status: http.StatusNotFound, Message: "Project not found"}
+		}
+		return removeProjectMember(project, username)
+	})
+	if err != nil {
+		respondError(w, err, "Failed to save data")
 		return
 	}
 
-	if req.TaskID == 0 || req.Text == "" {
-		respondJSON(w, http.StatusBadRequest, APIResponse{
-			Success: false,
-			Message: "task_id and text are required",
-		})
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Member removed",
+	})
+}
+
+// API Key Handlers
+func handleGetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
@@ -1302,70 +3415,148 @@ func handleAddComment(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Message: "Failed to load data",
+			Message: "Failed to load API keys",
 		})
 		return
 	}
 
-	// Find task and add comment
-	found := false
-	for i, task := range appData.Tasks {
-		if task.ID == req.TaskID {
-			// Generate comment ID
-			commentID := 1
-			if len(task.Comments) > 0 {
-				for _, c := range task.Comments {
-					if c.ID >= commentID {
-						commentID = c.ID + 1
-					}
-				}
-			}
+	user, _ := requestUser(r)
+	respondJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    userAPIKeys(appData, user.ID),
+	})
+}
 
-			comment := Comment{
-				ID:        commentID,
-				TaskID:    req.TaskID,
-				Author:    req.Author,
-				Text:      req.Text,
-				CreatedAt: time.Now(),
-			}
+// handleCreateAPIKey returns the new key once; only its hash is kept.
+func handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 
-			if appData.Tasks[i].Comments == nil {
-				appData.Tasks[i].Comments = []Comment{}
-			}
-			appData.Tasks[i].Comments = append(appData.Tasks[i].Comments, comment)
-			found = true
-			break
+	var req struct {
+		Name string `json:"name"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: "Invalid request body",
+			})
+			return
 		}
 	}
 
-	if !found {
-		respondJSON(w, http.StatusNotFound, APIResponse{
-			Success: false,
-			Message: "Task not found",
-		})
+	user, _ := requestUser(r)
+	var key string
+	var apiKey APIKey
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		var err error
+		key, apiKey, err = newAPIKey(appData, user.ID, req.Name)
+		return err
+	})
+	if err != nil {
+		respondError(w, err, "Failed to create API key")
 		return
 	}
 
-	if err := saveAppData(appData); err != nil {
-		respondJSON(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Message: "Failed to save data",
-		})
+	apiKey.KeyHash = ""
+	respondJSON(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "API key created; copy it now, it will not be shown again",
+		Data: map[string]interface{}{
+			"key":     key,
+			"api_key": apiKey,
+		},
+	})
+}
+
+func handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id := pathID(r.URL.Path, "/api/me/keys/")
+	user, _ := requestUser(r)
+	err := updateAppDataAs(requestActor(r), func(appData *AppData) error {
+		return revokeAPIKey(appData, user.ID, id)
+	})
+	if err != nil {
+		respondError(w, err, "Failed to revoke API key")
 		return
 	}
 
 	respondJSON(w, http.StatusOK, APIResponse{
 		Success: true,
-		Message: "Comment added successfully",
+		Message: "API key revoked",
 	})
 }
 
 func routeHandler(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+	enableCORS(w, r)
 
 	path := r.URL.Path
 
+	if r.Method != "OPTIONS" && !publicEndpoint(r) {
+		user, err := authenticate(r)
+		if err != nil {
+			respondError(w, err, "Failed to check the session")
+			return
+		}
+		if err := authorize(r, user); err != nil {
+			respondError(w, err, "Failed to check permissions")
+			return
+		}
+		r = withUser(r, user)
+	}
+
 	switch {
+	case r.Method == "OPTIONS":
+		w.WriteHeader(http.StatusOK)
+
+	// Auth endpoints
+	case path == "/api/auth/register" && r.Method == "POST":
+		handleRegister(w, r)
+	case path == "/api/auth/login" && r.Method == "POST":
+		handleLogin(w, r)
+	case path == "/api/auth/logout" && r.Method == "POST":
+		handleLogout(w, r)
+	case path == "/api/me" && r.Method == "GET":
+		handleMe(w, r)
+	case path == "/api/users" && r.Method == "GET":
+		handleGetUsers(w, r)
+	case path == "/api/me/keys" && r.Method == "GET":
+		handleGetAPIKeys(w, r)
+	case path == "/api/me/keys" && r.Method == "POST":
+		handleCreateAPIKey(w, r)
+	case strings.HasPrefix(path, "/api/me/keys/") && r.Method == "DELETE":
+		handleRevokeAPIKey(w, r)
+	case path == "/api/me/calendar" && r.Method == "POST":
+		handleCreateCalendarToken(w, r)
+	case path == "/api/me/calendar" && r.Method == "DELETE":
+		handleRevokeCalendarToken(w, r)
+	case path == calendarPath && r.Method == "GET":
+		handleCalendar(w, r)
+	case path == "/api/webhooks" && r.Method == "GET":
+		handleGetWebhooks(w, r)
+	case path == "/api/webhooks" && r.Method == "POST":
+		handleCreateWebhook(w, r)
+	case strings.HasPrefix(path, "/api/webhooks/") && strings.HasSuffix(path, "/deliveries") && r.Method == "GET":
+		handleGetWebhookDeliveries(w, r)
+	case strings.HasPrefix(path, "/api/webhooks/") && strings.HasSuffix(path, "/redeliver") && r.Method == "POST":
+		handleRedeliverWebhook(w, r)
+	case strings.HasPrefix(path, "/api/webhooks/") && r.Method == "PUT":
+		handleUpdateWebhook(w, r)
+	case strings.HasPrefix(path, "/api/webhooks/") && r.Method == "DELETE":
+		handleDeleteWebhook(w, r)
+	case path == "/api/me/reminders" && r.Method == "GET":
+		handleGetReminders(w, r)
+	case path == "/api/me/reminders" && r.Method == "PUT":
+		handleUpdateReminders(w, r)
+
 	// Task endpoints
 	case path == "/api/tasks" && r.Method == "GET":
 		handleGetTasks(w, r)
@@ -1375,16 +3566,60 @@ func routeHandler(w http.ResponseWriter, r *http.Request) {
 		handleMarkDone(w, r)
 	case strings.HasPrefix(path, "/api/tasks/") && strings.HasSuffix(path, "/undone") && r.Method == "PUT":
 		handleMarkUndone(w, r)
+	case strings.HasPrefix(path, "/api/tasks/") && strings.HasSuffix(path, "/snooze") && (r.Method == "POST" || r.Method == "DELETE"):
+		handleSnoozeTask(w, r)
+	case strings.HasPrefix(path, "/api/tasks/") && strings.HasSuffix(path, "/subtasks") && r.Method == "GET":
+		handleGetSubtasks(w, r)
+	case strings.HasPrefix(path, "/api/tasks/") && strings.HasSuffix(path, "/subtasks") && r.Method == "POST":
+		handleCreateSubtask(w, r)
+	case strings.HasPrefix(path, "/api/tasks/") && strings.HasSuffix(path, "/checklist") && r.Method == "GET":
+		handleGetChecklist(w, r)
+	case strings.HasPrefix(path, "/api/tasks/") && strings.Contains(path, "/checklist") && r.Method != "GET":
+		handleChangeChecklist(w, r)
+	case strings.HasPrefix(path, "/api/tasks/") && strings.HasSuffix(path, "/dependencies") && r.Method == "GET":
+		handleGetDependencies(w, r)
+	case strings.HasPrefix(path, "/api/tasks/") && strings.Contains(path, "/dependencies") && r.Method != "GET":
+		handleChangeDependency(w, r)
+	case strings.HasPrefix(path, "/api/tasks/") && strings.HasSuffix(path, "/history") && r.Method == "GET":
+		handleGetTaskHistory(w, r)
+	case strings.HasPrefix(path, "/api/tasks/") && r.Method == "GET":
+		handleGetTask(w, r)
 	case strings.HasPrefix(path, "/api/tasks/") && r.Method == "DELETE":
 		handleDeleteTask(w, r)
 	case strings.HasPrefix(path, "/api/tasks/") && r.Method == "PUT":
 		handleUpdateTask(w, r)
 
+	case path == "/api/search" && r.Method == "GET":
+		handleSearch(w, r)
+
+	// Trash endpoints
+	case path == "/api/trash" && r.Method == "GET":
+		handleGetTrash(w, r)
+	case strings.HasPrefix(path, "/api/trash/") && strings.HasSuffix(path, "/restore") && r.Method == "POST":
+		handleRestoreTrash(w, r)
+	case strings.HasPrefix(path, "/api/trash/") && r.Method == "DELETE":
+		handlePurgeTrash(w, r)
+
+	case path == "/api/events" && r.Method == "GET":
+		handleEvents(w, r)
+
 	// Project endpoints
 	case path == "/api/projects" && r.Method == "GET":
 		handleGetProjects(w, r)
 	case path == "/api/projects" && r.Method == "POST":
 		handleCreateProject(w, r)
+	case strings.HasPrefix(path, "/api/projects/") && strings.HasSuffix(path, "/members") && r.Method == "GET":
+		handleGetMembers(w, r)
+	case strings.HasPrefix(path, "/api/projects/") && strings.HasSuffix(path, "/members") && r.Method == "PUT":
+		handleSetMember(w, r)
+	case strings.HasPrefix(path, "/api/projects/") && strings.Contains(path, "/members/") && r.Method == "DELETE":
+		handleRemoveMember(w, r)
+	case strings.HasPrefix(path, "/api/projects/") && strings.HasSuffix(path, "/activity") && r.Method == "GET":
+		handleGetProjectActivity(w, r)
+	case strings.HasPrefix(path, "/api/projects/") && strings.HasSuffix(path, "/critical-path") && r.Method == "GET":
+		handleGetCriticalPath(w, r)
+	case strings.HasPrefix(path, "/api/projects/") && r.Method == "GET":
+		handleGetProject(w, r)
 	case strings.HasPrefix(path, "/api/projects/") && r.Method == "PUT":
 		handleUpdateProject(w, r)
 	case strings.HasPrefix(path, "/api/projects/") && r.Method == "DELETE":
@@ -1399,8 +3634,20 @@ func routeHandler(w http.ResponseWriter, r *http.Request) {
 	// Time tracking endpoints
 	case path == "/api/time/start" && r.Method == "POST":
 		handleStartTimer(w, r)
+	case path == "/api/time/current" && r.Method == "GET":
+		handleCurrentTimer(w, r)
 	case strings.HasPrefix(path, "/api/time/") && strings.HasSuffix(path, "/stop") && r.Method == "PUT":
 		handleStopTimer(w, r)
+	case strings.HasPrefix(path, "/api/time/") && (strings.HasSuffix(path, "/pause") || strings.HasSuffix(path, "/resume")) && r.Method == "PUT":
+		handlePauseTimer(w, r)
+	case strings.HasPrefix(path, "/api/time/") && strings.HasSuffix(path, "/split") && r.Method == "POST":
+		handleSplitTimeEntry(w, r)
+	case path == "/api/time" && r.Method == "POST":
+		handleAddTimeEntry(w, r)
+	case strings.HasPrefix(path, "/api/time/") && r.Method == "PUT":
+		handleEditTimeEntry(w, r)
+	case strings.HasPrefix(path, "/api/time/") && r.Method == "DELETE":
+		handleDeleteTimeEntry(w, r)
 	case path == "/api/time" && r.Method == "GET":
 		handleGetTimeEntries(w, r)
 
@@ -1409,6 +3656,16 @@ func routeHandler(w http.ResponseWriter, r *http.Request) {
 		handleGetStats(w, r)
 	case path == "/api/reports" && r.Method == "GET":
 		handleGetReports(w, r)
+	case path == "/api/reports/timesheet" && r.Method == "GET":
+		handleTimesheet(w, r)
+	case path == "/api/reports/analytics" && r.Method == "GET":
+		handleAnalytics(w, r)
+
+	// Import/export endpoints
+	case path == "/api/export" && r.Method == "GET":
+		handleExport(w, r)
+	case path == "/api/import" && r.Method == "POST":
+		handleImport(w, r)
 
 	// Comment endpoints
 	case path == "/api/comments" && r.Method == "GET":
@@ -1425,6 +3682,12 @@ func routeHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func startServer() {
+	loadCORSConfig()
+	go purgeTrashLoop()
+	go reminderLoop()
+	go webhookWorker()
+	go timerLoop()
+
 	fs := http.FileServer(http.Dir("./web"))
 	http.Handle("/", fs)
 
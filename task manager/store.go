@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Store persists the application data. The JSON file store keeps everything
+// in a single document; the SQLite store keeps one row per record so a save
+// only touches the rows that changed.
+//
+// Update is the only safe way to read-modify-write: it loads the current
+// data, runs fn and saves the result while holding the store's write lock,
+// so concurrent writers (other requests, or a REPL and a server sharing the
+// same file) cannot lose each other's changes. If fn returns an error
+// nothing is saved and the error is passed through.
+type Store interface {
+	Load() (*AppData, error)
+	Save(appData *AppData) error
+	Update(fn func(appData *AppData) error) error
+	Close() error
+}
+
+// dataStore is the backend used by loadAppData/saveAppData. main replaces it
+// according to the -store flag or TASKMANAGER_STORE.
+var dataStore Store = &jsonStore{}
+
+func newAppData() *AppData {
+	return &AppData{
+		Projects:    []Project{},
+		Tasks:       []Task{},
+		TimeEntries: []TimeEntry{},
+	}
+}
+
+func dataFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".project_manager.json"), nil
+}
+
+func sqliteFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".project_manager.db"), nil
+}
+
+// openStore opens the backend named by kind ("json" or "sqlite"). An empty
+// path selects the default file in the user's home directory.
+func openStore(kind, path string) (Store, error) {
+	switch strings.ToLower(kind) {
+	case "", "json":
+		return &jsonStore{path: path}, nil
+	case "sqlite", "sqlite3":
+		if path == "" {
+			var err error
+			if path, err = sqliteFile(); err != nil {
+				return nil, err
+			}
+		}
+		return openSQLiteStore(path)
+	default:
+		return nil, fmt.Errorf("unknown store %q (use json or sqlite)", kind)
+	}
+}
+
+// migrateStore copies everything from src into dst. It refuses to overwrite a
+// destination that already holds projects or tasks.
+func migrateStore(src, dst Store) (*AppData, error) {
+	existing, err := dst.Load()
+	if err != nil {
+		return nil, err
+	}
+	if len(existing.Projects) > 0 || len(existing.Tasks) > 0 {
+		return nil, errors.New("destination store is not empty")
+	}
+	appData, err := src.Load()
+	if err != nil {
+		return nil, err
+	}
+	if err := dst.Save(appData); err != nil {
+		return nil, err
+	}
+	return appData, nil
+}
+
+// jsonStore serializes writers with a mutex inside the process and an
+// advisory lock on "<file>.lock" across processes. Saves go to a temp file
+// that is fsynced and renamed over the original, so readers never see a
+// partially written file.
+type jsonStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (s *jsonStore) file() (string, error) {
+	if s.path != "" {
+		return s.path, nil
+	}
+	return dataFile()
+}
+
+func (s *jsonStore) Load() (*AppData, error) {
+	path, err := s.file()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return newAppData(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var appData AppData
+	if err := json.Unmarshal(data, &appData); err != nil {
+		return nil, err
+	}
+	return &appData, nil
+}
+
+func (s *jsonStore) Save(appData *AppData) error {
+	return s.Update(func(current *AppData) error {
+		*current = *appData
+		return nil
+	})
+}
+
+func (s *jsonStore) Update(fn func(appData *AppData) error) error {
+	path, err := s.file()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lockFile(lock); err != nil {
+		return err
+	}
+	defer unlockFile(lock)
+
+	appData, err := s.Load()
+	if err != nil {
+		return err
+	}
+	if err := fn(appData); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(appData, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o644)
+}
+
+// writeFileAtomic writes data to a temp file next to path, fsyncs it and
+// renames it into place.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself; not every platform can sync a directory.
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+func (s *jsonStore) Close() error {
+	return nil
+}
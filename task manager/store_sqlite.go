@@ -0,0 +1,381 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS projects (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	data TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tasks (
+	id INTEGER PRIMARY KEY,
+	project_id INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	done BOOLEAN NOT NULL DEFAULT 0,
+	data TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_tasks_project ON tasks(project_id);
+
+CREATE TABLE IF NOT EXISTS comments (
+	task_id INTEGER NOT NULL,
+	id INTEGER NOT NULL,
+	author TEXT,
+	text TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (task_id, id)
+);
+
+CREATE TABLE IF NOT EXISTS time_entries (
+	id INTEGER PRIMARY KEY,
+	task_id INTEGER NOT NULL,
+	start_time DATETIME NOT NULL,
+	data TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_time_entries_task ON time_entries(task_id);
+
+CREATE TABLE IF NOT EXISTS meta (
+	key TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+)`
+
+// sqliteStore keeps every record in its own row. Indexed columns are stored
+// alongside a JSON copy of the full record in "data", so new struct fields
+// do not need a schema change. Save diffs against what is on disk and only
+// writes the rows that changed. Transactions start with BEGIN IMMEDIATE, so
+// Update holds SQLite's write lock from the read until the commit.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+type sqliteTable struct {
+	name    string
+	keyExpr string   // SQL expression producing the row key as text
+	columns []string // insert order; "data" is always last
+}
+
+type sqliteRow struct {
+	key  string
+	args []interface{}
+}
+
+var (
+	projectsTable = sqliteTable{
+		name:    "projects",
+		keyExpr: "CAST(id AS TEXT)",
+		columns: []string{"id", "name", "data"},
+	}
+	tasksTable = sqliteTable{
+		name:    "tasks",
+		keyExpr: "CAST(id AS TEXT)",
+		columns: []string{"id", "project_id", "status", "done", "data"},
+	}
+	commentsTable = sqliteTable{
+		name:    "comments",
+		keyExpr: "task_id || ':' || id",
+		columns: []string{"task_id", "id", "author", "text", "created_at", "data"},
+	}
+	timeEntriesTable = sqliteTable{
+		name:    "time_entries",
+		keyExpr: "CAST(id AS TEXT)",
+		columns: []string{"id", "task_id", "start_time", "data"},
+	}
+	metaTable = sqliteTable{
+		name:    "meta",
+		keyExpr: "key",
+		columns: []string{"key", "data"},
+	}
+)
+
+func openSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_txlock=immediate&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqliteStore) Load() (*AppData, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return loadSQLite(tx)
+}
+
+func (s *sqliteStore) Save(appData *AppData) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := saveSQLite(tx, appData); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Update(fn func(appData *AppData) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	appData, err := loadSQLite(tx)
+	if err != nil {
+		return err
+	}
+	if err := fn(appData); err != nil {
+		return err
+	}
+	if err := saveSQLite(tx, appData); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func loadSQLite(tx *sql.Tx) (*AppData, error) {
+	// Fields other than the three record tables are kept as JSON documents
+	// in meta and merged back in by key.
+	doc := map[string]json.RawMessage{}
+	rows, err := tx.Query("SELECT key, data FROM meta")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var key, data string
+		if err := rows.Scan(&key, &data); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		doc[key] = json.RawMessage(data)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	appData := newAppData()
+	if len(doc) > 0 {
+		raw, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, appData); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := scanSQLite(tx, "SELECT data FROM projects ORDER BY id", func(data []byte) error {
+		var p Project
+		if err := json.Unmarshal(data, &p); err != nil {
+			return err
+		}
+		appData.Projects = append(appData.Projects, p)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	taskIndex := make(map[int]int)
+	if err := scanSQLite(tx, "SELECT data FROM tasks ORDER BY id", func(data []byte) error {
+		var t Task
+		if err := json.Unmarshal(data, &t); err != nil {
+			return err
+		}
+		taskIndex[t.ID] = len(appData.Tasks)
+		appData.Tasks = append(appData.Tasks, t)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := scanSQLite(tx, "SELECT data FROM comments ORDER BY task_id, id", func(data []byte) error {
+		var c Comment
+		if err := json.Unmarshal(data, &c); err != nil {
+			return err
+		}
+		if i, ok := taskIndex[c.TaskID]; ok {
+			appData.Tasks[i].Comments = append(appData.Tasks[i].Comments, c)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := scanSQLite(tx, "SELECT data FROM time_entries ORDER BY id", func(data []byte) error {
+		var e TimeEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return err
+		}
+		appData.TimeEntries = append(appData.TimeEntries, e)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return appData, nil
+}
+
+func scanSQLite(tx *sql.Tx, query string, fn func(data []byte) error) error {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func saveSQLite(tx *sql.Tx, appData *AppData) error {
+	var projects, tasks, comments, entries, meta []sqliteRow
+
+	for _, p := range appData.Projects {
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		projects = append(projects, sqliteRow{
+			key:  fmt.Sprint(p.ID),
+			args: []interface{}{p.ID, p.Name, string(data)},
+		})
+	}
+
+	for _, t := range appData.Tasks {
+		for _, c := range t.Comments {
+			data, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			comments = append(comments, sqliteRow{
+				key:  fmt.Sprintf("%d:%d", t.ID, c.ID),
+				args: []interface{}{t.ID, c.ID, c.Author, c.Text, c.CreatedAt.Format(time.RFC3339), string(data)},
+			})
+		}
+		t.Comments = nil
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, sqliteRow{
+			key:  fmt.Sprint(t.ID),
+			args: []interface{}{t.ID, t.ProjectID, string(t.Status), t.Done, string(data)},
+		})
+	}
+
+	for _, e := range appData.TimeEntries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, sqliteRow{
+			key:  fmt.Sprint(e.ID),
+			args: []interface{}{e.ID, e.TaskID, e.StartTime.Format(time.RFC3339), string(data)},
+		})
+	}
+
+	raw, err := json.Marshal(appData)
+	if err != nil {
+		return err
+	}
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+	delete(doc, "projects")
+	delete(doc, "tasks")
+	delete(doc, "time_entries")
+	for key, data := range doc {
+		meta = append(meta, sqliteRow{key: key, args: []interface{}{key, string(data)}})
+	}
+
+	for _, sync := range []struct {
+		table sqliteTable
+		rows  []sqliteRow
+	}{
+		{projectsTable, projects},
+		{tasksTable, tasks},
+		{commentsTable, comments},
+		{timeEntriesTable, entries},
+		{metaTable, meta},
+	} {
+		if err := syncSQLiteTable(tx, sync.table, sync.rows); err != nil {
+			return fmt.Errorf("saving %s: %w", sync.table.name, err)
+		}
+	}
+	return nil
+}
+
+// syncSQLiteTable makes table contain exactly rows, writing only the rows
+// whose data differs from what is stored and deleting the ones that are gone.
+func syncSQLiteTable(tx *sql.Tx, table sqliteTable, rows []sqliteRow) error {
+	existing := make(map[string]string)
+	result, err := tx.Query(fmt.Sprintf("SELECT %s, data FROM %s", table.keyExpr, table.name))
+	if err != nil {
+		return err
+	}
+	for result.Next() {
+		var key, data string
+		if err := result.Scan(&key, &data); err != nil {
+			result.Close()
+			return err
+		}
+		existing[key] = data
+	}
+	result.Close()
+	if err := result.Err(); err != nil {
+		return err
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(table.columns)), ", ")
+	upsert := fmt.Sprintf("INSERT OR REPLACE INTO %s (%s) VALUES (%s)",
+		table.name, strings.Join(table.columns, ", "), placeholders)
+
+	for _, row := range rows {
+		data := row.args[len(row.args)-1].(string)
+		stored, ok := existing[row.key]
+		delete(existing, row.key)
+		if ok && stored == data {
+			continue
+		}
+		if _, err := tx.Exec(upsert, row.args...); err != nil {
+			return err
+		}
+	}
+
+	remove := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", table.name, table.keyExpr)
+	for key := range existing {
+		if _, err := tx.Exec(remove, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func storeTestData() *AppData {
+	at := func(h int) time.Time { return time.Date(2026, time.October, 14, h, 0, 0, 0, time.UTC) }
+	end := at(11)
+	return &AppData{
+		Projects: []Project{
+			{ID: 1, Name: "Default", Members: []ProjectMember{{Username: "ann", Role: RoleOwner}}},
+			{ID: 2, Name: "Website", DefaultRole: RoleViewer},
+		},
+		Tasks: []Task{
+			{ID: 1, ProjectID: 1, Description: "Write post", Status: StatusTodo, Tags: []string{"blog"}, CreatedAt: at(8),
+				Comments: []Comment{{ID: 1, TaskID: 1, Author: "ann", Text: "first", CreatedAt: at(9)}, {ID: 2, TaskID: 1, Author: "bob", Text: "second", CreatedAt: at(10)}}},
+			{ID: 2, ProjectID: 2, Description: "Fix header", Status: StatusDone, Done: true, CompletedAt: &end, CreatedAt: at(8), BlockedBy: []int{1},
+				Comments: []Comment{{ID: 1, TaskID: 2, Author: "ann", Text: "done?", CreatedAt: at(11)}}},
+			{ID: 3, ProjectID: 2, ParentID: 2, Description: "Check mobile", Status: StatusBacklog, CreatedAt: at(9)},
+		},
+		TimeEntries: []TimeEntry{
+			{ID: 1, TaskID: 1, User: "ann", StartTime: at(9), EndTime: &end, Duration: 7200},
+			{ID: 2, TaskID: 2, User: "bob", StartTime: at(10)},
+		},
+		Users:         []User{{ID: 1, Username: "ann"}, {ID: 2, Username: "bob"}},
+		Activity:      []Activity{{ID: 1, Time: at(8), Actor: "ann", Action: "created", Entity: "task", EntityID: 1, ProjectID: 1}},
+		Webhooks:      []Webhook{{ID: 1, ProjectID: 2, URL: "https://hooks.example.com/", Events: webhookEvents, Active: true}},
+		LastTaskID:    3,
+		LastProjectID: 2,
+	}
+}
+
+// TestStoresRoundTrip saves the same data through both stores and checks
+// they load it back alike, including after records are removed.
+func TestStoresRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	sqlite, err := openSQLiteStore(filepath.Join(dir, "data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	stores := []struct {
+		name  string
+		store Store
+	}{
+		{"json", &jsonStore{path: filepath.Join(dir, "data.json")}},
+		{"sqlite", sqlite},
+	}
+
+	check := func(step string, appData *AppData) {
+		want := jsonString(appData)
+		for _, s := range stores {
+			if err := s.store.Save(appData); err != nil {
+				t.Fatalf("%s: saving to %s: %v", step, s.name, err)
+			}
+			loaded, err := s.store.Load()
+			if err != nil {
+				t.Fatalf("%s: loading from %s: %v", step, s.name, err)
+			}
+			if got := jsonString(loaded); got != want {
+				t.Errorf("%s: %s store loaded\n%s\nwant\n%s", step, s.name, got, want)
+			}
+		}
+	}
+
+	appData := storeTestData()
+	check("first save", appData)
+
+	// Change a task and a comment in place.
+	appData.Tasks[0].Description = "Write the post"
+	appData.Tasks[1].Comments[0].Text = "done."
+	check("edit", appData)
+
+	// Drop a project, a task with its comments, a comment of another task,
+	// a time entry and a whole meta document.
+	appData.Projects = appData.Projects[:1]
+	appData.Tasks = []Task{appData.Tasks[0]}
+	appData.Tasks[0].Comments = appData.Tasks[0].Comments[1:]
+	appData.TimeEntries = appData.TimeEntries[:1]
+	appData.Webhooks = nil
+	check("delete", appData)
+
+	for table, want := range map[string]int{"projects": 1, "tasks": 1, "comments": 1, "time_entries": 1} {
+		var n int
+		if err := sqlite.db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("%s holds %d rows after deleting, want %d", table, n, want)
+		}
+	}
+	var n int
+	sqlite.db.QueryRow("SELECT COUNT(*) FROM meta WHERE key = 'webhooks'").Scan(&n)
+	if n != 0 {
+		t.Errorf("webhooks still stored in meta")
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// TaskProgress rolls a task's subtasks (at every depth) and checklist up
+// into a single figure. Hours include the task's own estimate and tracked
+// time as well as those of its descendants.
+type TaskProgress struct {
+	SubtasksTotal     int     `json:"subtasks_total"`
+	SubtasksDone      int     `json:"subtasks_done"`
+	ChecklistTotal    int     `json:"checklist_total"`
+	ChecklistDone     int     `json:"checklist_done"`
+	Percent           int     `json:"percent"`
+	EstimatedHours    float64 `json:"estimated_hours"`
+	DoneEstimateHours float64 `json:"done_estimated_hours"` // estimates of finished tasks in the tree
+	TrackedHours      float64 `json:"tracked_hours"`
+}
+
+// TaskView is a task together with its rolled-up progress, as returned by
+// the kanban and subtask endpoints.
+type TaskView struct {
+	Task
+	Progress TaskProgress `json:"progress"`
+	Blocked  bool         `json:"blocked"` // some task in BlockedBy is unfinished
+}
+
+// TaskDetail is what "view" shows: the task, its progress, its direct
+// subtasks and the tasks it is linked to by dependencies.
+type TaskDetail struct {
+	TaskView
+	Subtasks []TaskView `json:"subtasks"`
+	Blockers []TaskRef  `json:"blockers"`
+	Blocks   []TaskRef  `json:"blocks"`
+	History  []Activity `json:"history,omitempty"`
+}
+
+// taskTree indexes tasks by ID and by parent so progress can be computed
+// without rescanning the task list for every node.
+type taskTree struct {
+	byID     map[int]Task
+	children map[int][]int
+	blocks   map[int][]int
+	tracked  map[int]float64 // hours per task, from time entries
+	progress map[int]TaskProgress
+	fraction map[int]float64
+}
+
+func newTaskTree(appData *AppData) *taskTree {
+	tree := &taskTree{
+		byID:     make(map[int]Task, len(appData.Tasks)),
+		children: make(map[int][]int),
+		blocks:   blocksIndex(appData),
+		tracked:  make(map[int]float64),
+		progress: make(map[int]TaskProgress),
+		fraction: make(map[int]float64),
+	}
+	for _, t := range appData.Tasks {
+		tree.byID[t.ID] = t
+	}
+	for _, t := range appData.Tasks {
+		// Orphans are treated as top-level rather than disappearing.
+		if _, ok := tree.byID[t.ParentID]; ok && t.ParentID != 0 {
+			tree.children[t.ParentID] = append(tree.children[t.ParentID], t.ID)
+		}
+	}
+	for _, e := range appData.TimeEntries {
+		tree.tracked[e.TaskID] += float64(e.Duration) / 3600
+	}
+	return tree
+}
+
+// Progress returns the rolled-up progress of task id.
+func (tree *taskTree) Progress(id int) TaskProgress {
+	tree.compute(id, map[int]bool{})
+	return tree.progress[id]
+}
+
+// compute fills in progress and fraction for id and its descendants. A done
+// task counts as complete; otherwise its fraction is the average over its
+// subtasks' fractions and its checklist items.
+func (tree *taskTree) compute(id int, visiting map[int]bool) float64 {
+	if f, ok := tree.fraction[id]; ok {
+		return f
+	}
+	if visiting[id] {
+		return 0 // corrupt data with a cycle; do not recurse forever
+	}
+	visiting[id] = true
+
+	t := tree.byID[id]
+	p := TaskProgress{
+		EstimatedHours: t.EstimatedHours,
+		TrackedHours:   tree.tracked[id],
+	}
+	if t.Done {
+		p.DoneEstimateHours = t.EstimatedHours
+	}
+
+	units, sum := 0, 0.0
+	for _, childID := range tree.children[id] {
+		f := tree.compute(childID, visiting)
+		child := tree.progress[childID]
+		p.SubtasksTotal += 1 + child.SubtasksTotal
+		p.SubtasksDone += child.SubtasksDone
+		if tree.byID[childID].Done {
+			p.SubtasksDone++
+		}
+		p.EstimatedHours += child.EstimatedHours
+		p.DoneEstimateHours += child.DoneEstimateHours
+		p.TrackedHours += child.TrackedHours
+		units++
+		sum += f
+	}
+	for _, item := range t.Checklist {
+		p.ChecklistTotal++
+		units++
+		if item.Done {
+			p.ChecklistDone++
+			sum++
+		}
+	}
+
+	fraction := 0.0
+	switch {
+	case t.Done:
+		fraction = 1
+	case units > 0:
+		fraction = sum / float64(units)
+	}
+	p.Percent = int(fraction*100 + 0.5)
+
+	tree.progress[id] = p
+	tree.fraction[id] = fraction
+	return fraction
+}
+
+// View returns task id with its progress.
+func (tree *taskTree) View(id int) TaskView {
+	view := TaskView{Task: tree.byID[id], Progress: tree.Progress(id)}
+	for _, b := range view.BlockedBy {
+		if blocker, ok := tree.byID[b]; ok && !blocker.Done {
+			view.Blocked = true
+		}
+	}
+	return view
+}
+
+// Subtasks returns the direct children of id ordered by position, then ID.
+func (tree *taskTree) Subtasks(id int) []TaskView {
+	views := []TaskView{}
+	for _, childID := range tree.children[id] {
+		views = append(views, tree.View(childID))
+	}
+	sort.Slice(views, func(i, j int) bool {
+		if views[i].Position != views[j].Position {
+			return views[i].Position < views[j].Position
+		}
+		return views[i].ID < views[j].ID
+	})
+	return views
+}
+
+// Detail returns the task, its progress and its direct subtasks.
+func (tree *taskTree) Detail(id int) TaskDetail {
+	detail := TaskDetail{
+		TaskView: tree.View(id),
+		Subtasks: tree.Subtasks(id),
+		Blockers: []TaskRef{},
+		Blocks:   []TaskRef{},
+	}
+	for _, b := range detail.BlockedBy {
+		if blocker, ok := tree.byID[b]; ok {
+			detail.Blockers = append(detail.Blockers, taskRef(blocker))
+		}
+	}
+	for _, b := range tree.blocks[id] {
+		detail.Blocks = append(detail.Blocks, taskRef(tree.byID[b]))
+	}
+	return detail
+}
+
+// taskDetail loads the data and returns the detail view of task id.
+func taskDetail(id int) (TaskDetail, error) {
+	appData, err := loadAppData()
+	if err != nil {
+		return TaskDetail{}, err
+	}
+	tree := newTaskTree(appData)
+	if _, ok := tree.byID[id]; !ok {
+		return TaskDetail{}, fmt.Errorf("task #%d not found", id)
+	}
+	detail := tree.Detail(id)
+	detail.History = taskHistory(appData, id)
+	return detail, nil
+}
+
+// validateParent checks that parentID can become the parent of taskID
+// (0 for a task that does not exist yet): the parent must exist, be in the
+// same project and must not be the task itself or one of its descendants.
+func validateParent(appData *AppData, taskID, parentID, projectID int) error {
+	parent := findTask(appData, parentID)
+	if parent == nil {
+		return &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("parent task #%d not found", parentID)}
+	}
+	if parent.ProjectID != projectID {
+		return &apiError{Status: http.StatusBadRequest, Message: "a subtask must be in the same project as its parent"}
+	}
+	if taskID == 0 {
+		return nil
+	}
+
+	parentOf := make(map[int]int, len(appData.Tasks))
+	for _, t := range appData.Tasks {
+		parentOf[t.ID] = t.ParentID
+	}
+	for id, steps := parentID, 0; id != 0 && steps <= len(appData.Tasks); id, steps = parentOf[id], steps+1 {
+		if id == taskID {
+			return &apiError{Status: http.StatusBadRequest, Message: "a task cannot be a subtask of itself or of its own subtasks"}
+		}
+	}
+	return nil
+}
+
+// detachSubtasks promotes the children of a deleted task to its parent, so
+// deleting a task never silently deletes its subtasks.
+func detachSubtasks(appData *AppData, deleted Task) {
+	for i := range appData.Tasks {
+		if appData.Tasks[i].ParentID == deleted.ID {
+			appData.Tasks[i].ParentID = deleted.ParentID
+		}
+	}
+}
+
+// moveSubtree moves task id and all of its descendants to projectID.
+func moveSubtree(appData *AppData, id, projectID int) {
+	moving := map[int]bool{id: true}
+	for changed := true; changed; {
+		changed = false
+		for _, t := range appData.Tasks {
+			if moving[t.ParentID] && !moving[t.ID] {
+				moving[t.ID] = true
+				changed = true
+			}
+		}
+	}
+	for i := range appData.Tasks {
+		if moving[appData.Tasks[i].ID] {
+			appData.Tasks[i].ProjectID = projectID
+		}
+	}
+}
+
+func nextChecklistID(items []ChecklistItem) int {
+	maxID := 0
+	for _, item := range items {
+		if item.ID > maxID {
+			maxID = item.ID
+		}
+	}
+	return maxID + 1
+}
+
+// addChecklistItem appends an item to t's checklist.
+func addChecklistItem(t *Task, text string) (ChecklistItem, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return ChecklistItem{}, &apiError{Status: http.StatusBadRequest, Message: "checklist text cannot be empty"}
+	}
+	item := ChecklistItem{ID: nextChecklistID(t.Checklist), Text: text}
+	t.Checklist = append(t.Checklist, item)
+	return item, nil
+}
+
+// findChecklistItem returns a pointer into t's checklist.
+func findChecklistItem(t *Task, itemID int) (*ChecklistItem, error) {
+	for i := range t.Checklist {
+		if t.Checklist[i].ID == itemID {
+			return &t.Checklist[i], nil
+		}
+	}
+	return nil, &apiError{Status: http.StatusNotFound, Message: fmt.Sprintf("checklist item %d not found on task #%d", itemID, t.ID)}
+}
+
+func removeChecklistItem(t *Task, itemID int) error {
+	for i := range t.Checklist {
+		if t.Checklist[i].ID == itemID {
+			t.Checklist = append(t.Checklist[:i], t.Checklist[i+1:]...)
+			return nil
+		}
+	}
+	return &apiError{Status: http.StatusNotFound, Message: fmt.Sprintf("checklist item %d not found on task #%d", itemID, t.ID)}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TaskInput is everything a caller can set when creating a task. Zero
+// values are filled in by createTask, so the CLI, the HTTP API and imports
+// all end up with the same defaults.
+type TaskInput struct {
+	Description    string
+	ProjectID      int    // 0 with no ProjectName means the "Default" project
+	ProjectName    string // name or numeric ID, resolved when ProjectID is 0
+	ParentID       int    // makes the task a subtask; defaults the project to the parent's
+	Category       string
+	Priority       *Priority // nil means Medium
+	Status         string    // "" means todo
+	DueDate        *time.Time
+	Tags           []string
+	Assignee       string
+	EstimatedHours float64
+	BlockedBy      []int // tasks that must be done before this one can start
+	Recurrence     *Recurrence
+}
+
+var validStatuses = []TaskStatus{StatusBacklog, StatusTodo, StatusInProgress, StatusInReview, StatusDone}
+
+// parseStatus accepts the kanban column names, tolerating case and "-" or
+// " " in place of "_".
+func parseStatus(s string) (TaskStatus, error) {
+	normalized := strings.ToLower(strings.TrimSpace(s))
+	normalized = strings.NewReplacer("-", "_", " ", "_").Replace(normalized)
+	for _, status := range validStatuses {
+		if string(status) == normalized {
+			return status, nil
+		}
+	}
+	return "", &apiError{
+		Status:  http.StatusBadRequest,
+		Message: fmt.Sprintf("invalid status %q (use backlog, todo, in_progress, in_review or done)", s),
+	}
+}
+
+// normalizeTags trims tags, drops empty ones and duplicates (ignoring case)
+// and rejects tags that could not round-trip through "--tags a,b".
+func normalizeTags(tags []string) ([]string, error) {
+	if tags == nil {
+		return nil, nil
+	}
+	out := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if strings.ContainsAny(tag, ",\n\t") {
+			return nil, &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid tag %q", tag)}
+		}
+		if seen[strings.ToLower(tag)] {
+			continue
+		}
+		seen[strings.ToLower(tag)] = true
+		out = append(out, tag)
+	}
+	return out, nil
+}
+
+// defaultProject returns the "Default" project, creating it on first use.
+func defaultProject(appData *AppData) *Project {
+	for i := range appData.Projects {
+		if appData.Projects[i].Name == "Default" {
+			return &appData.Projects[i]
+		}
+	}
+	appData.Projects = append(appData.Projects, Project{
+		ID:        newProjectID(appData),
+		Name:      "Default",
+		Color:     "#6366f1",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+	return &appData.Projects[len(appData.Projects)-1]
+}
+
+// findProject looks a project up by ID, or by name or ID when given as text.
+func findProject(appData *AppData, id int, name string) *Project {
+	for i := range appData.Projects {
+		p := &appData.Projects[i]
+		if id != 0 && p.ID == id {
+			return p
+		}
+		if name != "" && (strings.EqualFold(p.Name, name) || strconv.Itoa(p.ID) == name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// findTask returns a pointer into appData.Tasks, or nil.
+func findTask(appData *AppData, id int) *Task {
+	for i := range appData.Tasks {
+		if appData.Tasks[i].ID == id {
+			return &appData.Tasks[i]
+		}
+	}
+	return nil
+}
+
+// taskStatus reports the kanban status, treating an empty status the same
+// way the board does.
+func taskStatus(t Task) TaskStatus {
+	if t.Status != "" {
+		return t.Status
+	}
+	if t.Done {
+		return StatusDone
+	}
+	return StatusTodo
+}
+
+// nextPosition returns the position just past the last task in the given
+// project's kanban column.
+func nextPosition(appData *AppData, projectID int, status TaskStatus) int {
+	pos := 0
+	for _, t := range appData.Tasks {
+		if t.ProjectID == projectID && taskStatus(t) == status && t.Position >= pos {
+			pos = t.Position + 1
+		}
+	}
+	return pos
+}
+
+// setTaskStatus moves t to status and keeps Done/CompletedAt in step with it.
+func setTaskStatus(t *Task, status TaskStatus) {
+	t.Status = status
+	if status == StatusDone {
+		if !t.Done || t.CompletedAt == nil {
+			now := time.Now()
+			t.CompletedAt = &now
+		}
+		t.Done = true
+	} else {
+		t.Done = false
+		t.CompletedAt = nil
+	}
+}
+
+// createTask validates input, applies defaults and appends the new task.
+// It is the only place tasks are created.
+func createTask(appData *AppData, input TaskInput) (*Task, error) {
+	desc := strings.TrimSpace(input.Description)
+	if desc == "" {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: "description cannot be empty"}
+	}
+
+	if input.ParentID != 0 && input.ProjectID == 0 && input.ProjectName == "" {
+		if parent := findTask(appData, input.ParentID); parent != nil {
+			input.ProjectID = parent.ProjectID
+		}
+	}
+
+	var project *Project
+	switch {
+	case input.ProjectID != 0:
+		project = findProject(appData, input.ProjectID, "")
+		if project == nil {
+			return nil, &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("project #%d not found", input.ProjectID)}
+		}
+	case input.ProjectName != "":
+		project = findProject(appData, 0, input.ProjectName)
+		if project == nil {
+			return nil, &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("project %q not found", input.ProjectName)}
+		}
+	default:
+		project = defaultProject(appData)
+	}
+
+	status := StatusTodo
+	if input.Status != "" {
+		var err error
+		if status, err = parseStatus(input.Status); err != nil {
+			return nil, err
+		}
+	}
+
+	priority := Medium
+	if input.Priority != nil {
+		priority = *input.Priority
+	}
+
+	tags, err := normalizeTags(input.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.EstimatedHours < 0 {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: "estimated hours cannot be negative"}
+	}
+
+	dueDate := input.DueDate
+	if input.Recurrence != nil {
+		if err := input.Recurrence.validate(); err != nil {
+			return nil, err
+		}
+		if dueDate == nil {
+			first := input.Recurrence.First(time.Now())
+			dueDate = &first
+		}
+	}
+
+	if input.ParentID != 0 {
+		if err := validateParent(appData, 0, input.ParentID, project.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	task := Task{
+		ID:             newTaskID(appData),
+		ProjectID:      project.ID,
+		ParentID:       input.ParentID,
+		Description:    desc,
+		Category:       strings.TrimSpace(input.Category),
+		Priority:       priority,
+		DueDate:        dueDate,
+		Recurrence:     input.Recurrence,
+		CreatedAt:      time.Now(),
+		Tags:           tags,
+		Assignee:       strings.TrimSpace(input.Assignee),
+		EstimatedHours: input.EstimatedHours,
+		Position:       nextPosition(appData, project.ID, status),
+	}
+	setTaskStatus(&task, status)
+
+	appData.Tasks = append(appData.Tasks, task)
+	for _, blockerID := range input.BlockedBy {
+		if err := addDependency(appData, task.ID, blockerID); err != nil {
+			return nil, err
+		}
+	}
+	return &appData.Tasks[len(appData.Tasks)-1], nil
+}
+
+// saveNewTask runs createTask in its own update and returns the saved task.
+func saveNewTask(input TaskInput) (Task, error) {
+	var task *Task
+	err := updateAppData(func(appData *AppData) error {
+		var err error
+		task, err = createTask(appData, input)
+		return err
+	})
+	if err != nil {
+		return Task{}, err
+	}
+	return *task, nil
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timers belong to the user who started them, so everyone can track time
+// at once, but each user runs at most one timer. Entries from before
+// timers had owners belong to "".
+//
+// Pausing a timer splits it into segments; only the time inside segments
+// counts. Timers running (or paused) for longer than timerLimit are closed
+// at the limit and flagged AutoClosed, so a forgotten timer doesn't turn
+// into a 300-hour session. Entries can also be added, edited, split and
+// deleted by hand, as long as a user's entries don't overlap.
+
+const defaultTimerHours = 12
+
+// timerLimit is TASKMANAGER_TIMER_MAX_HOURS, or 0 to never auto-close.
+func timerLimit() time.Duration {
+	hours := float64(defaultTimerHours)
+	if v := os.Getenv("TASKMANAGER_TIMER_MAX_HOURS"); v != "" {
+		if n, err := strconv.ParseFloat(v, 64); err == nil && n >= 0 {
+			hours = n
+		}
+	}
+	return time.Duration(hours * float64(time.Hour))
+}
+
+// startTimerEntry opens a new time entry for taskID owned by user. The
+// user's running timer is stopped first and returned as stopped; with
+// autoStop false a running timer is an error instead.
+func startTimerEntry(appData *AppData, taskID int, user, note string, autoStop bool) (entry *TimeEntry, stopped *TimeEntry, err error) {
+	if findTask(appData, taskID) == nil {
+		return nil, nil, &apiError{Status: http.StatusNotFound, Message: fmt.Sprintf("Task #%d not found", taskID)}
+	}
+	autoCloseTimers(appData, time.Now())
+	if running := runningTimer(appData, user); running != nil {
+		if !autoStop {
+			return nil, nil, &apiError{
+				Status:  http.StatusConflict,
+				Message: fmt.Sprintf("Timer #%d is already running on task #%d; stop it first", running.ID, running.TaskID),
+				Data:    *running,
+			}
+		}
+		s, err := stopTimerEntry(appData, running.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		copied := *s
+		stopped = &copied
+	}
+
+	appData.TimeEntries = append(appData.TimeEntries, TimeEntry{
+		ID:        nextTimeEntryID(appData.TimeEntries),
+		TaskID:    taskID,
+		User:      user,
+		StartTime: time.Now(),
+		Note:      note,
+	})
+	return &appData.TimeEntries[len(appData.TimeEntries)-1], stopped, nil
+}
+
+// stopTimerEntry closes time entry id and records its duration.
+func stopTimerEntry(appData *AppData, id int) (*TimeEntry, error) {
+	e := findTimeEntry(appData, id)
+	if e == nil {
+		return nil, &apiError{Status: http.StatusNotFound, Message: "Time entry not found"}
+	}
+	if e.EndTime != nil {
+		return nil, &apiError{Status: http.StatusConflict, Message: fmt.Sprintf("Timer #%d is already stopped", id)}
+	}
+	end := time.Now()
+	if e.Paused {
+		end = *e.Segments[len(e.Segments)-1].End
+	}
+	closeEntryAt(e, end)
+	return e, nil
+}
+
+// runningTimer returns the user's entry that has not been stopped yet, if
+// any. A paused timer still counts as running.
+func runningTimer(appData *AppData, user string) *TimeEntry {
+	for i := range appData.TimeEntries {
+		if appData.TimeEntries[i].EndTime == nil && strings.EqualFold(appData.TimeEntries[i].User, user) {
+			return &appData.TimeEntries[i]
+		}
+	}
+	return nil
+}
+
+func findTimeEntry(appData *AppData, id int) *TimeEntry {
+	for i := range appData.TimeEntries {
+		if appData.TimeEntries[i].ID == id {
+			return &appData.TimeEntries[i]
+		}
+	}
+	return nil
+}
+
+// checkTimeEntryOwner lets users change their own entries, and project
+// owners everyone's.
+func checkTimeEntryOwner(appData *AppData, e TimeEntry, username string) error {
+	if e.User == "" || strings.EqualFold(e.User, username) {
+		return nil
+	}
+	if checkProjectRole(appData, taskProject(appData, e.TaskID), username, RoleOwner) == nil {
+		return nil
+	}
+	return &apiError{Status: http.StatusForbidden, Message: fmt.Sprintf("Time entry #%d belongs to %s", e.ID, e.User)}
+}
+
+// pauseTimerEntry stops the clock of a running timer without closing it.
+func pauseTimerEntry(appData *AppData, id int) (*TimeEntry, error) {
+	e := findTimeEntry(appData, id)
+	if e == nil {
+		return nil, &apiError{Status: http.StatusNotFound, Message: "Time entry not found"}
+	}
+	if e.EndTime != nil || e.Paused {
+		return nil, &apiError{Status: http.StatusConflict, Message: fmt.Sprintf("Timer #%d is not running", id)}
+	}
+	now := time.Now()
+	if len(e.Segments) == 0 {
+		e.Segments = []TimeSegment{{Start: e.StartTime}}
+	}
+	e.Segments[len(e.Segments)-1].End = &now
+	e.Paused = true
+	e.Duration = int(trackedDuration(*e, now).Seconds())
+	return e, nil
+}
+
+func resumeTimerEntry(appData *AppData, id int) (*TimeEntry, error) {
+	e := findTimeEntry(appData, id)
+	if e == nil {
+		return nil, &apiError{Status: http.StatusNotFound, Message: "Time entry not found"}
+	}
+	if !e.Paused {
+		return nil, &apiError{Status: http.StatusConflict, Message: fmt.Sprintf("Timer #%d is not paused", id)}
+	}
+	e.Segments = append(e.Segments, TimeSegment{Start: time.Now()})
+	e.Paused = false
+	return e, nil
+}
+
+// entrySpans returns the stretches of time an entry counts, open ones
+// ending at now.
+func entrySpans(e TimeEntry, now time.Time) [][2]time.Time {
+	end := func(t *time.Time) time.Time {
+		if t == nil {
+			return now
+		}
+		return *t
+	}
+	if len(e.Segments) == 0 {
+		return [][2]time.Time{{e.StartTime, end(e.EndTime)}}
+	}
+	spans := make([][2]time.Time, len(e.Segments))
+	for i, s := range e.Segments {
+		spans[i] = [2]time.Time{s.Start, end(s.End)}
+	}
+	return spans
+}
+
+func trackedDuration(e TimeEntry, now time.Time) time.Duration {
+	var total time.Duration
+	for _, span := range entrySpans(e, now) {
+		total += span[1].Sub(span[0])
+	}
+	return total
+}
+
+// clipSegments keeps the parts of the segments between from and to.
+func clipSegments(segments []TimeSegment, from, to time.Time) []TimeSegment {
+	var out []TimeSegment
+	for _, s := range segments {
+		if s.End != nil && !s.End.After(from) || !s.Start.Before(to) {
+			continue
+		}
+		if s.Start.Before(from) {
+			s.Start = from
+		}
+		if s.End == nil || s.End.After(to) {
+			end := to
+			s.End = &end
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// closeEntryAt ends an entry at end, dropping whatever was tracked after.
+func closeEntryAt(e *TimeEntry, end time.Time) {
+	if len(e.Segments) > 0 {
+		e.Segments = clipSegments(e.Segments, e.StartTime, end)
+	}
+	e.EndTime = &end
+	e.Paused = false
+	e.Duration = int(trackedDuration(*e, end).Seconds())
+}
+
+// timerChanges says what an update did to time entries, by entry ID:
+// "started" for a new running timer, "stopped" for a running one that was
+// closed, "added" for an entry made by hand and "split" for the second part
+// of a split entry. Splitting a running timer closes the first part, which
+// does not count as stopping it. snap was taken before the update.
+func timerChanges(snap versionSnapshot, appData *AppData) map[int]string {
+	type mark struct {
+		user string
+		task int
+		at   int64
+	}
+	markOf := func(e TimeEntry, at time.Time) mark {
+		return mark{strings.ToLower(e.User), e.TaskID, at.UnixNano()}
+	}
+	starts := map[mark]bool{} // of new entries
+	cuts := map[mark]bool{}   // new ends of existing entries
+	for _, e := range appData.TimeEntries {
+		before, ok := snap.timers[e.ID]
+		switch {
+		case !ok:
+			starts[markOf(e, e.StartTime)] = true
+		case e.EndTime != nil && (before.EndTime == nil || !e.EndTime.Equal(*before.EndTime)):
+			cuts[markOf(e, *e.EndTime)] = true
+		}
+	}
+
+	changes := map[int]string{}
+	for _, e := range appData.TimeEntries {
+		before, ok := snap.timers[e.ID]
+		switch {
+		case !ok && cuts[markOf(e, e.StartTime)]:
+			changes[e.ID] = "split"
+		case !ok && e.EndTime == nil:
+			changes[e.ID] = "started"
+		case !ok:
+			changes[e.ID] = "added"
+		case before.EndTime == nil && e.EndTime != nil && !starts[markOf(e, *e.EndTime)]:
+			changes[e.ID] = "stopped"
+		}
+	}
+	return changes
+}
+
+// autoCloseTimers closes timers that have tracked more than timerLimit, at
+// the moment they reached it, and paused ones left alone for as long. It
+// returns the IDs it closed.
+func autoCloseTimers(appData *AppData, now time.Time) []int {
+	limit := timerLimit()
+	if limit <= 0 {
+		return nil
+	}
+	var closed []int
+	for i := range appData.TimeEntries {
+		e := &appData.TimeEntries[i]
+		if e.EndTime != nil {
+			continue
+		}
+		var end time.Time
+		if e.Paused {
+			pausedAt := *e.Segments[len(e.Segments)-1].End
+			if now.Sub(pausedAt) <= limit {
+				continue
+			}
+			end = pausedAt
+		} else {
+			var acc time.Duration
+			for _, span := range entrySpans(*e, now) {
+				length := span[1].Sub(span[0])
+				if acc+length > limit {
+					end = span[0].Add(limit - acc)
+					break
+				}
+				acc += length
+			}
+			if end.IsZero() {
+				continue
+			}
+		}
+		closeEntryAt(e, end)
+		e.AutoClosed = true
+		closed = append(closed, e.ID)
+	}
+	return closed
+}
+
+// validateTimeEntry checks an added or edited entry: its task exists, it
+// ends after it starts, not in the future, and it doesn't overlap another
+// entry of the same user.
+func validateTimeEntry(appData *AppData, e TimeEntry) error {
+	now := time.Now()
+	if findTask(appData, e.TaskID) == nil {
+		return &apiError{Status: http.StatusNotFound, Message: fmt.Sprintf("Task #%d not found", e.TaskID)}
+	}
+	if e.StartTime.IsZero() {
+		return queryError("a time entry needs a start time")
+	}
+	if e.EndTime != nil && !e.EndTime.After(e.StartTime) {
+		return queryError("a time entry must end after it starts")
+	}
+	if e.StartTime.After(now) || e.EndTime != nil && e.EndTime.After(now) {
+		return queryError("a time entry can't be in the future")
+	}
+	spans := entrySpans(e, now)
+	for _, o := range appData.TimeEntries {
+		if o.ID == e.ID || !strings.EqualFold(o.User, e.User) {
+			continue
+		}
+		for _, a := range spans {
+			for _, b := range entrySpans(o, now) {
+				if a[0].Before(b[1]) && b[0].Before(a[1]) {
+					return &apiError{
+						Status: http.StatusConflict,
+						Message: fmt.Sprintf("overlaps time entry #%d (%s – %s)", o.ID,
+							b[0].Local().Format("2006-01-02 15:04"), b[1].Local().Format("15:04")),
+						Data: o,
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// addTimeEntry records time tracked without a timer.
+func addTimeEntry(appData *AppData, e TimeEntry) (*TimeEntry, error) {
+	if e.EndTime == nil {
+		return nil, queryError("a time entry needs an end time; start a timer instead")
+	}
+	e.ID = nextTimeEntryID(appData.TimeEntries)
+	e.Segments, e.Paused, e.AutoClosed = nil, false, false
+	if err := validateTimeEntry(appData, e); err != nil {
+		return nil, err
+	}
+	e.Duration = int(trackedDuration(e, *e.EndTime).Seconds())
+	appData.TimeEntries = append(appData.TimeEntries, e)
+	return &appData.TimeEntries[len(appData.TimeEntries)-1], nil
+}
+
+// TimeEntryPatch is an edit of a time entry; nil fields stay the same.
+type TimeEntryPatch struct {
+	TaskID    *int       `json:"task_id"`
+	StartTime *time.Time `json:"start_time"`
+	EndTime   *time.Time `json:"end_time"`
+	Note      *string    `json:"note"`
+	Billable  *bool      `json:"billable"`
+}
+
+// editTimeEntry changes an entry. Moving its start or end trims pauses to
+// the new range; the end of a running timer can't be set (stop it instead).
+func editTimeEntry(appData *AppData, id int, patch TimeEntryPatch) (*TimeEntry, error) {
+	e := findTimeEntry(appData, id)
+	if e == nil {
+		return nil, &apiError{Status: http.StatusNotFound, Message: "Time entry not found"}
+	}
+	updated := *e
+	updated.Segments = append([]TimeSegment(nil), e.Segments...)
+	if patch.TaskID != nil {
+		updated.TaskID = *patch.TaskID
+	}
+	if patch.Note != nil {
+		updated.Note = *patch.Note
+	}
+	if patch.Billable != nil {
+		billable := *patch.Billable
+		updated.Billable = &billable
+	}
+	if patch.EndTime != nil && e.EndTime == nil {
+		return nil, queryError("timer #%d is still running; stop it instead of setting its end", id)
+	}
+	if patch.StartTime != nil || patch.EndTime != nil {
+		if patch.StartTime != nil {
+			updated.StartTime = *patch.StartTime
+		}
+		if patch.EndTime != nil {
+			end := *patch.EndTime
+			updated.EndTime = &end
+			updated.AutoClosed = false
+		}
+		if len(updated.Segments) > 0 {
+			to := time.Now()
+			if updated.EndTime != nil {
+				to = *updated.EndTime
+			}
+			open := updated.EndTime == nil && !updated.Paused
+			updated.Segments = clipSegments(updated.Segments, updated.StartTime, to)
+			if open && len(updated.Segments) > 0 {
+				updated.Segments[len(updated.Segments)-1].End = nil
+			}
+			if len(updated.Segments) == 0 {
+				return nil, queryError("no tracked time of entry #%d is left in that range", id)
+			}
+		}
+	}
+	if err := validateTimeEntry(appData, updated); err != nil {
+		return nil, err
+	}
+	if updated.EndTime != nil {
+		updated.Duration = int(trackedDuration(updated, *updated.EndTime).Seconds())
+	} else {
+		updated.Duration = int(trackedDuration(updated, time.Now()).Seconds())
+	}
+	*e = updated
+	return e, nil
+}
+
+// splitTimeEntry cuts an entry in two at the given time. The second part
+// gets a new ID and keeps running if the entry was.
+func splitTimeEntry(appData *AppData, id int, at time.Time) (first, second TimeEntry, err error) {
+	e := findTimeEntry(appData, id)
+	if e == nil {
+		return first, second, &apiError{Status: http.StatusNotFound, Message: "Time entry not found"}
+	}
+	end := time.Now()
+	if e.EndTime != nil {
+		end = *e.EndTime
+	}
+	if !at.After(e.StartTime) || !at.Before(end) {
+		return first, second, queryError("split time must be between %s and %s",
+			e.StartTime.Local().Format("2006-01-02 15:04"), end.Local().Format("2006-01-02 15:04"))
+	}
+
+	second = *e
+	second.ID = nextTimeEntryID(appData.TimeEntries)
+	second.StartTime = at
+	if len(e.Segments) > 0 {
+		second.Segments = clipSegments(e.Segments, at, end)
+		switch {
+		case e.EndTime == nil && !e.Paused && len(second.Segments) > 0:
+			second.Segments[len(second.Segments)-1].End = nil
+		case e.Paused && len(second.Segments) == 0:
+			// Split during the pause: the second part hasn't tracked anything yet.
+			pausedAt := at
+			second.Segments = []TimeSegment{{Start: at, End: &pausedAt}}
+		}
+	}
+	second.Duration = int(trackedDuration(second, end).Seconds())
+
+	autoClosed := e.AutoClosed
+	closeEntryAt(e, at)
+	e.AutoClosed = false
+	second.AutoClosed = autoClosed
+	first = *e
+	appData.TimeEntries = append(appData.TimeEntries, second)
+	return first, second, nil
+}
+
+func deleteTimeEntry(appData *AppData, id int) bool {
+	for i, e := range appData.TimeEntries {
+		if e.ID == id {
+			appData.TimeEntries = append(appData.TimeEntries[:i], appData.TimeEntries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// timerLoop auto-closes forgotten timers while the server runs.
+func timerLoop() {
+	for {
+		appData, err := loadAppData()
+		if err == nil && len(autoCloseTimers(appData, time.Now())) > 0 {
+			updateAppDataAs("system", func(appData *AppData) error {
+				autoCloseTimers(appData, time.Now())
+				return nil
+			})
+		}
+		time.Sleep(time.Minute)
+	}
+}
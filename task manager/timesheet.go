@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A timesheet adds up the finished time entries that started in a date
+// range, grouped by any of day, week, user, project and task. Entries can
+// be rounded one by one (e.g. up to 15 minutes) before they are added.
+//
+// An entry is billable when its project is, unless the entry itself says
+// otherwise, and billable time is charged at the project's hourly rate in
+// TASKMANAGER_CURRENCY (default USD). Timesheets can be rendered as CSV or
+// as a printable HTML invoice of the billable rows.
+
+var (
+	timesheetGroups    = []string{"day", "week", "user", "project", "task"}
+	timesheetRoundings = []string{"up", "down", "nearest"}
+	timesheetFormats   = []string{"json", "csv", "html"}
+)
+
+// TimesheetOptions select and group the entries of a timesheet.
+type TimesheetOptions struct {
+	From      time.Time
+	To        time.Time // exclusive
+	GroupBy   []string
+	Round     time.Duration // 0 keeps exact durations
+	Rounding  string        // up, down or nearest
+	ProjectID int
+	User      string
+	Billable  *bool // only billable or only non-billable entries
+}
+
+type TimesheetRow struct {
+	Day           string  `json:"day,omitempty"`  // 2006-01-02
+	Week          string  `json:"week,omitempty"` // ISO week, 2006-W01
+	User          string  `json:"user,omitempty"`
+	ProjectID     int     `json:"project_id,omitempty"`
+	Project       string  `json:"project,omitempty"`
+	TaskID        int     `json:"task_id,omitempty"`
+	Task          string  `json:"task,omitempty"`
+	Entries       int     `json:"entries"`
+	Hours         float64 `json:"hours"`
+	BillableHours float64 `json:"billable_hours"`
+	Rate          float64 `json:"rate,omitempty"` // when all billable time has the same rate
+	Amount        float64 `json:"amount"`
+
+	seconds, billable int
+	amount            float64
+	rates             map[float64]bool
+}
+
+type Timesheet struct {
+	From     time.Time      `json:"from"`
+	To       time.Time      `json:"to"` // exclusive
+	GroupBy  []string       `json:"group_by"`
+	Rounding string         `json:"rounding,omitempty"` // e.g. "up to 15m"
+	Currency string         `json:"currency"`
+	Rows     []TimesheetRow `json:"rows"`
+	Total    TimesheetRow   `json:"total"`
+}
+
+// Invoice adds the header of a printed invoice to a timesheet.
+type Invoice struct {
+	Timesheet
+	Number string
+	Client string
+	Issued time.Time
+}
+
+func timesheetCurrency() string {
+	if c := strings.TrimSpace(os.Getenv("TASKMANAGER_CURRENCY")); c != "" {
+		return strings.ToUpper(c)
+	}
+	return "USD"
+}
+
+// parseTimesheetOptions reads the options shared by the API and the CLI:
+// from and to (dates, to inclusive; the current month by default), group
+// (comma-separated), round (a duration), rounding, project (name or id),
+// user and billable (true or false).
+func parseTimesheetOptions(appData *AppData, values map[string]string, now time.Time) (TimesheetOptions, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	opts := TimesheetOptions{
+		From:     time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location()),
+		To:       today.AddDate(0, 0, 1),
+		GroupBy:  []string{"project", "task"},
+		Rounding: "up",
+		User:     strings.TrimSpace(values["user"]),
+	}
+	var err error
+	if opts.From, opts.To, err = parseDayRange(values["from"], values["to"], opts.From, opts.To, now); err != nil {
+		return opts, err
+	}
+
+	if v := strings.TrimSpace(values["group"]); v != "" {
+		opts.GroupBy = nil
+		for _, g := range strings.Split(v, ",") {
+			g = strings.ToLower(strings.TrimSpace(g))
+			if !containsString(timesheetGroups, g) {
+				return opts, queryError("unknown group %q (use %s)", g, strings.Join(timesheetGroups, ", "))
+			}
+			if !containsString(opts.GroupBy, g) {
+				opts.GroupBy = append(opts.GroupBy, g)
+			}
+		}
+	}
+	if v := strings.TrimSpace(values["round"]); v != "" && v != "0" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Minute || d%time.Minute != 0 {
+			return opts, queryError("round must be whole minutes such as 6m, 15m or 1h")
+		}
+		opts.Round = d
+	}
+	if v := strings.ToLower(strings.TrimSpace(values["rounding"])); v != "" {
+		if !containsString(timesheetRoundings, v) {
+			return opts, queryError("rounding must be %s", strings.Join(timesheetRoundings, ", "))
+		}
+		opts.Rounding = v
+	}
+	if v := strings.TrimSpace(values["project"]); v != "" {
+		project := findProject(appData, 0, v)
+		if project == nil {
+			return opts, &apiError{Status: http.StatusNotFound, Message: fmt.Sprintf("project %q not found", v)}
+		}
+		opts.ProjectID = project.ID
+	}
+	if v := strings.TrimSpace(values["billable"]); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, queryError("billable must be true or false")
+		}
+		opts.Billable = &b
+	}
+	return opts, nil
+}
+
+// roundDuration rounds a tracked duration to a multiple of unit.
+func roundDuration(d, unit time.Duration, mode string) time.Duration {
+	if unit <= 0 {
+		return d
+	}
+	switch mode {
+	case "down":
+		return d.Truncate(unit)
+	case "nearest":
+		return d.Round(unit)
+	}
+	if r := d.Truncate(unit); r < d {
+		return r + unit
+	}
+	return d
+}
+
+// entryBillable reports whether an entry is billed: as set on the entry,
+// else as its project.
+func entryBillable(e TimeEntry, project *Project) bool {
+	if e.Billable != nil {
+		return *e.Billable
+	}
+	return project != nil && project.Billable
+}
+
+// buildTimesheet adds up the entries selected by opts.
+func buildTimesheet(appData *AppData, opts TimesheetOptions) Timesheet {
+	sheet := Timesheet{From: opts.From, To: opts.To, GroupBy: opts.GroupBy, Currency: timesheetCurrency(), Rows: []TimesheetRow{}}
+	if opts.Round > 0 {
+		unit := strings.TrimSuffix(opts.Round.String(), "0s") // 15m0s → 15m
+		if strings.HasSuffix(unit, "h0m") {
+			unit = strings.TrimSuffix(unit, "0m")
+		}
+		sheet.Rounding = fmt.Sprintf("%s to %s", opts.Rounding, unit)
+	}
+	groups := map[string]bool{}
+	for _, g := range opts.GroupBy {
+		groups[g] = true
+	}
+
+	byKey := map[string]*TimesheetRow{}
+	var keys []string
+	total := &TimesheetRow{}
+	for _, e := range appData.TimeEntries {
+		if e.EndTime == nil || e.StartTime.Before(opts.From) || !e.StartTime.Before(opts.To) {
+			continue
+		}
+		if opts.User != "" && !strings.EqualFold(e.User, opts.User) {
+			continue
+		}
+		var task Task
+		if t := findTask(appData, e.TaskID); t != nil {
+			task = *t
+		} else {
+			task = Task{ID: e.TaskID, Description: fmt.Sprintf("task #%d", e.TaskID)}
+		}
+		if opts.ProjectID != 0 && task.ProjectID != opts.ProjectID {
+			continue
+		}
+		project := findProject(appData, task.ProjectID, "")
+		billable := entryBillable(e, project)
+		if opts.Billable != nil && billable != *opts.Billable {
+			continue
+		}
+
+		var row TimesheetRow
+		start := e.StartTime.In(opts.From.Location())
+		if groups["day"] {
+			row.Day = start.Format("2006-01-02")
+		}
+		if groups["week"] {
+			year, week := start.ISOWeek()
+			row.Week = fmt.Sprintf("%d-W%02d", year, week)
+		}
+		if groups["user"] {
+			row.User = e.User
+		}
+		if groups["project"] || groups["task"] {
+			row.ProjectID = task.ProjectID
+			if project != nil {
+				row.Project = project.Name
+			}
+		}
+		if groups["task"] {
+			row.TaskID, row.Task = task.ID, task.Description
+		}
+		key := fmt.Sprintf("%s|%s|%s|%d|%d", row.Day, row.Week, strings.ToLower(row.User), row.ProjectID, row.TaskID)
+		if byKey[key] == nil {
+			byKey[key] = &row
+			keys = append(keys, key)
+		}
+
+		seconds := int(roundDuration(time.Duration(e.Duration)*time.Second, opts.Round, opts.Rounding).Seconds())
+		rate := 0.0
+		if project != nil {
+			rate = project.HourlyRate
+		}
+		for _, r := range []*TimesheetRow{byKey[key], total} {
+			r.Entries++
+			r.seconds += seconds
+			if billable {
+				r.billable += seconds
+				r.amount += float64(seconds) / 3600 * rate
+				if r.rates == nil {
+					r.rates = map[float64]bool{}
+				}
+				r.rates[rate] = true
+			}
+		}
+	}
+
+	for _, key := range keys {
+		sheet.Rows = append(sheet.Rows, byKey[key].finish())
+	}
+	sort.SliceStable(sheet.Rows, func(i, j int) bool {
+		a, b := sheet.Rows[i], sheet.Rows[j]
+		for _, g := range opts.GroupBy {
+			switch {
+			case g == "day" && a.Day != b.Day:
+				return a.Day < b.Day
+			case g == "week" && a.Week != b.Week:
+				return a.Week < b.Week
+			case g == "user" && !strings.EqualFold(a.User, b.User):
+				return strings.ToLower(a.User) < strings.ToLower(b.User)
+			case g == "project" && a.Project != b.Project:
+				return strings.ToLower(a.Project) < strings.ToLower(b.Project)
+			case g == "task" && a.TaskID != b.TaskID:
+				return a.TaskID < b.TaskID
+			}
+		}
+		return false
+	})
+	sheet.Total = total.finish()
+	return sheet
+}
+
+// finish turns the summed seconds into hours and the amount into cents.
+func (r *TimesheetRow) finish() TimesheetRow {
+	out := *r
+	out.Hours = math.Round(float64(r.seconds)/36) / 100
+	out.BillableHours = math.Round(float64(r.billable)/36) / 100
+	out.Amount = math.Round(r.amount*100) / 100
+	if len(r.rates) == 1 {
+		for rate := range r.rates {
+			out.Rate = rate
+		}
+	}
+	out.rates = nil
+	return out
+}
+
+// Label names the group of a row, e.g. "2026-10-12 · Web · #4 Fix login".
+func (r TimesheetRow) Label() string {
+	var parts []string
+	if r.Day != "" {
+		parts = append(parts, r.Day)
+	}
+	if r.Week != "" {
+		parts = append(parts, r.Week)
+	}
+	if r.User != "" {
+		parts = append(parts, r.User)
+	}
+	if r.Project != "" {
+		parts = append(parts, r.Project)
+	} else if r.ProjectID == 0 && r.TaskID != 0 {
+		parts = append(parts, "No project")
+	}
+	if r.TaskID != 0 {
+		parts = append(parts, fmt.Sprintf("#%d %s", r.TaskID, r.Task))
+	}
+	return strings.Join(parts, " · ")
+}
+
+// Until is the last day of the timesheet's range.
+func (s Timesheet) Until() time.Time {
+	return s.To.AddDate(0, 0, -1)
+}
+
+// timesheetCSV writes a row per group plus a total row.
+func timesheetCSV(sheet Timesheet) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	var header []string
+	for _, g := range sheet.GroupBy {
+		if g == "task" {
+			header = append(header, "task_id", "task")
+		} else {
+			header = append(header, g)
+		}
+	}
+	header = append(header, "entries", "hours", "billable_hours", "rate", "amount", "currency")
+	w.Write(header)
+
+	write := func(r TimesheetRow, label string) {
+		var row []string
+		for _, g := range sheet.GroupBy {
+			switch g {
+			case "day":
+				row = append(row, r.Day)
+			case "week":
+				row = append(row, r.Week)
+			case "user":
+				row = append(row, r.User)
+			case "project":
+				row = append(row, r.Project)
+			case "task":
+				id := ""
+				if r.TaskID != 0 {
+					id = strconv.Itoa(r.TaskID)
+				}
+				row = append(row, id, r.Task)
+			}
+		}
+		if label != "" && len(row) > 0 {
+			row[0] = label
+		}
+		rate := ""
+		if r.Rate != 0 {
+			rate = formatHours(r.Rate)
+		}
+		row = append(row, strconv.Itoa(r.Entries), strconv.FormatFloat(r.Hours, 'f', 2, 64),
+			strconv.FormatFloat(r.BillableHours, 'f', 2, 64), rate, strconv.FormatFloat(r.Amount, 'f', 2, 64), sheet.Currency)
+		w.Write(row)
+	}
+	for _, r := range sheet.Rows {
+		write(r, "")
+	}
+	write(sheet.Total, "Total")
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+var invoiceTemplate = template.Must(template.New("invoice").Funcs(template.FuncMap{
+	"hours": func(h float64) string { return strconv.FormatFloat(h, 'f', 2, 64) },
+	"money": func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Invoice{{if .Number}} {{.Number}}{{end}}</title>
+<style>
+  body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #1f2937; max-width: 800px; margin: 40px auto; padding: 0 24px; }
+  h1 { margin: 0 0 4px; font-size: 28px; }
+  .meta { display: flex; justify-content: space-between; margin: 24px 0; }
+  .meta div { line-height: 1.6; }
+  .label { color: #6b7280; font-size: 12px; text-transform: uppercase; letter-spacing: .05em; }
+  table { width: 100%; border-collapse: collapse; }
+  th, td { padding: 8px 6px; border-bottom: 1px solid #e5e7eb; text-align: left; }
+  th { font-size: 12px; color: #6b7280; text-transform: uppercase; }
+  td.num, th.num { text-align: right; white-space: nowrap; }
+  tfoot td { font-weight: 600; border-bottom: none; border-top: 2px solid #1f2937; }
+  .note { color: #6b7280; font-size: 13px; margin-top: 24px; }
+  @media print { body { margin: 0; } .no-print { display: none; } }
+</style>
+</head>
+<body>
+<h1>Invoice</h1>
+{{if .Number}}<div>No. {{.Number}}</div>{{end}}
+<div class="meta">
+  <div>{{if .Client}}<div class="label">Bill to</div><div>{{.Client}}</div>{{end}}</div>
+  <div>
+    <div><span class="label">Issued</span> {{.Issued.Format "2006-01-02"}}</div>
+    <div><span class="label">Period</span> {{.From.Format "2006-01-02"}} – {{.Until.Format "2006-01-02"}}</div>
+  </div>
+</div>
+<table>
+  <thead><tr><th>Description</th><th class="num">Hours</th><th class="num">Rate</th><th class="num">Amount</th></tr></thead>
+  <tbody>
+  {{- range .Rows}}{{if gt .BillableHours 0.0}}
+    <tr><td>{{.Label}}</td><td class="num">{{hours .BillableHours}}</td><td class="num">{{if .Rate}}{{money .Rate}}{{end}}</td><td class="num">{{money .Amount}}</td></tr>
+  {{- end}}{{end}}
+  </tbody>
+  <tfoot><tr><td>Total ({{.Currency}})</td><td class="num">{{hours .Total.BillableHours}}</td><td></td><td class="num">{{money .Total.Amount}}</td></tr></tfoot>
+</table>
+{{if .Rounding}}<p class="note">Time entries rounded {{.Rounding}}.</p>{{end}}
+<p class="no-print note">Use your browser's print dialog to save this invoice as PDF.</p>
+</body>
+</html>
+`))
+
+// renderInvoice writes the billable rows of a timesheet as an HTML invoice.
+func renderInvoice(inv Invoice) ([]byte, error) {
+	if inv.Issued.IsZero() {
+		inv.Issued = time.Now()
+	}
+	var buf bytes.Buffer
+	err := invoiceTemplate.Execute(&buf, inv)
+	return buf.Bytes(), err
+}
+
+// renderTimesheet writes a timesheet as CSV or an HTML invoice.
+func renderTimesheet(sheet Timesheet, format, client, number string) ([]byte, error) {
+	switch format {
+	case "csv":
+		return timesheetCSV(sheet)
+	case "html":
+		return renderInvoice(Invoice{Timesheet: sheet, Client: client, Number: number})
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func parseTimesheetFormat(s string) (string, error) {
+	format := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(s), "."))
+	if format == "htm" {
+		format = "html"
+	}
+	if !containsString(timesheetFormats, format) {
+		return "", queryError("unknown format %q (use %s)", s, strings.Join(timesheetFormats, ", "))
+	}
+	return format, nil
+}
+
+// setProjectBilling changes the billing settings that are given. Setting a
+// rate makes the project billable unless billable says otherwise.
+func setProjectBilling(p *Project, rate *float64, billable *bool) {
+	if rate != nil {
+		p.HourlyRate = *rate
+		p.Billable = *rate > 0
+	}
+	if billable != nil {
+		p.Billable = *billable
+	}
+}
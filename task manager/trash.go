@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Deleting a task or project moves it to the trash instead of dropping it.
+// A trash item holds everything one delete removed, plus the links that had
+// to be cut, so restoring it puts things back as they were. Items are purged
+// after TASKMANAGER_TRASH_DAYS days (default 30; 0 keeps them forever).
+// Trashed IDs stay reserved, so a restored task gets its old ID back.
+
+const defaultTrashDays = 30
+
+type TrashItem struct {
+	ID        int        `json:"id"`
+	Kind      string     `json:"kind"` // task or project
+	Title     string     `json:"title"`
+	ProjectID int        `json:"project_id,omitempty"`
+	DeletedAt time.Time  `json:"deleted_at"`
+	DeletedBy string     `json:"deleted_by"`
+	Project   *Project   `json:"project,omitempty"`
+	Tasks     []Task     `json:"tasks"`
+	Subtasks  []int      `json:"subtasks,omitempty"` // children of a deleted task, moved up a level
+	Links     []TaskLink `json:"links,omitempty"`    // blocked_by entries of other tasks that were cut
+}
+
+// TaskLink says TaskID is blocked by BlockerID.
+type TaskLink struct {
+	TaskID    int `json:"task_id"`
+	BlockerID int `json:"blocker_id"`
+}
+
+func trashRetention() time.Duration {
+	days := defaultTrashDays
+	if v := os.Getenv("TASKMANAGER_TRASH_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			days = n
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// newTaskID and newProjectID skip IDs held by trashed items and every ID
+// handed out before, including purged ones.
+func newTaskID(appData *AppData) int {
+	id := nextID(appData.Tasks)
+	if appData.LastTaskID >= id {
+		id = appData.LastTaskID + 1
+	}
+	for _, item := range appData.Trash {
+		for _, t := range item.Tasks {
+			if t.ID >= id {
+				id = t.ID + 1
+			}
+		}
+	}
+	return id
+}
+
+func newProjectID(appData *AppData) int {
+	id := nextProjectID(appData.Projects)
+	if appData.LastProjectID >= id {
+		id = appData.LastProjectID + 1
+	}
+	for _, item := range appData.Trash {
+		if item.Project != nil && item.Project.ID >= id {
+			id = item.Project.ID + 1
+		}
+	}
+	return id
+}
+
+// reserveIDs raises the high-water marks past every task and project ID in
+// use or in the trash. snap holds the marks from before the update, so
+// putting back an older state cannot lower them. Stores from before the
+// marks existed are seeded from the activity log, which still names the
+// IDs of purged items.
+func reserveIDs(appData *AppData, snap versionSnapshot) {
+	lastTask, lastProject := snap.lastTaskID, snap.lastProjectID
+	if lastTask == 0 && lastProject == 0 {
+		for _, a := range appData.Activity {
+			switch {
+			case a.Entity == "task" && a.EntityID > lastTask:
+				lastTask = a.EntityID
+			case a.Entity == "project" && a.EntityID > lastProject:
+				lastProject = a.EntityID
+			}
+		}
+	}
+	if id := newTaskID(appData) - 1; id > lastTask {
+		lastTask = id
+	}
+	if id := newProjectID(appData) - 1; id > lastProject {
+		lastProject = id
+	}
+	appData.LastTaskID, appData.LastProjectID = lastTask, lastProject
+}
+
+// addToTrash removes the tasks in ids from appData and files them, with the
+// dependency links that pointed at them, as a new trash item.
+func addToTrash(appData *AppData, item TrashItem, ids map[int]bool, actor string) *TrashItem {
+	kept := appData.Tasks[:0]
+	for _, t := range appData.Tasks {
+		if ids[t.ID] {
+			item.Tasks = append(item.Tasks, t)
+			continue
+		}
+		kept = append(kept, t)
+	}
+	appData.Tasks = kept
+	for _, t := range appData.Tasks {
+		for _, blocker := range t.BlockedBy {
+			if ids[blocker] {
+				item.Links = append(item.Links, TaskLink{TaskID: t.ID, BlockerID: blocker})
+			}
+		}
+	}
+	pruneDependencies(appData)
+
+	item.ID = 1
+	for _, other := range appData.Trash {
+		if other.ID >= item.ID {
+			item.ID = other.ID + 1
+		}
+	}
+	item.DeletedAt = time.Now()
+	item.DeletedBy = actor
+	purgeTrash(appData, item.DeletedAt)
+	appData.Trash = append(appData.Trash, item)
+	return &appData.Trash[len(appData.Trash)-1]
+}
+
+// trashTask moves a task to the trash. Its subtasks stay and move up a
+// level, as with a plain delete.
+func trashTask(appData *AppData, id int, actor string) (*TrashItem, error) {
+	task := findTask(appData, id)
+	if task == nil {
+		return nil, &apiError{Status: http.StatusNotFound, Message: fmt.Sprintf("task #%d not found", id)}
+	}
+	deleted := *task
+	item := TrashItem{Kind: "task", Title: deleted.Description, ProjectID: deleted.ProjectID}
+	for _, t := range appData.Tasks {
+		if t.ParentID == id {
+			item.Subtasks = append(item.Subtasks, t.ID)
+		}
+	}
+	detachSubtasks(appData, deleted)
+	return addToTrash(appData, item, map[int]bool{id: true}, actor), nil
+}
+
+// trashProject moves a project and all of its tasks to the trash.
+func trashProject(appData *AppData, id int, actor string) (*TrashItem, error) {
+	project := findProject(appData, id, "")
+	if project == nil {
+		return nil, &apiError{Status: http.StatusNotFound, Message: "Project not found"}
+	}
+	deleted := *project
+	projects := appData.Projects[:0]
+	for _, p := range appData.Projects {
+		if p.ID != id {
+			projects = append(projects, p)
+		}
+	}
+	appData.Projects = projects
+
+	ids := map[int]bool{}
+	for _, t := range appData.Tasks {
+		if t.ProjectID == id {
+			ids[t.ID] = true
+		}
+	}
+	item := TrashItem{Kind: "project", Title: deleted.Name, ProjectID: id, Project: &deleted}
+	return addToTrash(appData, item, ids, actor), nil
+}
+
+// checkTrashAccess fails unless the user may restore or purge item: an
+// owner of a trashed project, or an editor of a trashed task's project.
+func checkTrashAccess(appData *AppData, item *TrashItem, username string) error {
+	if item.Project != nil {
+		if role := projectRole(item.Project, username); role != RoleOwner {
+			return &apiError{Status: http.StatusForbidden, Message: fmt.Sprintf("Only an owner of project %q can restore it", item.Project.Name)}
+		}
+		return nil
+	}
+	return checkProjectRole(appData, item.ProjectID, username, RoleEditor)
+}
+
+// visibleTrash lists the trash items the user could restore, newest first.
+func visibleTrash(appData *AppData, username string) []TrashItem {
+	items := []TrashItem{}
+	for i := len(appData.Trash) - 1; i >= 0; i-- {
+		item := appData.Trash[i]
+		if username == "" || checkTrashAccess(appData, &item, username) == nil {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func findTrashItem(appData *AppData, id int) *TrashItem {
+	for i := range appData.Trash {
+		if appData.Trash[i].ID == id {
+			return &appData.Trash[i]
+		}
+	}
+	return nil
+}
+
+// restoreTrashItem puts a trash item back. A task whose project is gone
+// cannot come back until the project does; a task whose parent is gone
+// becomes top-level.
+func restoreTrashItem(appData *AppData, id int) (TrashItem, error) {
+	found := findTrashItem(appData, id)
+	if found == nil {
+		return TrashItem{}, &apiError{Status: http.StatusNotFound, Message: fmt.Sprintf("trash item %d not found", id)}
+	}
+	item := *found
+
+	if item.Project != nil {
+		if findProject(appData, item.Project.ID, "") != nil {
+			return TrashItem{}, &apiError{Status: http.StatusConflict, Message: fmt.Sprintf("project #%d already exists", item.Project.ID)}
+		}
+		appData.Projects = append(appData.Projects, *item.Project)
+	} else if item.ProjectID != 0 && findProject(appData, item.ProjectID, "") == nil {
+		return TrashItem{}, &apiError{Status: http.StatusConflict, Message: fmt.Sprintf("project #%d is gone; restore it first", item.ProjectID)}
+	}
+
+	restored := map[int]bool{}
+	for _, t := range item.Tasks {
+		if findTask(appData, t.ID) != nil {
+			continue
+		}
+		restored[t.ID] = true
+		appData.Tasks = append(appData.Tasks, t)
+	}
+	for i := range appData.Tasks {
+		t := &appData.Tasks[i]
+		if restored[t.ID] && t.ParentID != 0 && findTask(appData, t.ParentID) == nil {
+			t.ParentID = 0
+		}
+	}
+	if item.Kind == "task" && len(item.Tasks) == 1 {
+		task := item.Tasks[0]
+		for _, childID := range item.Subtasks {
+			if child := findTask(appData, childID); child != nil && child.ParentID == task.ParentID {
+				child.ParentID = task.ID
+			}
+		}
+	}
+	for _, link := range item.Links {
+		t := findTask(appData, link.TaskID)
+		if t == nil || findTask(appData, link.BlockerID) == nil || containsInt(t.BlockedBy, link.BlockerID) {
+			continue
+		}
+		t.BlockedBy = append(t.BlockedBy, link.BlockerID)
+	}
+	pruneDependencies(appData)
+
+	removeTrashItem(appData, id)
+	return item, nil
+}
+
+func removeTrashItem(appData *AppData, id int) bool {
+	for i, item := range appData.Trash {
+		if item.ID == id {
+			appData.Trash = append(appData.Trash[:i], appData.Trash[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// purgeTrash drops items older than the retention period and returns how
+// many it dropped.
+func purgeTrash(appData *AppData, now time.Time) int {
+	retention := trashRetention()
+	if retention == 0 {
+		return 0
+	}
+	kept := appData.Trash[:0]
+	for _, item := range appData.Trash {
+		if now.Sub(item.DeletedAt) < retention {
+			kept = append(kept, item)
+		}
+	}
+	purged := len(appData.Trash) - len(kept)
+	appData.Trash = kept
+	return purged
+}
+
+// purgeTrashLoop purges expired trash while the server runs.
+func purgeTrashLoop() {
+	for {
+		appData, err := loadAppData()
+		if err == nil && trashRetention() > 0 {
+			for _, item := range appData.Trash {
+				if time.Since(item.DeletedAt) >= trashRetention() {
+					updateAppDataAs("system", func(appData *AppData) error {
+						purgeTrash(appData, time.Now())
+						return nil
+					})
+					break
+				}
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
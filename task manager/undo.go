@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// The interactive mode remembers the last undoDepth commands that changed
+// something, so "undo" and "redo" can step back and forth through them. A
+// step keeps the tasks, projects, time entries and trash from before and
+// after the command. Undo refuses to run when the data changed in between
+// (from the web server or another terminal): putting the old state back
+// would silently throw that change away.
+
+const undoDepth = 20
+
+// undoState is the part of AppData that undo puts back. Users, sessions
+// and the activity log are left alone.
+type undoState struct {
+	Projects    []Project   `json:"projects"`
+	Tasks       []Task      `json:"tasks"`
+	TimeEntries []TimeEntry `json:"time_entries"`
+	Trash       []TrashItem `json:"trash"`
+}
+
+type undoStep struct {
+	label  string
+	before undoState
+	after  undoState
+}
+
+type undoStack struct {
+	done   []undoStep
+	undone []undoStep
+}
+
+// captureUndoState copies the undoable part of appData.
+func captureUndoState(appData *AppData) undoState {
+	var state undoState
+	data, _ := json.Marshal(undoState{
+		Projects:    appData.Projects,
+		Tasks:       appData.Tasks,
+		TimeEntries: appData.TimeEntries,
+		Trash:       appData.Trash,
+	})
+	json.Unmarshal(data, &state)
+	return state
+}
+
+// fingerprint identifies a state regardless of version numbers, which
+// undoing a change bumps rather than rolls back.
+func (s undoState) fingerprint() string {
+	tasks := append([]Task(nil), s.Tasks...)
+	for i := range tasks {
+		tasks[i].Version = 0
+	}
+	projects := append([]Project(nil), s.Projects...)
+	for i := range projects {
+		projects[i].Version = 0
+	}
+	return jsonString(undoState{Projects: projects, Tasks: tasks, TimeEntries: s.TimeEntries, Trash: s.Trash})
+}
+
+// record adds a step for a command if it changed anything. Recording a new
+// step forgets whatever could have been redone.
+func (u *undoStack) record(label string, before undoState) {
+	appData, err := loadAppData()
+	if err != nil {
+		return
+	}
+	after := captureUndoState(appData)
+	if after.fingerprint() == before.fingerprint() {
+		return
+	}
+	u.done = append(u.done, undoStep{label: label, before: before, after: after})
+	if len(u.done) > undoDepth {
+		u.done = u.done[len(u.done)-undoDepth:]
+	}
+	u.undone = nil
+}
+
+func (u *undoStack) undo() (string, error) {
+	if len(u.done) == 0 {
+		return "", errors.New("nothing to undo")
+	}
+	step := u.done[len(u.done)-1]
+	if err := switchUndoState(step.after, step.before); err != nil {
+		return "", err
+	}
+	u.done = u.done[:len(u.done)-1]
+	u.undone = append(u.undone, step)
+	return step.label, nil
+}
+
+func (u *undoStack) redo() (string, error) {
+	if len(u.undone) == 0 {
+		return "", errors.New("nothing to redo")
+	}
+	step := u.undone[len(u.undone)-1]
+	if err := switchUndoState(step.before, step.after); err != nil {
+		return "", err
+	}
+	u.undone = u.undone[:len(u.undone)-1]
+	u.done = append(u.done, step)
+	return step.label, nil
+}
+
+// switchUndoState replaces the data with target if it still matches from.
+// Tasks and projects keep counting up from their current versions, so
+// clients holding an ETag notice the change.
+func switchUndoState(from, target undoState) error {
+	return updateAppData(func(appData *AppData) error {
+		current := captureUndoState(appData)
+		if current.fingerprint() != from.fingerprint() {
+			return fmt.Errorf("the data has changed since; undo only works while nothing else has")
+		}
+		taskVersions := map[int]int{}
+		for _, t := range current.Tasks {
+			taskVersions[t.ID] = t.Version
+		}
+		projectVersions := map[int]int{}
+		for _, p := range current.Projects {
+			projectVersions[p.ID] = p.Version
+		}
+		for _, item := range current.Trash {
+			for _, t := range item.Tasks {
+				taskVersions[t.ID] = t.Version
+			}
+			if item.Project != nil {
+				projectVersions[item.Project.ID] = item.Project.Version
+			}
+		}
+
+		state := captureUndoState(&AppData{
+			Projects:    target.Projects,
+			Tasks:       target.Tasks,
+			TimeEntries: target.TimeEntries,
+			Trash:       target.Trash,
+		})
+		for i := range state.Tasks {
+			if v := taskVersions[state.Tasks[i].ID]; v > state.Tasks[i].Version {
+				state.Tasks[i].Version = v
+			}
+		}
+		for i := range state.Projects {
+			if v := projectVersions[state.Projects[i].ID]; v > state.Projects[i].Version {
+				state.Projects[i].Version = v
+			}
+		}
+		appData.Projects = state.Projects
+		appData.Tasks = state.Tasks
+		appData.TimeEntries = state.TimeEntries
+		appData.Trash = state.Trash
+		return nil
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// versionSnapshot records how every task and project looked before an
+// update, so the ones that changed can have their Version bumped afterwards
+// without each mutation having to remember to do it. It also notes which
+// time entries were open, for timerChanges, what was in the trash, so
+// recordActivity can tell a restore from a create, and the ID high-water
+// marks, which an undo must not lower.
+type versionSnapshot struct {
+	tasks    map[int]string
+	projects map[int]string
+	timers   map[int]TimeEntry
+
+	trashedTasks    map[int]bool
+	trashedProjects map[int]bool
+	lastTaskID      int
+	lastProjectID   int
+}
+
+func snapshotVersions(appData *AppData) versionSnapshot {
+	snap := versionSnapshot{
+		tasks:    make(map[int]string, len(appData.Tasks)),
+		projects: make(map[int]string, len(appData.Projects)),
+		timers:   make(map[int]TimeEntry, len(appData.TimeEntries)),
+
+		trashedTasks:    map[int]bool{},
+		trashedProjects: map[int]bool{},
+		lastTaskID:      appData.LastTaskID,
+		lastProjectID:   appData.LastProjectID,
+	}
+	for _, t := range appData.Tasks {
+		data, _ := json.Marshal(t)
+		snap.tasks[t.ID] = string(data)
+	}
+	for _, p := range appData.Projects {
+		data, _ := json.Marshal(p)
+		snap.projects[p.ID] = string(data)
+	}
+	for _, e := range appData.TimeEntries {
+		snap.timers[e.ID] = e
+	}
+	for _, item := range appData.Trash {
+		for _, t := range item.Tasks {
+			snap.trashedTasks[t.ID] = true
+		}
+		if item.Project != nil {
+			snap.trashedProjects[item.Project.ID] = true
+		}
+	}
+	return snap
+}
+
+// bump increments Version on every task and project that is new or differs
+// from the snapshot.
+func (snap versionSnapshot) bump(appData *AppData) {
+	for i := range appData.Tasks {
+		t := &appData.Tasks[i]
+		before, ok := snap.tasks[t.ID]
+		if !ok {
+			t.Version++
+			continue
+		}
+		data, _ := json.Marshal(t)
+		if string(data) != before {
+			t.Version++
+		}
+	}
+	for i := range appData.Projects {
+		p := &appData.Projects[i]
+		before, ok := snap.projects[p.ID]
+		if !ok {
+			p.Version++
+			continue
+		}
+		data, _ := json.Marshal(p)
+		if string(data) != before {
+			p.Version++
+		}
+	}
+}
+
+func etag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// checkIfMatch enforces the request's If-Match header against the current
+// version of a resource. A missing header always matches, so clients that
+// do not care about conflicts keep last-writer-wins behaviour.
+func checkIfMatch(r *http.Request, version int, current interface{}) error {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+	want := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == want {
+			return nil
+		}
+	}
+	return &apiError{
+		Status:  http.StatusPreconditionFailed,
+		Message: "Modified by someone else; reload and try again",
+		Data:    current,
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestIfMatch sends every write that honours If-Match with a stale ETag, the
+// current one and none at all.
+func TestIfMatch(t *testing.T) {
+	newData := func() *AppData {
+		return signInAll(&AppData{
+			Users: []User{{ID: 1, Username: "ann"}},
+			Projects: []Project{
+				{ID: 1, Name: "Default", Version: 4, Members: []ProjectMember{{Username: "ann", Role: RoleOwner}}},
+				{ID: 2, Name: "Old", Version: 4, Members: []ProjectMember{{Username: "ann", Role: RoleOwner}}},
+			},
+			Tasks: []Task{
+				{ID: 1, ProjectID: 1, Description: "Open", Status: StatusTodo, Version: 4},
+				{ID: 2, ProjectID: 1, Description: "Finished", Status: StatusDone, Done: true, Version: 4},
+				{ID: 3, ProjectID: 1, Description: "Blocker", Status: StatusTodo, Version: 4},
+			},
+		})
+	}
+	tests := []struct {
+		name, method, path, body string
+		task, project            int // the resource whose version is checked
+	}{
+		{"update task", "PUT", "/api/tasks/1", `{"description": "Edited"}`, 1, 0},
+		{"mark done", "PUT", "/api/tasks/1/done", "", 1, 0},
+		{"mark undone", "PUT", "/api/tasks/2/undone", "", 2, 0},
+		{"move task", "PUT", "/api/kanban/move", `{"task_id": 1, "new_status": "in_progress"}`, 1, 0},
+		{"add checklist item", "POST", "/api/tasks/1/checklist", `{"text": "step"}`, 1, 0},
+		{"add dependency", "POST", "/api/tasks/1/dependencies", `{"blocked_by": 3}`, 1, 0},
+		{"delete task", "DELETE", "/api/tasks/1", "", 1, 0},
+		{"update project", "PUT", "/api/projects/1", `{"name": "Renamed"}`, 0, 1},
+		{"delete project", "DELETE", "/api/projects/2", "", 0, 2},
+	}
+	for _, tt := range tests {
+		for _, ifMatch := range []string{`"3"`, `"4"`, `W/"4"`, `"1", "4"`, "*", ""} {
+			useTestStore(t, newData())
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "Bearer ann")
+			if ifMatch != "" {
+				r.Header.Set("If-Match", ifMatch)
+			}
+			w := httptest.NewRecorder()
+			routeHandler(w, r)
+
+			appData, err := loadAppData()
+			if err != nil {
+				t.Fatal(err)
+			}
+			version := 0
+			if task := findTask(appData, tt.task); task != nil {
+				version = task.Version
+			} else if project := findProject(appData, tt.project, ""); project != nil {
+				version = project.Version
+			}
+			desc := fmt.Sprintf("%s with If-Match %s", tt.name, ifMatch)
+			if ifMatch == `"3"` {
+				if w.Code != http.StatusPreconditionFailed || version != 4 {
+					t.Errorf("%s: status %d, version %d; want 412 and no change", desc, w.Code, version)
+				}
+				if !strings.Contains(w.Body.String(), `"version":4`) {
+					t.Errorf("%s: response lacks the current version: %s", desc, w.Body)
+				}
+				continue
+			}
+			deleted := strings.HasPrefix(tt.name, "delete")
+			switch {
+			case w.Code >= 300:
+				t.Errorf("%s: status %d: %s", desc, w.Code, w.Body)
+			case deleted && version != 0:
+				t.Errorf("%s: still there", desc)
+			case !deleted && version <= 4:
+				t.Errorf("%s: version %d, want it bumped", desc, version)
+			}
+		}
+	}
+}
@@ -8,7 +8,10 @@ let state = {
     timeEntries: [],
     currentProject: null,
     activeTimer: null,
-    currentView: 'dashboard'
+    currentView: 'dashboard',
+    searchSeq: 0,
+    user: null,
+    events: null
 };
 
 // Utility Functions
@@ -31,6 +34,16 @@ function formatDate(dateStr) {
     return date.toLocaleDateString();
 }
 
+function formatDue(dateStr) {
+    if (!dateStr || !hasDueTime(dateStr)) return formatDate(dateStr);
+    return new Date(dateStr).toLocaleString([], { dateStyle: 'short', timeStyle: 'short' });
+}
+
+// Due dates without a time are stored as 23:59:59 on that day.
+function hasDueTime(dateStr) {
+    return !/T23:59:59/.test(dateStr);
+}
+
 function formatDuration(seconds) {
     const hours = Math.floor(seconds / 3600);
     const minutes = Math.floor((seconds % 3600) / 60);
@@ -52,6 +65,7 @@ async function apiCall(endpoint, options = {}) {
     try {
         const response = await fetch(`${API_BASE}${endpoint}`, {
             ...options,
+            credentials: 'include',
             headers: {
                 'Content-Type': 'application/json',
                 ...options.headers
@@ -61,16 +75,154 @@ async function apiCall(endpoint, options = {}) {
         const data = await response.json();
         
         if (!response.ok) {
-            throw new Error(data.message || 'API request failed');
+            const error = new Error(data.message || 'API request failed');
+            error.status = response.status;
+            error.data = data.data;
+            throw error;
         }
         
         return data;
     } catch (error) {
-        showToast(error.message, 'error');
+        if (error.status === 401 && !options.quiet) {
+            showLogin();
+        } else if (error.status === 412) {
+            showToast('Someone else edited this in the meantime. Showing their latest version.', 'error');
+            loadViewData(state.currentView);
+        } else {
+            showToast(error.message, 'error');
+        }
         throw error;
     }
 }
 
+// Accounts
+function showLogin(register = false) {
+    state.user = null;
+    disconnectEvents();
+    document.getElementById('current-user').style.display = 'none';
+    document.getElementById('login-modal').dataset.register = register ? '1' : '';
+    document.getElementById('login-modal-title').textContent = register ? 'Create Account' : 'Sign In';
+    document.getElementById('btn-login').textContent = register ? 'Create Account' : 'Sign In';
+    document.getElementById('btn-toggle-register').textContent = register ? 'I have an account' : 'Create an account';
+    document.getElementById('login-name-group').style.display = register ? '' : 'none';
+    openModal('login-modal');
+}
+
+function setUser(user) {
+    state.user = user;
+    connectEvents();
+    document.getElementById('current-user-name').textContent = `👤 ${user.name || user.username}`;
+    document.getElementById('current-user').style.display = '';
+}
+
+async function submitLogin(e) {
+    e.preventDefault();
+    const register = document.getElementById('login-modal').dataset.register === '1';
+    const body = {
+        username: document.getElementById('login-username').value.trim(),
+        password: document.getElementById('login-password').value
+    };
+    if (register) {
+        body.name = document.getElementById('login-name').value.trim();
+    }
+
+    const data = await apiCall(register ? '/auth/register' : '/auth/login', {
+        method: 'POST',
+        body: JSON.stringify(body),
+        quiet: true
+    });
+    document.getElementById('login-form').reset();
+    closeModal('login-modal');
+    setUser(data.data.user);
+    await loadProjects();
+    await loadTasks();
+    switchView(state.currentView);
+}
+
+// createCalendarFeed makes a new calendar token; the old feed URL stops
+// working, so ask first.
+async function createCalendarFeed() {
+    if (!confirm('Create a calendar feed URL? Any earlier feed URL stops working.')) {
+        return;
+    }
+    const data = await apiCall('/me/calendar', { method: 'POST' });
+    prompt('Subscribe to this URL in your calendar app (add &kind=todo for tasks):', data.data.url);
+}
+
+async function logout() {
+    await apiCall('/auth/logout', { method: 'POST' });
+    showLogin();
+}
+
+// Live updates: the server pushes every change as an event. EventSource
+// reconnects by itself and resumes from the last event it received.
+let refreshTimer = null;
+
+function scheduleRefresh() {
+    clearTimeout(refreshTimer);
+    refreshTimer = setTimeout(() => loadViewData(state.currentView), 250);
+}
+
+function connectEvents() {
+    if (state.events) return;
+    const source = new EventSource(`${API_BASE}/events`, { withCredentials: true });
+    state.events = source;
+
+    const taskEvent = e => {
+        const event = JSON.parse(e.data);
+        const task = event.type === 'task.moved' ? event.data.task : event.data;
+        const i = state.tasks.findIndex(t => t.id === task.id);
+        if (i >= 0) state.tasks[i] = task; else state.tasks.push(task);
+        scheduleRefresh();
+    };
+    source.addEventListener('task.created', taskEvent);
+    source.addEventListener('task.updated', taskEvent);
+    source.addEventListener('task.moved', taskEvent);
+    source.addEventListener('task.deleted', e => {
+        const event = JSON.parse(e.data);
+        state.tasks = state.tasks.filter(t => t.id !== event.data.id);
+        scheduleRefresh();
+    });
+    ['project.created', 'project.updated', 'project.deleted', 'timer.started', 'timer.stopped', 'time_entry.created', 'reset'].forEach(type => {
+        source.addEventListener(type, scheduleRefresh);
+    });
+    source.addEventListener('comment.added', e => {
+        const comment = JSON.parse(e.data).data;
+        const modal = document.getElementById('view-task-modal');
+        if (modal.classList.contains('active') && parseInt(modal.dataset.taskId) === comment.task_id) {
+            loadViewComments(comment.task_id);
+            loadViewHistory(comment.task_id);
+        }
+    });
+}
+
+function disconnectEvents() {
+    if (state.events) {
+        state.events.close();
+        state.events = null;
+    }
+}
+
+// projectRole mirrors the server's rule: members have their role, everyone
+// else the project's default role. Without accounts nothing is checked.
+function projectRole(projectId) {
+    const project = state.projects.find(p => p.id === projectId);
+    if (!project || !state.user) return 'owner';
+    const member = (project.members || []).find(m => m.username.toLowerCase() === state.user.username.toLowerCase());
+    return member ? member.role : (project.default_role || null);
+}
+
+function canEdit(projectId) {
+    return ['owner', 'editor'].includes(projectRole(projectId));
+}
+
+// ifMatch returns the If-Match header for a task we have cached, so the
+// server can reject the write if the task changed since we loaded it.
+function ifMatch(taskId) {
+    const task = state.tasks.find(t => t.id === taskId);
+    return task ? { 'If-Match': `"${task.version}"` } : {};
+}
+
 // Data Loading Functions
 async function loadProjects() {
     const data = await apiCall('/projects');
@@ -79,8 +231,15 @@ async function loadProjects() {
 }
 
 async function loadTasks() {
-    const data = await apiCall('/tasks');
-    state.tasks = data.data || [];
+    // The API pages its results; the board needs every task.
+    const tasks = [];
+    for (;;) {
+        const data = await apiCall(`/tasks?sort=id&limit=1000&offset=${tasks.length}`);
+        const page = data.data || { tasks: [], total: 0 };
+        tasks.push(...page.tasks);
+        if (page.tasks.length === 0 || tasks.length >= page.total) break;
+    }
+    state.tasks = tasks;
     return state.tasks;
 }
 
@@ -121,7 +280,8 @@ function switchView(viewName) {
         kanban: 'Kanban Board',
         tasks: 'All Tasks',
         time: 'Time Tracking',
-        reports: 'Reports'
+        reports: 'Reports',
+        trash: 'Trash'
     };
     document.querySelector('.topbar-title').textContent = titles[viewName] || viewName;
     
@@ -151,6 +311,9 @@ async function loadViewData(viewName) {
         case 'reports':
             await renderReports();
             break;
+        case 'trash':
+            await renderTrash();
+            break;
     }
 }
 
@@ -222,9 +385,9 @@ async function renderProjects() {
                 <span>📋 ${taskCount} tasks</span>
             </div>
             <div class="project-actions">
-                <button class="btn btn-sm btn-secondary" onclick="event.stopPropagation(); editProject(${project.id})">Edit</button>
+                ${projectRole(project.id) === 'owner' ? `<button class="btn btn-sm btn-secondary" onclick="event.stopPropagation(); editProject(${project.id})">Edit</button>` : ''}
                 <button class="btn btn-sm btn-primary" onclick="event.stopPropagation(); selectProject(${project.id})">View Kanban</button>
-                <button class="btn btn-sm btn-danger" onclick="event.stopPropagation(); deleteProject(${project.id})">Delete</button>
+                ${projectRole(project.id) === 'owner' ? `<button class="btn btn-sm btn-danger" onclick="event.stopPropagation(); deleteProject(${project.id})">Delete</button>` : ''}
             </div>
         </div>
     `}).join('');
@@ -292,12 +455,12 @@ function editProject(projectId) {
 }
 
 async function deleteProject(projectId) {
-    if (!confirm('Are you sure you want to delete this project? All tasks will remain but will be unassigned.')) {
+    if (!confirm('Move this project and its tasks to the trash?')) {
         return;
     }
     
     await apiCall(`/projects/${projectId}`, { method: 'DELETE' });
-    showToast('Project deleted successfully');
+    showToast('Project moved to the trash');
     
     await loadProjects();
     if (state.currentView === 'projects') {
@@ -335,7 +498,7 @@ async function renderKanban() {
         countEl.textContent = tasks.length;
         
         const html = tasks.map(task => `
-            <div class="kanban-task" draggable="true" data-task-id="${task.id}" data-status="${status}" onclick="viewTask(${task.id})" style="cursor: pointer;">
+            <div class="kanban-task" draggable="${canEdit(task.project_id)}" data-task-id="${task.id}" data-version="${task.version}" data-status="${status}" onclick="viewTask(${task.id})" style="cursor: pointer;">
                 <div class="kanban-task-header">
                     <div style="flex: 1;">
                         <strong>${task.description}</strong>
@@ -345,8 +508,12 @@ async function renderKanban() {
                 <div class="kanban-task-meta">
                     ${task.category ? `<span>🏷️ ${task.category}</span>` : ''}
                     ${task.assignee ? `<span>👤 ${task.assignee}</span>` : ''}
-                    ${task.due_date ? `<span>📅 ${formatDate(task.due_date)}</span>` : ''}
+                    ${task.due_date ? `<span>📅 ${formatDue(task.due_date)}</span>` : ''}
                     ${task.estimated_hours ? `<span>⏱️ ${task.estimated_hours}h</span>` : ''}
+                    ${task.parent_id ? `<span>↳ #${task.parent_id}</span>` : ''}
+                    ${task.blocked ? `<span title="Blocked by unfinished tasks">⛔ blocked</span>` : ''}
+                    ${task.recurrence ? `<span title="Repeats">🔁</span>` : ''}
+                    ${task.progress && (task.progress.subtasks_total || task.progress.checklist_total) ? `<span>☑️ ${task.progress.percent}%</span>` : ''}
                 </div>
                 <div class="kanban-task-footer">
                     <div style="font-size: 0.75rem; color: var(--text-muted);">ID: ${task.id}</div>
@@ -381,6 +548,7 @@ function handleDragStart(e) {
     e.dataTransfer.effectAllowed = 'move';
     e.dataTransfer.setData('text/html', this.innerHTML);
     e.dataTransfer.setData('task-id', this.dataset.taskId);
+    e.dataTransfer.setData('task-version', this.dataset.version);
     e.dataTransfer.setData('old-status', this.dataset.status);
     this.classList.add('dragging');
 }
@@ -411,24 +579,39 @@ async function handleDrop(e) {
     this.style.background = '';
     
     const taskId = parseInt(e.dataTransfer.getData('task-id'));
+    const version = e.dataTransfer.getData('task-version');
     const oldStatus = e.dataTransfer.getData('old-status');
     const newStatus = this.id.replace('-column', '');
     
     if (oldStatus !== newStatus) {
+        const move = force => apiCall('/kanban/move', {
+            method: 'PUT',
+            headers: { 'If-Match': `"${version}"` },
+            body: JSON.stringify({
+                task_id: taskId,
+                new_status: newStatus,
+                position: 0,
+                force: force
+            })
+        });
+
         try {
-            await apiCall('/kanban/move', {
-                method: 'PUT',
-                body: JSON.stringify({
-                    task_id: taskId,
-                    new_status: newStatus,
-                    position: 0
-                })
-            });
+            try {
+                await move(false);
+            } catch (error) {
+                // 409: unfinished blockers. Let the user override.
+                if (error.status !== 409 || !Array.isArray(error.data)) throw error;
+                const blockers = error.data.map(t => `#${t.id} ${t.description}`).join('\n');
+                if (!confirm(`This task is blocked by:\n${blockers}\n\nMove it anyway?`)) return false;
+                await move(true);
+            }
             
             showToast('Task moved successfully');
             await renderKanban();
         } catch (error) {
-            showToast('Failed to move task', 'error');
+            if (error.status !== 412) {
+                showToast('Failed to move task', 'error');
+            }
         }
     }
     
@@ -462,7 +645,7 @@ function renderTasksList(tasks) {
                             ${task.category ? `<span>🏷️ ${task.category}</span>` : ''}
                             ${project ? `<span>📁 ${project.name}</span>` : ''}
                             ${task.assignee ? `<span>👤 ${task.assignee}</span>` : ''}
-                            ${task.due_date ? `<span>📅 ${formatDate(task.due_date)}</span>` : ''}
+                            ${task.due_date ? `<span>📅 ${formatDue(task.due_date)}</span>` : ''}
                             ${task.estimated_hours ? `<span>⏱️ ${task.estimated_hours}h</span>` : ''}
                             ${task.tags && task.tags.length ? `<span>🏷️ ${task.tags.join(', ')}</span>` : ''}
                         </div>
@@ -482,14 +665,27 @@ function renderTasksList(tasks) {
     document.getElementById('tasks-list').innerHTML = html || '<div class="empty-state"><div class="empty-state-icon">📝</div><p>No tasks found</p></div>';
 }
 
-function filterTasks() {
+async function filterTasks() {
     const projectId = document.getElementById('filter-project').value;
     const status = document.getElementById('filter-status').value;
     const priority = document.getElementById('filter-priority').value;
-    const search = document.getElementById('search-tasks').value.toLowerCase();
+    const search = document.getElementById('search-tasks').value.trim();
     
     let filtered = state.tasks;
     
+    if (search) {
+        // Ranked server-side search, which also looks at comments.
+        const seq = ++state.searchSeq;
+        try {
+            const data = await apiCall(`/search?limit=0&q=${encodeURIComponent(search)}`);
+            if (seq !== state.searchSeq) return; // a newer search is on its way
+            const byId = new Map(state.tasks.map(t => [t.id, t]));
+            filtered = data.data.results.map(r => byId.get(r.task.id)).filter(Boolean);
+        } catch (error) {
+            return;
+        }
+    }
+    
     if (projectId) {
         filtered = filtered.filter(t => t.project_id === parseInt(projectId));
     }
@@ -502,14 +698,6 @@ function filterTasks() {
         filtered = filtered.filter(t => t.priority === parseInt(priority));
     }
     
-    if (search) {
-        filtered = filtered.filter(t => 
-            t.description.toLowerCase().includes(search) ||
-            (t.category && t.category.toLowerCase().includes(search)) ||
-            (t.assignee && t.assignee.toLowerCase().includes(search))
-        );
-    }
-    
     renderTasksList(filtered);
 }
 
@@ -542,9 +730,11 @@ function openTaskModal(taskId = null) {
         document.getElementById('task-priority').value = task.priority;
         document.getElementById('task-category').value = task.category || '';
         document.getElementById('task-assignee').value = task.assignee || '';
-        document.getElementById('task-due-date').value = task.due_date ? task.due_date.split('T')[0] : '';
+        document.getElementById('task-due-date').value = !task.due_date ? '' :
+            hasDueTime(task.due_date) ? task.due_date : task.due_date.split('T')[0];
         document.getElementById('task-estimated-hours').value = task.estimated_hours || '';
         document.getElementById('task-tags').value = task.tags ? task.tags.join(', ') : '';
+        document.getElementById('task-repeat').value = recurrenceRule(task.recurrence);
         
         // Show and load comments
         if (commentsSection) {
@@ -595,7 +785,7 @@ function viewTask(taskId) {
     document.getElementById('view-task-status').innerHTML = getStatusBadge(task.status);
     document.getElementById('view-task-priority').innerHTML = getPriorityBadge(task.priority);
     document.getElementById('view-task-assignee').textContent = task.assignee || '-';
-    document.getElementById('view-task-due-date').textContent = task.due_date ? formatDate(task.due_date) : '-';
+    document.getElementById('view-task-due-date').textContent = task.due_date ? formatDue(task.due_date) : '-';
     document.getElementById('view-task-hours').textContent = task.estimated_hours ? `${task.estimated_hours}h` : '-';
     document.getElementById('view-task-category').textContent = task.category || '-';
     document.getElementById('view-task-tags').textContent = task.tags && task.tags.length ? task.tags.join(', ') : '-';
@@ -603,8 +793,9 @@ function viewTask(taskId) {
     // Store task ID for editing and comments
     document.getElementById('view-task-modal').dataset.taskId = taskId;
     
-    // Load comments
+    // Load comments and history
     loadViewComments(taskId);
+    loadViewHistory(taskId);
     
     openModal('view-task-modal');
 }
@@ -619,6 +810,19 @@ function editTask(taskId) {
     openTaskModal(taskId);
 }
 
+// recurrenceRule turns a task's recurrence back into the RRULE text the
+// server accepts, so editing a task keeps its repeat rule.
+function recurrenceRule(r) {
+    if (!r) return '';
+    const parts = [`FREQ=${r.freq.toUpperCase()}`];
+    if (r.interval > 1) parts.push(`INTERVAL=${r.interval}`);
+    if (r.weekdays && r.weekdays.length) parts.push(`BYDAY=${r.weekdays.join(',')}`);
+    if (r.month_day) parts.push(`BYMONTHDAY=${r.month_day}`);
+    if (r.count) parts.push(`COUNT=${r.count}`);
+    if (r.until) parts.push(`UNTIL=${new Date(r.until).toISOString().replace(/[-:]/g, '').split('.')[0]}Z`);
+    return parts.join(';');
+}
+
 async function saveTask() {
     const taskId = document.getElementById('task-id').value;
     const description = document.getElementById('task-description').value.trim();
@@ -630,6 +834,7 @@ async function saveTask() {
     const dueDate = document.getElementById('task-due-date').value;
     const estimatedHours = document.getElementById('task-estimated-hours').value;
     const tagsStr = document.getElementById('task-tags').value;
+    const repeat = document.getElementById('task-repeat').value.trim();
     
     if (!description) {
         showToast('Please enter a task description', 'error');
@@ -645,13 +850,15 @@ async function saveTask() {
         due_date: dueDate || undefined,
         estimated_hours: estimatedHours ? parseFloat(estimatedHours) : undefined,
         project_id: projectId ? parseInt(projectId) : undefined,
-        tags: tagsStr ? tagsStr.split(',').map(t => t.trim()).filter(t => t) : undefined
+        tags: tagsStr ? tagsStr.split(',').map(t => t.trim()).filter(t => t) : undefined,
+        repeat: repeat || (taskId ? 'none' : undefined)
     };
     
     if (taskId) {
         // Update
         await apiCall(`/tasks/${taskId}`, {
             method: 'PUT',
+            headers: ifMatch(parseInt(taskId)),
             body: JSON.stringify(taskData)
         });
         showToast('Task updated successfully');
@@ -672,7 +879,7 @@ async function saveTask() {
 }
 
 async function markTaskDone(taskId) {
-    await apiCall(`/tasks/${taskId}/done`, { method: 'PUT' });
+    await apiCall(`/tasks/${taskId}/done`, { method: 'PUT', headers: ifMatch(taskId) });
     showToast('Task marked as done');
     
     await loadTasks();
@@ -680,12 +887,12 @@ async function markTaskDone(taskId) {
 }
 
 async function deleteTask(taskId) {
-    if (!confirm('Are you sure you want to delete this task?')) {
+    if (!confirm('Move this task to the trash?')) {
         return;
     }
     
-    await apiCall(`/tasks/${taskId}`, { method: 'DELETE' });
-    showToast('Task deleted successfully');
+    await apiCall(`/tasks/${taskId}`, { method: 'DELETE', headers: ifMatch(taskId) });
+    showToast('Task moved to the trash');
     
     await loadTasks();
     loadViewData(state.currentView);
@@ -730,9 +937,57 @@ async function renderTimeTracking() {
     checkActiveTimer();
 }
 
+// Trash
+async function renderTrash() {
+    const data = await apiCall('/trash');
+    const items = data.data || [];
+    
+    const html = items.map(item => `
+        <div class="time-entry">
+            <div class="time-entry-header">
+                <div>
+                    <div class="time-entry-task">${item.kind === 'project' ? '📁' : '✓'} ${escapeHtml(item.title)}</div>
+                    <div class="time-entry-meta">
+                        ${item.kind === 'project' ? `${item.tasks.length} task(s) · ` : ''}Deleted ${formatDate(item.deleted_at)} by ${escapeHtml(item.deleted_by)}
+                    </div>
+                </div>
+                <div class="trash-actions">
+                    <button class="btn btn-sm btn-secondary" onclick="restoreTrashItem(${item.id})">Restore</button>
+                    <button class="btn btn-sm btn-danger" onclick="purgeTrashItem(${item.id})">Delete forever</button>
+                </div>
+            </div>
+        </div>
+    `).join('');
+    
+    document.getElementById('trash-list').innerHTML = html || '<div class="empty-state">The trash is empty</div>';
+}
+
+async function restoreTrashItem(id) {
+    const data = await apiCall(`/trash/${id}/restore`, { method: 'POST' });
+    showToast(data.message || 'Restored');
+    
+    await loadProjects();
+    await loadTasks();
+    renderTrash();
+}
+
+async function purgeTrashItem(id) {
+    if (!confirm('Delete this permanently? This cannot be undone.')) {
+        return;
+    }
+    
+    await apiCall(`/trash/${id}`, { method: 'DELETE' });
+    showToast('Deleted permanently');
+    renderTrash();
+}
+
 async function checkActiveTimer() {
-    const entries = await loadTimeEntries();
-    const activeEntry = entries.find(e => !e.end_time);
+    let activeEntry = null;
+    try {
+        activeEntry = (await apiCall('/time/current')).data;
+    } catch (error) {
+        console.error('Failed to load the running timer:', error);
+    }
     
     if (activeEntry) {
         state.activeTimer = activeEntry;
@@ -762,8 +1017,14 @@ function hideActiveTimer() {
 function updateTimerDisplay() {
     if (!state.activeTimer) return;
     
-    const elapsed = Math.floor((new Date() - new Date(state.activeTimer.start_time)) / 1000);
-    document.getElementById('active-timer-duration').textContent = formatDuration(elapsed);
+    // Once paused, only the segments count.
+    const timer = state.activeTimer;
+    const elapsed = timer.segments
+        ? timer.segments.reduce((sum, s) => sum + ((s.end ? new Date(s.end) : new Date()) - new Date(s.start)), 0) / 1000
+        : (new Date() - new Date(timer.start_time)) / 1000;
+    document.getElementById('active-timer-duration').textContent =
+        formatDuration(Math.floor(elapsed)) + (timer.paused ? ' (paused)' : '');
+    document.getElementById('btn-pause-timer').textContent = timer.paused ? 'Resume' : 'Pause';
 }
 
 function startTimerUpdate() {
@@ -780,11 +1041,11 @@ async function startTimer() {
     }
     
     if (state.activeTimer) {
-        showToast('Please stop the current timer first', 'error');
-        return;
+        const running = state.tasks.find(t => t.id === state.activeTimer.task_id);
+        if (!confirm(`Stop the timer on "${running ? running.description : 'another task'}" and start this one?`)) return;
     }
     
-    await apiCall('/time/start', {
+    const result = await apiCall('/time/start', {
         method: 'POST',
         body: JSON.stringify({
             task_id: parseInt(taskId),
@@ -792,7 +1053,7 @@ async function startTimer() {
         })
     });
     
-    showToast('Timer started');
+    showToast(result.message || 'Timer started');
     document.getElementById('time-notes').value = '';
     
     await renderTimeTracking();
@@ -811,12 +1072,62 @@ async function stopTimer() {
     await renderTimeTracking();
 }
 
+async function togglePauseTimer() {
+    if (!state.activeTimer) return;
+    
+    const action = state.activeTimer.paused ? 'resume' : 'pause';
+    const result = await apiCall(`/time/${state.activeTimer.id}/${action}`, {
+        method: 'PUT'
+    });
+    
+    state.activeTimer = result.data;
+    showToast(result.message);
+    updateTimerDisplay();
+}
+
 // Comments
 async function loadComments(taskId) {
     const comments = await apiCall(`/comments?task_id=${taskId}`);
     renderComments(comments || []);
 }
 
+async function loadViewHistory(taskId) {
+    const list = document.getElementById('view-history-list');
+    try {
+        const response = await apiCall(`/tasks/${taskId}/history`);
+        const history = (response.data || []).slice().reverse();
+        list.innerHTML = history.map(entry => `
+            <div class="history-item">
+                <span class="comment-author">${escapeHtml(entry.actor)}</span>
+                ${escapeHtml(describeChange(entry))}
+                <span class="comment-date">${new Date(entry.time).toLocaleString()}</span>
+            </div>
+        `).join('') || '<p style="color: var(--text-muted); font-size: 0.875rem;">No history yet</p>';
+    } catch (error) {
+        list.innerHTML = '';
+    }
+}
+
+function describeChange(entry) {
+    const value = v => v === undefined || v === null ? 'none' : (typeof v === 'object' ? JSON.stringify(v) : v);
+    switch (entry.action) {
+        case 'created': return 'created the task';
+        case 'deleted': return 'deleted the task';
+        case 'restored': return 'restored the task from the trash';
+        case 'commented': return 'commented';
+        case 'timer_started': return 'started a timer';
+        case 'timer_stopped': return `tracked ${formatDuration(entry.changes[0].to)}`;
+        case 'time_added': return `added ${formatDuration(entry.changes[0].to)} of time`;
+    }
+    return 'changed ' + (entry.changes || []).map(c => {
+        if (c.field === 'priority') {
+            const names = ['low', 'medium', 'high', 'urgent'];
+            return `priority: ${names[c.from]} → ${names[c.to]}`;
+        }
+        return `${c.field.replace('_', ' ')}: ${value(c.from)} → ${value(c.to)}`;
+    }).join(', ');
+}
+
 function renderComments(comments) {
     const commentsList = document.getElementById('comments-list');
     
@@ -843,10 +1154,9 @@ function renderComments(comments) {
 
 async function addComment() {
     const taskId = document.getElementById('task-id').value;
-    const author = document.getElementById('comment-author').value.trim();
     const text = document.getElementById('comment-text').value.trim();
     
-    if (!taskId || !author || !text) {
+    if (!taskId || !text) {
         showToast('Please fill in all comment fields');
         return;
     }
@@ -855,7 +1165,6 @@ async function addComment() {
         method: 'POST',
         body: JSON.stringify({
             task_id: parseInt(taskId),
-            author: author,
             text: text
         })
     });
@@ -1005,7 +1314,6 @@ function renderViewComments(comments) {
 
 async function addComment() {
     const taskId = document.getElementById('task-id').value;
-    const author = document.getElementById('comment-author').value.trim();
     const text = document.getElementById('comment-text').value.trim();
     
     if (!taskId) {
@@ -1023,7 +1331,6 @@ async function addComment() {
             method: 'POST',
             body: JSON.stringify({
                 task_id: parseInt(taskId),
-                author: author || 'Anonymous',
                 text: text
             })
         });
@@ -1042,7 +1349,6 @@ async function addComment() {
 async function addViewComment() {
     const modal = document.getElementById('view-task-modal');
     const taskId = modal.dataset.taskId;
-    const author = document.getElementById('view-comment-author').value.trim();
     const text = document.getElementById('view-comment-text').value.trim();
     
     if (!text) {
@@ -1055,7 +1361,6 @@ async function addViewComment() {
             method: 'POST',
             body: JSON.stringify({
                 task_id: parseInt(taskId),
-                author: author || 'Anonymous',
                 text: text
             })
         });
@@ -1164,6 +1469,7 @@ document.addEventListener('DOMContentLoaded', async () => {
     // Time tracking
     document.getElementById('btn-start-timer')?.addEventListener('click', startTimer);
     document.getElementById('btn-stop-timer')?.addEventListener('click', stopTimer);
+    document.getElementById('btn-pause-timer')?.addEventListener('click', togglePauseTimer);
     document.getElementById('time-task-select')?.addEventListener('change', function() {
         const taskId = parseInt(this.value);
         const descEl = document.getElementById('selected-task-desc');
@@ -1191,7 +1497,21 @@ document.addEventListener('DOMContentLoaded', async () => {
         editTask(parseInt(taskId));
     });
     
+    // Accounts
+    document.getElementById('login-form').addEventListener('submit', submitLogin);
+    document.getElementById('btn-toggle-register').addEventListener('click', () => {
+        showLogin(document.getElementById('login-modal').dataset.register !== '1');
+    });
+    document.getElementById('btn-logout').addEventListener('click', logout);
+    document.getElementById('btn-calendar').addEventListener('click', createCalendarFeed);
+
     // Initial load
+    const me = await fetch(`${API_BASE}/me`, { credentials: 'include' });
+    if (!me.ok) {
+        showLogin();
+        return;
+    }
+    setUser((await me.json()).data);
     await loadProjects();
     await loadTasks();
     switchView('dashboard');
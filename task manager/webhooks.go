@@ -0,0 +1,332 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Projects can register webhooks that are POSTed a JSON payload for some
+// of their events. updateAppDataAs queues a delivery for each matching
+// event in the same save as the change, so changes made from the CLI are
+// delivered too, once the server runs. The server's webhookWorker sends
+// them and retries failures with exponential backoff.
+//
+// Each payload is signed with the webhook's secret: the
+// X-Taskmanager-Signature header is "sha256=" and the hex HMAC-SHA256 of
+// the body. Receivers should compute the same over the raw body and
+// compare in constant time. Like reminder webhooks they must be public
+// https URLs, and the worker refuses to connect to private addresses.
+
+var webhookEvents = []string{"task.created", "task.moved", "task.completed", "comment.added", "timer.stopped", "time_entry.created"}
+
+var (
+	webhookBackoff     = 10 * time.Second // doubled after every failed attempt
+	webhookMaxAttempts = 6
+	webhookLogSize     = 100 // deliveries kept per webhook
+	webhookInterval    = 2 * time.Second
+)
+
+type Webhook struct {
+	ID        int       `json:"id"`
+	ProjectID int       `json:"project_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"` // only shown when created
+	Active    bool      `json:"active"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one event sent, or to be sent, to a webhook.
+type WebhookDelivery struct {
+	ID           int        `json:"id"`
+	WebhookID    int        `json:"webhook_id"`
+	Event        string     `json:"event"`
+	Payload      string     `json:"payload"`
+	Status       string     `json:"status"` // pending, delivered or failed
+	Attempts     int        `json:"attempts"`
+	ResponseCode int        `json:"response_code,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	NextAttempt  *time.Time `json:"next_attempt,omitempty"`
+	DeliveredAt  *time.Time `json:"delivered_at,omitempty"`
+}
+
+// WebhookPayload is the body POSTed to a webhook.
+type WebhookPayload struct {
+	Delivery  int         `json:"delivery"`
+	Event     string      `json:"event"`
+	ProjectID int         `json:"project_id"`
+	Time      time.Time   `json:"time"`
+	Data      interface{} `json:"data"`
+}
+
+// Public returns the webhook without its secret.
+func (h Webhook) Public() Webhook {
+	h.Secret = ""
+	return h
+}
+
+func (h Webhook) wants(event string) bool {
+	return h.Active && containsString(h.Events, event)
+}
+
+func findWebhook(appData *AppData, id int) *Webhook {
+	for i := range appData.Webhooks {
+		if appData.Webhooks[i].ID == id {
+			return &appData.Webhooks[i]
+		}
+	}
+	return nil
+}
+
+// validateWebhook checks the URL and events, defaulting to all events.
+func validateWebhook(h *Webhook) error {
+	if err := checkUserWebhookURL(h.URL); err != nil {
+		return queryError("%v", err)
+	}
+	if len(h.Events) == 0 {
+		h.Events = append([]string(nil), webhookEvents...)
+	}
+	for i, e := range h.Events {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if !containsString(webhookEvents, e) {
+			return queryError("unknown event %q (use %s)", e, strings.Join(webhookEvents, ", "))
+		}
+		h.Events[i] = e
+	}
+	return nil
+}
+
+// addWebhook registers a webhook, generating a secret unless one is given.
+func addWebhook(appData *AppData, h Webhook) (Webhook, error) {
+	if findProject(appData, h.ProjectID, "") == nil {
+		return h, &apiError{Status: http.StatusNotFound, Message: fmt.Sprintf("project #%d not found", h.ProjectID)}
+	}
+	if err := validateWebhook(&h); err != nil {
+		return h, err
+	}
+	if h.Secret == "" {
+		buf := make([]byte, 20)
+		if _, err := rand.Read(buf); err != nil {
+			return h, err
+		}
+		h.Secret = hex.EncodeToString(buf)
+	}
+	h.ID = 1
+	for _, w := range appData.Webhooks {
+		if w.ID >= h.ID {
+			h.ID = w.ID + 1
+		}
+	}
+	h.Active = true
+	h.CreatedAt = time.Now()
+	appData.Webhooks = append(appData.Webhooks, h)
+	return h, nil
+}
+
+// removeWebhook deletes a webhook and its delivery log.
+func removeWebhook(appData *AppData, id int) bool {
+	kept := appData.Webhooks[:0]
+	for _, h := range appData.Webhooks {
+		if h.ID != id {
+			kept = append(kept, h)
+		}
+	}
+	removed := len(kept) < len(appData.Webhooks)
+	appData.Webhooks = kept
+	deliveries := appData.WebhookDeliveries[:0]
+	for _, d := range appData.WebhookDeliveries {
+		if d.WebhookID != id {
+			deliveries = append(deliveries, d)
+		}
+	}
+	appData.WebhookDeliveries = deliveries
+	return removed
+}
+
+// webhookDeliveries returns a webhook's deliveries, newest first.
+func webhookDeliveries(appData *AppData, id int) []WebhookDelivery {
+	out := []WebhookDelivery{}
+	for _, d := range appData.WebhookDeliveries {
+		if d.WebhookID == id {
+			out = append(out, d)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].ID > out[j].ID })
+	return out
+}
+
+// redeliver queues a delivery to be sent again.
+func redeliver(appData *AppData, webhookID, deliveryID int) (*WebhookDelivery, error) {
+	for i := range appData.WebhookDeliveries {
+		d := &appData.WebhookDeliveries[i]
+		if d.ID == deliveryID && d.WebhookID == webhookID {
+			now := time.Now()
+			d.Status, d.Attempts, d.Error, d.NextAttempt = "pending", 0, "", &now
+			return d, nil
+		}
+	}
+	return nil, &apiError{Status: http.StatusNotFound, Message: fmt.Sprintf("delivery %d not found", deliveryID)}
+}
+
+// queueWebhookDeliveries adds a pending delivery for every active webhook
+// that wants one of the events, and trims each webhook's log.
+func queueWebhookDeliveries(appData *AppData, batch []Event) {
+	if len(appData.Webhooks) == 0 {
+		return
+	}
+	id := 1
+	for _, d := range appData.WebhookDeliveries {
+		if d.ID >= id {
+			id = d.ID + 1
+		}
+	}
+	queued := false
+	for _, e := range batch {
+		for _, h := range appData.Webhooks {
+			if h.ProjectID != e.ProjectID || !h.wants(e.Type) {
+				continue
+			}
+			body, err := json.Marshal(WebhookPayload{Delivery: id, Event: e.Type, ProjectID: e.ProjectID, Time: e.Time, Data: e.Data})
+			if err != nil {
+				continue
+			}
+			now := e.Time
+			appData.WebhookDeliveries = append(appData.WebhookDeliveries, WebhookDelivery{
+				ID: id, WebhookID: h.ID, Event: e.Type, Payload: string(body),
+				Status: "pending", CreatedAt: now, NextAttempt: &now,
+			})
+			id++
+			queued = true
+		}
+	}
+	if queued {
+		trimWebhookLog(appData)
+	}
+}
+
+// trimWebhookLog keeps the newest webhookLogSize deliveries per webhook,
+// never dropping pending ones.
+func trimWebhookLog(appData *AppData) {
+	count := map[int]int{}
+	keep := make([]bool, len(appData.WebhookDeliveries))
+	for i := len(appData.WebhookDeliveries) - 1; i >= 0; i-- {
+		d := appData.WebhookDeliveries[i]
+		count[d.WebhookID]++
+		keep[i] = d.Status == "pending" || count[d.WebhookID] <= webhookLogSize
+	}
+	kept := appData.WebhookDeliveries[:0]
+	for i, d := range appData.WebhookDeliveries {
+		if keep[i] {
+			kept = append(kept, d)
+		}
+	}
+	appData.WebhookDeliveries = kept
+}
+
+// signPayload returns the X-Taskmanager-Signature header for a body.
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// attemptDelivery POSTs a delivery and records the outcome on d: delivered
+// on a 2xx answer, otherwise retried after webhookBackoff doubled for each
+// earlier attempt, until webhookMaxAttempts have failed.
+func attemptDelivery(client *http.Client, h Webhook, d *WebhookDelivery, now time.Time) {
+	d.Attempts++
+	d.ResponseCode, d.Error = 0, ""
+	req, err := http.NewRequest("POST", h.URL, strings.NewReader(d.Payload))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "taskmanager-webhook")
+		req.Header.Set("X-Taskmanager-Event", d.Event)
+		req.Header.Set("X-Taskmanager-Delivery", fmt.Sprint(d.ID))
+		req.Header.Set("X-Taskmanager-Signature", signPayload(h.Secret, []byte(d.Payload)))
+		var resp *http.Response
+		if resp, err = client.Do(req); err == nil {
+			// The body is not recorded: it could hand the
+			// receiver's answer to whoever reads the deliveries.
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			d.ResponseCode = resp.StatusCode
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				err = errors.New(resp.Status)
+			}
+		}
+	}
+	if err == nil {
+		d.Status, d.NextAttempt, d.DeliveredAt = "delivered", nil, &now
+		return
+	}
+	d.Error = err.Error()
+	if d.Attempts >= webhookMaxAttempts {
+		d.Status, d.NextAttempt = "failed", nil
+		return
+	}
+	next := now.Add(webhookBackoff << (d.Attempts - 1))
+	d.Status, d.NextAttempt = "pending", &next
+}
+
+// sendDueDeliveries attempts the deliveries whose time has come and saves
+// the outcomes. It returns how many were attempted.
+func sendDueDeliveries(client *http.Client, now time.Time) (int, error) {
+	appData, err := loadAppData()
+	if err != nil {
+		return 0, err
+	}
+	var due []WebhookDelivery
+	for _, d := range appData.WebhookDeliveries {
+		if d.Status == "pending" && d.NextAttempt != nil && !d.NextAttempt.After(now) {
+			due = append(due, d)
+		}
+	}
+	if len(due) == 0 {
+		return 0, nil
+	}
+	attempts := map[int]int{}
+	for i := range due {
+		attempts[due[i].ID] = due[i].Attempts
+		h := findWebhook(appData, due[i].WebhookID)
+		if h == nil || !h.Active {
+			due[i].Status, due[i].Error, due[i].NextAttempt = "failed", "webhook removed or disabled", nil
+			continue
+		}
+		attemptDelivery(client, *h, &due[i], now)
+	}
+	return len(due), updateAppDataAs("system", func(appData *AppData) error {
+		for _, d := range due {
+			for i := range appData.WebhookDeliveries {
+				// A redelivery requested meanwhile wins.
+				if cur := appData.WebhookDeliveries[i]; cur.ID == d.ID && cur.Attempts == attempts[d.ID] {
+					appData.WebhookDeliveries[i] = d
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// webhookWorker sends queued deliveries while the server runs.
+func webhookWorker() {
+	client := publicClient(10 * time.Second)
+	for {
+		if _, err := sendDueDeliveries(client, time.Now()); err != nil {
+			log.Printf("webhooks: %v", err)
+		}
+		time.Sleep(webhookInterval)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQueueWebhookDeliveries(t *testing.T) {
+	appData := &AppData{Webhooks: []Webhook{
+		{ID: 1, ProjectID: 1, URL: "http://example.com/a", Events: []string{"task.completed"}, Active: true},
+		{ID: 2, ProjectID: 1, URL: "http://example.com/b", Events: webhookEvents, Active: false},
+		{ID: 3, ProjectID: 2, URL: "http://example.com/c", Events: webhookEvents, Active: true},
+	}}
+	now := time.Date(2026, time.October, 14, 10, 0, 0, 0, time.UTC)
+	queueWebhookDeliveries(appData, []Event{
+		{Type: "task.moved", ProjectID: 1, Time: now},
+		{Type: "task.completed", ProjectID: 1, Time: now, Data: Task{ID: 7, Done: true}},
+		{Type: "task.updated", ProjectID: 2, Time: now},
+	})
+
+	if len(appData.WebhookDeliveries) != 1 {
+		t.Fatalf("queued %d deliveries, want 1: %+v", len(appData.WebhookDeliveries), appData.WebhookDeliveries)
+	}
+	d := appData.WebhookDeliveries[0]
+	if d.WebhookID != 1 || d.Event != "task.completed" || d.Status != "pending" || d.NextAttempt == nil {
+		t.Errorf("delivery = %+v", d)
+	}
+	var payload struct {
+		Delivery int    `json:"delivery"`
+		Event    string `json:"event"`
+		Data     Task   `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(d.Payload), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Delivery != d.ID || payload.Event != "task.completed" || payload.Data.ID != 7 {
+		t.Errorf("payload = %s", d.Payload)
+	}
+}
+
+func TestAttemptDeliverySigns(t *testing.T) {
+	hook := Webhook{ID: 1, Secret: "s3cret", Active: true}
+	var got *http.Request
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+	hook.URL = receiver.URL
+
+	now := time.Now()
+	d := WebhookDelivery{ID: 4, WebhookID: 1, Event: "comment.added", Payload: `{"event":"comment.added"}`, Status: "pending"}
+	attemptDelivery(receiver.Client(), hook, &d, now)
+
+	if d.Status != "delivered" || d.Attempts != 1 || d.ResponseCode != http.StatusNoContent || d.DeliveredAt == nil {
+		t.Fatalf("delivery = %+v", d)
+	}
+	if string(body) != d.Payload {
+		t.Errorf("body = %q, want %q", body, d.Payload)
+	}
+	if got.Header.Get("X-Taskmanager-Event") != "comment.added" || got.Header.Get("X-Taskmanager-Delivery") != "4" {
+		t.Errorf("headers = %v", got.Header)
+	}
+	want := signPayload("s3cret", body)
+	if !hmac.Equal([]byte(got.Header.Get("X-Taskmanager-Signature")), []byte(want)) {
+		t.Errorf("signature = %q, want %q", got.Header.Get("X-Taskmanager-Signature"), want)
+	}
+}
+
+func TestAttemptDeliveryBacksOff(t *testing.T) {
+	calls := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+	hook := Webhook{ID: 1, URL: receiver.URL, Secret: "x", Active: true}
+
+	now := time.Date(2026, time.October, 14, 10, 0, 0, 0, time.UTC)
+	d := WebhookDelivery{ID: 1, WebhookID: 1, Event: "task.created", Payload: "{}", Status: "pending"}
+	wait := webhookBackoff
+	for attempt := 1; attempt < webhookMaxAttempts; attempt++ {
+		attemptDelivery(receiver.Client(), hook, &d, now)
+		if d.Status != "pending" || d.NextAttempt == nil || !d.NextAttempt.Equal(now.Add(wait)) {
+			t.Fatalf("attempt %d: status %s, next %v, want pending at %v", attempt, d.Status, d.NextAttempt, now.Add(wait))
+		}
+		if d.ResponseCode != http.StatusServiceUnavailable || d.Error != "503 Service Unavailable" {
+			t.Errorf("attempt %d: code %d, error %q", attempt, d.ResponseCode, d.Error)
+		}
+		wait *= 2
+	}
+	attemptDelivery(receiver.Client(), hook, &d, now)
+	if d.Status != "failed" || d.NextAttempt != nil || calls != webhookMaxAttempts {
+		t.Errorf("after %d calls: status %s, next %v", calls, d.Status, d.NextAttempt)
+	}
+}
+
+func TestSplitQueuesNoTimerStopped(t *testing.T) {
+	start := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	end := start.Add(2 * time.Hour)
+	tests := []struct {
+		name  string
+		entry TimeEntry
+	}{
+		{"closed entry", TimeEntry{ID: 1, TaskID: 1, User: "ann", StartTime: start, EndTime: &end, Duration: 7200}},
+		{"running timer", TimeEntry{ID: 1, TaskID: 1, User: "ann", StartTime: start}},
+	}
+	for _, tt := range tests {
+		appData := &AppData{
+			Tasks:       []Task{{ID: 1, ProjectID: 1}},
+			TimeEntries: []TimeEntry{tt.entry},
+			Webhooks:    []Webhook{{ID: 1, ProjectID: 1, URL: "http://example.com/hook", Events: webhookEvents, Active: true}},
+		}
+		snap := snapshotVersions(appData)
+		if _, _, err := splitTimeEntry(appData, 1, start.Add(time.Hour)); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		queueWebhookDeliveries(appData, changeEvents(snap, appData))
+
+		var events []string
+		for _, d := range appData.WebhookDeliveries {
+			events = append(events, d.Event)
+		}
+		if len(events) != 1 || events[0] != "time_entry.created" {
+			t.Errorf("%s: split queued %v, want only time_entry.created", tt.name, events)
+		}
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://hooks.example.com/taskmanager", true},
+		{"http://hooks.example.com/taskmanager", false},
+		{"https://127.0.0.1/hook", false},
+		{"https://localhost:8080/hook", false},
+		{"https://10.0.0.5/hook", false},
+		{"https://[::1]/hook", false},
+		{"ftp://hooks.example.com/", false},
+	}
+	for _, tt := range tests {
+		err := validateWebhook(&Webhook{URL: tt.url})
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: error %v, want ok %v", tt.url, err, tt.ok)
+		}
+	}
+}